@@ -0,0 +1,128 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// The Account type describes the Dropcam account logged in to: its user,
+// the cloud recording subscriptions it pays for, and the recording plan of
+// each camera, for billing dashboards
+type Account struct {
+	Id            int64          `json:"id"`
+	Username      string         `json:"username"`
+	Email         string         `json:"email"`
+	Name          string         `json:"name"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Cameras       []CameraPlan   `json:"cameras"`
+}
+
+// The Subscription type is a cloud recording plan of one camera, as
+// subscriptions.list reports it. Times are seconds since the epoch; see
+// Ends and Renews.
+type Subscription struct {
+	Id               int64   `json:"id"`
+	Camera           string  `json:"camera_uuid"`
+	Plan             string  `json:"plan_name"`
+	Status           string  `json:"status"`
+	DaysOfRecording  int     `json:"days_of_recording"`
+	HoursOfRecording float64 `json:"hours_of_recording"`
+	IsTrial          bool    `json:"is_trial"`
+	EndTime          float64 `json:"end_time"`
+	RenewTime        float64 `json:"renew_time"`
+}
+
+// The Ends method returns when the subscription ends, or the zero time
+func (s *Subscription) Ends() time.Time {
+	if s.EndTime <= 0 {
+		return time.Time{}
+	}
+	return epochTime(s.EndTime)
+}
+
+// The Renews method returns when the subscription renews, or the zero time
+func (s *Subscription) Renews() time.Time {
+	if s.RenewTime <= 0 {
+		return time.Time{}
+	}
+	return epochTime(s.RenewTime)
+}
+
+// The CameraPlan type sums up the recording of one camera: the hours of
+// cloud recording it keeps, its trial, and the plan of its subscription,
+// empty for a camera without one
+type CameraPlan struct {
+	Camera        string  `json:"camera_uuid"`
+	Title         string  `json:"title"`
+	Plan          string  `json:"plan,omitempty"`
+	CVR           bool    `json:"cvr"`
+	RecordingMax  float64 `json:"hours_of_recording_max"`
+	IsTrial       bool    `json:"is_trial"`
+	TrialDaysLeft int64   `json:"trial_days_left,omitempty"`
+}
+
+// accountUser is the record users.get_current returns
+type accountUser struct {
+	Id       int64  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+}
+
+// The Account method fetches the user and subscriptions of the account and
+// sums up the recording plan of each owned camera. Accounts on backends
+// without subscriptions.list have none listed.
+func (d *Dropcam) Account(ctx context.Context) (*Account, error) {
+
+	response, err := d.getRequest(ctx, d.UsersGetCurrent, url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("Get Account Failed: %w", err)
+	}
+	users, err := decodeEnvelope[[]accountUser]("Get Account", response)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New("Get Account returned no user")
+	}
+	u := users[0]
+	a := &Account{Id: u.Id, Username: u.Username, Email: u.Email, Name: u.Name}
+
+	response, err = d.getRequest(ctx, d.SubscriptionsList, url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("Get Subscriptions Failed: %w", err)
+	}
+	subs, err := decodeEnvelope[[]Subscription]("Get Subscriptions", response)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	a.Subscriptions = subs
+
+	c, err := d.Cameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plans := make(map[string]string)
+	for _, s := range subs {
+		plans[s.Camera] = s.Plan
+	}
+	for _, o := range c.Cam {
+		a.Cameras = append(a.Cameras, CameraPlan{
+			Camera:        o.Uuid,
+			Title:         o.Title,
+			Plan:          plans[o.Uuid],
+			CVR:           o.HasCVR(),
+			RecordingMax:  o.HoursOfRecordingMax,
+			IsTrial:       o.IsTrialMode,
+			TrialDaysLeft: o.TrialDaysLeft,
+		})
+	}
+	return a, nil
+}
@@ -0,0 +1,209 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The APIHandler type is an http.Handler exposing the cameras as a small
+// JSON API, so home-automation stacks in other languages can use them
+// without linking this package:
+//
+//	GET  /cameras                           the cameras, as CameraJSON
+//	GET  /cameras/<uuid>                    one camera
+//	GET  /cameras/<uuid>/snapshot[?width=]  the current JPEG, Width wide by default
+//	GET  /cameras/<uuid>/events[?since=&type=&important=]
+//	                                        events as EventJSON, the last day by default
+//	POST /cameras/<uuid>/streaming?enabled=true|false
+//	                                        turns the camera on or off and returns it
+//	GET  /openapi.json                      the OpenAPI 3 document of the above
+//
+// since is a duration back from now, like "2h", or an RFC 3339 time; type is
+// a comma-separated list of event types. Errors are returned as
+// {"error": "..."}. Guard, which is required, must grant the read scope for
+// GET requests and the control scope for POST ones, and only the cameras of
+// the caller's principal are listed or reachable. The OpenAPI document is
+// served to anyone, as it holds nothing about the account.
+type APIHandler struct {
+	Cameras *Cameras
+	Guard   Guard
+	Width   int
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Path == "/openapi.json" && r.Method == "GET" {
+		apiReply(w, OpenAPI())
+		return
+	}
+	if h.Guard == nil {
+		apiError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	scope := ScopeRead
+	if r.Method != "GET" && r.Method != "HEAD" {
+		scope = ScopeControl
+	}
+	Protect(h.Guard, scope, http.HandlerFunc(h.serve)).ServeHTTP(w, r)
+}
+
+func (h *APIHandler) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "cameras" || len(parts) > 3 {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	p := RequestPrincipal(r)
+	if len(parts) == 1 {
+		if r.Method != "GET" {
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		cams := []CameraJSON{}
+		for _, cj := range h.Cameras.JSON() {
+			if p == nil || p.AllowsCamera(cj.Uuid) {
+				cams = append(cams, cj)
+			}
+		}
+		apiReply(w, cams)
+		return
+	}
+
+	if p != nil && !p.AllowsCamera(parts[1]) {
+		apiError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	o := h.Cameras.findCamera(parts[1])
+	if o == nil {
+		apiError(w, http.StatusNotFound, "unknown camera")
+		return
+	}
+
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	method := "GET"
+	if action == "streaming" {
+		method = "POST"
+	}
+	if r.Method != method {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch action {
+	case "":
+		apiReply(w, cameraJSON(o, h.Cameras.isShared(o.Uuid)))
+	case "snapshot":
+		h.snapshot(w, r, o)
+	case "events":
+		h.events(w, r, o)
+	case "streaming":
+		h.streaming(w, r, o)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *APIHandler) snapshot(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	width := h.Width
+	if width <= 0 {
+		width = 720
+	}
+	if s := r.FormValue("width"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			apiError(w, http.StatusBadRequest, "width must be a positive number")
+			return
+		}
+		width = n
+	}
+	img, err := h.Cameras.getImage(r.Context(), o, width, time.Time{})
+	if err != nil {
+		apiError(w, apiStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(img)
+}
+
+func (h *APIHandler) events(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	q := EventQuery{Start: time.Now().Add(-24 * time.Hour)}
+	if s := r.FormValue("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			q.Start = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			q.Start = t
+		} else {
+			apiError(w, http.StatusBadRequest, "since takes a duration like 1h or an RFC 3339 time")
+			return
+		}
+	}
+	if s := r.FormValue("type"); s != "" {
+		q.Types = strings.Split(s, ",")
+	}
+	q.Important, _ = strconv.ParseBool(r.FormValue("important"))
+
+	events, err := h.Cameras.QueryEvents(r.Context(), o, q)
+	if err != nil {
+		apiError(w, apiStatus(err), err.Error())
+		return
+	}
+	apiReply(w, events.JSON())
+}
+
+func (h *APIHandler) streaming(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	on, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "enabled must be true or false")
+		return
+	}
+	if on {
+		_, err = h.Cameras.EnableStreaming(r.Context(), o)
+	} else {
+		_, err = h.Cameras.DisableStreaming(r.Context(), o)
+	}
+	if err != nil {
+		apiError(w, apiStatus(err), err.Error())
+		return
+	}
+	apiReply(w, cameraJSON(o, h.Cameras.isShared(o.Uuid)))
+}
+
+// apiStatus returns the HTTP status reporting err to API callers
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrCameraOffline), errors.Is(err, ErrNoCVR), errors.Is(err, ErrUnsupportedCapability):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadGateway
+}
+
+func apiReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
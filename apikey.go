@@ -0,0 +1,178 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The APIKey type describes one key allowed to use the server endpoints.
+// Cameras, if not empty, is the allowlist of camera uuids the key may address.
+// Only a hash of the key's token is kept.
+type APIKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Scopes  []string  `json:"scopes"`
+	Cameras []string  `json:"cameras,omitempty"`
+	Created time.Time `json:"created"`
+	Revoked bool      `json:"revoked,omitempty"`
+}
+
+func (k *APIKey) principal() *Principal {
+	return &Principal{Name: "key:" + k.Name, Scopes: k.Scopes, Cameras: k.Cameras}
+}
+
+// The APIKeys type manages the keys accepted by the server endpoints. When Path
+// is set, every change is saved there with owner-only permissions.
+type APIKeys struct {
+	Path string
+
+	mu   sync.Mutex
+	keys []*APIKey
+}
+
+// LoadAPIKeys reads keys saved at path; a missing file yields an empty set
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	k := &APIKeys{Path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &k.keys); err != nil {
+		return nil, errors.New("Failed to read API keys: " + err.Error())
+	}
+	return k, nil
+}
+
+func (k *APIKeys) save() error {
+	if k.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(k.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(k.Path, data, 0600)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create adds a key with the given scopes and camera allowlist and returns its
+// token. The token is shown only here; it cannot be recovered later.
+func (k *APIKeys) Create(name string, scopes []string, cameras []string) (string, *APIKey, error) {
+
+	for _, s := range scopes {
+		if scopeRank[s] == 0 {
+			return "", nil, errors.New("Unknown API key scope: " + s)
+		}
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(16)
+	if err != nil {
+		return "", nil, err
+	}
+	token := "dck_" + id + "_" + secret
+
+	key := &APIKey{
+		ID:      id,
+		Name:    name,
+		Hash:    hashToken(token),
+		Scopes:  scopes,
+		Cameras: cameras,
+		Created: time.Now(),
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append(k.keys, key)
+	if err := k.save(); err != nil {
+		return "", nil, err
+	}
+	return token, key, nil
+}
+
+// Revoke disables the key with the given id
+func (k *APIKeys) Revoke(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.keys {
+		if key.ID == id {
+			key.Revoked = true
+			return k.save()
+		}
+	}
+	return errors.New("Unknown API key: " + id)
+}
+
+// List returns the keys sorted by creation time
+func (k *APIKeys) List() []APIKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	out := make([]APIKey, len(k.keys))
+	for i, key := range k.keys {
+		out[i] = *key
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// Lookup returns the live key matching token, or nil
+func (k *APIKeys) Lookup(token string) *APIKey {
+	if token == "" {
+		return nil
+	}
+	h := hashToken(token)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.keys {
+		if key.Hash == h && !key.Revoked {
+			return key
+		}
+	}
+	return nil
+}
+
+// Authorize implements Guard for tokens passed as "Authorization: Bearer <token>"
+func (k *APIKeys) Authorize(r *http.Request) (*Principal, bool) {
+	key := k.Lookup(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if key == nil {
+		return nil, false
+	}
+	return key.principal(), true
+}
+
+// Middleware wraps h so that requests need a live key with the given scope
+func (k *APIKeys) Middleware(scope string, h http.Handler) http.Handler {
+	return Protect(k, scope, h)
+}
@@ -0,0 +1,211 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Archive formats
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ManifestName is the name of the index stored at the start of every archive
+const ManifestName = "manifest.json"
+
+// The ArchiveEntry type describes one file in an archive
+type ArchiveEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// The ArchiveManifest type is the index written into every daily archive
+type ArchiveManifest struct {
+	Camera  string         `json:"camera_uuid"`
+	Day     string         `json:"day"`
+	Created time.Time      `json:"created"`
+	Files   []ArchiveEntry `json:"files"`
+}
+
+// The Archive type packages a day's snapshots and clips for one camera into a
+// single compressed file and stores it as "<Camera>/<yyyy-mm-dd>.<Format>"
+type Archive struct {
+	Dir     string
+	Camera  string
+	Format  string
+	Storage Storage
+}
+
+// Day bundles every file in a.Dir last modified on day (in day's location)
+// together with a manifest, and returns the manifest. Days with no captures
+// produce no archive and a nil manifest.
+func (a *Archive) Day(day time.Time) (*ArchiveManifest, error) {
+
+	format := a.Format
+	if format == "" {
+		format = ArchiveTarGz
+	}
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return nil, errors.New("Unknown archive format: " + format)
+	}
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	manifest := &ArchiveManifest{
+		Camera:  a.Camera,
+		Day:     start.Format("2006-01-02"),
+		Created: time.Now(),
+	}
+
+	err := filepath.Walk(a.Dir, func(fn string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.ModTime().Before(start) || !fi.ModTime().Before(end) {
+			return nil
+		}
+		sum, err := hashFile(fn)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(a.Dir, fn)
+		manifest.Files = append(manifest.Files, ArchiveEntry{
+			Name:    filepath.ToSlash(rel),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			SHA256:  sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Files) == 0 {
+		return nil, nil
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Name < manifest.Files[j].Name
+	})
+
+	pr, pw := io.Pipe()
+	go func() {
+		if format == ArchiveZip {
+			pw.CloseWithError(a.writeZip(pw, manifest))
+		} else {
+			pw.CloseWithError(a.writeTarGz(pw, manifest))
+		}
+	}()
+
+	key := a.Camera + "/" + manifest.Day + "." + format
+	err = a.Storage.Put(key, pr)
+	pr.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	Dbg("archived %d files to %s\n", len(manifest.Files), key)
+	return manifest, nil
+}
+
+func (a *Archive) writeTarGz(w io.Writer, m *ArchiveManifest) error {
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	idx, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: ManifestName, Mode: 0644, Size: int64(len(idx)), ModTime: m.Created}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(idx); err != nil {
+		return err
+	}
+
+	for _, e := range m.Files {
+		hdr := &tar.Header{Name: e.Name, Mode: 0644, Size: e.Size, ModTime: e.ModTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := copyFile(tw, filepath.Join(a.Dir, filepath.FromSlash(e.Name))); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (a *Archive) writeZip(w io.Writer, m *ArchiveManifest) error {
+
+	zw := zip.NewWriter(w)
+
+	idx, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: m.Created})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(idx); err != nil {
+		return err
+	}
+
+	for _, e := range m.Files {
+		// JPEG and MP4 are already compressed
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: zip.Store, Modified: e.ModTime})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(f, filepath.Join(a.Dir, filepath.FromSlash(e.Name))); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func copyFile(w io.Writer, fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func hashFile(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,467 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Token endpoints used when NestOAuth or GoogleOAuth leave TokenURL empty
+const (
+	NestTokenURL   = "https://api.home.nest.com/oauth2/access_token"
+	GoogleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// tokenSlack is how long before expiry an access token is refreshed
+const tokenSlack = time.Minute
+
+// The Authenticator interface obtains credentials for a Dropcam session and
+// attaches them to each request. Login is called by Init; Authorize is called
+// for every request, including retries, and may refresh expired credentials.
+type Authenticator interface {
+	Login(ctx context.Context, d *Dropcam) error
+	Authorize(ctx context.Context, d *Dropcam, req *http.Request) error
+}
+
+// The CredentialsFunc type supplies a username and password when they are
+// needed to log in
+type CredentialsFunc func(ctx context.Context) (username, password string, err error)
+
+// ErrNoCredentials is wrapped by the errors of a CredentialsFunc that has no
+// credentials to give, as opposed to failing to read them
+var ErrNoCredentials = errors.New("No Credentials")
+
+// EnvCredentials returns a CredentialsFunc reading the username and password
+// from the environment variables userVar and passVar at every login
+func EnvCredentials(userVar, passVar string) CredentialsFunc {
+	return func(ctx context.Context) (string, string, error) {
+		u, p := os.Getenv(userVar), os.Getenv(passVar)
+		if u == "" || p == "" {
+			return "", "", fmt.Errorf("%w: need to set both %s and %s", ErrNoCredentials, userVar, passVar)
+		}
+		return u, p, nil
+	}
+}
+
+// ChainCredentials returns a CredentialsFunc asking each of providers in
+// turn, such as a keychain, a file, the environment and a prompt, and
+// returning the first credentials given. Providers failing with
+// ErrNoCredentials are passed over; any other error ends the search, so a
+// locked keychain is reported rather than hidden by a later prompt.
+func ChainCredentials(providers ...CredentialsFunc) CredentialsFunc {
+	return func(ctx context.Context) (string, string, error) {
+		var skipped []string
+		for _, p := range providers {
+			u, pw, err := p(ctx)
+			if err == nil {
+				return u, pw, nil
+			}
+			if !errors.Is(err, ErrNoCredentials) {
+				return "", "", err
+			}
+			skipped = append(skipped, strings.TrimPrefix(err.Error(), ErrNoCredentials.Error()+": "))
+		}
+		if len(skipped) == 0 {
+			return "", "", ErrNoCredentials
+		}
+		return "", "", fmt.Errorf("%w: %s", ErrNoCredentials, strings.Join(skipped, "; "))
+	}
+}
+
+// The CookieAuth type is the original login.login flow: the username and
+// password are posted as a form, never put in a URL, and exchanged for a
+// session cookie kept in Dropcam.Cookie. When the servers answer with a
+// challenge instead, Dropcam.Challenges is asked to solve it and the login is
+// sent again with its answer.
+//
+// Password is zeroed once a login succeeds, so without Credentials an expired
+// session cannot be renewed and the requests finding it expired fail with
+// ErrNoCredentials. Set Credentials instead and it is asked for the username
+// and password at every login, so they are only held while logging in, e.g.
+// when they come from a keychain or secret manager, and relogin keeps working.
+//
+// Accounts with two-factor authentication are asked for a one-time code at
+// login, which OTP supplies; without it the challenge goes to
+// Dropcam.Challenges like any other. With TrustDevice set the servers are
+// asked to trust this client once the code is accepted, and the token they
+// issue is kept in TrustedDevice, and in Dropcam.Sessions when set, so later
+// logins need no code.
+type CookieAuth struct {
+	Username    string
+	Password    string
+	Credentials CredentialsFunc
+
+	OTP           OTPFunc
+	TrustDevice   bool
+	TrustedDevice string
+
+	zeroed bool // Password was cleared by a login
+}
+
+// Login implements Authenticator
+func (a *CookieAuth) Login(ctx context.Context, d *Dropcam) error {
+
+	username, password := a.Username, a.Password
+	if a.Credentials != nil {
+		var err error
+		if username, password, err = a.Credentials(ctx); err != nil {
+			return fmt.Errorf("Login Failed: %w", err)
+		}
+	} else if a.zeroed && password == "" {
+		return fmt.Errorf("Login Failed: %w: the password was zeroed after the last login, set Credentials to log in again", ErrNoCredentials)
+	}
+
+	v := url.Values{}
+	v.Set("username", username)
+	v.Add("password", password)
+	defer v.Del("password")
+	if a.TrustedDevice != "" {
+		v.Set(trustedDeviceField, a.TrustedDevice)
+	}
+
+	var response *http.Response
+	for round := 0; ; round++ {
+		d.setCookie("")
+		var err error
+		response, err = d.formRequest(ctx, d.LoginPath, v)
+		if err != nil {
+			return fmt.Errorf("Login Request Failed: %w", err)
+		}
+		defer response.Body.Close()
+
+		c := detectChallenge(response, d.LoginPath)
+		if c == nil {
+			break
+		}
+		var answer url.Values
+		if c.Kind == ChallengeTwoFactor && a.OTP != nil {
+			answer, err = a.twoFactor(ctx, c, round)
+		} else {
+			answer, err = d.solveChallenge(ctx, c, round)
+		}
+		if err != nil {
+			return err
+		}
+		for k, vs := range answer {
+			v[k] = vs
+		}
+	}
+
+	cookie := response.Header.Get("Set-Cookie")
+	d.setCookie(cookie)
+	if cookie == "" {
+		body, _ := ioutil.ReadAll(response.Body)
+		e := newAPIError("Login", response, body)
+		if e.Err == nil {
+			e.Err = ErrAuthFailed
+		}
+		return e
+	}
+	if token := trustedDevice(response); token != "" && a.TrustDevice {
+		a.TrustedDevice = token
+	}
+	if a.Credentials == nil {
+		a.Password, a.zeroed = "", true
+	}
+	Dbg("logged in, session cookie set\n")
+	return nil
+}
+
+// formRequest posts the form v to url, keeping its values out of the URL
+func (d *Dropcam) formRequest(ctx context.Context, url string, v url.Values) (*http.Response, error) {
+
+	body := v.Encode()
+	return d.send(ctx, "", func() (*http.Request, error) {
+		req, err := d.newRequest(ctx, "POST", url, "", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if err := d.authorize(ctx, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}, 3)
+}
+
+// Authorize implements Authenticator
+func (a *CookieAuth) Authorize(ctx context.Context, d *Dropcam, req *http.Request) error {
+	if cookie := d.SessionCookie(); cookie != "" {
+		req.Header.Set("cookie", cookie)
+	}
+	return nil
+}
+
+// The OAuthToken type is an access token issued by an OAuth2 token endpoint
+type OAuthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid reports whether the token can be used for at least another minute
+func (t *OAuthToken) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Until(t.Expiry) > tokenSlack
+}
+
+// fetchToken posts form to the token endpoint at tokenURL
+func fetchToken(ctx context.Context, d *Dropcam, tokenURL string, form url.Values) (*OAuthToken, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Token Request Failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return nil, newAPIError("Token Request", resp, body)
+	}
+	if reply.Error != "" || reply.AccessToken == "" {
+		return nil, &APIError{
+			Op:          "Token Request",
+			HTTPStatus:  resp.StatusCode,
+			Description: reply.Error,
+			Detail:      reply.ErrorDescription,
+			Err:         ErrAuthFailed,
+		}
+	}
+
+	t := &OAuthToken{
+		AccessToken:  reply.AccessToken,
+		RefreshToken: reply.RefreshToken,
+		TokenType:    reply.TokenType,
+	}
+	if reply.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second)
+	}
+	return t, nil
+}
+
+// The NestOAuth type authenticates accounts migrated to Nest. Login exchanges
+// the authorization Code for an access token unless Token already holds a valid
+// one. Nest tokens are long-lived and cannot be refreshed; once expired the user
+// must authorize again. The token is sent to nexusapi as "Authorization: Basic".
+type NestOAuth struct {
+	ClientID     string
+	ClientSecret string
+	Code         string
+	TokenURL     string
+	Token        *OAuthToken
+
+	mu sync.Mutex
+}
+
+// Login implements Authenticator
+func (a *NestOAuth) Login(ctx context.Context, d *Dropcam) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Token.Valid() {
+		return nil
+	}
+	if a.Code == "" {
+		return errors.New("NestOAuth needs an authorization Code or a valid Token")
+	}
+
+	tokenURL := a.TokenURL
+	if tokenURL == "" {
+		tokenURL = NestTokenURL
+	}
+	t, err := fetchToken(ctx, d, tokenURL, url.Values{
+		"client_id":     {a.ClientID},
+		"client_secret": {a.ClientSecret},
+		"code":          {a.Code},
+		"grant_type":    {"authorization_code"},
+	})
+	if err != nil {
+		return err
+	}
+	// an authorization code can only be used once
+	a.Code = ""
+	a.Token = t
+	return nil
+}
+
+// Authorize implements Authenticator
+func (a *NestOAuth) Authorize(ctx context.Context, d *Dropcam, req *http.Request) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.Token.Valid() {
+		return errors.New("Nest token expired, authorize again")
+	}
+	req.Header.Set("Authorization", "Basic "+a.Token.AccessToken)
+	return nil
+}
+
+// The GoogleOAuth type authenticates accounts migrated to Google using a
+// refresh token. Access tokens are refreshed shortly before they expire and
+// sent as "Authorization: Bearer".
+type GoogleOAuth struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	TokenURL     string
+	Token        *OAuthToken
+
+	mu sync.Mutex
+}
+
+// Login implements Authenticator. It always obtains a fresh access token.
+func (a *GoogleOAuth) Login(ctx context.Context, d *Dropcam) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Token = nil
+	return a.refresh(ctx, d)
+}
+
+// Authorize implements Authenticator
+func (a *GoogleOAuth) Authorize(ctx context.Context, d *Dropcam, req *http.Request) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.refresh(ctx, d); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token.AccessToken)
+	return nil
+}
+
+// refresh obtains a new access token if the current one is about to expire
+func (a *GoogleOAuth) refresh(ctx context.Context, d *Dropcam) error {
+
+	if a.Token.Valid() {
+		return nil
+	}
+	if a.RefreshToken == "" {
+		return errors.New("GoogleOAuth needs a RefreshToken")
+	}
+
+	tokenURL := a.TokenURL
+	if tokenURL == "" {
+		tokenURL = GoogleTokenURL
+	}
+	t, err := fetchToken(ctx, d, tokenURL, url.Values{
+		"client_id":     {a.ClientID},
+		"client_secret": {a.ClientSecret},
+		"refresh_token": {a.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	})
+	if err != nil {
+		return err
+	}
+	// Google may rotate the refresh token
+	if t.RefreshToken != "" {
+		a.RefreshToken = t.RefreshToken
+	}
+	a.Token = t
+	return nil
+}
+
+// The ReloginPolicy type controls how a Dropcam recovers when the server
+// rejects its session with 401 or 403, typically after the cookie or token
+// expired mid-run. The session is renewed with Auth.Login and the request
+// repeated up to Attempts times, once when Attempts is zero.
+type ReloginPolicy struct {
+	Disabled bool
+	Attempts int
+}
+
+type noReloginContext struct{}
+
+// allow reports whether another re-login may be attempted after done so far.
+// Requests made while logging in never trigger a re-login themselves.
+func (p ReloginPolicy) allow(ctx context.Context, done int) bool {
+	if p.Disabled || ctx.Value(noReloginContext{}) != nil {
+		return false
+	}
+	attempts := p.Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	return done < attempts
+}
+
+// sessionRejected reports whether resp says the session is not valid
+func sessionRejected(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// sessionGen returns the current session generation
+func (d *Dropcam) sessionGen() uint64 {
+	return atomic.LoadUint64(&d.authGen)
+}
+
+// relogin renews the session that was current at generation gen and returns
+// the new generation. If another request already renewed it, it only returns
+// the newer generation so concurrent failures cause a single login.
+func (d *Dropcam) relogin(ctx context.Context, gen uint64) (uint64, error) {
+
+	d.authMu.Lock()
+	defer d.authMu.Unlock()
+
+	if cur := d.sessionGen(); cur != gen {
+		return cur, nil
+	}
+
+	// processes sharing a session store take turns, and one that finds a
+	// session newer than its own uses it rather than logging in again
+	unlock, err := d.lockSession(ctx)
+	if err != nil {
+		return gen, fmt.Errorf("Re-login Failed: %w", err)
+	}
+	defer unlock()
+	if d.resumeNewerSession() {
+		Dbg("session rejected, using the one saved by another process\n")
+		return atomic.AddUint64(&d.authGen, 1), nil
+	}
+
+	Dbg("session rejected, logging in again\n")
+	if err := d.Auth.Login(context.WithValue(ctx, noReloginContext{}, true), d); err != nil {
+		err = fmt.Errorf("Re-login Failed: %w", err)
+		d.stats.sessionFailed(err)
+		return gen, err
+	}
+	d.saveSession()
+	return atomic.AddUint64(&d.authGen, 1), nil
+}
@@ -0,0 +1,381 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Jobs bandwidth is accounted to
+const (
+	JobSnapshot = "snapshot"
+	JobClip     = "clip"
+	JobLive     = "live"
+	JobAPI      = "api"
+)
+
+// DefaultBandwidthDays is how many days of daily usage Bandwidth keeps
+const DefaultBandwidthDays = 62
+
+// The BandwidthUsage type is the bytes downloaded for one camera and job in
+// a period, a day as "2006-01-02" or a month as "2006-01". Requests not
+// about a particular camera have an empty Camera.
+type BandwidthUsage struct {
+	Period string `json:"period,omitempty"`
+	Camera string `json:"camera,omitempty"`
+	Job    string `json:"job"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type usageKey struct {
+	camera, job string
+}
+
+type usageTable map[usageKey]int64
+
+// The Bandwidth type accounts the bytes the client downloads, per camera and
+// per job, rolled up by day and by month in Location, time.Local when nil.
+// Set as Dropcam.Bandwidth it counts every response body: snapshots, clips,
+// live streams and the other API calls. Days older than Days, by default
+// DefaultBandwidthDays, are dropped; months are kept.
+//
+// When Path is set, LoadBandwidth and Save keep the counts between runs.
+// Served over HTTP, it writes the counts as Prometheus metrics to callers
+// granted the read scope by Guard, if set.
+type Bandwidth struct {
+	Location *time.Location
+	Days     int
+	Path     string
+	Guard    Guard
+
+	mu     sync.Mutex
+	days   map[string]usageTable
+	months map[string]usageTable
+	total  usageTable
+}
+
+type bandwidthFile struct {
+	Days   []BandwidthUsage `json:"days"`
+	Months []BandwidthUsage `json:"months"`
+	Total  []BandwidthUsage `json:"total"`
+}
+
+// LoadBandwidth reads counts saved at path; a missing file yields empty counts
+func LoadBandwidth(path string) (*Bandwidth, error) {
+
+	b := &Bandwidth{Path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f bandwidthFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New("Failed to read bandwidth usage: " + err.Error())
+	}
+
+	b.init()
+	for _, u := range f.Days {
+		b.table(b.days, u.Period)[usageKey{u.Camera, u.Job}] += u.Bytes
+	}
+	for _, u := range f.Months {
+		b.table(b.months, u.Period)[usageKey{u.Camera, u.Job}] += u.Bytes
+	}
+	for _, u := range f.Total {
+		b.total[usageKey{u.Camera, u.Job}] += u.Bytes
+	}
+	return b, nil
+}
+
+// Save writes the counts to Path
+func (b *Bandwidth) Save() error {
+
+	if b.Path == "" {
+		return nil
+	}
+	b.mu.Lock()
+	f := bandwidthFile{Total: usageList("", b.total)}
+	for period, t := range b.days {
+		f.Days = append(f.Days, usageList(period, t)...)
+	}
+	for period, t := range b.months {
+		f.Months = append(f.Months, usageList(period, t)...)
+	}
+	b.mu.Unlock()
+	sortUsage(f.Days)
+	sortUsage(f.Months)
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return (DirStorage{Root: filepath.Dir(b.Path)}).Put(filepath.Base(b.Path), bytes.NewReader(data))
+}
+
+func (b *Bandwidth) init() {
+	if b.total == nil {
+		b.days = make(map[string]usageTable)
+		b.months = make(map[string]usageTable)
+		b.total = make(usageTable)
+	}
+}
+
+func (b *Bandwidth) table(tables map[string]usageTable, period string) usageTable {
+	t := tables[period]
+	if t == nil {
+		t = make(usageTable)
+		tables[period] = t
+	}
+	return t
+}
+
+func (b *Bandwidth) location() *time.Location {
+	if b.Location != nil {
+		return b.Location
+	}
+	return time.Local
+}
+
+// Add counts n bytes downloaded for camera by job
+func (b *Bandwidth) Add(camera, job string, n int64) {
+
+	if n <= 0 {
+		return
+	}
+	now := time.Now().In(b.location())
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	k := usageKey{camera, job}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.init()
+	if b.days[day] == nil {
+		b.prune(now)
+	}
+	b.table(b.days, day)[k] += n
+	b.table(b.months, month)[k] += n
+	b.total[k] += n
+}
+
+// prune drops the days that are no longer kept
+func (b *Bandwidth) prune(now time.Time) {
+	keep := b.Days
+	if keep <= 0 {
+		keep = DefaultBandwidthDays
+	}
+	oldest := now.AddDate(0, 0, -keep).Format("2006-01-02")
+	for day := range b.days {
+		if day <= oldest {
+			delete(b.days, day)
+		}
+	}
+}
+
+// Daily returns the usage on the day of t, largest first
+func (b *Bandwidth) Daily(t time.Time) []BandwidthUsage {
+	return b.usage(b.days, t.In(b.location()).Format("2006-01-02"))
+}
+
+// Monthly returns the usage in the month of t, largest first
+func (b *Bandwidth) Monthly(t time.Time) []BandwidthUsage {
+	return b.usage(b.months, t.In(b.location()).Format("2006-01"))
+}
+
+// Total returns the usage since counting started, largest first
+func (b *Bandwidth) Total() []BandwidthUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sortUsage(usageList("", b.total))
+}
+
+func (b *Bandwidth) usage(tables map[string]usageTable, period string) []BandwidthUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sortUsage(usageList(period, tables[period]))
+}
+
+func usageList(period string, t usageTable) []BandwidthUsage {
+	list := make([]BandwidthUsage, 0, len(t))
+	for k, n := range t {
+		list = append(list, BandwidthUsage{Period: period, Camera: k.camera, Job: k.job, Bytes: n})
+	}
+	return list
+}
+
+// sortUsage orders usage by period, then by bytes, largest first
+func sortUsage(list []BandwidthUsage) []BandwidthUsage {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Camera != b.Camera {
+			return a.Camera < b.Camera
+		}
+		return a.Job < b.Job
+	})
+	return list
+}
+
+// WriteBandwidthReport writes usage to w as a table, per camera and job with
+// a total. Names maps camera uuids to titles.
+func WriteBandwidthReport(w io.Writer, usage []BandwidthUsage, names map[string]string) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CAMERA\tJOB\tBYTES\t\n")
+	var total int64
+	for _, u := range usage {
+		camera := u.Camera
+		if n, ok := names[camera]; ok {
+			camera = n
+		}
+		if camera == "" {
+			camera = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t\n", camera, u.Job, FormatBytes(u.Bytes))
+		total += u.Bytes
+	}
+	fmt.Fprintf(tw, "total\t\t%s\t\n", FormatBytes(total))
+	return tw.Flush()
+}
+
+// FormatBytes formats n bytes in binary units, e.g. "1.5 MiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func (b *Bandwidth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if b.Guard != nil {
+		Protect(b.Guard, ScopeRead, http.HandlerFunc(b.serve)).ServeHTTP(w, r)
+		return
+	}
+	b.serve(w, r)
+}
+
+func (b *Bandwidth) serve(w http.ResponseWriter, r *http.Request) {
+
+	p := RequestPrincipal(r)
+	now := time.Now()
+	series := []struct {
+		name, help, typ string
+		usage           []BandwidthUsage
+	}{
+		{"dropcam_bandwidth_bytes_total", "Bytes downloaded since counting started.", "counter", b.Total()},
+		{"dropcam_bandwidth_today_bytes", "Bytes downloaded today.", "gauge", b.Daily(now)},
+		{"dropcam_bandwidth_month_bytes", "Bytes downloaded this month.", "gauge", b.Monthly(now)},
+	}
+
+	var buf bytes.Buffer
+	for _, s := range series {
+		writeMetricHeader(&buf, s.name, s.help, s.typ)
+		for _, u := range s.usage {
+			if p != nil && u.Camera != "" && !p.AllowsCamera(u.Camera) {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s{camera_uuid=\"%s\",job=\"%s\"} %d\n", s.name, escapeLabel(u.Camera), escapeLabel(u.Job), u.Bytes)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+type jobContext struct{}
+
+// withJob marks the requests made with ctx as made for job
+func withJob(ctx context.Context, job string) context.Context {
+	return context.WithValue(ctx, jobContext{}, job)
+}
+
+// countBody has resp's body counted to the camera the request names by
+// uuid, for the job marked on ctx
+func (b *Bandwidth) countBody(ctx context.Context, req *http.Request, resp *http.Response) {
+	job, _ := ctx.Value(jobContext{}).(string)
+	if job == "" {
+		job = JobAPI
+	}
+	camera := req.URL.Query().Get("uuid")
+	resp.Body = &countingReader{ReadCloser: resp.Body, count: func(n int64) { b.Add(camera, job, n) }}
+}
+
+// countingReader reports the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	count func(int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count(int64(n))
+	return n, err
+}
+
+// countedStream counts a live stream to camera
+func countedStream(b *Bandwidth, camera string, rc io.ReadCloser) io.ReadCloser {
+	if b == nil {
+		return rc
+	}
+	return &countingReader{ReadCloser: rc, count: func(n int64) { b.Add(camera, JobLive, n) }}
+}
+
+// names returns the titles of the cameras by uuid
+func (c *Cameras) names() map[string]string {
+	names := make(map[string]string)
+	for _, o := range c.all() {
+		names[o.Uuid] = o.Title
+	}
+	return names
+}
+
+// The BandwidthReport method writes the usage of the day or month of t, as
+// period is "day" or "month", or since counting started for "total", to w
+// with camera titles
+func (c *Cameras) BandwidthReport(w io.Writer, period string, t time.Time) error {
+
+	b := c.Dropcam.Bandwidth
+	if b == nil {
+		return errors.New("Bandwidth accounting is not enabled")
+	}
+	var usage []BandwidthUsage
+	switch strings.ToLower(period) {
+	case "day":
+		usage = b.Daily(t)
+	case "month":
+		usage = b.Monthly(t)
+	case "total":
+		usage = b.Total()
+	default:
+		return errors.New("Unknown bandwidth report period: " + period)
+	}
+	return WriteBandwidthReport(w, usage, c.names())
+}
@@ -0,0 +1,108 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBulkConcurrency is how many cameras SetPropertiesBulk changes at once
+const DefaultBulkConcurrency = 8
+
+// A CameraFilter selects cameras for bulk operations
+type CameraFilter func(o *Owned) bool
+
+// AllCameras is a CameraFilter that selects every camera
+func AllCameras(o *Owned) bool {
+	return true
+}
+
+// InStructure returns a CameraFilter selecting the cameras of the structure
+// with ID or name structure
+func InStructure(structure string) CameraFilter {
+	return func(o *Owned) bool {
+		return o.StructureID() == structure || (structure != "" && strings.EqualFold(o.LocationName(), structure))
+	}
+}
+
+// TitleMatches returns a CameraFilter selecting the cameras whose title re matches
+func TitleMatches(re *regexp.Regexp) CameraFilter {
+	return func(o *Owned) bool {
+		return re.MatchString(o.Title)
+	}
+}
+
+// The BulkResult type reports the outcome of a bulk operation on one camera.
+// Failed maps each property that could not be set to its error.
+type BulkResult struct {
+	Camera Owned
+	Failed map[string]error
+}
+
+// OK reports whether every change was applied to the camera
+func (r *BulkResult) OK() bool {
+	return len(r.Failed) == 0
+}
+
+func (r *BulkResult) String() string {
+	if r.OK() {
+		return r.Camera.Title + ": ok"
+	}
+	return fmt.Sprintf("%s: %d failed %v", r.Camera.Title, len(r.Failed), r.Failed)
+}
+
+// The ApplyToAll method sets properties on every camera selected by filter,
+// as SetPropertiesBulk does
+func (c *Cameras) ApplyToAll(ctx context.Context, filter CameraFilter, properties map[string]string) []BulkResult {
+	return c.SetPropertiesBulk(ctx, filter, properties)
+}
+
+// The SetPropertiesBulk method sets properties on every owned camera selected
+// by filter, e.g. AllCameras, InStructure or TitleMatches, changing up to
+// DefaultBulkConcurrency cameras at once. It returns one result per selected
+// camera in the order the cameras appear in c.Cam; a camera's properties are
+// set in name order and one failing does not stop the others.
+func (c *Cameras) SetPropertiesBulk(ctx context.Context, filter CameraFilter, properties map[string]string) []BulkResult {
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var selected []*Owned
+	for i := range c.Cam {
+		if filter == nil || filter(&c.Cam[i]) {
+			selected = append(selected, &c.Cam[i])
+		}
+	}
+
+	results := make([]BulkResult, len(selected))
+	sem := make(chan struct{}, DefaultBulkConcurrency)
+	var wg sync.WaitGroup
+	for i, o := range selected {
+		wg.Add(1)
+		go func(i int, o *Owned) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res := BulkResult{Camera: *o, Failed: make(map[string]error)}
+			for _, name := range names {
+				if _, err := c.SetProperties(ctx, o, name, properties[name]); err != nil {
+					res.Failed[name] = err
+				}
+			}
+			results[i] = res
+		}(i, o)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cameraCache is the camera list a Dropcam last fetched, kept for
+// CamerasTTL and to find the cameras added, removed or renamed since
+type cameraCache struct {
+	mu      sync.Mutex
+	cams    *Cameras
+	fetched time.Time
+	call    *cameraCall
+}
+
+// cameraCall is a fetch of the camera list shared by the RefreshCameras calls
+// made while it runs. It runs detached from their contexts, and is given up
+// once every caller has given up on it.
+type cameraCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	cams    *Cameras
+	err     error
+}
+
+// copyCameras returns a copy of c whose lists can be changed without
+// changing c's
+func copyCameras(c *Cameras) *Cameras {
+	return &Cameras{
+		Dropcam: c.Dropcam,
+		Cam:     append([]Owned(nil), c.Cam...),
+		Shared:  append([]Subscribed(nil), c.Shared...),
+	}
+}
+
+// cached returns a copy of the cached list if it is younger than ttl
+func (cc *cameraCache) cached(ttl time.Duration) (*Cameras, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if ttl <= 0 || cc.cams == nil || time.Since(cc.fetched) >= ttl {
+		return nil, false
+	}
+	return copyCameras(cc.cams), true
+}
+
+// invalidate makes the next call to Cameras fetch the list. The cached list
+// is kept to tell what changed.
+func (cc *cameraCache) invalidate() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.fetched = time.Time{}
+}
+
+// The RefreshCameras method fetches the camera list now, whatever
+// CamerasTTL, and caches it. Concurrent calls share one fetch, which goes on
+// while any of them still waits for it, so a caller giving up does not fail
+// the others. Cameras added, removed or renamed since the last fetch are
+// passed to OnCamerasChange.
+func (d *Dropcam) RefreshCameras(ctx context.Context) (*Cameras, error) {
+
+	cc := &d.cameras
+	cc.mu.Lock()
+	call := cc.call
+	if call == nil {
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &cameraCall{done: make(chan struct{}), cancel: cancel}
+		cc.call = call
+		go d.refreshCameras(fetchCtx, call)
+	}
+	call.waiters++
+	cc.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		cc.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody wants the list any more; later calls fetch it afresh
+			call.cancel()
+			if cc.call == call {
+				cc.call = nil
+			}
+		}
+		cc.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return copyCameras(call.cams), nil
+}
+
+// refreshCameras runs the shared fetch call
+func (d *Dropcam) refreshCameras(ctx context.Context, call *cameraCall) {
+
+	defer call.cancel()
+	call.cams, call.err = d.fetchCameras(ctx)
+
+	cc := &d.cameras
+	cc.mu.Lock()
+	if cc.call == call {
+		cc.call = nil
+	}
+	prev := cc.cams
+	if call.err == nil {
+		cc.cams, cc.fetched = call.cams, time.Now()
+	}
+	cc.mu.Unlock()
+
+	if call.err == nil {
+		d.camStats.sample(call.cams, time.Now())
+		if prev != nil && d.OnCamerasChange != nil {
+			for _, ch := range diffCameras(prev, call.cams) {
+				Dbg("cameras: %s\n", ch)
+				d.OnCamerasChange(ch)
+			}
+		}
+	}
+	close(call.done)
+}
+
+// The Refresh method fetches the camera list again, whatever CamerasTTL,
+// and replaces c.Cam and c.Shared with it, so pointers into them taken
+// before may no longer point at the same cameras
+func (c *Cameras) Refresh(ctx context.Context) error {
+
+	fresh, err := c.Dropcam.RefreshCameras(ctx)
+	if err != nil {
+		return err
+	}
+	c.Cam, c.Shared = fresh.Cam, fresh.Shared
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestRefreshCamerasSurvivesCancelledCaller(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+
+	// hold camera listings until released, so two refreshes overlap
+	release := make(chan struct{})
+	target, _ := url.Parse(s.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "cameras.get_visible") {
+			<-release
+		}
+		rp.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	d := s.Dropcam()
+	d.BaseURL, d.NexusURL = slow.URL, slow.URL
+	if _, err := d.Init(ctx, s.Username, s.Password); err != nil {
+		t.Fatal(err)
+	}
+
+	first, cancel := context.WithCancel(ctx)
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := d.RefreshCameras(first)
+		firstErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		c, err := d.RefreshCameras(ctx)
+		if err == nil && len(c.Cam) == 0 {
+			t.Error("refresh returned no cameras")
+		}
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the caller that started the fetch gives up; the other still waits
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Errorf("cancelled caller got %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("waiting caller failed with %v after another gave up", err)
+	}
+}
+
+func TestRefreshCamerasAbandoned(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c, err := s.Cameras(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Dropcam.RefreshCameras(done); err != context.Canceled {
+		t.Errorf("RefreshCameras with a done context: %v", err)
+	}
+	// a fetch nobody waits for is dropped rather than joined
+	if _, err := c.Dropcam.RefreshCameras(ctx); err != nil {
+		t.Errorf("RefreshCameras after an abandoned one: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+)
+
+// DefaultCamerasPageSize is the number of camera groups requested per page
+// when Dropcam.CamerasPageSize is zero
+const DefaultCamerasPageSize = 100
+
+// maxCameraPages bounds the pages a listing follows, in case a server keeps
+// reporting more
+const maxCameraPages = 1000
+
+// The CamerasIter type lists the cameras of an account a page at a time, so
+// accounts with many owned or shared cameras are listed completely without
+// holding every page at once. Each camera is returned once, even when it
+// appears in several groups or pages.
+//
+//	it := d.CamerasIter(ctx)
+//	for it.Next() {
+//		fmt.Println(it.Camera().Title, it.Shared())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type CamerasIter struct {
+	d    *Dropcam
+	ctx  context.Context
+	page int
+	more bool
+	seen map[string]bool
+
+	buf []pagedCamera
+	cur pagedCamera
+	err error
+}
+
+// pagedCamera is a camera read from a page, and whether it is shared
+type pagedCamera struct {
+	cam    *Owned
+	shared bool
+}
+
+// The CamerasIter method returns an iterator over the cameras of the account.
+// Cameras reads the whole list through one.
+func (d *Dropcam) CamerasIter(ctx context.Context) *CamerasIter {
+	return &CamerasIter{d: d, ctx: ctx, page: 1, more: true, seen: make(map[string]bool)}
+}
+
+// Next advances to the next camera, fetching the next page when needed. It
+// returns false at the end of the list or on an error; see Err.
+func (it *CamerasIter) Next() bool {
+
+	for len(it.buf) == 0 {
+		if it.err != nil || !it.more {
+			return false
+		}
+		if it.page > maxCameraPages {
+			it.err = fmt.Errorf("Camera list exceeds %d pages", maxCameraPages)
+			return false
+		}
+		cam, err := it.d.camerasPage(it.ctx, it.page)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		// a server ignoring the paging parameters repeats the first page
+		n := len(it.seen)
+		it.fill(cam)
+		it.more = cam.more(it.page) && len(it.seen) > n
+		it.page++
+	}
+	it.cur, it.buf = it.buf[0], it.buf[1:]
+	return true
+}
+
+// fill queues the cameras of a page not seen before. Cameras without a
+// structure of their own take that of the group they are listed in.
+func (it *CamerasIter) fill(cam *Cam) {
+
+	add := func(o *Owned, shared bool, group interface{}) {
+		if o.Uuid != "" && it.seen[o.Uuid] {
+			return
+		}
+		it.seen[o.Uuid] = true
+		if propertyString(o.NestStructureId) == "" && propertyString(group) != "" {
+			o.NestStructureId = group
+		}
+		it.buf = append(it.buf, pagedCamera{cam: o, shared: shared})
+	}
+
+	for _, items := range cam.Items {
+		for i := range items.Owned {
+			add(&items.Owned[i], false, items.NestStructureId)
+		}
+		for i := range items.Subscribed {
+			add(&items.Subscribed[i].Owned, true, items.NestStructureId)
+		}
+	}
+}
+
+// Camera returns the current camera
+func (it *CamerasIter) Camera() *Owned {
+	return it.cur.cam
+}
+
+// Shared reports whether the current camera is shared with the account
+// rather than owned by it
+func (it *CamerasIter) Shared() bool {
+	return it.cur.shared
+}
+
+// Err returns the error that ended the iteration, if any
+func (it *CamerasIter) Err() error {
+	return it.err
+}
+
+// more reports whether the servers have pages after page
+func (cam *Cam) more(page int) bool {
+	switch {
+	case cam.NextPage > 0:
+		return cam.NextPage > page
+	case cam.TotalPages > 0:
+		return page < cam.TotalPages
+	}
+	return cam.HasMore
+}
+
+// camerasPage fetches page of the camera list, counting from 1
+func (d *Dropcam) camerasPage(ctx context.Context, page int) (*Cam, error) {
+
+	if d.Auth == nil && d.SessionCookie() == "" {
+		return nil, errors.New("Not Logged In")
+	}
+
+	size := d.CamerasPageSize
+	if size <= 0 {
+		size = DefaultCamerasPageSize
+	}
+	v := url.Values{}
+	v.Set("group_cameras", "True")
+	v.Set("page", strconv.Itoa(page))
+	v.Set("page_size", strconv.Itoa(size))
+
+	response, err := d.getRequest(ctx, d.CamerasGetVisible, v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Visible Cameras Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Get Visible Cameras", response, body)
+	}
+
+	cam := new(Cam)
+	if err := json.Unmarshal(body, cam); err != nil {
+		return nil, fmt.Errorf("Failed to read camera list page %d: %w", page, err)
+	}
+	return cam, nil
+}
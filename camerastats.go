@@ -0,0 +1,373 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats a StatsReport can be written in
+const (
+	StatsJSON = "json"
+	StatsCSV  = "csv"
+)
+
+// The CameraStats type is what a Dropcam did with one camera since Since,
+// when it was created or its statistics were last reset: the requests sent
+// for the camera, retries included, and how many of them were snapshots; the
+// bytes of their replies; the calls that failed after any retries; and the
+// average time a request took. Uptime is the fraction of Observed the camera
+// was online, as told by each camera list fetched, the time between two
+// fetches counting as the earlier one said; it is -1 before the list was
+// fetched twice. Offline is the time observed offline.
+type CameraStats struct {
+	Camera     string        `json:"camera_uuid"`
+	Title      string        `json:"title"`
+	Since      time.Time     `json:"since"`
+	Requests   int64         `json:"requests"`
+	Snapshots  int64         `json:"snapshots"`
+	Bytes      int64         `json:"bytes"`
+	Failures   int64         `json:"failures"`
+	AvgLatency time.Duration `json:"avg_latency"`
+	Uptime     float64       `json:"uptime"`
+	Observed   time.Duration `json:"observed"`
+	Offline    time.Duration `json:"offline"`
+}
+
+// cameraCounters are the running figures of one camera
+type cameraCounters struct {
+	requests, snapshots, bytes, failures int64
+	latency                              time.Duration
+	observed, offline                    time.Duration
+
+	// the state the camera list last reported, and when
+	sampled bool
+	online  bool
+	at      time.Time
+}
+
+// cameraStats keeps the counters of every camera a Dropcam dealt with
+type cameraStats struct {
+	mu    sync.Mutex
+	since time.Time
+	cams  map[string]*cameraCounters
+}
+
+// get returns the counters of camera uuid; s.mu must be held
+func (s *cameraStats) get(uuid string) *cameraCounters {
+	if s.cams == nil {
+		s.cams = make(map[string]*cameraCounters)
+	}
+	if s.since.IsZero() {
+		s.since = time.Now()
+	}
+	cc := s.cams[uuid]
+	if cc == nil {
+		cc = new(cameraCounters)
+		s.cams[uuid] = cc
+	}
+	return cc
+}
+
+// request counts a request for camera uuid that took took
+func (s *cameraStats) request(uuid string, took time.Duration) {
+	if uuid == "" {
+		return
+	}
+	s.mu.Lock()
+	cc := s.get(uuid)
+	cc.requests++
+	cc.latency += took
+	s.mu.Unlock()
+}
+
+// failure counts a call for camera uuid that failed
+func (s *cameraStats) failure(uuid string) {
+	if uuid == "" {
+		return
+	}
+	s.mu.Lock()
+	s.get(uuid).failures++
+	s.mu.Unlock()
+}
+
+// snapshot counts a snapshot of camera uuid
+func (s *cameraStats) snapshot(uuid string) {
+	s.mu.Lock()
+	s.get(uuid).snapshots++
+	s.mu.Unlock()
+}
+
+// countBody has the body of resp counted to camera uuid as it is read
+func (s *cameraStats) countBody(uuid string, resp *http.Response) {
+	if uuid == "" {
+		return
+	}
+	resp.Body = &countingReader{ReadCloser: resp.Body, count: func(n int64) {
+		s.mu.Lock()
+		s.get(uuid).bytes += n
+		s.mu.Unlock()
+	}}
+}
+
+// sample notes whether the cameras of a list fetched at now were online
+func (s *cameraStats) sample(cams *Cameras, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range cams.all() {
+		cc := s.get(o.Uuid)
+		if cc.sampled {
+			cc.observe(now)
+		}
+		cc.sampled, cc.online, cc.at = true, o.IsOnline, now
+	}
+}
+
+// observe counts the time from the last sample to now as the state it found
+func (cc *cameraCounters) observe(now time.Time) {
+	d := now.Sub(cc.at)
+	cc.observed += d
+	if !cc.online {
+		cc.offline += d
+	}
+	cc.at = now
+}
+
+// stats returns the figures of camera uuid; s.mu must be held
+func (s *cameraStats) stats(uuid string) CameraStats {
+	st := CameraStats{Camera: uuid, Since: s.since, Uptime: -1}
+	cc := s.cams[uuid]
+	if cc == nil {
+		return st
+	}
+	st.Requests, st.Snapshots, st.Bytes, st.Failures = cc.requests, cc.snapshots, cc.bytes, cc.failures
+	if cc.requests > 0 {
+		st.AvgLatency = cc.latency / time.Duration(cc.requests)
+	}
+	st.Observed, st.Offline = cc.observed, cc.offline
+	if cc.observed > 0 {
+		st.Uptime = float64(cc.observed-cc.offline) / float64(cc.observed)
+	}
+	return st
+}
+
+// The Stats method returns the statistics of each camera of c, in the order
+// of c.Cam followed by c.Shared
+func (c *Cameras) Stats() []CameraStats {
+	s := &c.Dropcam.camStats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(c)
+}
+
+// The ResetStats method returns the statistics of each camera of c, as
+// Stats does, and starts counting afresh, as at the start of a reporting day.
+// The time since the camera list was last fetched counts to the period
+// ending, as its last state said.
+func (c *Cameras) ResetStats() []CameraStats {
+	s := &c.Dropcam.camStats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, cc := range s.cams {
+		if cc.sampled && cc.at.Before(now) {
+			cc.observe(now)
+		}
+	}
+	stats := s.list(c)
+	for uuid, cc := range s.cams {
+		s.cams[uuid] = &cameraCounters{sampled: cc.sampled, online: cc.online, at: cc.at}
+	}
+	s.since = now
+	return stats
+}
+
+// started returns when the figures were last reset
+func (s *cameraStats) started() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		s.since = time.Now()
+	}
+	return s.since
+}
+
+// list returns the figures of the cameras of c with their titles; s.mu must
+// be held
+func (s *cameraStats) list(c *Cameras) []CameraStats {
+	var stats []CameraStats
+	for _, o := range c.all() {
+		st := s.stats(o.Uuid)
+		st.Title = o.Title
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// The StatsReport type is the statistics of every camera from From to To,
+// for customers who need evidence of their cameras' availability
+type StatsReport struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Cameras []CameraStats `json:"cameras"`
+}
+
+// WriteJSON writes r to w as indented JSON
+func (r *StatsReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes r to w as CSV, one row per camera, with latencies in
+// milliseconds and durations in seconds
+func (r *StatsReport) WriteCSV(w io.Writer) error {
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"from", "to", "camera_uuid", "title", "requests", "snapshots", "bytes",
+		"failures", "avg_latency_ms", "uptime_percent", "observed_s", "offline_s"})
+	for _, st := range r.Cameras {
+		uptime := ""
+		if st.Uptime >= 0 {
+			uptime = strconv.FormatFloat(st.Uptime*100, 'f', 3, 64)
+		}
+		cw.Write([]string{
+			r.From.Format(time.RFC3339),
+			r.To.Format(time.RFC3339),
+			st.Camera,
+			st.Title,
+			strconv.FormatInt(st.Requests, 10),
+			strconv.FormatInt(st.Snapshots, 10),
+			strconv.FormatInt(st.Bytes, 10),
+			strconv.FormatInt(st.Failures, 10),
+			strconv.FormatFloat(float64(st.AvgLatency)/float64(time.Millisecond), 'f', 1, 64),
+			uptime,
+			strconv.FormatFloat(st.Observed.Seconds(), 'f', 0, 64),
+			strconv.FormatFloat(st.Offline.Seconds(), 'f', 0, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// The StatsReporter type writes a StatsReport of every camera into Dir each
+// day at midnight local time, as stats-<yyyy-mm-dd>.json or .csv as Format
+// says, JSON when empty, and starts counting the next day afresh. The camera
+// list is fetched every Sample, 5 minutes when zero, so uptime is measured
+// even when nothing else fetches it.
+type StatsReporter struct {
+	Cameras *Cameras
+	Dir     string
+	Format  string
+	Sample  time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start begins sampling and writing reports in the background
+func (sr *StatsReporter) Start() error {
+
+	if sr.Cameras == nil || sr.Dir == "" {
+		return errors.New("StatsReporter needs Cameras and a Dir")
+	}
+	sr.Format = strings.ToLower(sr.Format)
+	switch sr.Format {
+	case "":
+		sr.Format = StatsJSON
+	case StatsJSON, StatsCSV:
+	default:
+		return fmt.Errorf("Unknown stats format %q", sr.Format)
+	}
+	if sr.Sample <= 0 {
+		sr.Sample = 5 * time.Minute
+	}
+	if err := os.MkdirAll(sr.Dir, 0755); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sr.cancel = cancel
+	sr.done = make(chan struct{})
+	go sr.run(ctx)
+	return nil
+}
+
+// Stop ends the background loop
+func (sr *StatsReporter) Stop() {
+	sr.cancel()
+	<-sr.done
+}
+
+func (sr *StatsReporter) run(ctx context.Context) {
+	defer close(sr.done)
+
+	tick := time.NewTicker(sr.Sample)
+	defer tick.Stop()
+
+	// a report covers the figures from when they were last reset, which
+	// may be before a reload restarted the reporter
+	from := sr.Cameras.Dropcam.camStats.started()
+	due := nextMidnight(time.Now())
+	for {
+		if _, err := sr.Cameras.Dropcam.RefreshCameras(ctx); err != nil && ctx.Err() == nil {
+			warnf("stats: failed to refresh cameras: %s\n", err)
+		}
+		if now := time.Now(); !now.Before(due) {
+			r := &StatsReport{From: from, To: now, Cameras: sr.Cameras.ResetStats()}
+			if err := sr.Write(r); err != nil {
+				warnf("stats: %s\n", err)
+			}
+			from, due = now, nextMidnight(now)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Write writes r into Dir, named after the day it starts
+func (sr *StatsReporter) Write(r *StatsReport) error {
+
+	var buf strings.Builder
+	var err error
+	if sr.Format == StatsCSV {
+		err = r.WriteCSV(&buf)
+	} else {
+		err = r.WriteJSON(&buf)
+	}
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(sr.Dir, "stats-"+r.From.Format("2006-01-02")+"."+sr.Format)
+	if err := ioutil.WriteFile(fn, []byte(buf.String()), 0644); err != nil {
+		return err
+	}
+	Dbg("stats: wrote %s\n", fn)
+	return nil
+}
+
+// nextMidnight returns the first midnight local time after t
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
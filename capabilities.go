@@ -0,0 +1,113 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedCapability is returned, wrapped, for a change a camera lacks
+// the hardware or firmware for
+var ErrUnsupportedCapability = errors.New("Unsupported Capability")
+
+// Capabilities cameras report
+const (
+	CapAudio         = "audio.microphone"
+	CapSpeaker       = "audio.speaker"
+	CapNightVision   = "irled"
+	CapStatusLED     = "statusled"
+	CapActivityZones = "detectors.activity_zones"
+	CapCloudRecord   = "streaming.cloud-recording"
+
+	// capHDPrefix starts the capabilities of the camera profiles a camera
+	// streams, e.g. "streaming.cameraprofile.720p"
+	capHDPrefix = "streaming.cameraprofile."
+)
+
+// The Capabilities type is the list of features a camera reports in
+// cameras.get. An empty list means the camera did not say, so every feature
+// is taken to be there.
+type Capabilities []string
+
+// The Has method reports whether the camera reports capability name
+func (c Capabilities) Has(name string) bool {
+	for _, s := range c {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The HasAudio method reports whether the camera has a microphone
+func (c Capabilities) HasAudio() bool {
+	return c.Has(CapAudio)
+}
+
+// The HasSpeaker method reports whether the camera can play talk-back audio
+func (c Capabilities) HasSpeaker() bool {
+	return c.Has(CapSpeaker)
+}
+
+// The HasNightVision method reports whether the camera has infrared LEDs
+func (c Capabilities) HasNightVision() bool {
+	return c.Has(CapNightVision)
+}
+
+// The HasStatusLED method reports whether the camera has a status light
+func (c Capabilities) HasStatusLED() bool {
+	return c.Has(CapStatusLED)
+}
+
+// The HasHD method reports whether the camera streams a 720p or 1080p profile
+func (c Capabilities) HasHD() bool {
+	for _, s := range c {
+		if s == capHDPrefix+"720p" || s == capHDPrefix+"1080p" {
+			return true
+		}
+	}
+	return false
+}
+
+// The SupportsZones method reports whether the camera can limit motion
+// detection to activity zones
+func (c Capabilities) SupportsZones() bool {
+	return c.Has(CapActivityZones)
+}
+
+// The SupportsCloudRecording method reports whether the camera can record to
+// the cloud, regardless of whether it has a plan to
+func (c Capabilities) SupportsCloudRecording() bool {
+	return c.Has(CapCloudRecord)
+}
+
+// propertyCapabilities maps the properties with typed setters to the
+// capability they need
+var propertyCapabilities = map[string]func(Capabilities) bool{
+	PropIRLED:        Capabilities.HasNightVision,
+	PropHD:           Capabilities.HasHD,
+	PropAudioEnabled: Capabilities.HasAudio,
+	PropStatusLED:    Capabilities.HasStatusLED,
+}
+
+// requireCapability returns ErrUnsupportedCapability, naming op and what,
+// unless camera o reports a capability has accepts, or reports none at all
+func requireCapability(op string, o *Owned, what string, has func(Capabilities) bool) error {
+	if len(o.Capabilities) == 0 || has(o.Capabilities) {
+		return nil
+	}
+	return fmt.Errorf("%s Failed: %s does not support %s: %w", op, o.Title, what, ErrUnsupportedCapability)
+}
+
+// requirePropertyCapability checks camera o supports setting property name,
+// for properties with a known capability
+func requirePropertyCapability(o *Owned, name string) error {
+	has, ok := propertyCapabilities[name]
+	if !ok {
+		return nil
+	}
+	return requireCapability("Set Property", o, name, has)
+}
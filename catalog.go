@@ -0,0 +1,253 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Kinds of media recorded in a Catalog
+const (
+	MediaSnapshot = "snapshot"
+	MediaClip     = "clip"
+)
+
+const catalogSchema = `CREATE TABLE IF NOT EXISTS media (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind   TEXT    NOT NULL,
+	camera TEXT    NOT NULL,
+	time   INTEGER NOT NULL,
+	key    TEXT    NOT NULL UNIQUE,
+	size   INTEGER NOT NULL,
+	sha256 TEXT    NOT NULL,
+	event  INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS media_camera_time ON media (camera, time)`
+
+// The MediaRecord type describes one stored snapshot or clip. Event is the
+// id of the cuepoint a clip was downloaded for, or 0.
+type MediaRecord struct {
+	ID     int64
+	Kind   string
+	Camera string
+	Time   time.Time
+	Key    string
+	Size   int64
+	SHA256 string
+	Event  int64
+}
+
+// The CatalogQuery type selects records from a Catalog. Zero fields match
+// everything; From is inclusive and To exclusive.
+type CatalogQuery struct {
+	Camera string
+	Kind   string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// The Catalog type indexes stored media in an SQLite database so galleries and
+// retention jobs can find files without walking storage. The caller opens the
+// database with the driver of its choice, e.g. sql.Open("sqlite3", "media.db").
+type Catalog struct {
+	db *sql.DB
+}
+
+// OpenCatalog creates the catalog table in db if needed
+func OpenCatalog(ctx context.Context, db *sql.DB) (*Catalog, error) {
+
+	for _, stmt := range strings.Split(catalogSchema, ";\n") {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Add records r, replacing any existing record with the same key, and sets r.ID
+func (c *Catalog) Add(ctx context.Context, r *MediaRecord) error {
+
+	if r.Key == "" || r.Camera == "" {
+		return errors.New("MediaRecord needs a Key and Camera")
+	}
+
+	res, err := c.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO media (kind, camera, time, key, size, sha256, event) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Kind, r.Camera, r.Time.UnixNano(), r.Key, r.Size, r.SHA256, r.Event)
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// AddFile records the file at path under key, taking its size and hash from disk
+func (c *Catalog) AddFile(ctx context.Context, kind, camera string, event int64, t time.Time, key, path string) (*MediaRecord, error) {
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MediaRecord{
+		Kind:   kind,
+		Camera: camera,
+		Time:   t,
+		Key:    key,
+		Size:   fi.Size(),
+		SHA256: sum,
+		Event:  event,
+	}
+	if err := c.Add(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Remove deletes the record for key
+func (c *Catalog) Remove(ctx context.Context, key string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM media WHERE key = ?`, key)
+	return err
+}
+
+// Lookup returns the record for key, or nil if there is none
+func (c *Catalog) Lookup(ctx context.Context, key string) (*MediaRecord, error) {
+
+	rs, err := c.query(ctx, `WHERE key = ?`, key)
+	if err != nil || len(rs) == 0 {
+		return nil, err
+	}
+	return &rs[0], nil
+}
+
+// Prefix returns the records whose keys start with prefix, ordered by key
+func (c *Catalog) Prefix(ctx context.Context, prefix string) ([]MediaRecord, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	rs, err := c.query(ctx, `WHERE key LIKE ? ESCAPE '\' ORDER BY key`, escaped+"%")
+	if err != nil {
+		return nil, err
+	}
+	// LIKE ignores ASCII case
+	out := rs[:0]
+	for _, r := range rs {
+		if strings.HasPrefix(r.Key, prefix) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// Query returns the records matching q, oldest first
+func (c *Catalog) Query(ctx context.Context, q CatalogQuery) ([]MediaRecord, error) {
+
+	where, args := q.where()
+	where += " ORDER BY time, id"
+	if q.Limit > 0 {
+		where += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	return c.query(ctx, where, args...)
+}
+
+// Usage returns the number and total size of the records matching q
+func (c *Catalog) Usage(ctx context.Context, q CatalogQuery) (count int, size int64, err error) {
+
+	where, args := q.where()
+	row := c.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM media `+where, args...)
+	err = row.Scan(&count, &size)
+	return count, size, err
+}
+
+func (q CatalogQuery) where() (string, []interface{}) {
+
+	var conds []string
+	var args []interface{}
+	if q.Camera != "" {
+		conds = append(conds, "camera = ?")
+		args = append(args, q.Camera)
+	}
+	if q.Kind != "" {
+		conds = append(conds, "kind = ?")
+		args = append(args, q.Kind)
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "time < ?")
+		args = append(args, q.To.UnixNano())
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	return where, args
+}
+
+func (c *Catalog) query(ctx context.Context, where string, args ...interface{}) ([]MediaRecord, error) {
+
+	rows, err := c.db.QueryContext(ctx,
+		`SELECT id, kind, camera, time, key, size, sha256, event FROM media `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rs []MediaRecord
+	for rows.Next() {
+		var r MediaRecord
+		var t int64
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Camera, &t, &r.Key, &r.Size, &r.SHA256, &r.Event); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, t)
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+// Cameras returns the uuids of the cameras that have records
+func (c *Catalog) Cameras(ctx context.Context) ([]string, error) {
+	return c.strings(ctx, `SELECT DISTINCT camera FROM media ORDER BY camera`)
+}
+
+// Days returns the local dates, as yyyy-mm-dd, on which camera has records of
+// the given kind, newest first
+func (c *Catalog) Days(ctx context.Context, camera, kind string) ([]string, error) {
+	return c.strings(ctx,
+		`SELECT DISTINCT date(time / 1000000000, 'unixepoch', 'localtime') AS day FROM media
+		WHERE camera = ? AND kind = ? ORDER BY day DESC`, camera, kind)
+}
+
+func (c *Catalog) strings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
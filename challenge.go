@@ -0,0 +1,212 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Kinds of login challenges
+const (
+	ChallengeCaptcha            = "captcha"
+	ChallengeConsent            = "consent"
+	ChallengeDeviceVerification = "device_verification"
+	ChallengeTwoFactor          = "two_factor"
+	ChallengeUnknown            = "unknown"
+)
+
+// maxChallenges bounds the challenges answered in one login
+const maxChallenges = 3
+
+// The Challenge type is something the servers ask for before they accept a
+// login: a captcha, agreeing to new terms, a code sent to verify a new
+// device or the one-time code of an account with two-factor authentication.
+// URL is the page the login was sent to, with the credentials removed from
+// its query, and Body the start of the page. Fields holds the form fields
+// found on it, hidden ones included, or the tokens of a JSON reply, so a
+// handler can send them back.
+type Challenge struct {
+	Kind    string
+	URL     string
+	Status  int
+	Message string
+	Body    string
+	Fields  url.Values
+}
+
+// The ChallengeHandler interface answers login challenges, interactively or
+// from a script. Solve returns the values added to the login form when it is
+// sent again, e.g. the captcha solution or the verification code, or an error
+// to give up.
+type ChallengeHandler interface {
+	Solve(ctx context.Context, c *Challenge) (url.Values, error)
+}
+
+// ChallengeHandlerFunc adapts an ordinary function to the ChallengeHandler interface
+type ChallengeHandlerFunc func(ctx context.Context, c *Challenge) (url.Values, error)
+
+// Solve calls f(ctx, c)
+func (f ChallengeHandlerFunc) Solve(ctx context.Context, c *Challenge) (url.Values, error) {
+	return f(ctx, c)
+}
+
+// The ChallengeError type is a login stopped by a challenge that was not
+// answered, because there is no Dropcam.Challenges handler, the handler gave
+// up or the challenge kept coming back. It wraps ErrAuthFailed.
+type ChallengeError struct {
+	Challenge *Challenge
+	Err       error
+}
+
+func (e *ChallengeError) Error() string {
+	msg := "Login needs a " + strings.Replace(e.Challenge.Kind, "_", " ", -1) + " challenge answered"
+	if e.Challenge.URL != "" {
+		msg += " at " + e.Challenge.URL
+	}
+	if e.Challenge.Message != "" {
+		msg += ": " + e.Challenge.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return ErrAuthFailed
+}
+
+var (
+	formField = regexp.MustCompile(`(?is)<input[^>]*>`)
+	fieldAttr = regexp.MustCompile(`(?is)\b(name|value)\s*=\s*["']([^"']*)["']`)
+)
+
+// detectChallenge returns the challenge resp presents instead of a login
+// reply, or nil. The login API answers in JSON; an HTML page, a redirect
+// elsewhere or a status naming a challenge means the servers want more.
+func detectChallenge(resp *http.Response, loginURL string) *Challenge {
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+
+	c := &Challenge{Status: resp.StatusCode, Fields: url.Values{}}
+	if resp.Request != nil && resp.Request.URL != nil {
+		c.URL = redactURL(resp.Request.URL)
+	}
+	if loc := resp.Header.Get("Location"); loc != "" && resp.StatusCode/100 == 3 {
+		c.URL = loc
+	}
+	if len(body) > 4<<10 {
+		c.Body = string(body[:4<<10])
+	} else {
+		c.Body = string(body)
+	}
+
+	var envelope struct {
+		StatusDescription string                   `json:"status_description"`
+		StatusDetail      string                   `json:"status_detail"`
+		Items             []map[string]interface{} `json:"items"`
+	}
+	isJSON := json.Unmarshal(body, &envelope) == nil
+	if isJSON {
+		c.Message = strings.TrimSpace(envelope.StatusDescription + " " + envelope.StatusDetail)
+		if len(envelope.Items) > 0 {
+			replyTokens(envelope.Items[0], c.Fields)
+		}
+	}
+
+	moved := c.URL != "" && !sameEndpoint(c.URL, loginURL)
+	html := strings.Contains(resp.Header.Get("Content-Type"), "html")
+	c.Kind = challengeKind(c.Message)
+	if !isJSON {
+		c.Kind = challengeKind(c.Body)
+	}
+	if c.Kind == "" && (moved || html) {
+		c.Kind = ChallengeUnknown
+	}
+	if c.Kind == "" {
+		return nil
+	}
+
+	for _, input := range formField.FindAllString(c.Body, -1) {
+		var name, value string
+		for _, m := range fieldAttr.FindAllStringSubmatch(input, -1) {
+			if strings.EqualFold(m[1], "name") {
+				name = m[2]
+			} else {
+				value = m[2]
+			}
+		}
+		if name != "" {
+			c.Fields.Add(name, value)
+		}
+	}
+	return c
+}
+
+// challengeKind names the challenge text describes, or returns ""
+func challengeKind(text string) string {
+	t := strings.ToLower(text)
+	switch {
+	case strings.Contains(t, "two-factor") || strings.Contains(t, "two factor") || strings.Contains(t, "2fa") ||
+		strings.Contains(t, "2-step") || strings.Contains(t, "one-time code"):
+		return ChallengeTwoFactor
+	case strings.Contains(t, "captcha"):
+		return ChallengeCaptcha
+	case strings.Contains(t, "verification code") || strings.Contains(t, "verify") || strings.Contains(t, "new device"):
+		return ChallengeDeviceVerification
+	case strings.Contains(t, "consent") || strings.Contains(t, "terms of service") || strings.Contains(t, "accept the terms"):
+		return ChallengeConsent
+	}
+	return ""
+}
+
+// replyTokens adds the string fields of a JSON reply item named like tokens,
+// such as the id of a pending two-factor login, to fields
+func replyTokens(item map[string]interface{}, fields url.Values) {
+	for k, v := range item {
+		if s, ok := v.(string); ok && strings.HasSuffix(k, "_token") {
+			fields.Set(k, s)
+		}
+	}
+}
+
+// sameEndpoint reports whether two URLs have the same host and path
+func sameEndpoint(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host == ub.Host && strings.TrimSuffix(ua.Path, "/") == strings.TrimSuffix(ub.Path, "/")
+}
+
+// solveChallenge has the Dropcam's handler answer c, the round'th challenge
+// of this login, and returns the values to log in again with
+func (d *Dropcam) solveChallenge(ctx context.Context, c *Challenge, round int) (url.Values, error) {
+
+	if d.Challenges == nil {
+		return nil, &ChallengeError{Challenge: c}
+	}
+	if round >= maxChallenges {
+		return nil, &ChallengeError{Challenge: c, Err: fmt.Errorf("still challenged after %d answers", round)}
+	}
+	Dbg("login challenged: %s at %s\n", c.Kind, c.URL)
+	v, err := d.Challenges.Solve(ctx, c)
+	if err != nil {
+		return nil, &ChallengeError{Challenge: c, Err: err}
+	}
+	return v, nil
+}
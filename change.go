@@ -0,0 +1,178 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	"sync"
+	"time"
+)
+
+// DefaultTolerance is the per-pixel luminance difference ignored as sensor noise
+const DefaultTolerance = 24
+
+// The ChangeThreshold type configures change detection for a single camera.
+// Threshold is the fraction (0..1) of pixels inside Mask that must change before
+// an alert is raised. Mask rectangles are in pixels of the requested snapshot width;
+// an empty Mask considers the whole frame.
+type ChangeThreshold struct {
+	Threshold float64
+	Tolerance uint8
+	Mask      []image.Rectangle
+}
+
+// The ChangeDetector compares consecutive snapshots of each camera and emits a
+// NotifyChange Notification when the changed fraction exceeds the camera's threshold.
+// It is a cheap tripwire for accounts without cloud event history. OnChange,
+// if set, is also called then, with the percentage of the frame that changed.
+type ChangeDetector struct {
+	Default  ChangeThreshold
+	Notifier Notifier
+	OnChange func(o *Owned, percent float64, img []byte)
+
+	mu         sync.Mutex
+	thresholds map[string]ChangeThreshold
+	last       map[string]*image.Gray
+}
+
+// NewChangeDetector returns a ChangeDetector that alerts through n when more than
+// 5% of a frame changes between snapshots
+func NewChangeDetector(n Notifier) *ChangeDetector {
+	return &ChangeDetector{
+		Default:    ChangeThreshold{Threshold: 0.05, Tolerance: DefaultTolerance},
+		Notifier:   n,
+		thresholds: make(map[string]ChangeThreshold),
+		last:       make(map[string]*image.Gray),
+	}
+}
+
+// SetThreshold overrides the default threshold and mask for the camera with the given uuid
+func (cd *ChangeDetector) SetThreshold(uuid string, t ChangeThreshold) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	cd.thresholds[uuid] = t
+}
+
+// Reset forgets the previous snapshot of a camera so the next Observe starts fresh
+func (cd *ChangeDetector) Reset(uuid string) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	delete(cd.last, uuid)
+}
+
+// Observe records a new snapshot for camera o and returns the fraction of the
+// masked area that changed since the previous snapshot. The first snapshot of a
+// camera, or one whose size differs from its predecessor, scores 0.
+func (cd *ChangeDetector) Observe(o *Owned, img []byte) (float64, error) {
+
+	decoded, _, err := image.Decode(bytes.NewReader(img))
+	if err != nil {
+		return 0, errors.New("Failed to decode snapshot: " + err.Error())
+	}
+	gray := toGray(decoded)
+
+	cd.mu.Lock()
+	t, ok := cd.thresholds[o.Uuid]
+	if !ok {
+		t = cd.Default
+	}
+	prev := cd.last[o.Uuid]
+	cd.last[o.Uuid] = gray
+	cd.mu.Unlock()
+
+	if prev == nil || prev.Bounds() != gray.Bounds() {
+		return 0, nil
+	}
+
+	score := changedFraction(prev, gray, t.Tolerance, t.Mask)
+	Dbg("change score for %s: %f\n", o.Uuid, score)
+
+	if t.Threshold > 0 && score > t.Threshold && cd.OnChange != nil {
+		cd.OnChange(o, score*100, img)
+	}
+	if t.Threshold > 0 && score > t.Threshold && cd.Notifier != nil {
+		n := &Notification{
+			Kind:    NotifyChange,
+			Camera:  o.Uuid,
+			Title:   o.Title,
+			Message: fmt.Sprintf("%.1f%% of the frame changed", score*100),
+			Time:    time.Now(),
+			Image:   img,
+		}
+		if err := cd.Notifier.Notify(n); err != nil {
+			return score, err
+		}
+	}
+	return score, nil
+}
+
+// The CheckChange method grabs a snapshot from camera o and feeds it to the ChangeDetector
+func (c *Cameras) CheckChange(ctx context.Context, cd *ChangeDetector, o *Owned, width int) (float64, error) {
+
+	img, err := c.getImage(ctx, o, width, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return cd.Observe(o, img)
+}
+
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+	b := img.Bounds()
+	g := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(g, g.Bounds(), img, b.Min, draw.Src)
+	return g
+}
+
+// changedFraction returns the fraction of the pixels in mask, every pixel
+// when empty, that differ by more than tolerance between a and b. A pixel
+// covered by several rectangles of mask counts once.
+func changedFraction(a, b *image.Gray, tolerance uint8, mask []image.Rectangle) float64 {
+
+	bounds := a.Bounds()
+	var in []bool
+	if len(mask) > 0 {
+		in = make([]bool, bounds.Dx()*bounds.Dy())
+		for _, r := range mask {
+			r = r.Intersect(bounds)
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					in[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] = true
+				}
+			}
+		}
+	}
+
+	var total, changed int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if in != nil && !in[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] {
+				continue
+			}
+			pa := a.GrayAt(x, y).Y
+			pb := b.GrayAt(x, y).Y
+			d := pa - pb
+			if pb > pa {
+				d = pb - pa
+			}
+			total++
+			if d > tolerance {
+				changed++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(changed) / float64(total)
+}
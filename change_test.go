@@ -0,0 +1,41 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestChangedFractionOverlappingMask(t *testing.T) {
+
+	a := image.NewGray(image.Rect(0, 0, 10, 10))
+	b := image.NewGray(image.Rect(0, 0, 10, 10))
+	// the left half changes
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 5; x++ {
+			b.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	tests := []struct {
+		mask []image.Rectangle
+		want float64
+	}{
+		{nil, 0.5},
+		{[]image.Rectangle{image.Rect(0, 0, 10, 10)}, 0.5},
+		// the changed pixels of the first row lie in both rectangles;
+		// counting them twice would give 10 of 15
+		{[]image.Rectangle{image.Rect(0, 0, 5, 1), image.Rect(0, 0, 10, 1)}, 0.5},
+		{[]image.Rectangle{image.Rect(0, 0, 5, 10), image.Rect(0, 0, 5, 10)}, 1},
+		{[]image.Rectangle{image.Rect(20, 20, 30, 30)}, 0},
+	}
+	for _, tt := range tests {
+		if got := changedFraction(a, b, 0, tt.mask); got != tt.want {
+			t.Errorf("changedFraction(%v) = %g, want %g", tt.mask, got, tt.want)
+		}
+	}
+}
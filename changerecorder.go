@@ -0,0 +1,234 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultChangeHold is how long a ChangeRecorder keeps recording after the
+// last change when Hold is zero
+const DefaultChangeHold = 30 * time.Second
+
+// The ChangeSegment type is one recording a ChangeRecorder made: the frames
+// saved into Dir from Start to End. Score is the changed fraction of the
+// frame that started it, and Peak the largest seen while it lasted.
+type ChangeSegment struct {
+	Camera string
+	Dir    string
+	Start  time.Time
+	End    time.Time
+	Score  float64
+	Peak   float64
+	Frames []string
+}
+
+// The ChangeRecorder type saves frames of Camera, polled every Interval,
+// only while its scene changes, so static scenes take no storage. A
+// recording starts when more than Threshold.Threshold of the frame, 5% when
+// zero, changes from one frame to the next. It goes on while frames change
+// by more than Release, half of that when zero, and ends once none has for
+// Hold, DefaultChangeHold when zero. The two thresholds keep flickering
+// scenes from starting and ending a recording at every frame.
+//
+// Each recording goes into its own folder below Dir/<uuid>, named by its
+// start time and tagged with the percentage that started it, e.g.
+// "20240621-190405-score12.5", as frame-000000.jpg and onwards. OnSegment,
+// if set, is called with each recording once it ends. With Dropcam.Uploads
+// set, frames are uploaded as UploadSnapshot captures. With Backoff set, a
+// camera that is offline or keeps failing is polled less often until it
+// answers again.
+type ChangeRecorder struct {
+	Cameras   *Cameras
+	Camera    *Owned
+	Interval  time.Duration
+	Width     int
+	Dir       string
+	Threshold ChangeThreshold
+	Release   float64
+	Hold      time.Duration
+	OnSegment func(s ChangeSegment)
+	Backoff   *PollBackoff
+
+	detector   *ChangeDetector
+	mu         sync.Mutex
+	cur        *ChangeSegment
+	lastChange time.Time
+	segments   []ChangeSegment
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Start begins polling in the background
+func (r *ChangeRecorder) Start() error {
+
+	if r.Cameras == nil || r.Camera == nil {
+		return errors.New("ChangeRecorder needs Cameras and a Camera")
+	}
+	if r.Interval <= 0 {
+		r.Interval = 5 * time.Second
+	}
+	if r.Dir == "" {
+		r.Dir = "."
+	}
+	if r.Threshold.Threshold <= 0 {
+		r.Threshold.Threshold = 0.05
+	}
+	if r.Threshold.Tolerance == 0 {
+		r.Threshold.Tolerance = DefaultTolerance
+	}
+	if r.Release <= 0 || r.Release > r.Threshold.Threshold {
+		r.Release = r.Threshold.Threshold / 2
+	}
+	if r.Hold <= 0 {
+		r.Hold = DefaultChangeHold
+	}
+
+	// the detector only scores; the recorder decides what is kept
+	r.detector = NewChangeDetector(nil)
+	r.detector.Default = ChangeThreshold{Tolerance: r.Threshold.Tolerance, Mask: r.Threshold.Mask}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx)
+	return nil
+}
+
+// Stop ends polling, and the recording in progress if any
+func (r *ChangeRecorder) Stop() {
+	r.cancel()
+	<-r.done
+	r.finish()
+}
+
+// Segments returns the recordings that have ended so far
+func (r *ChangeRecorder) Segments() []ChangeSegment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ChangeSegment(nil), r.segments...)
+}
+
+// Recording reports whether a recording is in progress
+func (r *ChangeRecorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cur != nil
+}
+
+func (r *ChangeRecorder) run(ctx context.Context) {
+	defer close(r.done)
+
+	tick := time.NewTicker(r.Interval)
+	defer tick.Stop()
+
+	var ps pollState
+	for {
+		err := r.poll(ctx, time.Now())
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			warnf("change recorder: %s: %s\n", r.Camera.Title, err)
+		}
+		next := tick.C
+		if wait := r.Backoff.next(&ps, r.Interval, err); wait > 0 {
+			next = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+		}
+	}
+}
+
+// poll scores a new frame and saves it if a recording is in progress. Only
+// the loop changes the recording; r.mu guards it for Segments and Recording.
+func (r *ChangeRecorder) poll(ctx context.Context, now time.Time) error {
+
+	var img bytes.Buffer
+	if _, err := r.Cameras.writeImage(ctx, r.Camera, &img, ImageOptions{Width: r.Width}, false); err != nil {
+		return err
+	}
+	score, err := r.detector.Observe(r.Camera, img.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	cur := r.cur
+	switch {
+	case cur == nil && score <= r.Threshold.Threshold:
+		r.mu.Unlock()
+		return nil
+	case cur == nil:
+		name := fmt.Sprintf("%s-score%.1f", r.Camera.In(now).Format("20060102-150405"), score*100)
+		dir := filepath.Join(r.Dir, r.Camera.Uuid, name)
+		// recordings starting within a second of each other
+		for n := 2; ; n++ {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				break
+			}
+			dir = filepath.Join(r.Dir, r.Camera.Uuid, fmt.Sprintf("%s-%d", name, n))
+		}
+		cur = &ChangeSegment{
+			Camera: r.Camera.Uuid,
+			Dir:    dir,
+			Start:  now,
+			Score:  score,
+		}
+		r.cur, r.lastChange = cur, now
+		Dbg("change recorder: %s started recording at %.1f%%\n", r.Camera.Title, score*100)
+	case score > r.Release:
+		r.lastChange = now
+	case now.Sub(r.lastChange) >= r.Hold:
+		r.mu.Unlock()
+		r.finish()
+		return nil
+	}
+	if score > cur.Peak {
+		cur.Peak = score
+	}
+	fn := filepath.Join(cur.Dir, fmt.Sprintf("frame-%06d.jpg", len(cur.Frames)))
+	r.mu.Unlock()
+
+	fn, err = r.Cameras.storeImage(ctx, r.Camera, fn, img.Bytes(), now, UploadSnapshot)
+	if err != nil || fn == "" {
+		return err
+	}
+	r.mu.Lock()
+	cur.Frames = append(cur.Frames, fn)
+	cur.End = now
+	r.mu.Unlock()
+	return nil
+}
+
+// finish ends the recording in progress, if any
+func (r *ChangeRecorder) finish() {
+
+	r.mu.Lock()
+	cur := r.cur
+	r.cur = nil
+	if cur == nil || len(cur.Frames) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	s := *cur
+	r.segments = append(r.segments, s)
+	r.mu.Unlock()
+
+	Dbg("change recorder: %s recorded %d frames\n", r.Camera.Title, len(s.Frames))
+	if r.OnSegment != nil {
+		r.OnSegment(s)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultEDLFrameRate is the timecode frame rate of an EDL when none is given
+const DefaultEDLFrameRate = 30
+
+// The EventTimeline type places a camera's events on the timeline of a video
+// that begins at Start and lasts Duration, typically a day's recording, so they
+// can be exported as chapters or edit markers for reviewing the footage in a
+// player or video editor. Events outside the video are left out.
+type EventTimeline struct {
+	Title    string
+	Start    time.Time
+	Duration time.Duration
+	Events   Events
+}
+
+// The DayTimeline method returns the events of camera o on the local day
+// containing day, on the timeline of a video of that whole day
+func (c *Cameras) DayTimeline(ctx context.Context, o *Owned, day time.Time) (*EventTimeline, error) {
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+	events, err := c.GetEvents(ctx, o, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &EventTimeline{
+		Title:    o.Title + " " + start.Format("2006-01-02"),
+		Start:    start,
+		Duration: end.Sub(start),
+		Events:   events,
+	}, nil
+}
+
+// timelineMark is an event as an offset range into the video
+type timelineMark struct {
+	name     string
+	from, to time.Duration
+}
+
+func (tl *EventTimeline) marks() []timelineMark {
+
+	var marks []timelineMark
+	for i := range tl.Events {
+		e := &tl.Events[i]
+		from := e.Start().Sub(tl.Start)
+		to := e.End().Sub(tl.Start)
+		if e.EndTime <= e.StartTime {
+			to = from + time.Second
+		}
+		if from < 0 {
+			from = 0
+		}
+		if tl.Duration > 0 && to > tl.Duration {
+			to = tl.Duration
+		}
+		if to <= from {
+			continue
+		}
+
+		kind := e.Type
+		if kind == "" {
+			kind = "event"
+		}
+		name := strings.ToUpper(kind[:1]) + kind[1:] + " " + e.Start().In(tl.Start.Location()).Format("15:04:05")
+		marks = append(marks, timelineMark{name: name, from: from, to: to})
+	}
+	return marks
+}
+
+// WriteFFMetadata writes the events as chapters in ffmpeg's metadata format.
+// Attach them to a video with
+//
+//	ffmpeg -i day.mp4 -i chapters.txt -map_metadata 1 -codec copy out.mp4
+func (tl *EventTimeline) WriteFFMetadata(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, ";FFMETADATA1\ntitle=%s\n", ffmetaEscape(tl.Title))
+	for _, m := range tl.marks() {
+		fmt.Fprintf(bw, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			m.from.Milliseconds(), m.to.Milliseconds(), ffmetaEscape(m.name))
+	}
+	return bw.Flush()
+}
+
+var ffmetaEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+func ffmetaEscape(s string) string {
+	return ffmetaEscaper.Replace(s)
+}
+
+// WriteEDL writes the events as a CMX 3600 edit decision list with one edit
+// per event, its record timecode the event's position in the video. fps is the
+// timecode frame rate, DefaultEDLFrameRate when zero.
+func (tl *EventTimeline) WriteEDL(w io.Writer, fps int) error {
+
+	if fps <= 0 {
+		fps = DefaultEDLFrameRate
+	}
+	tc := func(d time.Duration) string {
+		frames := int64(d) * int64(fps) / int64(time.Second)
+		f := frames % int64(fps)
+		s := frames / int64(fps)
+		return fmt.Sprintf("%02d:%02d:%02d:%02d", s/3600, s/60%60, s%60, f)
+	}
+
+	bw := bufio.NewWriter(w)
+	title := strings.ReplaceAll(tl.Title, "\n", " ")
+	fmt.Fprintf(bw, "TITLE: %s\nFCM: NON-DROP FRAME\n", title)
+	for i, m := range tl.marks() {
+		fmt.Fprintf(bw, "\n%03d  AX       V     C        %s %s %s %s\n",
+			i+1, tc(m.from), tc(m.to), tc(m.from), tc(m.to))
+		fmt.Fprintf(bw, "* FROM CLIP NAME: %s\n", title)
+		fmt.Fprintf(bw, "* COMMENT: %s\n", m.name)
+	}
+	return bw.Flush()
+}
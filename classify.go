@@ -0,0 +1,91 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// The Classifier interface labels what a snapshot shows, e.g. "person", "pet"
+// or "vehicle". Implementations wrap a local model or an external service.
+type Classifier interface {
+	Classify(img []byte) ([]string, error)
+}
+
+// ClassifierFunc adapts an ordinary function to the Classifier interface
+type ClassifierFunc func(img []byte) ([]string, error)
+
+// Classify calls f(img)
+func (f ClassifierFunc) Classify(img []byte) ([]string, error) {
+	return f(img)
+}
+
+// The HTTPClassifier type sends the JPEG as the body of a POST to URL and
+// expects a JSON reply of the form {"labels": ["person", ...]}
+type HTTPClassifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Classify implements Classifier
+func (h *HTTPClassifier) Classify(img []byte) ([]string, error) {
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(h.URL, "image/jpeg", bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Classifier returned %s", resp.Status)
+	}
+
+	var reply struct {
+		Labels []string `json:"labels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	return reply.Labels, nil
+}
+
+// ClassifyNotifier wraps n so that notifications carrying a snapshot are labeled
+// by c before delivery. A classifier failure is logged and the notification is
+// delivered unlabeled.
+func ClassifyNotifier(c Classifier, n Notifier) Notifier {
+	return NotifierFunc(func(note *Notification) error {
+		if len(note.Image) > 0 {
+			labels, err := c.Classify(note.Image)
+			if err != nil {
+				warnf("classifier failed: %s\n", err)
+			}
+			note.Labels = append(note.Labels, labels...)
+		}
+		return n.Notify(note)
+	})
+}
+
+// LabelFilter wraps n so that only notifications carrying at least one of
+// labels are delivered
+func LabelFilter(n Notifier, labels ...string) Notifier {
+	want := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		want[l] = true
+	}
+	return NotifierFunc(func(note *Notification) error {
+		for _, l := range note.Labels {
+			if want[l] {
+				return n.Notify(note)
+			}
+		}
+		return nil
+	})
+}
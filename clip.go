@@ -0,0 +1,116 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The GetClip method downloads the recorded MP4 clip for event from camera o
+// into w and returns its size. A clip cut short of the length the servers
+// announced fails with io.ErrUnexpectedEOF. OpenClip reads the clip as it
+// arrives instead.
+func (c *Cameras) GetClip(ctx context.Context, o *Owned, event Event, w io.Writer) (int64, error) {
+
+	clip, err := c.OpenClip(ctx, o, event)
+	if err != nil {
+		return 0, err
+	}
+	defer clip.Close()
+
+	n, err := io.Copy(w, clip)
+	if err != nil {
+		return n, fmt.Errorf("Get Clip Failed: %w", err)
+	}
+	if clip.Length > 0 && n != clip.Length {
+		return n, fmt.Errorf("Get Clip: received %d of %d bytes: %w", n, clip.Length, io.ErrUnexpectedEOF)
+	}
+	return n, nil
+}
+
+// The SaveClip method downloads the clip for event from camera o and writes
+// it to path. The file only appears once the whole clip has been received.
+// With Dropcam.Uploads set the clip is uploaded as well, or instead. With
+// Dropcam.Downloader set the clip is downloaded through it, in chunks and
+// resuming an earlier attempt.
+func (c *Cameras) SaveClip(ctx context.Context, o *Owned, event Event, path string) error {
+
+	if c.Dropcam.Downloader != nil {
+		if err := c.Dropcam.requireCVR("Get Clip", o); err != nil {
+			return err
+		}
+		v := url.Values{}
+		v.Set("uuid", o.Uuid)
+		v.Add("cuepoint_id", fmt.Sprintf("%d", event.Id))
+		open := func(ctx context.Context, hdr http.Header) (*http.Response, error) {
+			return c.Dropcam.getRequestHeader(withJob(ctx, JobClip), c.Dropcam.nexusPath(o, c.Dropcam.EventGetClipPath), v, hdr)
+		}
+		return c.saveResumable(ctx, o, "Get Clip", open, path, event.Start())
+	}
+
+	clip, err := c.OpenClip(ctx, o, event)
+	if err != nil {
+		Dbg("Failed to OpenClip: %s\n", err)
+		return err
+	}
+	defer clip.Close()
+
+	return c.writeClip(ctx, o, clip, path, event.Start(), fmt.Sprintf("event %d", event.Id))
+}
+
+// writeClip writes the clip of camera o read from clip, started at taken, to
+// path through a temporary file, uploading it when Dropcam.Uploads is set
+func (c *Cameras) writeClip(ctx context.Context, o *Owned, clip io.Reader, path string, taken time.Time, what string) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".clip-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	n, err := io.Copy(f, clip)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		Dbg("failed to write clip into file: '%s', %s\n", path, err)
+		return err
+	}
+	if n == 0 {
+		os.Remove(tmp)
+		return fmt.Errorf("Clip for %s is empty", what)
+	}
+	return c.finishClip(ctx, o, tmp, path, taken)
+}
+
+// finishClip uploads the complete clip in tmp when Dropcam.Uploads is set
+// and renames it to path
+func (c *Cameras) finishClip(ctx context.Context, o *Owned, tmp, path string, taken time.Time) error {
+
+	if u := c.Dropcam.Uploads; u != nil {
+		_, err := u.putFile(ctx, UploadClip, o, filepath.Base(path), taken, tmp)
+		if err != nil || !u.KeepLocal {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	Dbg("wrote clip to \"%s\"\n", path)
+	return nil
+}
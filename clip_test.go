@@ -0,0 +1,46 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestGetClip(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c, err := s.Cameras(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := c.ByUUID(dropcamtest.FrontDoor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mp4 := bytes.Repeat([]byte("ftypmp42"), 4096)
+	s.SetClip(o.Uuid, 42, mp4)
+
+	var buf bytes.Buffer
+	n, err := c.GetClip(ctx, o, dropcam.Event{Id: 42}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(mp4)) || !bytes.Equal(buf.Bytes(), mp4) {
+		t.Errorf("GetClip wrote %d bytes, want the %d of the clip", n, len(mp4))
+	}
+
+	if _, err := c.GetClip(ctx, o, dropcam.Event{Id: 43}, &buf); !errors.Is(err, dropcam.ErrNotFound) {
+		t.Errorf("GetClip of an event without a clip: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// States of a requested clip
+const (
+	ClipPending    = "pending"
+	ClipProcessing = "processing"
+	ClipReady      = "ready"
+	ClipFailed     = "failed"
+)
+
+// DefaultClipPollInterval is how often WaitClip asks after a clip
+const DefaultClipPollInterval = 5 * time.Second
+
+// The ClipRequest type is a clip the servers make on demand from a camera's
+// recording. Progress runs from 0 to 1; DownloadURL is set once State is
+// ClipReady.
+type ClipRequest struct {
+	Id          string    `json:"id"`
+	Camera      string    `json:"camera_uuid"`
+	Title       string    `json:"title"`
+	Start       time.Time `json:"-"`
+	End         time.Time `json:"-"`
+	State       string    `json:"status"`
+	Progress    float64   `json:"progress"`
+	DownloadURL string    `json:"download_url"`
+	Message     string    `json:"status_detail"`
+
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// Done reports whether the clip is ready or has failed
+func (r *ClipRequest) Done() bool {
+	return r.State == ClipReady || r.State == ClipFailed
+}
+
+// decodeClipRequest reads a clip request from a reply, bare or wrapped in items
+func decodeClipRequest(body []byte) (*ClipRequest, error) {
+
+	var req ClipRequest
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && bytes.Contains(trimmed, []byte(`"items"`)) {
+		var reply struct {
+			Items []ClipRequest `json:"items"`
+		}
+		if err := json.Unmarshal(trimmed, &reply); err != nil {
+			return nil, err
+		}
+		if len(reply.Items) == 0 {
+			return nil, errors.New("reply has no clip")
+		}
+		req = reply.Items[0]
+	} else if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, errors.New("reply has no clip id")
+	}
+	if req.StartTime > 0 {
+		req.Start = epochTime(req.StartTime)
+	}
+	if req.EndTime > 0 {
+		req.End = epochTime(req.EndTime)
+	}
+	return &req, nil
+}
+
+// The RequestClip method asks the servers to make a clip of camera o's
+// recording from start to end, named title, so any part of the history can
+// be exported rather than only the clips of events. The clip is made in the
+// background; follow it with ClipStatus or WaitClip and fetch it with
+// DownloadClip. The camera needs cloud recording covering the range.
+func (c *Cameras) RequestClip(ctx context.Context, o *Owned, start, end time.Time, title string) (*ClipRequest, error) {
+
+	if !end.After(start) {
+		return nil, errors.New("Request Clip: end must be after start")
+	}
+	if err := c.Dropcam.requireCVR("Request Clip", o); err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"uuid":       o.Uuid,
+		"start_time": float64(start.UnixNano()) / 1e9,
+		"end_time":   float64(end.UnixNano()) / 1e9,
+		"title":      title,
+	}
+	path := c.Dropcam.nexusPath(o, c.Dropcam.ClipRequestPath)
+	if err := c.Dropcam.refuseWrite("Request Clip", "POST", path, o.Uuid, data); err != nil {
+		return nil, err
+	}
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", path, o.Uuid, data)
+	if err != nil {
+		return nil, fmt.Errorf("Request Clip Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 && response.StatusCode != 202 {
+		return nil, newAPIError("Request Clip", response, body)
+	}
+	req, err := decodeClipRequest(body)
+	if err != nil {
+		return nil, errors.New("Can't unmarshal Clip Request: " + err.Error())
+	}
+	req.Camera = o.Uuid
+	if req.Title == "" {
+		req.Title = title
+	}
+	if req.Start.IsZero() {
+		req.Start, req.End = start, end
+	}
+	if req.State == "" {
+		req.State = ClipPending
+	}
+	return req, nil
+}
+
+// The ClipStatus method fetches the current state of the clip requested as id
+// from camera o
+func (c *Cameras) ClipStatus(ctx context.Context, o *Owned, id string) (*ClipRequest, error) {
+
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	v.Add("id", id)
+	response, err := c.Dropcam.getRequest(ctx, c.Dropcam.nexusPath(o, c.Dropcam.ClipStatusPath), v)
+	if err != nil {
+		return nil, fmt.Errorf("Clip Status Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Clip Status", response, body)
+	}
+	req, err := decodeClipRequest(body)
+	if err != nil {
+		return nil, errors.New("Can't unmarshal Clip Status: " + err.Error())
+	}
+	req.Camera = o.Uuid
+	return req, nil
+}
+
+// The WaitClip method polls the clip requested as req every interval,
+// DefaultClipPollInterval when zero, until it is ready, it fails or ctx is
+// done, and returns its last state. A failed clip is returned with an error.
+func (c *Cameras) WaitClip(ctx context.Context, o *Owned, req *ClipRequest, interval time.Duration) (*ClipRequest, error) {
+
+	if interval <= 0 {
+		interval = DefaultClipPollInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for !req.Done() {
+		select {
+		case <-ctx.Done():
+			return req, ctx.Err()
+		case <-tick.C:
+		}
+		cur, err := c.ClipStatus(ctx, o, req.Id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return req, ctx.Err()
+			}
+			Dbg("clip %s: %s\n", req.Id, err)
+			continue
+		}
+		req = cur
+	}
+	if req.State == ClipFailed {
+		return req, fmt.Errorf("Clip %q failed: %s", req.Title, req.Message)
+	}
+	return req, nil
+}
+
+// The DownloadClip method writes the ready clip req of camera o to path, as
+// SaveClip does for event clips
+func (c *Cameras) DownloadClip(ctx context.Context, o *Owned, req *ClipRequest, path string) error {
+
+	if c.Dropcam.Downloader != nil {
+		u, v, err := clipRequestURL(o, req)
+		if err != nil {
+			return err
+		}
+		open := func(ctx context.Context, hdr http.Header) (*http.Response, error) {
+			return c.Dropcam.getRequestHeader(withJob(ctx, JobClip), u, v, hdr)
+		}
+		return c.saveResumable(ctx, o, "Download Clip", open, path, req.Start)
+	}
+
+	clip, err := c.OpenClipRequest(ctx, o, req)
+	if err != nil {
+		return err
+	}
+	defer clip.Close()
+	return c.writeClip(ctx, o, clip, path, req.Start, "clip "+req.Id)
+}
+
+// The OpenClipRequest method opens the ready clip req of camera o as it
+// arrives
+func (c *Cameras) OpenClipRequest(ctx context.Context, o *Owned, req *ClipRequest) (*Download, error) {
+
+	u, v, err := clipRequestURL(o, req)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.Dropcam.getRequest(withJob(ctx, JobClip), u, v)
+	if err != nil {
+		return nil, fmt.Errorf("Download Clip Failed: %w", err)
+	}
+	return openDownload("Download Clip", response)
+}
+
+// clipRequestURL returns the URL and parameters the ready clip req of camera
+// o downloads from
+func clipRequestURL(o *Owned, req *ClipRequest) (string, url.Values, error) {
+
+	if req.State != ClipReady || req.DownloadURL == "" {
+		return "", nil, fmt.Errorf("Clip %q is not ready: %s", req.Title, req.State)
+	}
+	u, err := url.Parse(req.DownloadURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("Bad clip download URL: %w", err)
+	}
+	v := u.Query()
+	v.Set("uuid", o.Uuid)
+	u.RawQuery = ""
+	return u.String(), v, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command dropcam-apiserver serves the cameras of a Dropcam account as a
+// JSON API over HTTP, for home-automation stacks in Python, Node and the like
+// that cannot link Go code.
+//
+//	dropcam-apiserver -listen localhost:8081 -keys keys.json
+//	dropcam-apiserver -keys keys.json -create-key homeassistant -scopes read,control
+//	dropcam-apiserver -users users.json -add-user alice -role operator < password
+//
+// The account is read from DROPCAM_USER and DROPCAM_PASS and the login is
+// kept in -sessions. Every request needs a key from -keys, sent as
+// "Authorization: Bearer <key>", or the basic auth of a user from -users;
+// at least one of the two is required. -create-key adds a key, prints it and
+// exits; -add-user adds a user, or replaces its password and role, with the
+// password read from standard input, and exits. Keys with the read scope, and
+// viewers, can list cameras, snapshots and events, and those with the
+// control scope, and operators, can also turn streaming on and off:
+//
+//	curl -H "Authorization: Bearer $KEY" localhost:8081/cameras
+//	curl -H "Authorization: Bearer $KEY" localhost:8081/cameras/<uuid>/snapshot?width=1280 -o now.jpg
+//	curl -H "Authorization: Bearer $KEY" localhost:8081/cameras/<uuid>/events?since=2h&type=motion
+//	curl -H "Authorization: Bearer $KEY" -X POST localhost:8081/cameras/<uuid>/streaming?enabled=false
+//	curl -u alice localhost:8081/cameras
+//
+// Every request of a user is logged to standard error as allowed or denied.
+// -proxy, -cacert and -insecure work as for the dropcam command.
+//
+// See dropcam.APIHandler for the endpoints, which /openapi.json describes as
+// an OpenAPI 3 document for client generators. The camera list is refreshed
+// every -refresh so new cameras and changed states appear. /healthz and
+// /readyz answer liveness and readiness probes without a key, failing once
+// the session is rejected and cannot be renewed; see dropcam.Health.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rabarar/dropcam"
+)
+
+const (
+	USER = "DROPCAM_USER"
+	PASS = "DROPCAM_PASS"
+)
+
+func main() {
+
+	listen := flag.String("listen", "localhost:8081", "address to serve the API on")
+	keysPath := flag.String("keys", "", "API keys file")
+	sessions := flag.String("sessions", "", "file keeping the login between runs")
+	refresh := flag.Duration("refresh", 5*time.Minute, "how often to reload the camera list")
+	width := flag.Int("width", 720, "snapshot width when a request does not give one")
+	createKey := flag.String("create-key", "", "add a key with this name to -keys, print it and exit")
+	scopes := flag.String("scopes", dropcam.ScopeRead, "comma-separated scopes of the key -create-key adds")
+	usersPath := flag.String("users", "", "users file")
+	addUser := flag.String("add-user", "", "add a user with this name to -users, reading its password from standard input, and exit")
+	role := flag.String("role", dropcam.RoleViewer, "role of the user -add-user adds: viewer, operator or admin")
+	proxy := flag.String("proxy", "", "proxy URL, or none; HTTPS_PROXY and HTTP_PROXY when empty")
+	cacert := flag.String("cacert", "", "PEM file of certificate authorities to trust, such as a TLS-intercepting proxy's")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (unsafe; for diagnosing proxies only)")
+	flag.Parse()
+
+	if *keysPath == "" && *usersPath == "" {
+		log.Fatal("dropcam-apiserver: -keys or -users is required")
+	}
+	var guard dropcam.Guards
+	if *keysPath != "" {
+		keys, err := dropcam.LoadAPIKeys(*keysPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *createKey != "" {
+			token, _, err := keys.Create(*createKey, strings.Split(*scopes, ","), nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(token)
+			return
+		}
+		guard = append(guard, keys)
+	}
+	if *usersPath != "" {
+		users, err := dropcam.LoadUsers(*usersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *addUser != "" {
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && password == "" {
+				log.Fatal("dropcam-apiserver: no password on standard input")
+			}
+			if err := users.Set(*addUser, strings.TrimRight(password, "\r\n"), *role, nil); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		users.Account, users.Audit = os.Getenv(USER), os.Stderr
+		guard = append(guard, users)
+	}
+	if *createKey != "" || *addUser != "" {
+		log.Fatal("dropcam-apiserver: -create-key needs -keys and -add-user needs -users")
+	}
+
+	var dial dropcam.DialConfig
+	err := dial.SetProxy(*proxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cacert != "" {
+		if dial.RootCAs, err = dropcam.LoadCABundle(*cacert); err != nil {
+			log.Fatal(err)
+		}
+	}
+	dial.InsecureSkipVerify = *insecure
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	c, err := login(ctx, *sessions, dial)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	api := &apiServer{cameras: c, guard: guard, width: *width}
+	mux := http.NewServeMux()
+	health := &dropcam.Health{Dropcam: c.Dropcam, Guard: guard, Started: time.Now()}
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.Handle("/", api)
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("dropcam-apiserver: serving %d cameras on %s", len(c.Cam)+len(c.Shared), *listen)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	tick := time.NewTicker(*refresh)
+	defer tick.Stop()
+loop:
+	for {
+		select {
+		case <-sig:
+			break loop
+		case <-tick.C:
+			api.reload()
+		}
+	}
+
+	log.Printf("dropcam-apiserver: shutting down")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	srv.Shutdown(ctx)
+	cancel()
+}
+
+// apiServer serves the API over the latest camera list
+type apiServer struct {
+	guard dropcam.Guards
+	width int
+
+	mu      sync.Mutex
+	cameras *dropcam.Cameras
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	h := &dropcam.APIHandler{Cameras: s.cameras, Guard: s.guard, Width: s.width}
+	s.mu.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+// reload fetches the camera list again, keeping the old one on failure
+func (s *apiServer) reload() {
+
+	s.mu.Lock()
+	d := s.cameras.Dropcam
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c, err := d.Cameras(ctx)
+	if err != nil {
+		log.Printf("dropcam-apiserver: camera list not refreshed: %s", err)
+		return
+	}
+	s.mu.Lock()
+	s.cameras = c
+	s.mu.Unlock()
+}
+
+func login(ctx context.Context, sessions string, dial dropcam.DialConfig) (*dropcam.Cameras, error) {
+
+	u, p := os.Getenv(USER), os.Getenv(PASS)
+	if u == "" || p == "" {
+		return nil, fmt.Errorf("need to set both %s and %s", USER, PASS)
+	}
+	d := new(dropcam.Dropcam)
+	d.Dial = dial
+	if sessions != "" {
+		d.Sessions = &dropcam.FileSessionStore{Path: sessions}
+	}
+	d.Auth = &dropcam.CookieAuth{Credentials: dropcam.EnvCredentials(USER, PASS)}
+	if _, err := d.Init(ctx, u, ""); err != nil {
+		return nil, err
+	}
+	return d.Cameras(ctx)
+}
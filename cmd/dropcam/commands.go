@@ -0,0 +1,885 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rabarar/dropcam"
+)
+
+func cmdList(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Parse(args)
+
+	if *asJSON {
+		return c.WriteJSON(os.Stdout)
+	}
+	cams, _ := cameras(c, "")
+	for _, o := range cams {
+		state := "offline"
+		if o.IsOnline {
+			state = "online"
+		}
+		fmt.Printf("%s  %-8s %s\n", o.Uuid, state, o.Title)
+	}
+	return nil
+}
+
+func cmdSnapshot(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid, title or group")
+	width := fs.Int("width", 720, "image width")
+	height := fs.Int("height", 0, "scale to this height as well, keeping the aspect ratio when zero")
+	out := fs.String("o", "", "output file, <uuid>.jpg when empty; .png and .webp files are converted")
+	fallback := fs.String("fallback", "", "narrower widths to retry at, such as 720,480")
+	timeout := fs.Duration("timeout", 0, "time to allow each width before falling back")
+	stamp := fs.String("stamp", "", "stamp the title and time in this corner: top-left, top-right, bottom-left or bottom-right")
+	text := fs.String("text", "", "text to stamp as well")
+	exif := fs.Bool("exif", false, "write the time, camera and position into the image's EXIF")
+	exists := fs.String("exists", dropcam.CollisionOverwrite, "when the file exists: overwrite, skip or suffix")
+	fsync := fs.Bool("fsync", false, "flush the image to disk before exiting")
+	quality := fs.Int("quality", 0, "JPEG quality from 1 to 100, the servers' when zero")
+	crop := fs.String("crop", "", "only this part of the image, as x,y,width,height at --width")
+	aspect := fs.String("aspect", "", "cut the image to this aspect ratio, such as 16:9")
+	fs.Parse(args)
+
+	c.Dropcam.Files = dropcam.FileOptions{Collision: *exists, Fsync: *fsync}
+
+	if *stamp != "" || *text != "" {
+		c.Dropcam.Overlay = &dropcam.Overlay{Title: *stamp != "", Time: *stamp != "", Text: *text, Position: *stamp}
+	}
+	if *exif {
+		c.Dropcam.EXIF = &dropcam.EXIF{}
+	}
+
+	opts := dropcam.ImageOptions{Width: *width, Height: *height, Quality: *quality, Aspect: *aspect}
+	if *crop != "" {
+		var x, y, w, h int
+		if n, _ := fmt.Sscanf(*crop, "%d,%d,%d,%d", &x, &y, &w, &h); n != 4 || w <= 0 || h <= 0 {
+			return fmt.Errorf("bad -crop %q; want x,y,width,height", *crop)
+		}
+		opts.Crop = image.Rect(x, y, x+w, y+h)
+	}
+
+	if *fallback != "" {
+		wf := &dropcam.WidthFallback{Timeout: *timeout}
+		for _, f := range strings.Split(*fallback, ",") {
+			w, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				return fmt.Errorf("bad -fallback width %q", f)
+			}
+			wf.Widths = append(wf.Widths, w)
+		}
+		c.Dropcam.WidthFallback = wf
+	}
+
+	if *name != "" {
+		if _, ok := groups.Members(*name); ok {
+			if *out != "" {
+				return errors.New("-o can't be used with a group")
+			}
+			cams, err := cameras(c, *name)
+			if err != nil {
+				return err
+			}
+			for _, o := range cams {
+				if err := c.SaveImageAs(ctx, o, o.Uuid+".jpg", time.Now(), opts); err != nil {
+					return fmt.Errorf("%s: %w", o.Title, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = o.Uuid + ".jpg"
+	}
+	switch strings.ToLower(filepath.Ext(*out)) {
+	case ".png", ".webp":
+	default:
+		if opts == (dropcam.ImageOptions{Width: *width}) {
+			return c.SaveImage(ctx, o, *out, *width, time.Now())
+		}
+	}
+	return c.SaveImageAs(ctx, o, *out, time.Now(), opts)
+}
+
+// parseSince takes a duration before now or an RFC 3339 time
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("--since takes a duration like 1h or an RFC 3339 time")
+	}
+	return t, nil
+}
+
+func cmdEvents(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid, title or group, every camera when empty")
+	since := fs.String("since", "24h", "how far back to list, as a duration or RFC 3339 time")
+	types := fs.String("type", "", "only events of these comma-separated types, e.g. motion,person")
+	important := fs.Bool("important", false, "only events marked important")
+	minDuration := fs.Duration("min-duration", 0, "only events lasting at least this long")
+	asJSON := fs.Bool("json", false, "write JSON")
+	until := fs.String("until", "", "list up to this time, as a duration ago or RFC 3339 time; now when empty")
+	format := fs.String("format", "", "write the events as json, jsonl, csv or ics")
+	out := fs.String("o", "", "write to this file instead of standard output")
+	fs.Parse(args)
+
+	from, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	var to time.Time
+	if *until != "" {
+		if to, err = parseSince(*until); err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		*format = dropcam.ExportJSON
+	}
+	cams, err := cameras(c, *name)
+	if err != nil {
+		return err
+	}
+
+	q := dropcam.EventQuery{Start: from, End: to, Important: *important, MinDuration: *minDuration}
+	if *types != "" {
+		q.Types = strings.Split(*types, ",")
+	}
+
+	titles := make(map[string]string)
+	var all dropcam.Events
+	for _, o := range cams {
+		events, err := c.QueryEvents(ctx, o, q)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.Title, err)
+		}
+		titles[o.Uuid] = o.Title
+		all = append(all, events...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].StartTime < all[j].StartTime })
+
+	if *format != "" {
+		w := os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := all.Export(w, *format, titles); err != nil {
+			return err
+		}
+		if *out != "" {
+			return w.Close()
+		}
+		return nil
+	}
+	for _, e := range all {
+		fmt.Printf("%s  %-12d %-8s %6.1fs  %s\n", e.Start().Format("2006-01-02 15:04:05 MST"), e.Id, e.Type,
+			e.End().Sub(e.Start()).Seconds(), titles[e.Camera])
+	}
+	return nil
+}
+
+func cmdClip(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("clip", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title")
+	id := fs.Int64("id", 0, "event id, as listed by events")
+	since := fs.String("since", "168h", "how far back to look for the event, or the start of a --to range")
+	to := fs.String("to", "", "instead of an event, have a clip made from --since to this time, as a duration or RFC 3339 time")
+	out := fs.String("o", "", "output file, <event id>.mp4 when empty")
+	preset := fs.String("preset", "", "re-encode with a transcode preset: web, mobile or archive")
+	resume := fs.Bool("resume", false, "download in chunks, resuming an interrupted download of the same file")
+	share := fs.Duration("share", 0, "instead of downloading the clip, print a link to it that lasts this long")
+	fs.Parse(args)
+
+	if *resume {
+		c.Dropcam.Downloader = &dropcam.Downloader{}
+	}
+
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+	if *to != "" {
+		return requestClip(ctx, c, o, *since, *to, *out)
+	}
+	if *id == 0 {
+		return errors.New("--id or --to is required")
+	}
+	from, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+
+	events, err := c.GetEvents(ctx, o, from, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Id != *id {
+			continue
+		}
+		if *share > 0 {
+			link, err := c.GetClipShareURL(ctx, o, e, *share)
+			if err != nil {
+				return err
+			}
+			fmt.Println(link)
+			return nil
+		}
+		if *out == "" {
+			*out = strconv.FormatInt(e.Id, 10) + ".mp4"
+		}
+		if *preset != "" {
+			p, err := dropcam.LookupPreset(*preset)
+			if err != nil {
+				return err
+			}
+			return c.SaveClipPreset(ctx, o, e, *out, p, nil)
+		}
+		return c.SaveClip(ctx, o, e, *out)
+	}
+	return fmt.Errorf("no event %d on %s since %s", *id, o.Title, from.Format(time.RFC3339))
+}
+
+// requestClip has a clip of o made from since to to and saves it to out
+func requestClip(ctx context.Context, c *dropcam.Cameras, o *dropcam.Owned, since, to, out string) error {
+
+	from, err := parseSince(since)
+	if err != nil {
+		return err
+	}
+	end, err := parseSince(to)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = o.Uuid + "-" + from.Format("20060102-150405") + ".mp4"
+	}
+
+	req, err := c.RequestClip(ctx, o, from, end, filepath.Base(out))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "waiting for clip %s\n", req.Id)
+	if req, err = c.WaitClip(ctx, o, req, 0); err != nil {
+		return err
+	}
+	return c.DownloadClip(ctx, o, req, out)
+}
+
+func cmdBackfill(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title")
+	since := fs.String("since", "24h", "start of the range, as a duration or RFC 3339 time")
+	until := fs.String("until", "0s", "end of the range, as a duration or RFC 3339 time")
+	step := fs.Duration("step", time.Minute, "time between frames")
+	dir := fs.String("dir", ".", "directory the frames are saved below, in <uuid>/backfill")
+	fs.Parse(args)
+
+	from, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	to, err := parseSince(*until)
+	if err != nil {
+		return err
+	}
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.Backfill(ctx, o, from, to, *step, dropcam.DirStorage{Root: *dir})
+	if res != nil {
+		fmt.Printf("%d saved, %d already saved, %d duplicate, %d missing\n",
+			len(res.Saved), res.Existing, res.Duplicate, res.Missing)
+	}
+	return err
+}
+
+func cmdSheet(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("sheet", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title")
+	since := fs.String("since", "24h", "start of the range, as a duration or RFC 3339 time")
+	until := fs.String("until", "0s", "end of the range, as a duration or RFC 3339 time")
+	step := fs.Duration("step", 30*time.Minute, "time between frames")
+	columns := fs.Int("columns", 8, "frames per row")
+	width := fs.Int("width", 240, "width of each frame")
+	out := fs.String("o", "", "output file, <uuid>-sheet.jpg when empty; .png and .webp files are converted")
+	fs.Parse(args)
+
+	from, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	to, err := parseSince(*until)
+	if err != nil {
+		return err
+	}
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = o.Uuid + "-sheet.jpg"
+	}
+
+	sheet, err := c.ContactSheet(ctx, o, from, to, *step, dropcam.ContactSheetLayout{Columns: *columns, TileWidth: *width, Gap: 2})
+	if err != nil {
+		return err
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+	data, err := dropcam.EncodeImage(ctx, sheet, format, dropcam.ImageOptions{})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*out, data, 0644)
+}
+
+func cmdRecorded(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("recorded", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title")
+	at := fs.String("at", "", "save the recorded frame nearest this time, as a duration ago or RFC 3339 time")
+	width := fs.Int("width", 720, "image width")
+	out := fs.String("o", "", "with --at, output file, <uuid>-<time>.jpg when empty")
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Parse(args)
+
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+
+	if *at != "" {
+		t, err := parseSince(*at)
+		if err != nil {
+			return err
+		}
+		img, taken, err := c.GetFrameAt(ctx, o, t, *width)
+		if err != nil {
+			return err
+		}
+		if *out == "" {
+			*out = o.Uuid + "-" + taken.UTC().Format("20060102-150405") + ".jpg"
+		}
+		if err := ioutil.WriteFile(*out, img, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("%s: frame of %s\n", *out, taken.Format(time.RFC3339))
+		return nil
+	}
+
+	ranges, err := c.GetAvailableRanges(ctx, o)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(os.Stdout).Encode(ranges)
+	}
+	for _, r := range ranges {
+		fmt.Printf("%s  %s  %s\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.End.Sub(r.Start).Round(time.Second))
+	}
+	return nil
+}
+
+func cmdProp(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	if len(args) == 0 || (args[0] != "get" && args[0] != "set") {
+		return errors.New("usage: dropcam prop get|set [--camera <uuid|title>] ...")
+	}
+	fs := flag.NewFlagSet("prop "+args[0], flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title; set also takes a group")
+	structure := fs.String("structure", "", "set: only the cameras of this structure id or name")
+	match := fs.String("match", "", "set: only the cameras whose title matches this regular expression")
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Parse(args[1:])
+
+	if args[0] == "get" {
+		o, err := camera(c, *name)
+		if err != nil {
+			return err
+		}
+		info, err := c.CameraInfo(ctx, o)
+		if err != nil {
+			return err
+		}
+		props := make(map[string]interface{})
+		for _, p := range info.Properties {
+			if fs.NArg() == 0 || p.Name == fs.Arg(0) {
+				props[p.Name] = p.Value
+			}
+		}
+		if *asJSON {
+			return json.NewEncoder(os.Stdout).Encode(props)
+		}
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s = %v\n", name, props[name])
+		}
+		return nil
+	}
+
+	if fs.NArg() != 2 {
+		return errors.New("usage: dropcam prop set [--camera <uuid|title>] [--structure <name>] [--match <regexp>] <name> <value>")
+	}
+	cams, err := cameras(c, *name)
+	if err != nil {
+		return err
+	}
+	uuids := make([]string, len(cams))
+	for i, o := range cams {
+		uuids[i] = o.Uuid
+	}
+	filters := []dropcam.CameraFilter{dropcam.CameraUUIDs(uuids...)}
+	if *structure != "" {
+		filters = append(filters, dropcam.InStructure(*structure))
+	}
+	if *match != "" {
+		re, err := regexp.Compile(*match)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, dropcam.TitleMatches(re))
+	}
+	filter := func(o *dropcam.Owned) bool {
+		for _, f := range filters {
+			if !f(o) {
+				return false
+			}
+		}
+		return true
+	}
+
+	failed := false
+	enc := json.NewEncoder(os.Stdout)
+	for _, res := range c.SetPropertiesBulk(ctx, filter, map[string]string{fs.Arg(0): fs.Arg(1)}) {
+		failed = failed || !res.OK()
+		if !*asJSON {
+			fmt.Println(res.String())
+			continue
+		}
+		errs := make(map[string]string)
+		for name, err := range res.Failed {
+			errs[name] = err.Error()
+		}
+		enc.Encode(map[string]interface{}{"camera_uuid": res.Camera.Uuid, "title": res.Camera.Title, "ok": res.OK(), "failed": errs})
+	}
+	if failed {
+		return errors.New("not every camera was changed")
+	}
+	return nil
+}
+
+func cmdWatch(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid, title or group, every camera when empty")
+	script := fs.String("exec", "", "command run for every event, added to the on-event hook")
+	interval := fs.Duration("interval", dropcam.DefaultWatchInterval, "how often to poll")
+	asJSON := fs.Bool("json", false, "write each event as a line of JSON")
+	push := fs.Bool("push", false, "subscribe to the servers' event stream instead of polling")
+	fs.Parse(args)
+
+	cams, err := cameras(c, *name)
+	if err != nil {
+		return err
+	}
+	if *script != "" {
+		hooks.Add(dropcam.HookEvent, *script)
+	}
+
+	if hooks.Has(dropcam.HookCameraOffline) || hooks.Has(dropcam.HookCameraOnline) || hooks.Has(dropcam.HookName(dropcam.NotifyTrial)) {
+		m := &dropcam.Monitor{Dropcam: c.Dropcam, Interval: *interval, Notifier: hooks}
+		if err := m.Start(); err != nil {
+			return err
+		}
+		defer m.Stop()
+	}
+
+	var out sync.Mutex
+	enc := json.NewEncoder(os.Stdout)
+	var wg sync.WaitGroup
+	for _, o := range cams {
+		w := c.Watch(ctx, o, dropcam.WatchOptions{Interval: *interval, Push: *push})
+		wg.Add(1)
+		go func(o *dropcam.Owned) {
+			defer wg.Done()
+			for e := range w.C {
+				out.Lock()
+				if *asJSON {
+					enc.Encode(e.JSON())
+				} else {
+					fmt.Printf("%s  %-12d %-8s %s\n", e.Start().Format("2006-01-02 15:04:05"), e.Id, e.Type, o.Title)
+				}
+				out.Unlock()
+				err := hooks.Notify(&dropcam.Notification{
+					Kind:      dropcam.NotifyEvent,
+					Camera:    o.Uuid,
+					Title:     o.Title,
+					Message:   e.Type + " detected",
+					Time:      e.Start(),
+					EventType: e.Type,
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "dropcam: %s\n", err)
+				}
+			}
+			if err := w.Err(); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "dropcam: %s: %s\n", o.Title, err)
+			}
+		}(o)
+	}
+	wg.Wait()
+	return nil
+}
+
+func cmdDiag(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("diag", flag.ExitOnError)
+	out := fs.String("o", "dropcam-forensics.zip", "bundle file")
+	fs.Parse(args)
+
+	cams, _ := cameras(c, "")
+	failed := 0
+	for _, o := range cams {
+		var err error
+		if _, err = c.CameraInfo(ctx, o); err == nil {
+			_, err = c.GetImage(ctx, o, 320, time.Time{})
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("%-8s %s: %s\n", "FAIL", o.Title, err)
+		} else {
+			fmt.Printf("%-8s %s\n", "ok", o.Title)
+		}
+	}
+
+	reason := fmt.Sprintf("diag: %d of %d cameras failed", failed, len(cams))
+	if err := writeBundle(*out, reason); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", *out)
+	return nil
+}
+
+func cmdUsage(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	period := fs.String("period", "month", "day, month or total")
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Parse(args)
+
+	if *asJSON {
+		b := c.Dropcam.Bandwidth
+		var usage []dropcam.BandwidthUsage
+		switch *period {
+		case "day":
+			usage = b.Daily(time.Now())
+		case "month":
+			usage = b.Monthly(time.Now())
+		case "total":
+			usage = b.Total()
+		default:
+			return fmt.Errorf("unknown period %q", *period)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(usage)
+	}
+	return c.BandwidthReport(os.Stdout, *period, time.Now())
+}
+
+func cmdGroup(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	verb, args := args[0], args[1:]
+	switch verb {
+	case "list":
+		names := groups.Names()
+		if len(args) > 0 {
+			names = args
+		}
+		for _, name := range names {
+			cams, err := c.Group(groups, name)
+			if err != nil {
+				return err
+			}
+			titles := make([]string, len(cams))
+			for i, o := range cams {
+				titles[i] = o.Title
+			}
+			fmt.Printf("%-16s %s\n", name, strings.Join(titles, ", "))
+		}
+		return nil
+	case "delete":
+		if len(args) != 1 {
+			return errors.New("usage: dropcam group delete <group>")
+		}
+		groups.Delete(args[0])
+		return groups.Save()
+	case "set", "add", "remove":
+	default:
+		return errors.New("usage: dropcam group list|set|add|remove|delete ...")
+	}
+
+	if len(args) < 1 || (verb != "set" && len(args) < 2) {
+		return fmt.Errorf("usage: dropcam group %s <group> <uuid|title>...", verb)
+	}
+	var uuids []string
+	for _, name := range args[1:] {
+		cams, err := cameras(c, name)
+		if err != nil && verb == "remove" {
+			// cameras no longer on the account are removed by uuid
+			uuids = append(uuids, name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, o := range cams {
+			uuids = append(uuids, o.Uuid)
+		}
+	}
+	switch verb {
+	case "set":
+		groups.Set(args[0], uuids...)
+	case "add":
+		groups.Add(args[0], uuids...)
+	case "remove":
+		groups.Remove(args[0], uuids...)
+	}
+	return groups.Save()
+}
+
+func cmdReconcile(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	path := fs.String("state", "desired.json", "desired state file")
+	fix := fs.Bool("fix", false, "change drifted settings back")
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*path); err != nil {
+		return err
+	}
+	state, err := dropcam.LoadDesiredState(*path)
+	if err != nil {
+		return err
+	}
+	rc := &dropcam.Reconciler{Cameras: c, Groups: groups, State: state, Correct: *fix}
+	drift, err := rc.Reconcile(ctx)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if eerr := enc.Encode(drift); eerr != nil {
+			return eerr
+		}
+	} else {
+		for _, d := range drift {
+			fmt.Println(d)
+		}
+	}
+	return err
+}
+
+func cmdInventory(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("usage: dropcam inventory export|import [flags]")
+	}
+	verb, args := args[0], args[1:]
+	switch verb {
+	case "export":
+		fs := flag.NewFlagSet("inventory export", flag.ExitOnError)
+		name := fs.String("camera", "", "camera uuid, title or group; every camera when empty")
+		out := fs.String("o", "", "output file, stdout when empty")
+		fs.Parse(args)
+
+		var filter dropcam.CameraFilter
+		if *name != "" {
+			cams, err := cameras(c, *name)
+			if err != nil {
+				return err
+			}
+			uuids := make([]string, len(cams))
+			for i, o := range cams {
+				uuids[i] = o.Uuid
+			}
+			filter = dropcam.CameraUUIDs(uuids...)
+		}
+		if *out == "" {
+			return c.ExportInventory(ctx, os.Stdout, nil, filter)
+		}
+		var buf bytes.Buffer
+		if err := c.ExportInventory(ctx, &buf, nil, filter); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(*out, buf.Bytes(), 0600)
+
+	case "import":
+		fs := flag.NewFlagSet("inventory import", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "only report what would change")
+		clone := fs.String("clone", "", "apply the inventory camera <uuid> to other cameras instead, as <uuid>=<camera>[,<camera>...]")
+		asJSON := fs.Bool("json", false, "write JSON")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: dropcam inventory import [--dry-run] [--clone <uuid>=<camera>,...] <inventory.json>")
+		}
+
+		opts := dropcam.InventoryImport{Groups: groups, DryRun: *dryRun}
+		if *clone != "" {
+			src, dst, ok := strings.Cut(*clone, "=")
+			if !ok || dst == "" {
+				return fmt.Errorf("bad --clone %q, want <uuid>=<camera>[,<camera>...]", *clone)
+			}
+			opts.Targets = map[string][]string{src: strings.Split(dst, ",")}
+		}
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		drift, err := c.ImportInventory(ctx, f, opts)
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if eerr := enc.Encode(drift); eerr != nil {
+				return eerr
+			}
+		} else {
+			for _, d := range drift {
+				fmt.Println(d)
+			}
+		}
+		return err
+	}
+	return fmt.Errorf("unknown inventory command %q", verb)
+}
+
+func cmdCamera(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	if len(args) == 0 {
+		return errors.New("usage: dropcam camera add|remove [flags]")
+	}
+	verb, args := args[0], args[1:]
+	switch verb {
+	case "add":
+		fs := flag.NewFlagSet("camera add", flag.ExitOnError)
+		var p dropcam.CameraPairing
+		fs.StringVar(&p.MacAddress, "mac", "", "MAC address of the new camera")
+		fs.StringVar(&p.Serial, "serial", "", "serial number of the new camera, instead of -mac")
+		fs.StringVar(&p.SetupToken, "token", "", "setup token the camera shows")
+		fs.StringVar(&p.Title, "title", "", "title of the new camera")
+		fs.StringVar(&p.Timezone, "timezone", "", "time zone of the new camera, e.g. America/New_York")
+		fs.StringVar(&p.StructureId, "structure", "", "structure to add the camera to")
+		fs.Parse(args)
+
+		o, err := c.AddCamera(ctx, p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s  %s\n", o.Uuid, o.Title)
+		return nil
+	case "remove":
+		fs := flag.NewFlagSet("camera remove", flag.ExitOnError)
+		name := fs.String("camera", "", "camera uuid or title")
+		yes := fs.Bool("yes", false, "remove without asking; the camera's recordings are deleted")
+		fs.Parse(args)
+
+		o, err := camera(c, *name)
+		if err != nil {
+			return err
+		}
+		if !*yes {
+			return fmt.Errorf("removing %s deletes its recordings; run again with --yes", o.Title)
+		}
+		return c.RemoveCamera(ctx, o)
+	}
+	return errors.New("usage: dropcam camera add|remove [flags]")
+}
+
+func cmdSay(ctx context.Context, c *dropcam.Cameras, args []string) error {
+
+	fs := flag.NewFlagSet("say", flag.ExitOnError)
+	name := fs.String("camera", "", "camera uuid or title")
+	format := fs.String("format", "", "audio format: pcm or opus; taken from the file name when empty, any ffmpeg reads otherwise")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: dropcam say --camera <uuid|title> <file|->")
+	}
+
+	o, err := camera(c, *name)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	file := fs.Arg(0)
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".pcm", ".raw":
+			*format = dropcam.AudioPCM
+		case ".opus":
+			*format = dropcam.AudioOpus
+		}
+	}
+
+	t, err := c.Talkback(ctx, o)
+	if err != nil {
+		return err
+	}
+	return t.Send(ctx, in, *format)
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/rabarar/dropcam"
+)
+
+// keychainService names the account's entry in the OS keychain
+const keychainService = "dropcam"
+
+// defaultCredentials is the order credentials are looked for in by default
+const defaultCredentials = "env,keychain,file,prompt"
+
+// fileCredentials is the credentials file, as -credentials-file names it
+type fileCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// credentialChain returns the provider chain -credentials lists, for the
+// account user; user may be empty for the sources that can tell it
+func credentialChain(sources, user, file string) (dropcam.CredentialsFunc, error) {
+
+	var chain []dropcam.CredentialsFunc
+	for _, src := range strings.Split(sources, ",") {
+		switch strings.TrimSpace(src) {
+		case "env":
+			chain = append(chain, envCredentials(user))
+		case "keychain":
+			chain = append(chain, func(ctx context.Context) (string, string, error) {
+				return keychainCredentials(ctx, keychainService, user)
+			})
+		case "file":
+			chain = append(chain, func(ctx context.Context) (string, string, error) {
+				return readCredentialsFile(file, user)
+			})
+		case "prompt":
+			chain = append(chain, func(ctx context.Context) (string, string, error) {
+				return promptCredentials(user)
+			})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown credential source %q; want env, keychain, file or prompt", src)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("-credentials lists no source")
+	}
+	return dropcam.ChainCredentials(chain...), nil
+}
+
+// envCredentials reads the password from DROPCAM_PASS and the username from
+// DROPCAM_USER, unless user is given
+func envCredentials(user string) dropcam.CredentialsFunc {
+	return func(ctx context.Context) (string, string, error) {
+		u, p := user, os.Getenv(PASS)
+		if u == "" {
+			u = os.Getenv(USER)
+		}
+		if u == "" || p == "" {
+			return "", "", fmt.Errorf("%w: %s and %s are not both set", dropcam.ErrNoCredentials, USER, PASS)
+		}
+		return u, p, nil
+	}
+}
+
+// readCredentialsFile reads the credentials of user, or whoever it holds,
+// from the JSON file path. The file must only be readable by its owner.
+func readCredentialsFile(path, user string) (string, string, error) {
+
+	if path == "" {
+		return "", "", fmt.Errorf("%w: no credentials file", dropcam.ErrNoCredentials)
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("%w: no credentials file %s", dropcam.ErrNoCredentials, path)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return "", "", fmt.Errorf("credentials file %s is accessible to others (mode %o); chmod 600 it", path, fi.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var fc fileCredentials
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return "", "", fmt.Errorf("credentials file %s: %w", path, err)
+	}
+	if fc.Password == "" || (user != "" && fc.Username != user) {
+		return "", "", fmt.Errorf("%w: credentials file %s has no password for %s", dropcam.ErrNoCredentials, path, user)
+	}
+	return fc.Username, fc.Password, nil
+}
+
+// promptCredentials asks for the username, unless user is given, and the
+// password when stdin is a terminal, without echoing the password
+func promptCredentials(user string) (string, string, error) {
+
+	if !isTerminal(os.Stdin) {
+		return "", "", fmt.Errorf("%w: not a terminal to prompt on", dropcam.ErrNoCredentials)
+	}
+	if user == "" {
+		fmt.Fprint(os.Stderr, "Dropcam username: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err == io.EOF {
+			return "", "", fmt.Errorf("%w: nothing entered", dropcam.ErrNoCredentials)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		user = strings.TrimSpace(line)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+	p, err := readHidden()
+	fmt.Fprintln(os.Stderr)
+	if err != nil && err != io.EOF {
+		return "", "", err
+	}
+	if user == "" || p == "" {
+		return "", "", fmt.Errorf("%w: nothing entered", dropcam.ErrNoCredentials)
+	}
+	return user, p, nil
+}
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
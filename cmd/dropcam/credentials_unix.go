@@ -0,0 +1,123 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/rabarar/dropcam"
+)
+
+// keychainAccount is the account of an entry as security lists it
+var keychainAccount = regexp.MustCompile(`"acct"<blob>="([^"]*)"`)
+
+// keychainCredentials reads the password of user from the macOS Keychain, or
+// from the Secret Service, such as GNOME Keyring or KWallet, elsewhere. With
+// user empty the account of the first entry of service is used. Entries are
+// made with
+//
+//	security add-generic-password -s dropcam -a <username> -w
+//	secret-tool store --label=dropcam service dropcam username <username>
+func keychainCredentials(ctx context.Context, service, user string) (string, string, error) {
+	if runtime.GOOS == "darwin" {
+		return macKeychain(ctx, service, user)
+	}
+	return secretService(ctx, service, user)
+}
+
+func macKeychain(ctx context.Context, service, user string) (string, string, error) {
+
+	if user == "" {
+		out, err := exec.CommandContext(ctx, "security", "find-generic-password", "-s", service).Output()
+		if err != nil {
+			return "", "", keychainError("security", err)
+		}
+		m := keychainAccount.FindSubmatch(out)
+		if m == nil {
+			return "", "", fmt.Errorf("%w: keychain entry %s has no account", dropcam.ErrNoCredentials, service)
+		}
+		user = string(m[1])
+	}
+	out, err := exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", user, "-w").Output()
+	if err != nil {
+		return "", "", keychainError("security", err)
+	}
+	return user, strings.TrimRight(string(out), "\n"), nil
+}
+
+func secretService(ctx context.Context, service, user string) (string, string, error) {
+
+	if user != "" {
+		out, err := exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "username", user).Output()
+		if err != nil || len(out) == 0 {
+			return "", "", keychainError("secret-tool", err)
+		}
+		return user, strings.TrimRight(string(out), "\n"), nil
+	}
+
+	// search lists the attributes and secret of each matching item
+	out, err := exec.CommandContext(ctx, "secret-tool", "search", "service", service).CombinedOutput()
+	if err != nil {
+		return "", "", keychainError("secret-tool", err)
+	}
+	var secret string
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, " = ")
+		switch {
+		case !ok:
+		case k == "secret" && secret == "":
+			secret = v
+		case k == "attribute.username" && user == "":
+			user = v
+		}
+	}
+	if user == "" || secret == "" {
+		return "", "", fmt.Errorf("%w: no Secret Service item for %s", dropcam.ErrNoCredentials, service)
+	}
+	return user, secret, nil
+}
+
+// keychainError tells a missing tool or entry, which means no credentials,
+// from a keychain that could not be read
+func keychainError(tool string, err error) error {
+
+	var exit *exec.ExitError
+	switch {
+	case err == nil, errors.Is(err, exec.ErrNotFound):
+		return fmt.Errorf("%w: no keychain entry", dropcam.ErrNoCredentials)
+	case errors.As(err, &exit) && (exit.ExitCode() == 44 || tool == "secret-tool" && exit.ExitCode() == 1):
+		// security exits 44 and secret-tool 1 for an item not found
+		return fmt.Errorf("%w: no keychain entry", dropcam.ErrNoCredentials)
+	case errors.As(err, &exit) && len(exit.Stderr) > 0:
+		return fmt.Errorf("%s: %s", tool, strings.TrimSpace(string(exit.Stderr)))
+	}
+	return fmt.Errorf("%s: %w", tool, err)
+}
+
+// readHidden reads a line from the terminal on stdin without echoing it
+func readHidden() (string, error) {
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("can't turn off echo: %w", err)
+	}
+	defer stty("echo")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,114 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/rabarar/dropcam"
+)
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredFree   = advapi32.NewProc("CredFree")
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsole = kernel32.NewProc("GetConsoleMode")
+	procSetConsole = kernel32.NewProc("SetConsoleMode")
+)
+
+const (
+	credTypeGeneric = 1
+	errorNotFound   = syscall.Errno(1168)
+	enableEchoInput = 0x4
+)
+
+// credential is the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keychainCredentials reads the generic credential service from the Windows
+// Credential Manager, as made by
+//
+//	cmdkey /generic:dropcam /user:<username> /pass
+//
+// With user given, the credential must be for user.
+func keychainCredentials(ctx context.Context, service, user string) (string, string, error) {
+
+	target, err := syscall.UTF16PtrFromString(service)
+	if err != nil {
+		return "", "", err
+	}
+	var cred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return "", "", fmt.Errorf("%w: no Credential Manager entry %s", dropcam.ErrNoCredentials, service)
+		}
+		return "", "", fmt.Errorf("Credential Manager: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	name := ""
+	if cred.UserName != nil {
+		name = syscall.UTF16ToString(unsafe.Slice(cred.UserName, wcslen(cred.UserName)))
+	}
+	if user != "" && !strings.EqualFold(name, user) {
+		return "", "", fmt.Errorf("%w: Credential Manager entry %s is for %s", dropcam.ErrNoCredentials, service, name)
+	}
+	// cmdkey stores the password as UTF-16
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	u := make([]uint16, len(blob)/2)
+	for i := range u {
+		u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return name, string(utf16.Decode(u)), nil
+}
+
+// wcslen returns the length of the NUL-terminated UTF-16 string at p
+func wcslen(p *uint16) int {
+	n := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(p), 2*n)) != 0 {
+		n++
+	}
+	return n
+}
+
+// readHidden reads a line from the console on stdin without echoing it
+func readHidden() (string, error) {
+
+	h := os.Stdin.Fd()
+	var mode uint32
+	if r, _, err := procGetConsole.Call(h, uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return "", fmt.Errorf("can't turn off echo: %w", err)
+	}
+	if r, _, err := procSetConsole.Call(h, uintptr(mode&^enableEchoInput)); r == 0 {
+		return "", fmt.Errorf("can't turn off echo: %w", err)
+	}
+	defer procSetConsole.Call(h, uintptr(mode))
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
@@ -0,0 +1,391 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command dropcam works with the cameras of a Dropcam account from the shell.
+//
+//	dropcam list
+//	dropcam snapshot --camera <uuid|title> --width 1080 -o out.jpg
+//	dropcam snapshot --camera <uuid|title> --width 1080 --fallback 720,480 --timeout 10s
+//	dropcam snapshot --camera <uuid|title> --stamp bottom-right --text "Case 1234" [--exif]
+//	dropcam snapshot --camera <uuid|title> -o archive/front.jpg --exists suffix [--fsync]
+//	dropcam snapshot --camera <uuid|title> --width 480 --quality 40 --crop 0,0,240,135 --aspect 16:9
+//	dropcam events --camera <uuid|title> --since 1h [--type motion,person] [--important] [--min-duration 10s]
+//	dropcam events --since 2024-03-01T00:00:00Z --until 2024-04-01T00:00:00Z --format csv|jsonl|ics -o events.csv
+//	dropcam clip --camera <uuid|title> --id <event id> [--preset web] -o clip.mp4
+//	dropcam clip --camera <uuid|title> --since 2h --to 90m [--resume] -o clip.mp4
+//	dropcam clip --camera <uuid|title> --id <event id> --share 24h
+//	dropcam backfill --camera <uuid|title> --since 168h --step 1m --dir frames
+//	dropcam sheet --camera <uuid|title> --since 24h --step 30m [--columns 8] [--width 240] -o day.jpg
+//	dropcam recorded --camera <uuid|title> [--at 3h -o frame.jpg]
+//	dropcam prop get --camera <uuid|title> [name]
+//	dropcam prop set [--camera <uuid|title>] [--structure <name>] [--match <regexp>] <name> <value>
+//	dropcam watch [--camera <uuid|title>] [--push] --exec script.sh
+//	dropcam diag -o bundle.zip
+//	dropcam usage [--period day|month|total]
+//	dropcam group set|add|remove <group> <uuid|title>...
+//	dropcam group list|delete [group]
+//	dropcam reconcile --state desired.json [--fix]
+//	dropcam inventory export [--camera <uuid|title|group>] -o inventory.json
+//	dropcam inventory import [--dry-run] [--clone <uuid>=<camera>,...] inventory.json
+//	dropcam camera add --mac <address>|--serial <number> --token <setup token> [--title <title>]
+//	dropcam camera remove --camera <uuid|title> --yes
+//	dropcam say --camera <uuid|title> [--format pcm|opus] <file.wav|->
+//
+// The account is -user, or DROPCAM_USER. Its password is looked for in the
+// sources -credentials lists, in order, env,keychain,file,prompt by default:
+// DROPCAM_PASS; the macOS Keychain, Secret Service or Windows Credential
+// Manager entry "dropcam", made with
+//
+//	security add-generic-password -s dropcam -a <username> -w
+//	secret-tool store --label=dropcam service dropcam username <username>
+//	cmdkey /generic:dropcam /user:<username> /pass
+//
+// credentials.json in the config directory, or -credentials-file, holding
+// {"username": ..., "password": ...} and readable only by its owner; and a
+// prompt on the terminal that does not echo the password. Without a username
+// the keychain, file or prompt supplies it too. The login is kept in the
+// user's config directory so frequent runs do not log in every time.
+// Accounts with two-factor authentication take the code from DROPCAM_OTP, or
+// prompt for it on a terminal; the device is then trusted so later logins
+// need no code.
+//
+// Requests go through the proxy in HTTPS_PROXY, or -proxy; -proxy none
+// connects directly. Behind a proxy that intercepts TLS, -cacert names a PEM
+// file of its certificate authority. -insecure skips certificate checks
+// altogether and should only be used to diagnose a proxy.
+//
+// With -read-only, commands that would change the account or its cameras,
+// such as prop set, camera add and reconcile --fix, log what they would have
+// sent and fail instead, to try scripts against a live account.
+//
+// With -pushgateway, the outcome, duration and bytes downloaded of the
+// command are pushed to a Prometheus Pushgateway as job dropcam_<command>,
+// so cron runs are observable; see dropcam.JobMetrics.
+//
+// Hooks, external commands run on-event, on-snapshot-saved, on-camera-offline,
+// on-camera-online and on-trial, are read from hooks.json in the same
+// directory, e.g.
+//
+//	{"on-event": ["/usr/local/bin/notify-me"], "on-camera-offline": ["page-oncall --urgent"]}
+//
+// Each gets the notification as JSON on stdin and in DROPCAM_* variables; see
+// dropcam.ExecHooks.
+//
+// list, events, prop, recorded and watch take --json to write machine-readable output
+// for scripts and jq.
+//
+// Camera groups are kept in groups.json in the config directory. A group
+// name can be given to --camera wherever a title can; snapshot then saves
+// one <uuid>.jpg per camera of the group.
+//
+// The bytes downloaded are counted per camera and job in usage.json in the
+// config directory; usage reports them for budgeting metered connections.
+//
+// reconcile compares the cameras with a desired state file and prints each
+// setting that drifted; --fix changes them back. See dropcam.DesiredState,
+// e.g.
+//
+//	{"cameras": [{"camera": "outside", "streaming": true, "irled": "auto_on",
+//	  "notifications": {"motion": true, "sound": false}}]}
+//
+// say plays audio on the speaker of a camera with two-way audio, such as a
+// Dropcam Pro. It needs ffmpeg built with libspeex.
+//
+// diag checks every camera and writes a forensics bundle of the requests
+// made, failed responses and configuration, with credentials removed, to
+// attach to a bug report. The global -bundle flag writes the same bundle
+// whenever a command fails, including when logging in does.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/rabarar/dropcam"
+)
+
+const (
+	USER = "DROPCAM_USER"
+	PASS = "DROPCAM_PASS"
+	OTP  = "DROPCAM_OTP"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, c *dropcam.Cameras, args []string) error
+}
+
+// hooks are the commands run on lifecycle hooks
+var hooks *dropcam.ExecHooks
+
+// forensics records the requests made for diag and -bundle
+var forensics = new(dropcam.Forensics)
+
+// bandwidth counts the bytes downloaded, kept between runs
+var bandwidth *dropcam.Bandwidth
+
+// groups are the user's camera groups, accepted wherever a camera is
+var groups *dropcam.Groups
+
+var commands = []command{
+	{"list", "list the cameras", cmdList},
+	{"snapshot", "save a snapshot", cmdSnapshot},
+	{"events", "list recent events", cmdEvents},
+	{"clip", "download an event clip", cmdClip},
+	{"backfill", "save recorded frames over a past time range", cmdBackfill},
+	{"sheet", "tile recorded frames over a time range into one image", cmdSheet},
+	{"recorded", "list the recorded time ranges, or save the frame nearest a time", cmdRecorded},
+	{"prop", "get or set camera properties", cmdProp},
+	{"watch", "run a command for every new event", cmdWatch},
+	{"diag", "check the cameras and write a forensics bundle", cmdDiag},
+	{"usage", "report the bytes downloaded", cmdUsage},
+	{"group", "list or change camera groups", cmdGroup},
+	{"reconcile", "compare the cameras with a desired state", cmdReconcile},
+	{"inventory", "back up the camera configuration or apply a backup", cmdInventory},
+	{"camera", "add a camera to the account or remove one", cmdCamera},
+	{"say", "play an audio file on a camera's speaker", cmdSay},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: dropcam [-sessions file] [-hooks file] [-bundle file] [-proxy url] [-cacert file] [-pushgateway url] [-read-only] <command> [flags]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+
+	sessions := flag.String("sessions", configPath("sessions.json"), "file keeping the login between runs, none when empty")
+	hookFile := flag.String("hooks", configPath("hooks.json"), "file listing the hook commands")
+	flag.StringVar(&bundle, "bundle", "", "write a forensics bundle to this file if the command fails")
+	proxy := flag.String("proxy", "", "proxy URL, or none; HTTPS_PROXY and HTTP_PROXY when empty")
+	cacert := flag.String("cacert", "", "PEM file of certificate authorities to trust, such as a TLS-intercepting proxy's")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification (unsafe; for diagnosing proxies only)")
+	gateway := flag.String("pushgateway", "", "Prometheus Pushgateway URL to push the command's job metrics to")
+	readOnly := flag.Bool("read-only", false, "log the changes commands would make to the account instead of making them")
+	user := flag.String("user", "", "account username; "+USER+" when empty")
+	sources := flag.String("credentials", defaultCredentials, "where to look for the password, in order: env, keychain, file, prompt")
+	credFile := flag.String("credentials-file", configPath("credentials.json"), "JSON file of the username and password, mode 0600")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage()
+	}
+
+	var err error
+	var dial dropcam.DialConfig
+	if err := dial.SetProxy(*proxy); err != nil {
+		fatal(err)
+	}
+	if *cacert != "" {
+		if dial.RootCAs, err = dropcam.LoadCABundle(*cacert); err != nil {
+			fatal(err)
+		}
+	}
+	dial.InsecureSkipVerify = *insecure
+	if hooks, err = dropcam.LoadExecHooks(*hookFile); err != nil {
+		fatal(err)
+	}
+	if bandwidth, err = dropcam.LoadBandwidth(configPath("usage.json")); err != nil {
+		fatal(err)
+	}
+	if groups, err = dropcam.LoadGroups(configPath("groups.json")); err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *user == "" {
+		*user = os.Getenv(USER)
+	}
+	creds, err := credentialChain(*sources, *user, *credFile)
+	if err != nil {
+		fatal(err)
+	}
+	job := dropcam.StartJob("dropcam_" + cmd.name)
+	downloaded := totalBytes(bandwidth)
+	push := func(err error) {
+		if *gateway == "" {
+			return
+		}
+		job.Finish(err)
+		job.Bytes = totalBytes(bandwidth) - downloaded
+		d := &dropcam.Dropcam{Dial: dial}
+		if perr := d.PushMetrics(context.Background(), *gateway, job); perr != nil {
+			fmt.Fprintf(os.Stderr, "dropcam: %s\n", perr)
+		}
+	}
+
+	c, err := login(ctx, *sessions, dial, *user, creds)
+	if err != nil {
+		push(err)
+		fatal(err)
+	}
+	c.Dropcam.ReadOnly = *readOnly
+	err = cmd.run(ctx, c, flag.Args()[1:])
+	if serr := bandwidth.Save(); serr != nil {
+		fmt.Fprintf(os.Stderr, "dropcam: %s\n", serr)
+	}
+	push(err)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// totalBytes returns the bytes b counted since counting started
+func totalBytes(b *dropcam.Bandwidth) int64 {
+	var n int64
+	for _, u := range b.Total() {
+		n += u.Bytes
+	}
+	return n
+}
+
+// bundle is where fatal writes a forensics bundle, nowhere when empty
+var bundle string
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "dropcam: %s\n", err)
+	if bundle != "" {
+		if berr := writeBundle(bundle, err.Error()); berr != nil {
+			fmt.Fprintf(os.Stderr, "dropcam: %s\n", berr)
+		} else {
+			fmt.Fprintf(os.Stderr, "dropcam: wrote forensics bundle %s\n", bundle)
+		}
+	}
+	os.Exit(1)
+}
+
+// writeBundle writes the forensics bundle to path
+func writeBundle(path, reason string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := forensics.WriteBundle(f, reason); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// configPath returns the path of the named file in the config directory
+func configPath(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dropcam", name)
+}
+
+// login logs in to the account user with the credentials creds gives, or
+// resumes its saved session. Without user, creds is asked for the account
+// now, else only when a login is needed.
+func login(ctx context.Context, sessions string, dial dropcam.DialConfig, user string, creds dropcam.CredentialsFunc) (*dropcam.Cameras, error) {
+
+	if user == "" {
+		u, p, err := creds(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// the first login uses what was just given rather than ask again
+		user = u
+		next := creds
+		creds = func(ctx context.Context) (string, string, error) {
+			if p != "" {
+				defer func() { p = "" }()
+				return user, p, nil
+			}
+			return next(ctx)
+		}
+	}
+
+	d := new(dropcam.Dropcam)
+	d.Dial = dial
+	d.Forensics = forensics
+	d.Bandwidth = bandwidth
+	if hooks.Has(dropcam.HookSnapshotSaved) {
+		d.Saved = hooks
+	}
+	if sessions != "" {
+		if err := os.MkdirAll(filepath.Dir(sessions), 0700); err == nil {
+			d.Sessions = &dropcam.FileSessionStore{Path: sessions}
+		}
+	}
+	d.Auth = &dropcam.CookieAuth{Credentials: creds, OTP: promptOTP, TrustDevice: true}
+	if _, err := d.Init(ctx, user, ""); err != nil {
+		return nil, err
+	}
+	return d.Cameras(ctx)
+}
+
+// promptOTP supplies a two-factor code from DROPCAM_OTP, or asks for it when
+// stdin is a terminal
+func promptOTP(ctx context.Context, c *dropcam.Challenge) (string, error) {
+
+	if code := os.Getenv(OTP); code != "" {
+		return code, nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("two-factor code needed, set %s", OTP)
+	}
+	if c.Message != "" {
+		fmt.Fprintln(os.Stderr, c.Message)
+	}
+	fmt.Fprint(os.Stderr, "Two-factor code: ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(code), nil
+}
+
+// cameras returns the camera named by uuid or title, the cameras of the group
+// name, or every camera when name is empty
+func cameras(c *dropcam.Cameras, name string) ([]*dropcam.Owned, error) {
+
+	if name == "" {
+		var all []*dropcam.Owned
+		for i := range c.Cam {
+			all = append(all, &c.Cam[i])
+		}
+		for i := range c.Shared {
+			all = append(all, &c.Shared[i].Owned)
+		}
+		return all, nil
+	}
+	return c.Select(groups, name)
+}
+
+// camera returns the one camera named, or the only camera of the account
+func camera(c *dropcam.Cameras, name string) (*dropcam.Owned, error) {
+	cams, err := cameras(c, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(cams) != 1 {
+		return nil, errors.New("--camera is required")
+	}
+	return cams[0], nil
+}
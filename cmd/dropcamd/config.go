@@ -0,0 +1,384 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/rabarar/dropcam"
+)
+
+// duration is a time.Duration written in JSON as a string such as "90s"
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("durations are strings such as \"30s\" or \"24h\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// cameraConfig is one camera, or group of cameras, to record: every
+// interval, or with Change only while the scene changes
+type cameraConfig struct {
+	Camera   string        `json:"camera"`
+	Interval duration      `json:"interval"`
+	Width    int           `json:"width"`
+	Change   *changeConfig `json:"change"`
+}
+
+// changeConfig records a camera only while more than Threshold of the frame
+// changes, going on while it changes by more than Release and for Hold after
+type changeConfig struct {
+	Threshold float64  `json:"threshold"`
+	Release   float64  `json:"release"`
+	Hold      duration `json:"hold"`
+}
+
+// outputConfig is where the snapshots go: Dir, and object storage when S3
+// or GCS is set. Path lays them out below Dir, and Rotate archives each
+// finished day in that format.
+type outputConfig struct {
+	Dir       string `json:"dir"`
+	KeepLocal bool   `json:"keep_local"`
+	Template  string `json:"template"`
+	Path      string `json:"path"`
+	Rotate    string `json:"rotate"`
+
+	S3 *struct {
+		Endpoint  string `json:"endpoint"`
+		Region    string `json:"region"`
+		Bucket    string `json:"bucket"`
+		AccessKey string `json:"access_key"`
+		SecretKey string `json:"secret_key"`
+		PathStyle bool   `json:"path_style"`
+	} `json:"s3"`
+	GCS *struct {
+		Bucket string `json:"bucket"`
+	} `json:"gcs"`
+}
+
+// retentionConfig bounds how long snapshots are kept in the output
+// directory, and how much space they take
+type retentionConfig struct {
+	MaxAge  duration `json:"max_age"`
+	MaxSize int64    `json:"max_size"`
+}
+
+// webhookConfig is where notifications are posted
+type webhookConfig struct {
+	URLs   []string `json:"urls"`
+	Secret string   `json:"secret"`
+}
+
+// mqttConfig is the MQTT broker events and camera status are published to,
+// and with Commands the snapshot and profile commands are taken from
+type mqttConfig struct {
+	Broker    string            `json:"broker"`
+	Username  string            `json:"username"`
+	Password  string            `json:"password"`
+	Prefix    string            `json:"prefix"`
+	QoS       byte              `json:"qos"`
+	Snapshots bool              `json:"snapshots"`
+	Discovery bool              `json:"discovery"`
+	Commands  bool              `json:"commands"`
+	Profiles  []dropcam.Profile `json:"profiles"`
+}
+
+// nightVisionConfig switches the night vision of Cameras, every camera when
+// empty, at sunset and sunrise, placing cameras without coordinates at
+// Latitude and Longitude, or at the cron times NightCron and DayCron
+type nightVisionConfig struct {
+	Cameras   []string `json:"cameras"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Night     string   `json:"night"`
+	Day       string   `json:"day"`
+	NightCron string   `json:"night_cron"`
+	DayCron   string   `json:"day_cron"`
+}
+
+// healthConfig tunes /readyz and /healthz: readiness fails with more than
+// MaxBacklog jobs behind schedule, and cameras are stale once their last
+// snapshot is older than MaxSnapshotAge
+type healthConfig struct {
+	MaxBacklog     int      `json:"max_backlog"`
+	MaxSnapshotAge duration `json:"max_snapshot_age"`
+}
+
+// statsConfig is where the daily statistics of each camera are written, as
+// Format, json or csv
+type statsConfig struct {
+	Dir    string `json:"dir"`
+	Format string `json:"format"`
+}
+
+// config is the daemon's configuration file
+type config struct {
+	path string
+
+	Listen   string `json:"listen"`
+	APIKeys  string `json:"api_keys"`
+	Users    string `json:"users"`
+	Sessions string `json:"sessions"`
+	Groups   string `json:"groups"`
+
+	Proxy              string `json:"proxy"`
+	CABundle           string `json:"ca_bundle"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	Interval duration       `json:"interval"`
+	Width    int            `json:"width"`
+	Cameras  []cameraConfig `json:"cameras"`
+
+	Output    outputConfig    `json:"output"`
+	Retention retentionConfig `json:"retention"`
+	Webhooks  webhookConfig   `json:"webhooks"`
+	MQTT      *mqttConfig     `json:"mqtt"`
+
+	NightVision *nightVisionConfig `json:"night_vision"`
+	Health      healthConfig       `json:"health"`
+	Stats       *statsConfig       `json:"stats"`
+
+	MonitorInterval duration `json:"monitor_interval"`
+	SlowRequests    duration `json:"slow_requests"`
+	MaxBackoff      duration `json:"max_backoff"`
+}
+
+// loadConfig reads and checks the configuration file at path, filling in
+// the defaults
+func loadConfig(path string) (*config, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{
+		path:            path,
+		Listen:          "localhost:8080",
+		Interval:        duration(time.Minute),
+		Width:           720,
+		MonitorInterval: duration(time.Minute),
+		MaxBackoff:      duration(dropcam.DefaultPollBackoffMax),
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := cfg.Validate(nil, nil); err != nil {
+		return nil, err
+	}
+
+	if cfg.Output.Dir == "" {
+		cfg.Output.Dir = "."
+	}
+	for i := range cfg.Cameras {
+		cc := &cfg.Cameras[i]
+		if cc.Interval == 0 {
+			cc.Interval = cfg.Interval
+		}
+		if cc.Width == 0 {
+			cc.Width = cfg.Width
+		}
+	}
+	if cfg.Output.Rotate != "" && cfg.Output.Path == "" {
+		cfg.Output.Path = string(dropcam.DefaultPathTemplate)
+	}
+	return cfg, nil
+}
+
+// configError lists every problem of a configuration file, each led by
+// the path of the field at fault, such as "cameras[2].interval"
+type configError struct {
+	path     string
+	problems []string
+}
+
+func (e *configError) add(field, format string, args ...interface{}) {
+	e.problems = append(e.problems, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (e *configError) Error() string {
+	return e.path + ": " + strings.Join(e.problems, "\n"+e.path+": ")
+}
+
+// Validate checks the configuration as a whole, reporting every problem at
+// once: values out of range, schedules that never run, storage without
+// credentials and options that conflict. With the cameras of the account,
+// and the groups of the groups file, it also checks that every camera named
+// exists.
+func (cfg *config) Validate(c *dropcam.Cameras, groups *dropcam.Groups) error {
+
+	e := &configError{path: cfg.path}
+	positive := func(field string, d duration) {
+		if d < 0 {
+			e.add(field, "must not be negative")
+		}
+	}
+	camera := func(field, name string) {
+		if c == nil || name == "" {
+			return
+		}
+		if _, err := c.Select(groups, name); err != nil {
+			e.add(field, "no camera or group %q", name)
+		}
+	}
+
+	if len(cfg.Cameras) == 0 {
+		e.add("cameras", "no cameras to record")
+	}
+	positive("interval", cfg.Interval)
+	if cfg.Width < 0 {
+		e.add("width", "must not be negative")
+	}
+	for i, cc := range cfg.Cameras {
+		field := fmt.Sprintf("cameras[%d]", i)
+		if cc.Camera == "" {
+			e.add(field+".camera", "missing")
+		}
+		camera(field+".camera", cc.Camera)
+		positive(field+".interval", cc.Interval)
+		if cc.Width < 0 {
+			e.add(field+".width", "must not be negative")
+		}
+		if ch := cc.Change; ch != nil {
+			if ch.Threshold < 0 || ch.Threshold > 1 {
+				e.add(field+".change.threshold", "must be a fraction from 0 to 1")
+			}
+			threshold := ch.Threshold
+			if threshold == 0 {
+				threshold = 0.05 // that of dropcam.ChangeRecorder
+			}
+			if ch.Release < 0 || ch.Release > 1 {
+				e.add(field+".change.release", "must be a fraction from 0 to 1")
+			} else if ch.Release > threshold {
+				e.add(field+".change.release", "is above the threshold, so half the threshold is used instead")
+			}
+			positive(field+".change.hold", ch.Hold)
+		}
+	}
+
+	out := cfg.Output
+	if out.S3 != nil && out.GCS != nil {
+		e.add("output", "s3 and gcs conflict; upload to one of them")
+	}
+	if s3 := out.S3; s3 != nil {
+		if s3.Bucket == "" {
+			e.add("output.s3.bucket", "missing")
+		}
+		if s3.AccessKey == "" {
+			e.add("output.s3.access_key", "missing")
+		}
+		if s3.SecretKey == "" {
+			e.add("output.s3.secret_key", "missing")
+		}
+	}
+	if out.GCS != nil && out.GCS.Bucket == "" {
+		e.add("output.gcs.bucket", "missing")
+	}
+	if out.S3 == nil && out.GCS == nil {
+		if out.KeepLocal {
+			e.add("output.keep_local", "only applies with s3 or gcs")
+		}
+		if out.Template != "" {
+			e.add("output.template", "only applies with s3 or gcs")
+		}
+	}
+	switch out.Rotate {
+	case "", dropcam.ArchiveTarGz, dropcam.ArchiveZip:
+	default:
+		e.add("output.rotate", "must be %s or %s", dropcam.ArchiveTarGz, dropcam.ArchiveZip)
+	}
+	positive("retention.max_age", cfg.Retention.MaxAge)
+	if cfg.Retention.MaxSize < 0 {
+		e.add("retention.max_size", "must not be negative")
+	}
+
+	if m := cfg.MQTT; m != nil {
+		if m.Broker == "" {
+			e.add("mqtt.broker", "missing")
+		}
+		if m.Password != "" && m.Username == "" {
+			e.add("mqtt.password", "needs mqtt.username")
+		}
+		if m.QoS > 1 {
+			e.add("mqtt.qos", "must be 0 or 1")
+		}
+		if len(m.Profiles) > 0 && !m.Commands {
+			e.add("mqtt.profiles", "only apply with mqtt.commands")
+		}
+		for i, p := range m.Profiles {
+			if p.Name == "" {
+				e.add(fmt.Sprintf("mqtt.profiles[%d].name", i), "missing")
+			}
+		}
+	}
+
+	if nv := cfg.NightVision; nv != nil {
+		if (nv.Latitude == nil) != (nv.Longitude == nil) {
+			e.add("night_vision", "needs both latitude and longitude")
+		}
+		if (nv.NightCron == "") != (nv.DayCron == "") {
+			e.add("night_vision", "needs both night_cron and day_cron")
+		}
+		for _, f := range [][2]string{{"night_cron", nv.NightCron}, {"day_cron", nv.DayCron}} {
+			if f[1] != "" {
+				if err := dropcam.CheckCron(f[1]); err != nil {
+					e.add("night_vision."+f[0], "%s", err)
+				}
+			}
+		}
+		if nv.NightCron != "" && nv.NightCron == nv.DayCron {
+			e.add("night_vision.day_cron", "is night_cron, so night and day fight")
+		}
+		for _, f := range [][2]string{{"night", nv.Night}, {"day", nv.Day}} {
+			switch dropcam.IRLEDMode(f[1]) {
+			case "", dropcam.IRLEDAuto, dropcam.IRLEDOn, dropcam.IRLEDOff:
+			default:
+				e.add("night_vision."+f[0], "must be %s, %s or %s", dropcam.IRLEDAuto, dropcam.IRLEDOn, dropcam.IRLEDOff)
+			}
+		}
+		for i, name := range nv.Cameras {
+			camera(fmt.Sprintf("night_vision.cameras[%d]", i), name)
+		}
+	}
+
+	if cfg.Health.MaxBacklog < 0 {
+		e.add("health.max_backlog", "must not be negative")
+	}
+	positive("health.max_snapshot_age", cfg.Health.MaxSnapshotAge)
+	if st := cfg.Stats; st != nil {
+		if st.Dir == "" {
+			e.add("stats.dir", "missing")
+		}
+		switch st.Format {
+		case "", dropcam.StatsJSON, dropcam.StatsCSV:
+		default:
+			e.add("stats.format", "must be %s or %s", dropcam.StatsJSON, dropcam.StatsCSV)
+		}
+	}
+	positive("monitor_interval", cfg.MonitorInterval)
+	positive("slow_requests", cfg.SlowRequests)
+	positive("max_backoff", cfg.MaxBackoff)
+
+	if len(e.problems) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestValidate(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "dropcamd.json")
+	data := `{
+	  "cameras": [{"camera": "Front Door"}, {"camera": "Porhc", "interval": "-1s"},
+	    {"camera": "Garage", "change": {"threshold": 0.02, "release": 0.1}}],
+	  "output": {"s3": {"bucket": "cams", "access_key": "AKIA"}, "gcs": {"bucket": "cams"}},
+	  "night_vision": {"night_cron": "0 0 31 2 *", "day_cron": "0 6 * * *", "day": "sometimes"}
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("loaded a configuration with problems")
+	}
+	want := []string{
+		path + `: cameras[1].interval: must not be negative`,
+		path + `: cameras[2].change.release: is above the threshold, so half the threshold is used instead`,
+		path + `: output: s3 and gcs conflict; upload to one of them`,
+		path + `: output.s3.secret_key: missing`,
+		path + `: night_vision.night_cron: cron expression "0 0 31 2 *" never fires`,
+		path + `: night_vision.day: must be auto_on, always_on or always_off`,
+	}
+	if got := err.Error(); got != strings.Join(want, "\n") {
+		t.Errorf("problems\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// the camera names are checked against the account
+	data = `{"cameras": [{"camera": "Front Door"}, {"camera": "Porhc"}], "night_vision": {"cameras": ["Garage", "Shed"]}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c, err := s.Cameras(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		path + `: cameras[1].camera: no camera or group "Porhc"`,
+		path + `: night_vision.cameras[1]: no camera or group "Shed"`,
+	}
+	if err := cfg.Validate(c, nil); err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("problems\n%v\nwant\n%s", err, strings.Join(want, "\n"))
+	}
+}
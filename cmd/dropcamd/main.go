@@ -0,0 +1,564 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command dropcamd records the cameras of a Dropcam account as a long-running
+// service, in place of shell loops around the dropcam command.
+//
+//	dropcamd -config /etc/dropcamd.json
+//	dropcamd -config /etc/dropcamd.json -check
+//
+// The account is read from DROPCAM_USER and DROPCAM_PASS. The configuration
+// is JSON; only "cameras" is required:
+//
+//	{
+//	  "listen": "localhost:8080",
+//	  "api_keys": "/etc/dropcamd/keys.json",
+//	  "users": "/etc/dropcamd/users.json",
+//	  "sessions": "/var/lib/dropcamd/session.json",
+//	  "groups": "/etc/dropcamd/groups.json",
+//	  "proxy": "http://proxy.corp:3128",
+//	  "ca_bundle": "/etc/ssl/corp-proxy.pem",
+//	  "interval": "1m",
+//	  "width": 720,
+//	  "cameras": [{"camera": "Porch", "interval": "10s"}, {"camera": "outside"},
+//	    {"camera": "Shed", "interval": "5s", "change": {"threshold": 0.05, "release": 0.02, "hold": "30s"}}],
+//	  "output": {"dir": "/var/lib/dropcamd", "path": "{camera}/{yyyy}/{mm}/{dd}/{hhmmss}.jpg", "rotate": "tar.gz"},
+//	  "retention": {"max_age": "720h", "max_size": 50000000000},
+//	  "webhooks": {"urls": ["https://example.com/hook"], "secret": "..."},
+//	  "mqtt": {"broker": "tcp://localhost:1883", "prefix": "dropcam", "qos": 1, "snapshots": true, "discovery": true},
+//	  "night_vision": {"cameras": ["outside"], "latitude": 40.7, "longitude": -74.0, "night": "always_on", "day": "auto_on"},
+//	  "health": {"max_backlog": 5, "max_snapshot_age": "10m"},
+//	  "stats": {"dir": "/var/lib/dropcamd/stats", "format": "csv"},
+//	  "slow_requests": "5s",
+//	  "max_backoff": "30m",
+//	  "monitor_interval": "1m"
+//	}
+//
+// A camera is a uuid, a title or a group from the groups file. Every camera
+// is snapshotted each interval into <dir>/<uuid>, or below dir as the output
+// path template lays them out, or uploaded when output names an S3 or GCS
+// bucket, such as {"s3": {"region": "us-east-1", "bucket": "cams",
+// "access_key": "...", "secret_key": "..."}}. Cameras with change are only
+// recorded while their scene changes, each recording into its own folder
+// <dir>/<uuid>/<start>-score<percent>; see dropcam.ChangeRecorder. With
+// rotate, each finished day of snapshots is archived into <dir>/archives and
+// its folder removed. Snapshots older than the retention max_age are
+// deleted, then the oldest while they take more than max_size bytes. Cameras
+// going offline or online, and expiring trials, are posted to the webhooks.
+// With mqtt, events and camera status are published to the broker as well,
+// and with discovery the cameras appear in Home Assistant on their own. With
+// commands, publishing to <prefix>/<uuid>/cmd/snapshot takes a snapshot and
+// publishing a profile name to <prefix>/<uuid>/cmd/profile applies that
+// profile of the mqtt profiles, such as {"name": "night", "properties":
+// {"irled.state": "always_on"}}; see dropcam.MQTT.
+// With night_vision, the night vision of its cameras, every camera when
+// none are listed, is switched to night at sunset and to day at sunrise, by
+// the coordinates of each camera's location or else latitude and longitude;
+// night_cron and day_cron, such as "0 19 * * *", switch at set times
+// instead. API calls taking longer than slow_requests are logged with their
+// endpoint, camera and status, to find the cameras slowing snapshots down.
+// Cameras that are offline, or keep failing, are polled less and less often,
+// up to every max_backoff, until they answer again; "0s" keeps them on
+// schedule. With stats, the requests, snapshots, bytes, failures, latency
+// and uptime of each camera are written every midnight into the stats dir
+// as stats-<yyyy-mm-dd>.json, or .csv with format "csv"; see
+// dropcam.StatsReporter.
+//
+// The service answers on listen:
+//
+//	/healthz  liveness: 503 once the session is rejected and cannot be renewed
+//	/readyz   readiness: 503 until a request has succeeded, or while the
+//	          session is invalid or more than health.max_backlog jobs are late
+//	/metrics  camera metrics for Prometheus
+//	/jobs     the capture jobs, which can be paused, resumed and run
+//
+// Both probes report the session, failing and late jobs, and cameras whose
+// last snapshot is older than health.max_snapshot_age, three intervals by
+// default, without authentication; with a read key they list every camera.
+// /metrics and /jobs need a key from api_keys, or the basic auth of a user
+// from users, such as those dropcam-apiserver -add-user adds; without either
+// they refuse every request. Every request of a user is logged as allowed or
+// denied. Requests go through proxy, HTTPS_PROXY when empty or directly
+// with "none", trusting the certificate authorities in ca_bundle as well as
+// the system's; insecure_skip_verify turns certificate checks off and is
+// only for diagnosing a proxy. SIGHUP reloads the configuration, except
+// listen, sessions and the proxy settings, without dropping the login; SIGTERM and SIGINT stop the jobs and shut down.
+//
+// The configuration is checked as a whole when it is loaded: unknown
+// cameras and groups, cron times that never come, object storage without
+// its credentials and options that conflict are all reported at once, each
+// with the path of its field, such as
+//
+//	/etc/dropcamd.json: cameras[2].camera: no camera or group "Porhc"
+//	/etc/dropcamd.json: output.s3.secret_key: missing
+//
+// -check only checks it, logging in to look the cameras up, and exits.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rabarar/dropcam"
+)
+
+const (
+	USER = "DROPCAM_USER"
+	PASS = "DROPCAM_PASS"
+)
+
+// daemon is the running service: the login, and the jobs and monitor built
+// from the current configuration
+type daemon struct {
+	path    string
+	cameras *dropcam.Cameras
+	started time.Time
+	slow    int64 // slow_requests, read by every call
+
+	mu      sync.Mutex
+	cfg     *config
+	guard   dropcam.Guards
+	jobs    *dropcam.Jobs
+	monitor *dropcam.Monitor
+	mqtt    *dropcam.MQTT
+	events  *dropcam.Dispatcher
+	night   *dropcam.NightVision
+	stats   *dropcam.StatsReporter
+	changes []*dropcam.ChangeRecorder
+}
+
+func main() {
+
+	path := flag.String("config", "/etc/dropcamd.json", "configuration file")
+	check := flag.Bool("check", false, "check the configuration, and the cameras it names, and exit")
+	flag.Parse()
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	c, err := login(ctx, cfg)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *check {
+		var groups *dropcam.Groups
+		if cfg.Groups != "" {
+			if groups, err = dropcam.LoadGroups(cfg.Groups); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := cfg.Validate(c, groups); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: ok\n", *path)
+		return
+	}
+
+	dm := &daemon{path: *path, cameras: c, started: time.Now()}
+	c.Dropcam.Tracer = &dropcam.Tracer{OnResponse: dm.traced}
+	if err := dm.apply(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", dm.health)
+	mux.HandleFunc("/readyz", dm.health)
+	mux.Handle("/metrics", dm.handler(func() http.Handler { return dm.monitor }))
+	mux.Handle("/jobs", dm.handler(func() http.Handler { return dm.jobs }))
+	mux.Handle("/jobs/", dm.handler(func() http.Handler { return dm.jobs }))
+	srv := &http.Server{Addr: cfg.Listen, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("dropcamd: recording %d cameras, listening on %s", len(dm.jobs.States()), cfg.Listen)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			break
+		}
+		cfg, err := loadConfig(dm.path)
+		if err == nil {
+			err = dm.apply(cfg)
+		}
+		if err != nil {
+			log.Printf("dropcamd: reload failed, keeping the running configuration: %s", err)
+			continue
+		}
+		log.Printf("dropcamd: reloaded %s", dm.path)
+	}
+
+	log.Printf("dropcamd: shutting down")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	srv.Shutdown(ctx)
+	cancel()
+	dm.stop()
+}
+
+func login(ctx context.Context, cfg *config) (*dropcam.Cameras, error) {
+
+	u, p := os.Getenv(USER), os.Getenv(PASS)
+	if u == "" || p == "" {
+		return nil, fmt.Errorf("need to set both %s and %s", USER, PASS)
+	}
+	d := new(dropcam.Dropcam)
+	if err := d.Dial.SetProxy(cfg.Proxy); err != nil {
+		return nil, err
+	}
+	if cfg.CABundle != "" {
+		pool, err := dropcam.LoadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		d.Dial.RootCAs = pool
+	}
+	d.Dial.InsecureSkipVerify = cfg.InsecureSkipVerify
+	if cfg.Sessions != "" {
+		d.Sessions = &dropcam.FileSessionStore{Path: cfg.Sessions}
+	}
+	d.Auth = &dropcam.CookieAuth{Credentials: dropcam.EnvCredentials(USER, PASS)}
+	if _, err := d.Init(ctx, u, ""); err != nil {
+		return nil, err
+	}
+	return d.Cameras(ctx)
+}
+
+// apply builds the jobs and monitor of cfg and, once that has worked,
+// replaces the running ones with them
+func (dm *daemon) apply(cfg *config) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// new cameras and titles are picked up on reload
+	c, err := dm.cameras.Dropcam.Cameras(ctx)
+	if err != nil {
+		return err
+	}
+
+	var groups *dropcam.Groups
+	if cfg.Groups != "" {
+		if groups, err = dropcam.LoadGroups(cfg.Groups); err != nil {
+			return err
+		}
+	}
+	if err := cfg.Validate(c, groups); err != nil {
+		return err
+	}
+	var guard dropcam.Guards
+	if cfg.APIKeys != "" {
+		keys, err := dropcam.LoadAPIKeys(cfg.APIKeys)
+		if err != nil {
+			return err
+		}
+		guard = append(guard, keys)
+	}
+	if cfg.Users != "" {
+		users, err := dropcam.LoadUsers(cfg.Users)
+		if err != nil {
+			return err
+		}
+		users.Account, users.Audit = os.Getenv(USER), os.Stderr
+		guard = append(guard, users)
+	}
+
+	var backoff *dropcam.PollBackoff
+	if cfg.MaxBackoff > 0 {
+		backoff = &dropcam.PollBackoff{Max: time.Duration(cfg.MaxBackoff)}
+	}
+	jobs := &dropcam.Jobs{Guard: guard, Backoff: backoff}
+	var changes []*dropcam.ChangeRecorder
+	for _, cc := range cfg.Cameras {
+		cams, err := c.Select(groups, cc.Camera)
+		if err != nil {
+			return err
+		}
+		for _, o := range cams {
+			if ch := cc.Change; ch != nil {
+				changes = append(changes, &dropcam.ChangeRecorder{
+					Cameras:   c,
+					Camera:    o,
+					Interval:  time.Duration(cc.Interval),
+					Width:     cc.Width,
+					Dir:       cfg.Output.Dir,
+					Threshold: dropcam.ChangeThreshold{Threshold: ch.Threshold},
+					Release:   ch.Release,
+					Hold:      time.Duration(ch.Hold),
+					Backoff:   backoff,
+				})
+				continue
+			}
+			job := dropcam.SnapshotPathJob(c, o, cfg.Output.Dir, dropcam.PathTemplate(cfg.Output.Path), cc.Width, time.Duration(cc.Interval))
+			if cfg.Output.Path == "" {
+				dir := filepath.Join(cfg.Output.Dir, o.Uuid)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+				job = dropcam.SnapshotJob(c, o, dir, cc.Width, time.Duration(cc.Interval))
+			}
+			if err := jobs.Add(job); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.Retention.MaxAge > 0 || cfg.Retention.MaxSize > 0 {
+		rm := &dropcam.RetentionManager{
+			Dir:     cfg.Output.Dir,
+			MaxAge:  time.Duration(cfg.Retention.MaxAge),
+			MaxSize: cfg.Retention.MaxSize,
+		}
+		err := jobs.Add(dropcam.Job{
+			Name:     "retention",
+			Interval: dropcam.DefaultRetentionInterval,
+			Run: func(ctx context.Context) error {
+				_, err := rm.Prune(ctx, time.Now())
+				return err
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.Output.Rotate != "" {
+		rot := &dropcam.Rotation{
+			Cameras: c,
+			Root:    cfg.Output.Dir,
+			Path:    dropcam.PathTemplate(cfg.Output.Path),
+			Format:  cfg.Output.Rotate,
+			Storage: dropcam.DirStorage{Root: filepath.Join(cfg.Output.Dir, "archives")},
+			Remove:  true,
+		}
+		if err := jobs.Add(rot.Job()); err != nil {
+			return err
+		}
+	}
+
+	uploads, err := outputUploads(cfg.Output)
+	if err != nil {
+		return err
+	}
+	monitor := &dropcam.Monitor{Dropcam: c.Dropcam, Interval: time.Duration(cfg.MonitorInterval), Guard: guard}
+	if len(cfg.Webhooks.URLs) > 0 {
+		monitor.Notifier = &dropcam.Webhook{URLs: cfg.Webhooks.URLs, Secret: cfg.Webhooks.Secret}
+	}
+
+	var mq *dropcam.MQTT
+	var events *dropcam.Dispatcher
+	if m := cfg.MQTT; m != nil {
+		mq = &dropcam.MQTT{
+			Broker:    m.Broker,
+			Username:  m.Username,
+			Password:  m.Password,
+			Prefix:    m.Prefix,
+			QoS:       m.QoS,
+			Snapshots: m.Snapshots,
+			Discovery: m.Discovery,
+		}
+		if m.Commands {
+			mq.Cameras, mq.Width = c, cfg.Width
+			if len(m.Profiles) > 0 {
+				mq.Profiles = dropcam.NewProfiles(m.Profiles...)
+			}
+		}
+		events = &dropcam.Dispatcher{Cameras: c, Notifier: mq, Interval: time.Duration(cfg.MonitorInterval)}
+		events.Watch.Backoff = backoff
+		if m.Snapshots {
+			events.Width = cfg.Width
+		}
+	}
+
+	var night *dropcam.NightVision
+	if nc := cfg.NightVision; nc != nil {
+		night = &dropcam.NightVision{
+			Cameras:   c,
+			Night:     dropcam.IRLEDMode(nc.Night),
+			Day:       dropcam.IRLEDMode(nc.Day),
+			NightCron: nc.NightCron,
+			DayCron:   nc.DayCron,
+		}
+		if nc.Latitude != nil {
+			night.Position = &dropcam.Position{Latitude: *nc.Latitude, Longitude: *nc.Longitude}
+		}
+		for _, name := range nc.Cameras {
+			cams, err := c.Select(groups, name)
+			if err != nil {
+				return err
+			}
+			for _, o := range cams {
+				night.UUIDs = append(night.UUIDs, o.Uuid)
+			}
+		}
+	}
+
+	var stats *dropcam.StatsReporter
+	if st := cfg.Stats; st != nil {
+		stats = &dropcam.StatsReporter{Cameras: c, Dir: st.Dir, Format: st.Format, Sample: time.Duration(cfg.MonitorInterval)}
+	}
+
+	dm.stop()
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	c.Dropcam.Uploads = uploads
+	atomic.StoreInt64(&dm.slow, int64(cfg.SlowRequests))
+	dm.cfg, dm.guard, dm.jobs, dm.monitor = cfg, guard, jobs, monitor
+	dm.mqtt, dm.events, dm.night, dm.stats, dm.changes = mq, events, night, stats, changes
+	if err := monitor.Start(); err != nil {
+		return err
+	}
+	if night != nil {
+		if err := night.Start(); err != nil {
+			return err
+		}
+	}
+	for _, r := range changes {
+		if err := r.Start(); err != nil {
+			return err
+		}
+	}
+	if stats != nil {
+		if err := stats.Start(); err != nil {
+			return err
+		}
+	}
+	if mq != nil {
+		// the broker may come up later; publishing retries the connection
+		if err := mq.Announce(c); err != nil {
+			log.Printf("mqtt: %s", err)
+		}
+		if err := events.Start(); err != nil {
+			return err
+		}
+	}
+	return jobs.Start()
+}
+
+// stop stops the running jobs, change recorders, monitor, night vision
+// switching, statistics reports and MQTT publishing
+func (dm *daemon) stop() {
+	dm.mu.Lock()
+	jobs, monitor, mq, events, night := dm.jobs, dm.monitor, dm.mqtt, dm.events, dm.night
+	changes, stats := dm.changes, dm.stats
+	dm.mu.Unlock()
+	for _, r := range changes {
+		r.Stop()
+	}
+	if jobs != nil {
+		jobs.Stop()
+	}
+	if monitor != nil {
+		monitor.Stop()
+	}
+	if events != nil {
+		events.Stop()
+	}
+	if night != nil {
+		night.Stop()
+	}
+	if stats != nil {
+		stats.Stop()
+	}
+	if mq != nil {
+		mq.Close()
+	}
+}
+
+// outputUploads returns the Uploads for the object storage named in out, or
+// nil when snapshots stay local
+func outputUploads(out outputConfig) (*dropcam.Uploads, error) {
+
+	var up dropcam.Uploader
+	switch {
+	case out.S3 != nil:
+		up = &dropcam.S3Uploader{
+			Endpoint:  out.S3.Endpoint,
+			Region:    out.S3.Region,
+			Bucket:    out.S3.Bucket,
+			AccessKey: out.S3.AccessKey,
+			SecretKey: out.S3.SecretKey,
+			PathStyle: out.S3.PathStyle,
+		}
+	case out.GCS != nil:
+		up = &dropcam.GCSUploader{Bucket: out.GCS.Bucket}
+	default:
+		return nil, nil
+	}
+	return &dropcam.Uploads{Uploader: up, Template: out.Template, KeepLocal: out.KeepLocal}, nil
+}
+
+// Authorize implements dropcam.Guard with the keys and users of the current
+// configuration; there are none without api_keys and users
+func (dm *daemon) Authorize(r *http.Request) (*dropcam.Principal, bool) {
+	dm.mu.Lock()
+	guard := dm.guard
+	dm.mu.Unlock()
+	return guard.Authorize(r)
+}
+
+// handler serves each request with the handler current returns, so requests
+// reach the jobs and monitor of the latest configuration
+func (dm *daemon) handler(current func() http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dm.mu.Lock()
+		h := current()
+		dm.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// traced logs the calls slower than slow_requests
+func (dm *daemon) traced(ctx context.Context, t *dropcam.RequestTrace) {
+
+	slow := time.Duration(atomic.LoadInt64(&dm.slow))
+	if slow <= 0 || t.Latency < slow {
+		return
+	}
+	camera := t.Camera
+	if camera == "" {
+		camera = "-"
+	}
+	outcome := fmt.Sprint(t.Status)
+	if t.Err != nil {
+		outcome = t.Err.Error()
+	}
+	log.Printf("dropcamd: slow request: %s camera %s took %s in %d attempts: %s",
+		t.Endpoint, camera, t.Latency.Round(time.Millisecond), t.Attempts, outcome)
+}
+
+// health answers the liveness and readiness probes for the jobs and monitor
+// of the current configuration
+func (dm *daemon) health(w http.ResponseWriter, r *http.Request) {
+
+	dm.mu.Lock()
+	h := &dropcam.Health{
+		Dropcam:        dm.cameras.Dropcam,
+		Monitor:        dm.monitor,
+		Jobs:           dm.jobs,
+		MaxBacklog:     dm.cfg.Health.MaxBacklog,
+		MaxSnapshotAge: time.Duration(dm.cfg.Health.MaxSnapshotAge),
+		Guard:          dm,
+		Started:        dm.started,
+	}
+	dm.mu.Unlock()
+	h.ServeHTTP(w, r)
+}
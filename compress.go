@@ -0,0 +1,96 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// DefaultJPEGQuality is the quality snapshots are re-encoded at when no
+// JPEGBudget sets one
+const DefaultJPEGQuality = 90
+
+// The JPEGBudget type controls how snapshots are recompressed. Quality is the
+// JPEG quality to encode at. When MaxBytes is set, images larger than that are
+// encoded at the highest quality down to MinQuality, 30 when zero, that fits;
+// if none does they are scaled down until they fit, so every snapshot stays
+// within the budget and archive growth is predictable.
+type JPEGBudget struct {
+	Quality    int
+	MaxBytes   int
+	MinQuality int
+}
+
+// needed reports whether jpg has to be recompressed to meet the budget
+func (b *JPEGBudget) needed(jpg []byte) bool {
+	return b != nil && (b.Quality > 0 || (b.MaxBytes > 0 && len(jpg) > b.MaxBytes))
+}
+
+// Encode encodes img as a JPEG within the budget. A nil budget encodes at
+// DefaultJPEGQuality.
+func (b *JPEGBudget) Encode(img image.Image) ([]byte, error) {
+
+	quality := DefaultJPEGQuality
+	if b != nil && b.Quality > 0 {
+		quality = b.Quality
+	}
+	out, err := encodeJPEG(img, quality)
+	if err != nil || b == nil || b.MaxBytes <= 0 || len(out) <= b.MaxBytes {
+		return out, err
+	}
+
+	min := b.MinQuality
+	if min <= 0 {
+		min = 30
+	}
+	if min > quality {
+		min = quality
+	}
+
+	for scaled := 0; ; scaled++ {
+		// binary search for the highest quality that fits
+		lo, hi := min, quality
+		var best []byte
+		for lo <= hi {
+			q := (lo + hi) / 2
+			enc, err := encodeJPEG(img, q)
+			if err != nil {
+				return nil, err
+			}
+			if len(enc) <= b.MaxBytes {
+				best, lo = enc, q+1
+			} else {
+				out, hi = enc, q-1
+			}
+		}
+		if best != nil || scaled == 8 {
+			if best == nil {
+				Dbg("snapshot still %d bytes over budget\n", len(out)-b.MaxBytes)
+				return out, nil
+			}
+			return best, nil
+		}
+
+		// bytes grow roughly with the pixel count
+		f := math.Sqrt(float64(b.MaxBytes)/float64(len(out))) * 0.95
+		bounds := img.Bounds()
+		w, h := int(float64(bounds.Dx())*f), int(float64(bounds.Dy())*f)
+		if w < 16 || h < 16 {
+			return out, nil
+		}
+		img = resize(toRGBA(img), w, h)
+	}
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+)
+
+// MaxContactSheetFrames bounds the frames of one contact sheet, so a wrong
+// step cannot set off thousands of requests
+const MaxContactSheetFrames = 1000
+
+// The ContactSheetLayout type controls how ContactSheet tiles frames.
+// Columns defaults to 8 and TileWidth, the width frames are requested at, to
+// 240. Concurrency bounds the frames fetched at once, 4 when zero.
+type ContactSheetLayout struct {
+	Columns     int
+	TileWidth   int
+	Gap         int
+	Background  color.Color
+	Concurrency int
+}
+
+// The ContactSheet method fetches the recorded frames of camera o from from
+// up to, but not including, to, one every step, and tiles them in time order
+// into a single image under a header naming the camera and range, each frame
+// stamped with its time in the camera's zone, to scan a day's footage at a
+// glance. Times no frame could be had for, as during a gap in the
+// recording, are left as empty tiles marked NO FRAME. The camera needs cloud
+// recording covering the range. Its requests are low priority for
+// Dropcam.Quota.
+func (c *Cameras) ContactSheet(ctx context.Context, o *Owned, from, to time.Time, step time.Duration, layout ContactSheetLayout) (image.Image, error) {
+
+	if step <= 0 {
+		return nil, errors.New("ContactSheet needs a positive step")
+	}
+	if !to.After(from) {
+		return nil, errors.New("ContactSheet needs a range ending after it starts")
+	}
+	n := int((to.Sub(from) + step - 1) / step)
+	if n > MaxContactSheetFrames {
+		return nil, fmt.Errorf("ContactSheet: %d frames is more than %d; use a longer step", n, MaxContactSheetFrames)
+	}
+	if err := c.Dropcam.requireCVR("Contact Sheet", o); err != nil {
+		return nil, err
+	}
+	if layout.Columns <= 0 {
+		layout.Columns = 8
+	}
+	if layout.TileWidth <= 0 {
+		layout.TileWidth = 240
+	}
+	if layout.Background == nil {
+		layout.Background = color.Black
+	}
+	if layout.Concurrency <= 0 {
+		layout.Concurrency = 4
+	}
+
+	ctx = WithLowPriority(ctx)
+	frames := make([]image.Image, n)
+	sem := make(chan struct{}, layout.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		t := from.Add(time.Duration(i) * step)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t time.Time) {
+			defer func() { <-sem; wg.Done() }()
+			jpg, err := c.getImage(ctx, o, layout.TileWidth, t)
+			if err == nil {
+				frames[i], _, err = image.Decode(bytes.NewReader(jpg))
+			}
+			if err != nil {
+				Dbg("contact sheet: %s at %s: %s\n", o.Title, t.Format(time.RFC3339), err)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tiles take the aspect ratio of the first frame that arrived
+	tileH := 0
+	for _, f := range frames {
+		if f != nil {
+			b := f.Bounds()
+			tileH = layout.TileWidth * b.Dy() / b.Dx()
+			break
+		}
+	}
+	if tileH == 0 {
+		return nil, fmt.Errorf("ContactSheet got no frame of %s in the range", o.Title)
+	}
+
+	// times are shown with the date when the range spans days
+	stamp := "15:04:05"
+	if o.In(from).YearDay() != o.In(to.Add(-step)).YearDay() || to.Sub(from) > 24*time.Hour {
+		stamp = "Jan 2 15:04"
+	}
+
+	cols := layout.Columns
+	if n < cols {
+		cols = n
+	}
+	rows := (n + cols - 1) / cols
+	gap := layout.Gap
+	headerH := textSize("", 2).Y + 8
+	out := image.NewRGBA(image.Rect(0, 0, cols*layout.TileWidth+(cols+1)*gap, headerH+rows*tileH+(rows+1)*gap))
+	draw.Draw(out, out.Bounds(), image.NewUniform(layout.Background), image.Point{}, draw.Src)
+
+	header := fmt.Sprintf("%s  %s - %s  every %s", o.Title,
+		o.In(from).Format("Jan 2 2006 15:04"), o.In(to).Format("Jan 2 2006 15:04"), step)
+	drawText(out, image.Pt(gap+4, 4), header, color.White, 2)
+
+	for i, f := range frames {
+		t := from.Add(time.Duration(i) * step)
+		x := gap + (i%cols)*(layout.TileWidth+gap)
+		y := headerH + gap + (i/cols)*(tileH+gap)
+		tile := image.Rect(x, y, x+layout.TileWidth, y+tileH)
+
+		label := o.In(t).Format(stamp)
+		if f != nil {
+			draw.Draw(out, tile, resize(toRGBA(f), layout.TileWidth, tileH), image.Point{}, draw.Src)
+		} else {
+			label += " NO FRAME"
+		}
+		drawLabel(out, tile, label)
+	}
+	return out, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// The ContentStore type stores media under the SHA-256 of its content,
+//
+//	<uuid>/sha256/<first two hex digits>/<hex digest><ext>
+//
+// so identical snapshots are kept once, names never change once written, and
+// every object can be verified against its own name. This suits deduplicating
+// sync and backup tools such as rclone and restic.
+//
+// The capture metadata is recorded in Catalog when set, otherwise in a JSON
+// sidecar stored next to the object under "<key>.json".
+type ContentStore struct {
+	Storage Storage
+	Catalog *Catalog
+}
+
+// ContentKey returns the key data with extension ext is stored under for camera
+func ContentKey(camera string, data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	h := hex.EncodeToString(sum[:])
+	return camera + "/sha256/" + h[:2] + "/" + h + ext
+}
+
+// Put stores data for camera unless identical content is already stored, and
+// returns its record and whether it was a duplicate. The record of a duplicate
+// is the one stored first.
+func (cs *ContentStore) Put(ctx context.Context, kind, camera string, event int64, t time.Time, data []byte, ext string) (*MediaRecord, bool, error) {
+
+	key := ContentKey(camera, data, ext)
+	if rec, err := cs.Lookup(ctx, key); err != nil {
+		return nil, false, err
+	} else if rec != nil {
+		return rec, true, nil
+	}
+
+	rec := &MediaRecord{
+		Kind:   kind,
+		Camera: camera,
+		Time:   t,
+		Key:    key,
+		Size:   int64(len(data)),
+		SHA256: contentHash(key),
+		Event:  event,
+	}
+	if err := cs.Storage.Put(key, bytes.NewReader(data)); err != nil {
+		return nil, false, err
+	}
+
+	if cs.Catalog != nil {
+		return rec, false, cs.Catalog.Add(ctx, rec)
+	}
+	meta, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	return rec, false, cs.Storage.Put(key+".json", bytes.NewReader(meta))
+}
+
+// Lookup returns the record for key, or nil if nothing is stored under it
+func (cs *ContentStore) Lookup(ctx context.Context, key string) (*MediaRecord, error) {
+
+	if cs.Catalog != nil {
+		return cs.Catalog.Lookup(ctx, key)
+	}
+	rc, err := cs.Storage.Get(key + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var rec MediaRecord
+	if err := json.NewDecoder(rc).Decode(&rec); err != nil {
+		return nil, fmt.Errorf("Bad metadata for %s: %s", key, err)
+	}
+	return &rec, nil
+}
+
+// Verify reads the object stored under key and checks it against the digest in its name
+func (cs *ContentStore) Verify(key string) error {
+
+	want := contentHash(key)
+	if want == "" {
+		return fmt.Errorf("%s is not a content-addressed key", key)
+	}
+	rc, err := cs.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%s is corrupt: content hashes to %s", key, got)
+	}
+	return nil
+}
+
+// Snapshot captures an image from camera o and stores it
+func (cs *ContentStore) Snapshot(ctx context.Context, c *Cameras, o *Owned, width int) (*MediaRecord, bool, error) {
+
+	t := time.Now()
+	img, err := c.getImage(ctx, o, width, t)
+	if err != nil {
+		return nil, false, err
+	}
+	rec, dup, err := cs.Put(ctx, MediaSnapshot, o.Uuid, 0, t, img, ".jpg")
+	if err == nil {
+		c.Dropcam.updateLatest(o, img)
+	}
+	return rec, dup, err
+}
+
+// contentHash returns the hex digest named by a content-addressed key, or ""
+func contentHash(key string) string {
+
+	dir, name := path.Split(key)
+	h := strings.TrimSuffix(name, path.Ext(name))
+	if len(h) != sha256.Size*2 || !strings.HasSuffix(dir, "/sha256/"+h[:2]+"/") {
+		return ""
+	}
+	if _, err := hex.DecodeString(h); err != nil {
+		return ""
+	}
+	return h
+}
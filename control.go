@@ -0,0 +1,191 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The ControlHandler type is an http.Handler that lets external systems trigger
+// actions. Requests must be granted the control scope by Guard, or when Guard is
+// nil carry the shared Token as "Authorization: Bearer <token>". With neither
+// set all requests are rejected. Endpoints, all POST:
+//
+//	/snapshot?camera=<uuid>[&width=<px>]       returns the current JPEG
+//	/profile?name=<profile>[&camera=<uuid>...] applies a profile
+//	/mute?minutes=<n>[&camera=<uuid>]          silences notifications
+type ControlHandler struct {
+	Cameras  *Cameras
+	Profiles *Profiles
+	Mute     *Mute
+	Guard    Guard
+	Token    string
+	Width    int
+}
+
+func (h *ControlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.Guard != nil {
+		Protect(h.Guard, ScopeControl, http.HandlerFunc(h.serve)).ServeHTTP(w, r)
+		return
+	}
+	if !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.serve(w, r)
+}
+
+func (h *ControlHandler) serve(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/snapshot":
+		h.snapshot(w, r)
+	case "/profile":
+		h.profile(w, r)
+	case "/mute":
+		h.mute(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *ControlHandler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(h.Token)) == 1
+}
+
+func (h *ControlHandler) snapshot(w http.ResponseWriter, r *http.Request) {
+
+	o := h.Cameras.findCamera(r.FormValue("camera"))
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+	width := h.Width
+	if v, err := strconv.Atoi(r.FormValue("width")); err == nil && v > 0 {
+		width = v
+	}
+	if width <= 0 {
+		width = 720
+	}
+
+	img, err := h.Cameras.getImage(r.Context(), o, width, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(img)
+}
+
+func (h *ControlHandler) profile(w http.ResponseWriter, r *http.Request) {
+
+	if h.Profiles == nil {
+		http.Error(w, "no profiles configured", http.StatusNotImplemented)
+		return
+	}
+	r.ParseForm()
+	filter := CameraFilter(AllCameras)
+	if uuids := r.Form["camera"]; len(uuids) > 0 {
+		filter = CameraUUIDs(uuids...)
+	} else if p := RequestPrincipal(r); p != nil && len(p.Cameras) > 0 {
+		filter = CameraUUIDs(p.Cameras...)
+	}
+
+	results, err := h.Profiles.Apply(r.Context(), h.Cameras, filter, r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type result struct {
+		Camera string            `json:"camera_uuid"`
+		OK     bool              `json:"ok"`
+		Failed map[string]string `json:"failed,omitempty"`
+	}
+	out := make([]result, len(results))
+	for i, res := range results {
+		out[i] = result{Camera: res.Camera.Uuid, OK: res.OK()}
+		for name, err := range res.Failed {
+			if out[i].Failed == nil {
+				out[i].Failed = make(map[string]string)
+			}
+			out[i].Failed[name] = err.Error()
+		}
+	}
+	writeJSON(w, out)
+}
+
+func (h *ControlHandler) mute(w http.ResponseWriter, r *http.Request) {
+
+	if h.Mute == nil {
+		http.Error(w, "muting not configured", http.StatusNotImplemented)
+		return
+	}
+	minutes, err := strconv.Atoi(r.FormValue("minutes"))
+	if err != nil || minutes < 0 {
+		http.Error(w, "minutes must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	uuid := r.FormValue("camera")
+	if p := RequestPrincipal(r); uuid == "" && p != nil && len(p.Cameras) > 0 {
+		http.Error(w, "a camera must be named to mute", http.StatusForbidden)
+		return
+	}
+	if uuid != "" && h.Cameras.findCamera(uuid) == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	until := h.Mute.MuteFor(uuid, time.Duration(minutes)*time.Minute)
+	writeJSON(w, map[string]interface{}{"camera_uuid": uuid, "until": until})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// findCamera returns the owned or shared camera with the given uuid, or nil
+func (c *Cameras) findCamera(uuid string) *Owned {
+	if uuid == "" {
+		return nil
+	}
+	for i := range c.Cam {
+		if c.Cam[i].Uuid == uuid {
+			return &c.Cam[i]
+		}
+	}
+	for i := range c.Shared {
+		if c.Shared[i].Uuid == uuid {
+			return &c.Shared[i].Owned
+		}
+	}
+	return nil
+}
+
+// isShared reports whether uuid is one of the shared cameras
+func (c *Cameras) isShared(uuid string) bool {
+	for i := range c.Shared {
+		if c.Shared[i].Uuid == uuid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultToggles are the properties a Dashboard offers as on/off switches
+var DefaultToggles = []string{"streaming.enabled", "audio.enabled", "notify.motion.enabled", "notify.sound.enabled", "statusled.enabled"}
+
+// The Dashboard type is an http.Handler serving a small self-hosted web interface:
+// a grid of camera tiles with live snapshots, and per camera an event timeline and
+// property toggles.
+//
+//	/                               camera tiles
+//	/cameras/<uuid>                 timeline and toggles
+//	/cameras/<uuid>/snapshot.jpg    current snapshot, served through Images
+//	/cameras/<uuid>/set             POST name=<property>&value=<value>
+//	/cameras/<uuid>/events.json     ?cursor=&limit=, a page of ListEvents
+//
+// When Guard is set, viewing needs the read scope and changing properties the
+// control scope. Without a Guard the dashboard is read-only.
+type Dashboard struct {
+	Cameras  *Cameras
+	Images   *ImageCache
+	Guard    Guard
+	Toggles  []string
+	Timeline time.Duration
+
+	once   sync.Once
+	images *ImageCache
+}
+
+type dashboardCamera struct {
+	Owned
+	Toggles []dashboardToggle
+	Events  Events
+	Error   string
+	CanSet  bool
+}
+
+type dashboardToggle struct {
+	Name  string
+	Value string
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.Guard != nil {
+		Protect(d.Guard, ScopeRead, http.HandlerFunc(d.serve)).ServeHTTP(w, r)
+		return
+	}
+	d.serve(w, r)
+}
+
+func (d *Dashboard) serve(w http.ResponseWriter, r *http.Request) {
+
+	d.once.Do(func() {
+		d.images = d.Images
+		if d.images == nil {
+			d.images = &ImageCache{Cameras: d.Cameras}
+		}
+	})
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		d.index(w, r)
+		return
+	case len(parts) < 2 || parts[0] != "cameras":
+		http.NotFound(w, r)
+		return
+	}
+
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	o := d.Cameras.findCamera(parts[1])
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2:
+		d.camera(w, r, o)
+	case len(parts) == 3 && parts[2] == "snapshot.jpg":
+		d.images.serve(w, r)
+	case len(parts) == 3 && parts[2] == "set":
+		d.set(w, r, o)
+	case len(parts) == 3 && parts[2] == "events.json":
+		d.events(w, r, o)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// canSet reports whether the caller of r may change camera properties
+func (d *Dashboard) canSet(r *http.Request) bool {
+	p := RequestPrincipal(r)
+	return d.Guard != nil && p != nil && p.HasScope(ScopeControl)
+}
+
+func (d *Dashboard) index(w http.ResponseWriter, r *http.Request) {
+
+	var cams []Owned
+	p := RequestPrincipal(r)
+	for _, o := range d.Cameras.Cam {
+		if p == nil || p.AllowsCamera(o.Uuid) {
+			cams = append(cams, o)
+		}
+	}
+	for _, s := range d.Cameras.Shared {
+		if p == nil || p.AllowsCamera(s.Uuid) {
+			cams = append(cams, s.Owned)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.ExecuteTemplate(w, "index", cams); err != nil {
+		warnf("dashboard: %s\n", err)
+	}
+}
+
+func (d *Dashboard) camera(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	view := dashboardCamera{Owned: *o, CanSet: d.canSet(r)}
+
+	toggles := d.Toggles
+	if toggles == nil {
+		toggles = DefaultToggles
+	}
+	info, err := d.Cameras.CameraInfo(r.Context(), o)
+	if err != nil {
+		view.Error = err.Error()
+	} else {
+		values := make(map[string]string)
+		for _, prop := range info.Properties {
+			values[prop.Name] = propertyString(prop.Value)
+		}
+		for _, name := range toggles {
+			if v, ok := values[name]; ok {
+				view.Toggles = append(view.Toggles, dashboardToggle{Name: name, Value: v})
+			}
+		}
+	}
+
+	window := d.Timeline
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	now := time.Now()
+	if events, err := d.Cameras.GetEvents(r.Context(), o, now.Add(-window), now); err != nil {
+		if view.Error == "" {
+			view.Error = err.Error()
+		}
+	} else {
+		for i := len(events) - 1; i >= 0; i-- {
+			view.Events = append(view.Events, events[i])
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.ExecuteTemplate(w, "camera", view); err != nil {
+		warnf("dashboard: %s\n", err)
+	}
+}
+
+// events serves a page of the camera's events for scrolling timelines
+func (d *Dashboard) events(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit > 500 {
+		limit = 500
+	}
+	events, next, err := d.Cameras.ListEvents(r.Context(), o, r.FormValue("cursor"), limit)
+	if errors.Is(err, ErrBadCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if events == nil {
+		events = Events{}
+	}
+	writeJSON(w, map[string]interface{}{"events": events, "next": next})
+}
+
+func (d *Dashboard) set(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.canSet(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	name, value := r.FormValue("name"), r.FormValue("value")
+	if name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := d.Cameras.SetProperties(r.Context(), o, name, value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, "../"+o.Uuid, http.StatusSeeOther)
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"toggle": func(v string) string {
+		switch v {
+		case "true":
+			return "false"
+		case "false":
+			return "true"
+		case "on":
+			return "off"
+		case "off":
+			return "on"
+		}
+		return ""
+	},
+	"clock": func(e Event) string {
+		return e.Start().Format("Jan 2 15:04:05")
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>
+body{font-family:sans-serif;background:#222;color:#eee;margin:1em}
+a{color:#9cf}
+.grid{display:flex;flex-wrap:wrap;gap:1em}
+.tile{background:#333;padding:.5em;width:360px}
+.tile img,.live{width:100%;display:block;background:#000}
+.live{max-width:960px}
+table{border-collapse:collapse}td,th{padding:.2em .8em;text-align:left}
+.err{color:#f88}
+</style>
+<script>
+setInterval(function(){
+	document.querySelectorAll("img[data-src]").forEach(function(img){
+		img.src = img.dataset.src + (img.dataset.src.indexOf("?") < 0 ? "?" : "&") + "t=" + Date.now();
+	});
+}, 5000);
+</script>
+</head><body>{{end}}
+
+{{define "index"}}{{template "head" "Cameras"}}
+<h1>Cameras</h1>
+<div class="grid">
+{{range .}}<div class="tile">
+<a href="cameras/{{.Uuid}}"><img src="cameras/{{.Uuid}}/snapshot.jpg?width=360" data-src="cameras/{{.Uuid}}/snapshot.jpg?width=360" alt="{{.Title}}"></a>
+{{.Title}}{{if not .IsOnline}} (offline){{end}}
+</div>
+{{else}}<p>No cameras.</p>
+{{end}}</div>
+</body></html>{{end}}
+
+{{define "camera"}}{{template "head" .Title}}
+<p><a href="../">All cameras</a></p>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+<img class="live" src="{{.Uuid}}/snapshot.jpg" data-src="{{.Uuid}}/snapshot.jpg" alt="{{.Title}}">
+<h2>Settings</h2>
+<table>
+{{$uuid := .Uuid}}{{$canSet := .CanSet}}
+{{range .Toggles}}<tr><td>{{.Name}}</td><td>{{.Value}}</td><td>
+{{if and $canSet (toggle .Value)}}<form method="post" action="{{$uuid}}/set">
+<input type="hidden" name="name" value="{{.Name}}"><input type="hidden" name="value" value="{{toggle .Value}}">
+<button>Turn {{toggle .Value}}</button></form>{{end}}
+</td></tr>
+{{end}}</table>
+<h2>Events</h2>
+<table>
+<tr><th>Time</th><th>Type</th><th>Length</th><th></th></tr>
+{{range .Events}}<tr><td>{{clock .}}</td><td>{{.Type}}</td><td>{{.Duration}}</td><td>{{if .IsImportant}}important{{end}}</td></tr>
+{{else}}<tr><td colspan="4">No events.</td></tr>
+{{end}}</table>
+</body></html>{{end}}
+`))
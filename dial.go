@@ -0,0 +1,168 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The DialConfig type controls how connections to the Dropcam servers are made.
+// Some residential networks resolve the dropcam endpoints poorly; Hosts pins a
+// hostname to a fixed address, Resolver replaces the system resolver, and
+// PreferIPv4 tries IPv4 addresses before IPv6 ones. MaxIdlePerHost bounds the
+// kept-alive connections per server. Dropcams left with the zero DialConfig
+// share one Transport, and so their connections, as the clients of several
+// accounts do.
+//
+// Requests go through the proxy HTTP_PROXY, HTTPS_PROXY and NO_PROXY name,
+// or through Proxy when set, or directly with NoProxy. Behind a proxy that
+// intercepts TLS, RootCAs, such as LoadCABundle returns, adds the proxy's
+// certificate authority to those trusted. InsecureSkipVerify turns off
+// certificate checks altogether, exposing the session to anyone on the path;
+// it is meant only for diagnosing a proxy, and is warned about whenever a
+// client is built with it.
+type DialConfig struct {
+	Resolver       *net.Resolver
+	Hosts          map[string]string
+	PreferIPv4     bool
+	Timeout        time.Duration
+	MaxIdlePerHost int
+
+	Proxy              *url.URL
+	NoProxy            bool
+	RootCAs            *x509.CertPool
+	InsecureSkipVerify bool
+}
+
+// DefaultMaxIdlePerHost is the number of idle connections kept per server,
+// enough for the default bulk and save-all concurrency
+const DefaultMaxIdlePerHost = 16
+
+// DialContext connects to addr honoring the host overrides, resolver and
+// address family preference. It has the signature expected by http.Transport.
+func (dc *DialConfig) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip, ok := dc.Hosts[host]; ok {
+		host = ip
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dc.Timeout,
+		KeepAlive: 30 * time.Second,
+		Resolver:  dc.Resolver,
+	}
+
+	if !dc.PreferIPv4 || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, net.JoinHostPort(host, port))
+	}
+
+	resolver := dc.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []net.IPAddr
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+
+	var lastErr error
+	for _, a := range append(v4, v6...) {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Transport returns an http.Transport that dials through dc, by way of its
+// proxy and trusting its certificate authorities. It keeps up to
+// MaxIdlePerHost connections to each server alive, DefaultMaxIdlePerHost when
+// zero, so concurrent requests reuse them instead of dialing anew.
+func (dc *DialConfig) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dc.DialContext
+	t.MaxIdleConnsPerHost = dc.MaxIdlePerHost
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = DefaultMaxIdlePerHost
+	}
+
+	switch {
+	case dc.NoProxy:
+		t.Proxy = nil
+	case dc.Proxy != nil:
+		t.Proxy = http.ProxyURL(dc.Proxy)
+	}
+
+	if dc.RootCAs != nil || dc.InsecureSkipVerify {
+		t.TLSClientConfig = &tls.Config{RootCAs: dc.RootCAs, InsecureSkipVerify: dc.InsecureSkipVerify}
+	}
+	if dc.InsecureSkipVerify {
+		warnf("TLS certificate verification is off; the session can be intercepted\n")
+	}
+	return t
+}
+
+// SetProxy sets the proxy from spec, as given on a command line or in a
+// configuration file: a URL such as "http://proxy.corp:3128", "none" to
+// connect directly, or empty to use the environment
+func (dc *DialConfig) SetProxy(spec string) error {
+
+	dc.Proxy, dc.NoProxy = nil, false
+	switch spec {
+	case "":
+	case "none":
+		dc.NoProxy = true
+	default:
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("Invalid proxy %q", spec)
+		}
+		dc.Proxy = u
+	}
+	return nil
+}
+
+// LoadCABundle returns the system's certificate authorities together with
+// those in the PEM files at paths, such as a corporate proxy's
+func LoadCABundle(paths ...string) (*x509.CertPool, error) {
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range paths {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", path)
+		}
+	}
+	return pool, nil
+}
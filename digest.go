@@ -0,0 +1,261 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The Digest type compiles a camera's event clips for one day into a single
+// video. Each clip is prefixed with a short title card naming the event and
+// stamped with its time, then all are joined in order. ffmpeg does the video
+// work and must be installed.
+//
+// When Storage is set the digest is stored under "<uuid>/digest-<yyyy-mm-dd>.mp4";
+// when Notifier is set it is told about the finished digest. When Preset is set
+// the finished digest is re-encoded with it by Transcoder, an FFmpegTranscoder
+// using FFmpeg when nil.
+type Digest struct {
+	Cameras    *Cameras
+	FFmpeg     string
+	Width      int
+	Storage    Storage
+	Notifier   Notifier
+	Preset     *TranscodePreset
+	Transcoder Transcoder
+}
+
+// DefaultDigestWidth is the frame width of a digest when Digest.Width is zero
+const DefaultDigestWidth = 1280
+
+// Build compiles the events of camera o on the local day containing day into
+// the MP4 file out and returns the number of clips it contains
+func (dg *Digest) Build(ctx context.Context, o *Owned, day time.Time, out string) (int, error) {
+
+	if err := dg.Cameras.Dropcam.requireCVR("Digest", o); err != nil {
+		return 0, err
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	events, err := dg.Cameras.GetEvents(ctx, o, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, err
+	}
+
+	work, err := ioutil.TempDir("", "dropcam-digest-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(work)
+
+	var parts []string
+	for i, e := range events {
+		raw := filepath.Join(work, fmt.Sprintf("raw-%04d.mp4", i))
+		if err := dg.Cameras.SaveClip(ctx, o, e, raw); err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			Dbg("digest: skipping event %d: %s\n", e.Id, err)
+			continue
+		}
+		part := filepath.Join(work, fmt.Sprintf("part-%04d.mp4", i))
+		if err := dg.stamp(ctx, raw, part, e); err != nil {
+			Dbg("digest: skipping event %d: %s\n", e.Id, err)
+			continue
+		}
+		os.Remove(raw)
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("No clips for %s on %s", o.Title, start.Format("2006-01-02"))
+	}
+
+	list := filepath.Join(work, "parts.txt")
+	var buf bytes.Buffer
+	for _, p := range parts {
+		fmt.Fprintf(&buf, "file '%s'\n", strings.Replace(p, "'", `'\''`, -1))
+	}
+	if err := ioutil.WriteFile(list, buf.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	joined := out
+	if dg.Preset != nil {
+		joined = filepath.Join(work, "joined.mp4")
+	}
+	if err := dg.ffmpeg(ctx, "-f", "concat", "-safe", "0", "-i", list, "-c", "copy", "-movflags", "+faststart", "-y", joined); err != nil {
+		return 0, err
+	}
+	if dg.Preset != nil {
+		t := dg.Transcoder
+		if t == nil {
+			t = &FFmpegTranscoder{FFmpeg: dg.FFmpeg}
+		}
+		if err := t.Transcode(ctx, joined, out, *dg.Preset); err != nil {
+			return 0, err
+		}
+	}
+	return len(parts), nil
+}
+
+// Run builds the digest for camera o and day, stores it and sends the
+// notification as configured. For a camera without cloud recording, e.g.
+// after its trial lapsed, there are no clips; the notification then carries
+// a live snapshot instead.
+func (dg *Digest) Run(ctx context.Context, o *Owned, day time.Time) error {
+
+	if !dg.Cameras.Dropcam.hasCVR(o) {
+		return dg.snapshotOnly(ctx, o, day)
+	}
+
+	f, err := ioutil.TempFile("", "dropcam-digest-*.mp4")
+	if err != nil {
+		return err
+	}
+	out := f.Name()
+	f.Close()
+	defer os.Remove(out)
+
+	n, err := dg.Build(ctx, o, day, out)
+	if err != nil {
+		return err
+	}
+
+	media := out
+	if dg.Storage != nil {
+		media = o.Uuid + "/digest-" + day.Format("2006-01-02") + ".mp4"
+		f, err := os.Open(out)
+		if err != nil {
+			return err
+		}
+		err = dg.Storage.Put(media, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if dg.Notifier == nil {
+		return nil
+	}
+	note := &Notification{
+		Kind:    NotifyDigest,
+		Camera:  o.Uuid,
+		Title:   o.Title + " daily digest",
+		Message: fmt.Sprintf("%d events on %s", n, day.Format("Mon Jan 2")),
+		Time:    time.Now(),
+		Media:   media,
+	}
+	if dg.Storage == nil {
+		// the file is removed on return, so only a still can be attached
+		note.Media = ""
+	}
+	note.Image, _ = dg.still(ctx, out)
+	return dg.Notifier.Notify(note)
+}
+
+// snapshotOnly sends the digest notification of a camera without recordings
+func (dg *Digest) snapshotOnly(ctx context.Context, o *Owned, day time.Time) error {
+
+	if dg.Notifier == nil {
+		return nil
+	}
+	width := dg.Width
+	if width <= 0 {
+		width = DefaultDigestWidth
+	}
+	img, err := dg.Cameras.getImage(ctx, o, width, time.Now())
+	if err != nil {
+		return err
+	}
+	return dg.Notifier.Notify(&Notification{
+		Kind:    NotifyDigest,
+		Camera:  o.Uuid,
+		Title:   o.Title + " daily digest",
+		Message: fmt.Sprintf("No recordings on %s, the camera has no cloud recording; current view attached", day.Format("Mon Jan 2")),
+		Time:    time.Now(),
+		Image:   img,
+	})
+}
+
+// stamp re-encodes clip in to out at the digest size, adding a title card for
+// the first two seconds and the event time in the corner throughout
+func (dg *Digest) stamp(ctx context.Context, in, out string, e Event) error {
+
+	width := dg.Width
+	if width <= 0 {
+		width = DefaultDigestWidth
+	}
+	title := strings.ToUpper(e.Type)
+	if title == "" {
+		title = "EVENT"
+	}
+	clock := e.Start().Format("Mon Jan 2 15:04:05")
+
+	// text is passed in files so it needs no filtergraph escaping
+	titleFile := strings.TrimSuffix(out, ".mp4") + "-title.txt"
+	clockFile := strings.TrimSuffix(out, ".mp4") + "-clock.txt"
+	if err := ioutil.WriteFile(titleFile, []byte(title+"  "+clock), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(clockFile, []byte(clock), 0644); err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("scale=%d:-2,"+
+		"drawtext=textfile='%s':expansion=none:fontsize=h/12:fontcolor=white:box=1:boxcolor=black@0.6:x=(w-text_w)/2:y=(h-text_h)/2:enable='lt(t,2)',"+
+		"drawtext=textfile='%s':expansion=none:fontsize=h/24:fontcolor=white:box=1:boxcolor=black@0.5:x=10:y=h-text_h-10",
+		width, titleFile, clockFile)
+
+	return dg.ffmpeg(ctx, "-i", in, "-vf", filter, "-an", "-c:v", "libx264", "-preset", "veryfast",
+		"-pix_fmt", "yuv420p", "-r", "15", "-y", out)
+}
+
+// still grabs a JPEG frame from video
+func (dg *Digest) still(ctx context.Context, video string) ([]byte, error) {
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, dg.bin(), "-loglevel", "error", "-ss", "1", "-i", video,
+		"-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (dg *Digest) bin() string {
+	if dg.FFmpeg != "" {
+		return dg.FFmpeg
+	}
+	return "ffmpeg"
+}
+
+func (dg *Digest) ffmpeg(ctx context.Context, args ...string) error {
+	return runFFmpeg(ctx, dg.bin(), args...)
+}
+
+// runFFmpeg runs ffmpeg binary bin with args, returning its error output as the error
+func runFFmpeg(ctx context.Context, bin string, args ...string) error {
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, append([]string{"-loglevel", "error"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return err
+		}
+		return errors.New("ffmpeg: " + msg)
+	}
+	return nil
+}
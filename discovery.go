@@ -0,0 +1,230 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DropcamOUI is the MAC address prefix assigned to Dropcam hardware
+const DropcamOUI = "308cfb"
+
+// The LANDevice type is a device found on the local network. Server and
+// Location come from its SSDP answer, Name from the service instance it
+// announces over mDNS.
+type LANDevice struct {
+	IP       string
+	MAC      string
+	Server   string
+	Location string
+	Name     string
+}
+
+// The DiscoveryMatch type pairs an API camera with the LAN device carrying its MAC address
+type DiscoveryMatch struct {
+	Camera Owned
+	Device LANDevice
+}
+
+// The DiscoveryReport type is the result of matching LAN devices to API cameras.
+// Mismatches are listed separately: cameras the API reports online that are not
+// on the LAN, cameras on the LAN that the API reports offline, and Dropcam
+// hardware on the LAN that does not belong to the account.
+type DiscoveryReport struct {
+	Matched         []DiscoveryMatch
+	OnlineNotOnLAN  []Owned
+	OfflineButOnLAN []DiscoveryMatch
+	Unknown         []LANDevice
+}
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n"
+
+// SearchSSDP multicasts an SSDP search and collects the devices that answer within timeout
+func SearchSSDP(timeout time.Duration) ([]LANDevice, error) {
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	if _, err := conn.WriteTo([]byte(ssdpSearch), dst); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	seen := make(map[string]bool)
+	var devices []LANDevice
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		ip := from.(*net.UDPAddr).IP.String()
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+
+		dev := LANDevice{IP: ip}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err == nil {
+			dev.Server = resp.Header.Get("Server")
+			dev.Location = resp.Header.Get("Location")
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+var arpLine = regexp.MustCompile(`(\d+\.\d+\.\d+\.\d+)\D.*?\b([0-9A-Fa-f]{1,2}(?:[:-][0-9A-Fa-f]{1,2}){5})\b`)
+
+// ARPTable returns the IP to MAC mapping known to the operating system
+func ARPTable() (map[string]string, error) {
+
+	out, err := ioutil.ReadFile("/proc/net/arp")
+	if err != nil {
+		out, err = exec.Command("arp", "-an").Output()
+		if err != nil {
+			out, err = exec.Command("arp", "-a").Output()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	table := make(map[string]string)
+	for _, l := range strings.Split(string(out), "\n") {
+		m := arpLine.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		mac := normalizeMAC(m[2])
+		if mac != "000000000000" {
+			table[m[1]] = mac
+		}
+	}
+	return table, nil
+}
+
+// normalizeMAC returns mac as twelve lower case hex digits without separators
+func normalizeMAC(mac string) string {
+	parts := strings.FieldsFunc(strings.ToLower(mac), func(r rune) bool {
+		return r == ':' || r == '-' || r == '.'
+	})
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// The Discover method scans the local network with SSDP and mDNS, probes
+// each camera's last known LAN address, and matches what it finds to the
+// account's cameras by MAC address. It is only meaningful when run on the
+// cameras' network.
+func (c *Cameras) Discover(timeout time.Duration) (*DiscoveryReport, error) {
+
+	var announced []LANDevice
+	var mdnsErr error
+	mdnsDone := make(chan struct{})
+	go func() {
+		defer close(mdnsDone)
+		announced, mdnsErr = SearchMDNS(timeout)
+	}()
+	devices, err := SearchSSDP(timeout)
+	<-mdnsDone
+	if err != nil {
+		return nil, err
+	}
+	if mdnsErr != nil {
+		// SSDP and the ARP table still find the cameras
+		Dbg("mDNS search failed: %s\n", mdnsErr)
+	}
+	devices = append(devices, announced...)
+
+	// Probing the last known addresses also fills in the ARP cache
+	var wg sync.WaitGroup
+	for i := range c.Cam {
+		wg.Add(1)
+		go func(o *Owned) {
+			defer wg.Done()
+			o.OnLAN(timeout)
+		}(&c.Cam[i])
+	}
+	wg.Wait()
+
+	arp, err := ARPTable()
+	if err != nil {
+		return nil, err
+	}
+
+	byMAC := make(map[string]LANDevice)
+	for _, d := range devices {
+		if d.MAC == "" {
+			d.MAC = arp[d.IP]
+		}
+		if d.MAC == "" {
+			continue
+		}
+		// a device answering both searches is one device
+		if prev, ok := byMAC[d.MAC]; ok {
+			if d.Server == "" {
+				d.Server, d.Location = prev.Server, prev.Location
+			}
+			if d.Name == "" {
+				d.Name = prev.Name
+			}
+		}
+		byMAC[d.MAC] = d
+	}
+	for ip, mac := range arp {
+		if _, ok := byMAC[mac]; !ok {
+			byMAC[mac] = LANDevice{IP: ip, MAC: mac}
+		}
+	}
+
+	report := new(DiscoveryReport)
+	owned := make(map[string]bool)
+	for _, o := range c.Cam {
+		mac := normalizeMAC(o.MacAddress)
+		owned[mac] = true
+
+		d, onLAN := byMAC[mac]
+		switch {
+		case onLAN && o.IsOnline:
+			report.Matched = append(report.Matched, DiscoveryMatch{Camera: o, Device: d})
+		case onLAN:
+			report.OfflineButOnLAN = append(report.OfflineButOnLAN, DiscoveryMatch{Camera: o, Device: d})
+		case o.IsOnline:
+			report.OnlineNotOnLAN = append(report.OnlineNotOnLAN, o)
+		}
+	}
+	for mac, d := range byMAC {
+		if strings.HasPrefix(mac, DropcamOUI) && !owned[mac] {
+			report.Unknown = append(report.Unknown, d)
+		}
+	}
+	return report, nil
+}
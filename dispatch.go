@@ -0,0 +1,149 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Dispatcher type turns what happens on the cameras into notifications:
+// a NotifyEvent for every new motion or sound event, found with Cameras.Watch,
+// and NotifyOffline or NotifyOnline when a camera's status changes, checked
+// every Interval. When Width is set event notifications carry the event's
+// snapshot, as GetEventSnapshot returns it, at that width. When ShareTTL is
+// set they carry a ClipURL, as GetClipShareURL makes it, lasting that long.
+// Pair it with a Webhook to call out to other systems.
+type Dispatcher struct {
+	Cameras  *Cameras
+	Notifier Notifier
+	Watch    WatchOptions
+	Interval time.Duration
+	Width    int
+	ShareTTL time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start begins watching every camera in the background
+func (dp *Dispatcher) Start() error {
+
+	if dp.Cameras == nil || dp.Notifier == nil {
+		return errors.New("Dispatcher needs Cameras and a Notifier")
+	}
+	if dp.Interval <= 0 {
+		dp.Interval = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dp.cancel = cancel
+
+	cams := append([]Owned(nil), dp.Cameras.Cam...)
+	for _, s := range dp.Cameras.Shared {
+		cams = append(cams, s.Owned)
+	}
+	for i := range cams {
+		o := &cams[i]
+		w := dp.Cameras.Watch(ctx, o, dp.Watch)
+		dp.wg.Add(1)
+		go func() {
+			defer dp.wg.Done()
+			for e := range w.C {
+				dp.event(ctx, o, e)
+			}
+		}()
+	}
+
+	dp.wg.Add(1)
+	go dp.status(ctx, cams)
+	return nil
+}
+
+// Stop ends watching
+func (dp *Dispatcher) Stop() {
+	dp.cancel()
+	dp.wg.Wait()
+}
+
+func (dp *Dispatcher) event(ctx context.Context, o *Owned, e Event) {
+
+	kind := e.Type
+	if kind == "" {
+		kind = "event"
+	}
+	n := &Notification{
+		Kind:      NotifyEvent,
+		Camera:    o.Uuid,
+		Title:     o.Title,
+		Message:   strings.ToUpper(kind[:1]) + kind[1:] + " detected",
+		Time:      e.Start(),
+		EventType: e.Type,
+	}
+	if dp.Width > 0 {
+		img, err := dp.Cameras.GetEventSnapshot(ctx, o, e, dp.Width)
+		if err != nil {
+			Dbg("dispatch: no snapshot for %s: %s\n", o.Title, err)
+		}
+		n.Image = img
+	}
+	if dp.ShareTTL > 0 {
+		link, err := dp.Cameras.GetClipShareURL(ctx, o, e, dp.ShareTTL)
+		if err != nil {
+			Dbg("dispatch: no clip link for %s: %s\n", o.Title, err)
+		}
+		n.ClipURL = link
+	}
+	if err := dp.Notifier.Notify(n); err != nil {
+		warnf("dispatch: %s\n", err)
+	}
+}
+
+// status notifies when a camera goes offline or comes back online
+func (dp *Dispatcher) status(ctx context.Context, cams []Owned) {
+	defer dp.wg.Done()
+
+	online := make(map[string]bool, len(cams))
+	for _, o := range cams {
+		online[o.Uuid] = o.IsOnline
+	}
+
+	tick := time.NewTicker(dp.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		c, err := dp.Cameras.Dropcam.RefreshCameras(ctx)
+		if err != nil {
+			warnf("dispatch: failed to refresh cameras: %s\n", err)
+			continue
+		}
+		current := append([]Owned(nil), c.Cam...)
+		for _, s := range c.Shared {
+			current = append(current, s.Owned)
+		}
+		for _, o := range current {
+			was, known := online[o.Uuid]
+			online[o.Uuid] = o.IsOnline
+			if !known || was == o.IsOnline {
+				continue
+			}
+			n := &Notification{Kind: NotifyOnline, Camera: o.Uuid, Title: o.Title, Message: "Camera is back online", Time: time.Now()}
+			if !o.IsOnline {
+				n.Kind, n.Message = NotifyOffline, "Camera went offline"
+			}
+			if err := dp.Notifier.Notify(n); err != nil {
+				warnf("dispatch: %s\n", err)
+			}
+		}
+	}
+}
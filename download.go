@@ -0,0 +1,121 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The ProgressFunc type is told how much of a download has been read so far
+// and its total size, -1 when the servers did not say
+type ProgressFunc func(done, total int64)
+
+type progressContext struct{}
+
+// WithProgress returns a context whose downloads, clips and images alike,
+// report their progress to fn as their bodies are read
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressContext{}, fn)
+}
+
+// trackProgress has the body of resp report to the ProgressFunc of ctx, if any
+func trackProgress(ctx context.Context, resp *http.Response) {
+	fn, _ := ctx.Value(progressContext{}).(ProgressFunc)
+	if fn == nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	resp.Body = &progressReader{ReadCloser: resp.Body, total: resp.ContentLength, fn: fn}
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	io.ReadCloser
+	done, total int64
+	fn          ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.done += int64(n)
+		r.fn(r.done, r.total)
+	}
+	return n, err
+}
+
+// The Download type is a body being downloaded, read straight from the
+// connection so clips of hundreds of megabytes can be piped to a file or an
+// upload in constant memory. Length is its size, -1 when the servers did not
+// say. The caller must close it.
+type Download struct {
+	io.ReadCloser
+	Length      int64
+	ContentType string
+}
+
+// openDownload returns the body of resp as a Download, or the APIError of op
+// when the request failed
+func openDownload(op string, resp *http.Response) (*Download, error) {
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, newAPIError(op, resp, body)
+	}
+	return &Download{ReadCloser: resp.Body, Length: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// The OpenImage method opens the image of camera o at width taken at st, or
+// live when st is zero, as it arrives. Unlike GetImage the image is neither
+// cached, deduplicated nor run through the Pipeline or Overlay.
+func (c *Cameras) OpenImage(ctx context.Context, o *Owned, width int, st time.Time) (*Download, error) {
+
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	v.Add("width", fmt.Sprintf("%d", width))
+	if c.isShared(o.Uuid) && o.PublicToken != "" {
+		v.Add("public_token", o.PublicToken)
+	}
+	if isHistorical(st) {
+		if err := c.Dropcam.requireCVR("Get Image", o); err != nil {
+			return nil, err
+		}
+		v.Add("time", strconv.FormatFloat(float64(st.UnixNano())/1e9, 'f', 3, 64))
+	} else if err := c.Dropcam.requireOnline("Get Image", o); err != nil {
+		return nil, err
+	}
+
+	response, err := c.Dropcam.getRequest(withJob(ctx, JobSnapshot), c.Dropcam.CamerasGetImagePath, v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Image Failed: %w", err)
+	}
+	return openDownload("Get Image", response)
+}
+
+// The OpenClip method opens the recorded MP4 clip for event from camera o as
+// it arrives
+func (c *Cameras) OpenClip(ctx context.Context, o *Owned, event Event) (*Download, error) {
+
+	if err := c.Dropcam.requireCVR("Get Clip", o); err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	v.Add("cuepoint_id", fmt.Sprintf("%d", event.Id))
+
+	response, err := c.Dropcam.getRequest(withJob(ctx, JobClip), c.Dropcam.nexusPath(o, c.Dropcam.EventGetClipPath), v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Clip Failed: %w", err)
+	}
+	return openDownload("Get Clip", response)
+}
@@ -0,0 +1,310 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is how much a Downloader requests at a time when
+// ChunkSize is zero
+const DefaultChunkSize = 8 << 20
+
+// DefaultDownloadRetries is how many times in a row a Downloader retries a
+// chunk that fails when Retries is zero
+const DefaultDownloadRetries = 5
+
+// ErrChecksumMismatch is returned when a download does not match the
+// checksum the servers gave for it
+var ErrChecksumMismatch = errors.New("Download does not match its checksum")
+
+// The Downloader type downloads large clips over flaky links a chunk at a
+// time with HTTP range requests, so a dropped connection costs at most a
+// chunk. A download goes into <path>.part, with what is known of it in
+// <path>.part.json, and only becomes path once complete; a download
+// interrupted, even by a restart, resumes from the end of the part file, or
+// starts over if the clip changed since. Chunks that fail are retried up to
+// Retries times in a row, waiting as Dropcam.Retry does between attempts.
+//
+// Once complete, the download is checked against its length and against the
+// MD5 the servers gave in Content-MD5, X-Goog-Hash or a plain MD5 ETag, when
+// they gave one; a mismatch fails with ErrChecksumMismatch and discards the
+// part file. Servers ignoring ranges are read in one go.
+//
+// With Dropcam.Downloader set, SaveClip and DownloadClip download through it.
+type Downloader struct {
+	ChunkSize int64
+	Retries   int
+}
+
+// partState is what a Downloader knows of a partial download
+type partState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Length       int64  `json:"length"`
+	MD5          string `json:"md5,omitempty"`
+}
+
+// rangeOpener requests a download with the extra headers hdr
+type rangeOpener func(ctx context.Context, hdr http.Header) (*http.Response, error)
+
+var contentRange = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+|\*)$`)
+
+// fetch downloads what open returns into part, resuming it if it exists,
+// and returns once part holds the whole, verified download
+func (dl *Downloader) fetch(ctx context.Context, d *Dropcam, op string, open rangeOpener, part string) error {
+
+	chunk := dl.ChunkSize
+	if chunk <= 0 {
+		chunk = DefaultChunkSize
+	}
+	retries := dl.Retries
+	if retries <= 0 {
+		retries = DefaultDownloadRetries
+	}
+	progress, _ := ctx.Value(progressContext{}).(ProgressFunc)
+
+	stateFile := part + ".json"
+	var st partState
+	if data, err := ioutil.ReadFile(stateFile); err == nil {
+		json.Unmarshal(data, &st)
+	}
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && st.ETag == "" && st.LastModified == "" {
+		// nothing tells whether the clip is still the one begun
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	if offset > 0 {
+		Dbg("download: resuming %s at %d of %d bytes\n", part, offset, st.Length)
+	}
+
+	failures := 0
+	for st.Length == 0 || offset < st.Length {
+		hdr := http.Header{}
+		hdr.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+chunk-1))
+		if offset > 0 {
+			if st.ETag != "" {
+				hdr.Set("If-Range", st.ETag)
+			} else {
+				hdr.Set("If-Range", st.LastModified)
+			}
+		}
+
+		n, err := dl.chunk(ctx, op, open, hdr, f, &offset, &st)
+		if err == nil || n > 0 {
+			data, _ := json.Marshal(st)
+			ioutil.WriteFile(stateFile, data, 0644)
+			if progress != nil {
+				progress(offset, st.Length)
+			}
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.HTTPStatus < 500 && apiErr.HTTPStatus != http.StatusTooManyRequests {
+			return err
+		}
+		if n > 0 {
+			// a dropped connection that got somewhere is not a failure
+			failures = 0
+		}
+		failures++
+		if failures > retries {
+			return fmt.Errorf("%s Failed after %d attempts: %w", op, failures, err)
+		}
+		wait := d.Retry.backoff(failures - 1)
+		Dbg("download: %s at %d: %s, retrying in %s\n", part, offset, err, wait)
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if err := verifyDownload(f, &st); err != nil {
+		f.Close()
+		os.Remove(part)
+		os.Remove(stateFile)
+		return fmt.Errorf("%s Failed: %w", op, err)
+	}
+	os.Remove(stateFile)
+	return nil
+}
+
+// chunk requests one range into f at *offset, advancing it by what was
+// written, and returns how much that was
+func (dl *Downloader) chunk(ctx context.Context, op string, open rangeOpener, hdr http.Header, f *os.File, offset *int64, st *partState) (int64, error) {
+
+	resp, err := open(ctx, hdr)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		m := contentRange.FindStringSubmatch(resp.Header.Get("Content-Range"))
+		if m == nil {
+			return 0, fmt.Errorf("%s: bad Content-Range %q", op, resp.Header.Get("Content-Range"))
+		}
+		start, _ := strconv.ParseInt(m[1], 10, 64)
+		if start != *offset {
+			return 0, fmt.Errorf("%s: asked for bytes from %d, got them from %d", op, *offset, start)
+		}
+		if m[3] != "*" {
+			st.Length, _ = strconv.ParseInt(m[3], 10, 64)
+		}
+		st.ETag, st.LastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if sum := headerMD5(resp.Header, false); sum != "" {
+			st.MD5 = sum
+		}
+
+	case http.StatusOK:
+		// the whole clip, because ranges are not supported or it changed
+		if *offset > 0 {
+			Dbg("download: clip changed or ranges unsupported, starting over\n")
+		}
+		if err := f.Truncate(0); err != nil {
+			return 0, err
+		}
+		*offset = 0
+		*st = partState{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Length:       resp.ContentLength,
+			MD5:          headerMD5(resp.Header, true),
+		}
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part file already holds everything
+		if st.Length == 0 {
+			st.Length = *offset
+		}
+		return 0, nil
+
+	default:
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return 0, newAPIError(op, resp, body)
+	}
+
+	if _, err := f.Seek(*offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, resp.Body)
+	*offset += n
+	if err != nil {
+		return n, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		st.Length = *offset
+	}
+	if n == 0 && *offset < st.Length {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// headerMD5 returns the hex MD5 of a whole download the headers h give, if
+// any. Content-MD5 only covers the whole download in full replies.
+func headerMD5(h http.Header, full bool) string {
+
+	decode := func(b64 string) string {
+		sum, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil || len(sum) != md5.Size {
+			return ""
+		}
+		return hex.EncodeToString(sum)
+	}
+	if full {
+		if sum := decode(h.Get("Content-MD5")); sum != "" {
+			return sum
+		}
+	}
+	for _, v := range h.Values("X-Goog-Hash") {
+		for _, part := range strings.Split(v, ",") {
+			if b64 := strings.TrimPrefix(strings.TrimSpace(part), "md5="); b64 != strings.TrimSpace(part) {
+				if sum := decode(b64); sum != "" {
+					return sum
+				}
+			}
+		}
+	}
+	// single-part object stores tag objects with their MD5
+	etag := strings.Trim(strings.TrimPrefix(h.Get("ETag"), "W/"), `"`)
+	if len(etag) == 2*md5.Size && !strings.HasPrefix(h.Get("ETag"), "W/") {
+		if _, err := hex.DecodeString(etag); err == nil {
+			return strings.ToLower(etag)
+		}
+	}
+	return ""
+}
+
+// verifyDownload checks the complete download in f against st
+func verifyDownload(f *os.File, st *partState) error {
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return errors.New("download is empty")
+	}
+	if st.Length > 0 && size != st.Length {
+		return fmt.Errorf("%w: %d bytes of %d", ErrChecksumMismatch, size, st.Length)
+	}
+	if st.MD5 == "" {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != st.MD5 {
+		return fmt.Errorf("%w: md5 %s, expected %s", ErrChecksumMismatch, sum, st.MD5)
+	}
+	return nil
+}
+
+// saveResumable downloads what open returns to path through the Downloader
+// of c, then uploads it as writeClip does
+func (c *Cameras) saveResumable(ctx context.Context, o *Owned, op string, open rangeOpener, path string, taken time.Time) error {
+
+	part := path + ".part"
+	if err := c.Dropcam.Downloader.fetch(ctx, c.Dropcam, op, open, part); err != nil {
+		return err
+	}
+	return c.finishClip(ctx, o, part, path, taken)
+}
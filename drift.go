@@ -0,0 +1,94 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// The PropertyDiff type is one property whose value differs between two sides.
+// A side that does not have the property at all reports an empty value.
+type PropertyDiff struct {
+	Name string
+	A    string
+	B    string
+}
+
+func (d PropertyDiff) String() string {
+	return fmt.Sprintf("%s: %q != %q", d.Name, d.A, d.B)
+}
+
+// propertyMap returns the writable properties of camera o as strings
+func (c *Cameras) propertyMap(ctx context.Context, o *Owned) (map[string]string, error) {
+	info, err := c.CameraInfo(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	props := make(map[string]string, len(info.Properties))
+	for _, p := range info.Properties {
+		if !readOnlyProperties[p.Name] {
+			props[p.Name] = propertyString(p.Value)
+		}
+	}
+	return props, nil
+}
+
+// diffProperties compares the keys of a (or only keys, if given) against b
+func diffProperties(a, b map[string]string, keys []string) []PropertyDiff {
+
+	if keys == nil {
+		seen := make(map[string]bool)
+		for k := range a {
+			seen[k] = true
+		}
+		for k := range b {
+			seen[k] = true
+		}
+		for k := range seen {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []PropertyDiff
+	for _, k := range keys {
+		if a[k] != b[k] {
+			diffs = append(diffs, PropertyDiff{Name: k, A: a[k], B: b[k]})
+		}
+	}
+	return diffs
+}
+
+// The Diff method reports every writable property whose value differs between
+// cameras a and b. In each PropertyDiff, A is camera a's value and B camera b's.
+func (c *Cameras) Diff(ctx context.Context, a, b *Owned) ([]PropertyDiff, error) {
+
+	pa, err := c.propertyMap(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := c.propertyMap(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	return diffProperties(pa, pb, nil), nil
+}
+
+// The DiffAgainstProfile method reports the properties of the profile that
+// camera o does not currently match. A is the camera's value, B the profile's.
+func (c *Cameras) DiffAgainstProfile(ctx context.Context, o *Owned, pr Profile) ([]PropertyDiff, error) {
+
+	current, err := c.propertyMap(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(pr.Properties))
+	for k := range pr.Properties {
+		keys = append(keys, k)
+	}
+	return diffProperties(current, pr.Properties, keys), nil
+}
@@ -3,22 +3,23 @@
 // license that can be found in the LICENSE file.
 
 // Package dropcam implements a basic library to access DropCam cameras.
-//
 package dropcam
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
-
-	"github.com/lafikl/fluent"
 )
 
 // Constants
@@ -26,247 +27,677 @@ const (
 	NexusBase = "https://nexusapi.dropcam.com"
 	ApiBase   = "https://www.dropcam.com"
 	ApiPath   = "api/v1"
-	Devel     = false
+
+	// Devel enables debug messages on the default logger; SetLogger and
+	// Dropcam.Logger choose the level at run time instead
+	Devel = false
 )
 
-// The UserCreds contains the credentials sent to the DropCam URL
+// The UserCreds contains the username the session was opened for. Password
+// is no longer kept after Init; see CookieAuth.Credentials.
 type UserCreds struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-// The DropCam type lists the URL acess points and contains the credentials and session cookie
+// The DropCam type lists the URL acess points and contains the credentials and session cookie.
+// Once Init has returned, a Dropcam and the Cameras it lists are safe to use from several
+// goroutines: the session is renewed once however many requests it fails, and requests share
+// one pool of kept-alive connections. Its exported fields must not be changed while requests
+// are in flight.
 type Dropcam struct {
 	LoginPath           string
 	CamerasGet          string
 	CamerasUpdate       string
+	CamerasAdd          string
+	CamerasDelete       string
 	CamerasGetVisible   string
+	CamerasGetPublic    string
 	CamerasGetImagePath string
 	EventPath           string
+	EventStreamPath     string
 	EventGetClipPath    string
+	ClipRequestPath     string
+	ClipStatusPath      string
+	ClipSharePath       string
+	AvailablePath       string
 	PropertiesPath      string
-
+	CameraInfoPath      string
+	SessionTokenPath    string
+	TalkbackPath        string
+	UsersGetCurrent     string
+	SubscriptionsList   string
+
+	// BaseURL and NexusURL, if set, replace ApiBase and NexusBase in the
+	// paths Init sets, for regional backends, or so a fake server such as
+	// dropcamtest.Server can stand in for the Dropcam servers. See
+	// WithAPIBase and WithNexusBase.
+	BaseURL  string
+	NexusURL string
+
+	// CameraHosts, if set, replaces the download and live stream hosts
+	// cameras report, by uuid
+	CameraHosts map[string]CameraHosts
+
+	// Cookie, the session cookie of CookieAuth, is replaced under a lock
+	// when the session is renewed; read it with SessionCookie while requests
+	// may be in flight
 	Creds  UserCreds
 	Cookie string
+
+	// Auth obtains and attaches credentials. When nil, Init uses CookieAuth
+	// with the username and password it is given.
+	Auth Authenticator
+
+	// Relogin controls logging in again when the server rejects the session
+	Relogin ReloginPolicy
+
+	// Challenges, if set, answers captchas, consent pages and new-device
+	// verifications the servers present at login
+	Challenges ChallengeHandler
+
+	// Sessions, if set, keeps the login between runs. Init resumes the stored
+	// session instead of logging in, and every login is saved. Sessions are
+	// stored under SessionKey, the username when empty.
+	Sessions   SessionStore
+	SessionKey string
+
+	// Dial controls name resolution and address family preference for all
+	// requests. It must be set before Init.
+	Dial DialConfig
+
+	// CameraLimits bounds concurrency and request frequency per camera uuid;
+	// cameras without an entry use DefaultCameraLimit. They must be set before Init.
+	CameraLimits       map[string]CameraLimit
+	DefaultCameraLimit CameraLimit
+
+	// Pipeline is applied to every snapshot before it is stored or returned
+	Pipeline Pipeline
+
+	// Overlay, if set, stamps every snapshot with its capture time, camera
+	// title or other text after Pipeline
+	Overlay *Overlay
+
+	// WidthFallback, if set, retries snapshots at narrower widths on
+	// constrained links; see ImageOptions
+	WidthFallback *WidthFallback
+
+	// Compression, if set, recompresses snapshots to its quality or size budget
+	Compression *JPEGBudget
+
+	// EXIF, if set, writes the capture time, camera and position into every
+	// snapshot, last
+	EXIF *EXIF
+
+	// HTTPClient, if set, sends every request instead of a client built from
+	// Dial, so proxies, TLS settings, timeouts and instrumented transports can
+	// be supplied
+	HTTPClient *http.Client
+
+	// Retry controls which failed requests are retried, how often and how long
+	// apart
+	Retry RetryPolicy
+
+	// RetryBudget, if set, limits retries across all requests
+	RetryBudget *RetryBudget
+
+	// LatestDir, if set, receives a copy of every captured snapshot as
+	// <LatestDir>/<uuid>/latest.jpg, replaced atomically
+	LatestDir string
+
+	// Saved, if set, is sent a NotifySnapshot notification for every snapshot
+	// SaveImage writes, with Media set to the file
+	Saved Notifier
+
+	// Dedup makes the client remember each camera's last snapshot, asking the
+	// server with If-None-Match / If-Modified-Since when it supplied validators,
+	// so SaveNewImage can skip frames identical to the previous one
+	Dedup bool
+
+	// DedupDistance, with Dedup, also skips frames that merely look like the
+	// previous one: those whose PerceptualHash is at most this many bits from
+	// it. Around 4 absorbs JPEG noise and a ticking clock overlay; 0 only skips
+	// identical frames.
+	DedupDistance int
+
+	// Forensics, if set, records recent requests and failed responses for
+	// diagnosing failures
+	Forensics *Forensics
+
+	// Tracer, if set, is told of every API call with its endpoint, camera,
+	// status and latency, for tracing and instrumentation
+	Tracer *Tracer
+
+	// Bandwidth, if set, accounts the bytes downloaded per camera and job
+	Bandwidth *Bandwidth
+
+	// Uploads, if set, sends saved snapshots and clips to object storage
+	Uploads *Uploads
+
+	// Files controls how snapshots are written: what is done when the file
+	// exists and whether it is synced to disk
+	Files FileOptions
+
+	// ReadOnly refuses the calls that change the account or its cameras:
+	// SetProperties and the setters built on it, UpdateCamera, SetActivityZone,
+	// DeleteActivityZone, AddCamera, RemoveCamera, RequestClip, Talkback and
+	// GetClipShareURL, when the servers make the link, fail with ErrReadOnly,
+	// after checking their arguments, and log the request they would have
+	// sent, so automation can be tried against a live account
+	ReadOnly bool
+
+	// Downloader, if set, makes SaveClip and DownloadClip download in chunks
+	// that survive dropped connections and restarts
+	Downloader *Downloader
+
+	// RateLimit, if set, paces all requests; see Stats for the counts
+	RateLimit *RateLimiter
+
+	// Quota, if set, tracks requests against assumed quotas and holds back
+	// low priority requests as they run out
+	Quota *QuotaTracker
+
+	// CamerasPageSize is the number of camera groups Cameras requests per
+	// page, DefaultCamerasPageSize when zero
+	CamerasPageSize int
+
+	// CamerasTTL, if set, makes Cameras return the list it fetched less than
+	// CamerasTTL ago rather than fetch it again; RefreshCameras and
+	// Cameras.Refresh fetch it whatever its age. Adding, removing and
+	// updating cameras expires it.
+	CamerasTTL time.Duration
+
+	// OnCamerasChange, if set, is called with every camera added, removed or
+	// renamed between two fetches of the camera list
+	OnCamerasChange func(CameraChange)
+
+	// Logger, if set, receives the request log of this client instead of the
+	// package logger set with SetLogger. Credentials are redacted.
+	Logger *slog.Logger
+
+	client     *http.Client
+	clientOnce sync.Once
+	urls       sync.Map
+	cookieMu   sync.RWMutex
+	limiter    *cameraLimiter
+	authMu     sync.Mutex
+	authGen    uint64
+	frames     frameMemo
+	cvr        cvrState
+	online     onlineState
+	stats      requestStats
+	camStats   cameraStats
+	cameras    cameraCache
 }
 
-// The Cameras type contains all of the user-owned dropcams associated with the Drocpam object
+// The Cameras type contains all of the user-owned dropcams associated with the Drocpam object,
+// and in Shared the cameras other accounts have shared with the user
 type Cameras struct {
 	Dropcam *Dropcam
 	Cam     []Owned
+	Shared  []Subscribed
+}
+
+// The Event type is a single motion or sound cuepoint recorded by a camera.
+// Start and end times are seconds since the epoch as returned by get_cuepoint;
+// the Start and End methods return them in the camera's zone for events
+// returned by GetEvents and QueryEvents.
+type Event struct {
+	Id          int64   `json:"id"`
+	Type        string  `json:"type"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time"`
+	ZoneIds     []int64 `json:"zone_ids"`
+	IsImportant bool    `json:"is_important"`
+	HasClip     bool    `json:"has_clip"`
+
+	// Camera is the uuid of the camera the event was requested for
+	Camera string `json:"-"`
+
+	// loc is the zone of the camera, when known
+	loc *time.Location
+}
+
+// The Start method returns the time the event began
+func (e *Event) Start() time.Time {
+	return e.in(epochTime(e.StartTime))
+}
+
+// The End method returns the time the event ended, or the zero time if it is still in progress
+func (e *Event) End() time.Time {
+	if e.EndTime == 0 {
+		return time.Time{}
+	}
+	return e.in(epochTime(e.EndTime))
+}
+
+// in returns t in the zone of the event's camera, when known
+func (e *Event) in(t time.Time) time.Time {
+	if e.loc == nil {
+		return t
+	}
+	return t.In(e.loc)
+}
+
+// The Duration method returns the length of the event, or zero if it is still in progress
+func (e *Event) Duration() time.Duration {
+	if e.EndTime == 0 {
+		return 0
+	}
+	return e.End().Sub(e.Start())
 }
 
 // The Events type contains events for a specific camera over a defined epoch
-type Events struct {
+type Events []Event
+
+// epochTime converts fractional seconds since the epoch to a time.Time
+func epochTime(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second)))
 }
 
 // The Owned type contains the attribuetes associated with a users dropcam
 type Owned struct {
-	Capabilities        []string    `json:"capabilities"`
-	Description         string      `json:"description"`
-	DownloadHost        string      `json:"download_host"`
-	HasBundle           bool        `json:"has_bundle"`
-	HoursOfRecordingMax float64     `json:"hours_of_recording_max"`
-	Id                  int64       `json:"id"`
-	IsConnected         bool        `json:"is_connected"`
-	IsOnline            bool        `json:"is_online"`
-	IsPublic            bool        `json:"is_public"`
-	IsStreaming         bool        `json:"is_streaming"`
-	IsStreamingEnabled  bool        `json:"is_streaming_enabled"`
-	IsTrialMode         bool        `json:"is_trial_mode"`
-	IsTrialWarning      bool        `json:"is_trial_warning"`
-	LastLocalIp         string      `json:"last_local_ip"`
-	LiveStreamHost      string      `json:"live_stream_host"`
-	Location            interface{} `json:"location"`
-	MacAddress          string      `json:"mac_address"`
-	Name                string      `json:"name"`
-	NestStructureId     interface{} `json:"nest_structure_id"`
-	OwnerId             string      `json:"owner_id"`
-	PublicToken         string      `json:"public_token"`
-	Timezone            string      `json:"timezone"`
-	TimezoneUtcOffset   int64       `json:"timezone_utc_offset"`
-	Title               string      `json:"title"`
-	TrialDaysLeft       int64       `json:"trial_days_left"`
-	Type                int64       `json:"type"`
-	Uuid                string      `json:"uuid"`
-	Where               string      `json:"where"`
+	Capabilities        Capabilities `json:"capabilities"`
+	Description         string       `json:"description"`
+	DownloadHost        string       `json:"download_host"`
+	HasBundle           bool         `json:"has_bundle"`
+	HoursOfRecordingMax float64      `json:"hours_of_recording_max"`
+	Id                  int64        `json:"id"`
+	IsConnected         bool         `json:"is_connected"`
+	IsOnline            bool         `json:"is_online"`
+	IsPublic            bool         `json:"is_public"`
+	IsStreaming         bool         `json:"is_streaming"`
+	IsStreamingEnabled  bool         `json:"is_streaming_enabled"`
+	IsTrialMode         bool         `json:"is_trial_mode"`
+	IsTrialWarning      bool         `json:"is_trial_warning"`
+	LastLocalIp         string       `json:"last_local_ip"`
+	LiveStreamHost      string       `json:"live_stream_host"`
+	Location            interface{}  `json:"location"`
+	MacAddress          string       `json:"mac_address"`
+	Name                string       `json:"name"`
+	NestStructureId     interface{}  `json:"nest_structure_id"`
+	OwnerId             string       `json:"owner_id"`
+	PublicToken         string       `json:"public_token"`
+	Timezone            string       `json:"timezone"`
+	TimezoneUtcOffset   int64        `json:"timezone_utc_offset"`
+	Title               string       `json:"title"`
+	TrialDaysLeft       int64        `json:"trial_days_left"`
+	Type                int64        `json:"type"`
+	Uuid                string       `json:"uuid"`
+	Where               string       `json:"where"`
+}
+
+// The Subscribed type is a camera owned by another account and shared with the user.
+// Its PublicToken is sent with snapshot requests.
+type Subscribed struct {
+	Owned
 }
 
+// The Items type is one group of the camera list. Grouped listings carry the
+// Nest structure of the group's cameras.
 type Items struct {
-	Owned      []Owned       `json:"owned"`
-	Subscribed []interface{} `json:"subscribed"`
+	Owned           []Owned      `json:"owned"`
+	Subscribed      []Subscribed `json:"subscribed"`
+	NestStructureId interface{}  `json:"nest_structure_id"`
 }
 
+// The Cam type is one page of the camera list. Large accounts are listed in
+// pages; NextPage, TotalPages or HasMore tell whether more follow.
 type Cam struct {
-	Items []struct {
-		Owned      []Owned       `json:"owned"`
-		Subscribed []interface{} `json:"subscribed"`
-	} `json:"items"`
-	Status            int64  `json:"status"`
-	StatusDescription string `json:"status_description"`
-	StatusDetail      string `json:"status_detail"`
+	Items             []Items `json:"items"`
+	Status            int64   `json:"status"`
+	StatusDescription string  `json:"status_description"`
+	StatusDetail      string  `json:"status_detail"`
+	NextPage          int     `json:"next_page"`
+	TotalPages        int     `json:"total_pages"`
+	HasMore           bool    `json:"has_more"`
 }
 
 // Private Methods
 //
 
-func Dbg(format string, args ...interface{}) {
-	if !Devel {
-		return
+func (d *Dropcam) httpClient() *http.Client {
+	if d.HTTPClient != nil {
+		return d.HTTPClient
 	}
-	str := fmt.Sprintf(format, args...)
-	fmt.Fprintf(os.Stderr, "%v\n", str)
+	d.clientOnce.Do(func() {
+		d.client = &http.Client{Transport: d.Dial.transport()}
+	})
+	return d.client
 }
 
-func getBodyRespCode(rb io.ReadCloser) (int, error) {
-	body, _ := ioutil.ReadAll(rb)
-	// log.Println("response Body:", string(body))
-
-	type BodyStatus struct {
-		Status int
-	}
-	var bStat BodyStatus
-	err := json.Unmarshal(body, &bStat)
-	if err != nil {
-		return 0, err
+// send issues the request built by newReq on behalf of camera uuid, if any,
+// retrying the failures d.Retry selects as it allows, up to retries times by
+// default. Waiting between attempts stops early if ctx is done. A rejected
+// session is renewed and the request repeated as allowed by d.Relogin.
+func (d *Dropcam) send(ctx context.Context, uuid string, newReq func() (*http.Request, error), retries int) (resp *http.Response, err error) {
+
+	var trace *RequestTrace
+	traceCtx := ctx
+	defer func() {
+		if err != nil || resp.StatusCode >= 400 {
+			d.stats.add(&d.stats.Failed)
+			d.camStats.failure(uuid)
+		}
+		d.stats.outcome(resp, err)
+		if trace != nil {
+			d.Tracer.end(traceCtx, trace, resp, err)
+		}
+	}()
+	if d.RetryBudget != nil {
+		d.RetryBudget.recordRequest()
 	}
-	// Dbg("response Body code: %d", bStat.Status)
-	return bStat.Status, nil
-}
-
-func (d *Dropcam) postRequest(url string, uuid string, data interface{}) (resp *http.Response, err error) {
 
-	req := fluent.New()
-	req.Post(url).
-		InitialInterval(time.Duration(time.Millisecond)).
-		Json(data)
-
-	referer := ApiBase + "/" + "watch" + "/" + uuid
-	req.SetHeader("Referer", referer)
-	req.SetHeader("cookie", d.Cookie)
+	retries = d.Retry.retries(retries)
+	gen := d.sessionGen()
+	relogins, limited := 0, 0
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		if d.Tracer != nil {
+			if trace == nil {
+				traceCtx, trace = d.Tracer.begin(ctx, req, uuid)
+			}
+			req = req.WithContext(traceCtx)
+		}
+		if err := d.Quota.wait(ctx, req.URL.Path); err != nil {
+			return nil, err
+		}
+		if err := d.RateLimit.wait(ctx, req.URL.Path, &d.stats); err != nil {
+			return nil, err
+		}
+		d.stats.add(&d.stats.Requests)
+		d.Quota.record(req.URL.Path, time.Now())
 
-	resp, err = req.Send()
+		start := time.Now()
+		resp, err := d.httpClient().Do(req)
+		if trace != nil {
+			trace.Attempts++
+		}
+		d.camStats.request(uuid, time.Since(start))
+		if err == nil {
+			d.camStats.countBody(uuid, resp)
+		}
+		if err == nil && d.Bandwidth != nil {
+			d.Bandwidth.countBody(ctx, req, resp)
+		}
+		if d.Forensics != nil {
+			d.Forensics.record(d, req, resp, err, attempt, time.Since(start))
+		}
+		if l := d.logger(); l.Enabled(ctx, slog.LevelDebug) {
+			attrs := []any{"method", req.Method, "url", req.URL, "attempt", attempt, "duration", time.Since(start)}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			} else {
+				attrs = append(attrs, "status", resp.StatusCode)
+			}
+			l.DebugContext(ctx, "request", attrs...)
+		}
+		if err == nil && sessionRejected(resp) && d.Relogin.allow(ctx, relogins) && d.Auth != nil {
+			resp.Body.Close()
+			relogins++
+			if gen, err = d.relogin(ctx, gen); err != nil {
+				return nil, err
+			}
+			attempt--
+			continue
+		}
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			if wait, ok := d.Retry.rateLimitWait(resp, limited); ok {
+				resp.Body.Close()
+				limited++
+				Dbg("rate limited, retrying in %s\n", wait)
+				if err := sleepCtx(ctx, wait); err != nil {
+					return nil, err
+				}
+				attempt--
+				continue
+			}
+		}
+		if !d.Retry.retryable(resp, err) {
+			return resp, err
+		}
+		if attempt >= retries {
+			return resp, err
+		}
+		if d.RetryBudget != nil && !d.RetryBudget.allowRetry() {
+			Dbg("retry budget exhausted, failing fast\n")
+			return resp, err
+		}
+		wait := d.Retry.backoff(attempt)
+		if ra := retryAfter(resp); ra > wait {
+			wait = ra
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	log.Println("response Status:", resp.Status)
-	log.Println("response Headers:", resp.Header)
+// postRequest posts data as JSON to url on behalf of camera uuid and returns
+// the items of the reply, failing as op when the servers refuse it
+func (d *Dropcam) postRequest(ctx context.Context, op string, url string, uuid string, data interface{}) (json.RawMessage, error) {
 
-	rc, err := getBodyRespCode(resp.Body)
+	resp, err := d.jsonRequest(ctx, "POST", url, uuid, data)
 	if err != nil {
-		return nil, errors.New("Failed to get Reply Response Code")
-	}
-	if rc != 200 {
-		return nil, errors.New("Malformed Request")
+		return nil, err
 	}
 
-	return resp, nil
+	d.logger().Debug("post response", "status", resp.Status, "header", resp.Header)
+	return decodeEnvelope[json.RawMessage](op, resp)
 }
 
-func (d *Dropcam) getRequest(url string, v url.Values) (resp *http.Response, err error) {
-
-	// Dropcam http request function.
+// jsonRequest sends data, if not nil, as JSON to url on behalf of camera uuid
+// with the given method, and returns the reply whatever its status
+func (d *Dropcam) jsonRequest(ctx context.Context, method string, url string, uuid string, data interface{}) (resp *http.Response, err error) {
 
-	req := fluent.New()
-	if d.Cookie != "" {
-		req.SetHeader("cookie", d.Cookie)
+	var body []byte
+	if data != nil {
+		if body, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
 	}
 
-	reqUrl := url + "?" + v.Encode()
-	Dbg("REQ[%s] =>[%s]\n", d.Cookie, reqUrl)
-	req.Get(reqUrl).
-		InitialInterval(time.Duration(time.Millisecond)).
-		Retry(3)
-
-	resp, err = req.Send()
+	referer := d.apiBase() + "/" + "watch" + "/" + uuid
 
+	release, err := d.limiter.acquire(ctx, uuid, d.cameraLimit(uuid))
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	return resp, nil
+	return d.send(ctx, uuid, func() (*http.Request, error) {
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := d.newRequest(ctx, method, url, "", rd)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Referer", referer)
+		if err := d.authorize(ctx, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}, 0)
 }
 
-// Init is the method that passed the credentials to the dropcam server and receives back a session cookie
-// for subsequent requests
-func (d *Dropcam) Init(username string, password string) (*Dropcam, error) {
-
-	d.LoginPath = ApiBase + "/" + ApiPath + "/" + "login.login"
-	d.CamerasGet = ApiBase + "/" + ApiPath + "/" + "cameras.get"
-	d.CamerasUpdate = ApiBase + "/" + ApiPath + "/" + "cameras.update"
-	d.CamerasGetVisible = ApiBase + "/" + ApiPath + "/" + "cameras.get_visible"
-	d.CamerasGetImagePath = ApiBase + "/" + ApiPath + "/" + "cameras.get_image"
-	d.EventPath = NexusBase + "/" + "get_cuepoint"
-	d.EventGetClipPath = NexusBase + "/" + "get_event_clip"
-	d.PropertiesPath = ApiBase + "/" + "app/cameras/properties"
-	// Creates a new dropcam API instance.
+func (d *Dropcam) getRequest(ctx context.Context, url string, v url.Values) (resp *http.Response, err error) {
+	return d.getRequestHeader(ctx, url, v, nil)
+}
 
-	d.Creds.Username = username
-	d.Creds.Password = password
-	d.Cookie = ""
+// getRequestHeader is getRequest adding the headers in hdr
+func (d *Dropcam) getRequestHeader(ctx context.Context, url string, v url.Values, hdr http.Header) (resp *http.Response, err error) {
+
+	// Dropcam http request function.
 
-	err := d.login()
+	query := v.Encode()
+	d.logger().Debug("get request", "url", url, "query", query)
+
+	uuid := v.Get("uuid")
+	release, err := d.limiter.acquire(ctx, uuid, d.cameraLimit(uuid))
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	return d, nil
-}
+	resp, err = d.send(ctx, uuid, func() (*http.Request, error) {
+		req, err := d.newRequest(ctx, "GET", url, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, vs := range hdr {
+			req.Header[k] = vs
+		}
+		if err := d.authorize(ctx, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}, 3)
 
-func (d *Dropcam) login() error {
+	if err != nil {
+		return nil, err
+	}
+	trackProgress(ctx, resp)
 
-	v := url.Values{}
-	v.Set("username", d.Creds.Username)
-	v.Add("password", d.Creds.Password)
+	return resp, nil
+}
 
-	response, err := d.getRequest(d.LoginPath, v)
-	if err != nil {
-		errStr := fmt.Sprintf("Login Request Failed: %s", err)
-		return errors.New(errStr)
+// authorize attaches the session credentials to req
+func (d *Dropcam) authorize(ctx context.Context, req *http.Request) error {
+	if d.Auth != nil {
+		return d.Auth.Authorize(ctx, d, req)
 	}
-	d.Cookie = response.Header.Get("Set-Cookie")
-	if d.Cookie == "" {
-		errStr := fmt.Sprintf("Login Returned No Cookie")
-		return errors.New(errStr)
+	if cookie := d.SessionCookie(); cookie != "" {
+		req.Header.Set("cookie", cookie)
 	}
-	Dbg("setting cookie -> [%s]\n", d.Cookie)
 	return nil
+}
 
+// The SessionCookie method returns the session cookie, safely while other
+// goroutines may be logging in again
+func (d *Dropcam) SessionCookie() string {
+	d.cookieMu.RLock()
+	defer d.cookieMu.RUnlock()
+	return d.Cookie
 }
 
-// The Cameras method will return a list of DropCam cameras from the server.
-// These are soley private cameras owned by the credentials.
-func (d *Dropcam) Cameras() (*Cameras, error) {
-	// returns: list of Camera class objects
+// setCookie replaces the session cookie
+func (d *Dropcam) setCookie(cookie string) {
+	d.cookieMu.Lock()
+	d.Cookie = cookie
+	d.cookieMu.Unlock()
+}
 
-	if d.Cookie == "" {
-		return nil, d.login()
-	}
+// defaultPaths points d at the Dropcam servers, or at BaseURL and NexusURL
+func (d *Dropcam) defaultPaths() {
+	api, nexus := d.apiBase(), d.nexusBase()
+	d.LoginPath = api + "/" + ApiPath + "/" + "login.login"
+	d.CamerasGet = api + "/" + ApiPath + "/" + "cameras.get"
+	d.CamerasUpdate = api + "/" + ApiPath + "/" + "cameras.update"
+	d.CamerasAdd = api + "/" + ApiPath + "/" + "cameras.add"
+	d.CamerasDelete = api + "/" + ApiPath + "/" + "cameras.delete"
+	d.CamerasGetVisible = api + "/" + ApiPath + "/" + "cameras.get_visible"
+	d.CamerasGetPublic = api + "/" + ApiPath + "/" + "cameras.get_by_public_token"
+	d.CamerasGetImagePath = api + "/" + ApiPath + "/" + "cameras.get_image"
+	d.EventPath = nexus + "/" + "get_cuepoint"
+	d.EventStreamPath = nexus + "/" + "subscribe"
+	d.EventGetClipPath = nexus + "/" + "get_event_clip"
+	d.ClipRequestPath = nexus + "/" + "request_clip"
+	d.ClipStatusPath = nexus + "/" + "get_clip_status"
+	d.ClipSharePath = nexus + "/" + "share_clip"
+	d.AvailablePath = nexus + "/" + "get_available"
+	d.PropertiesPath = api + "/" + "app/cameras/properties"
+	d.CameraInfoPath = api + "/" + "app/cameras"
+	d.SessionTokenPath = api + "/" + ApiPath + "/" + "users.get_session_token"
+	d.TalkbackPath = nexus + "/" + "start_talkback"
+	d.UsersGetCurrent = api + "/" + ApiPath + "/" + "users.get_current"
+	d.SubscriptionsList = api + "/" + ApiPath + "/" + "subscriptions.list"
+}
 
-	v := url.Values{}
-	v.Set("group_cameras", "True")
+// Init is the method that passed the credentials to the dropcam server and receives back a session cookie
+// for subsequent requests. Like every method that talks to the server, it gives up when ctx is done.
+// The password is only used to build the default CookieAuth, which zeroes it once logged in, so
+// the session cannot be renewed when it expires; set Auth to a CookieAuth with Credentials to
+// log in again. With Auth set the password may be empty.
+func (d *Dropcam) Init(ctx context.Context, username string, password string) (*Dropcam, error) {
 
-	response, err := d.getRequest(d.CamerasGetVisible, v)
-	if err != nil {
-		return nil, errors.New("Get Visible Cameras Request Failed")
+	d.defaultPaths()
+	// Creates a new dropcam API instance.
+
+	d.Creds.Username = username
+	d.Creds.Password = ""
+	d.setCookie("")
+	d.limiter = newCameraLimiter()
+	if d.Auth == nil {
+		d.Auth = &CookieAuth{Username: username, Password: password}
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	if d.Sessions != nil {
+		unlock, err := d.lockSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+
+		// a rejected resumed session is replaced through Relogin
+		if d.resumeSession() {
+			return d, nil
+		}
+	}
 
-	var cam Cam
-	err = json.Unmarshal(body, &cam)
+	// a rejected login is not retried through Relogin, which would wait on
+	// the session lock held here
+	err := d.Auth.Login(context.WithValue(ctx, noReloginContext{}, true), d)
 	if err != nil {
-		fmt.Println("error:", err)
 		return nil, err
 	}
+	d.saveSession()
+
+	return d, nil
+}
+
+// The Cameras method will return a list of DropCam cameras from the server:
+// the cameras owned by the credentials in Cam and those shared with them in Shared.
+// Every page of the list is read; see CamerasIter to read it a page at a time.
+// With CamerasTTL set, the list fetched less than CamerasTTL ago is returned
+// instead; see RefreshCameras.
+func (d *Dropcam) Cameras(ctx context.Context) (*Cameras, error) {
+	if c, ok := d.cameras.cached(d.CamerasTTL); ok {
+		return c, nil
+	}
+	return d.RefreshCameras(ctx)
+}
+
+// fetchCameras reads every page of the camera list
+func (d *Dropcam) fetchCameras(ctx context.Context) (*Cameras, error) {
+	// returns: list of Camera class objects
 
 	cameras := new(Cameras)
 	cameras.Dropcam = d
 
-	for _, items := range cam.Items {
-		for _, owned := range items.Owned {
-			//fmt.Printf("%d: %s\n", j, owned)
-			cameras.Cam = append(cameras.Cam, owned)
+	it := d.CamerasIter(ctx)
+	for it.Next() {
+		if it.Shared() {
+			cameras.Shared = append(cameras.Shared, Subscribed{*it.Camera()})
+		} else {
+			cameras.Cam = append(cameras.Cam, *it.Camera())
 		}
 	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	d.cvr.note(cameras)
+	d.online.note(cameras)
 	return cameras, nil
 }
 
@@ -277,16 +708,15 @@ type CamProp struct {
 }
 
 // The SetProperties method will set varias properties on an individual
-// Owned Camera
-func (c *Cameras) SetProperties(o *Owned, name string, value string) (bool, error) {
-
-	// Changes a property on the camera
-	// Examples:
-	// irled.state: auto_on / always_on / always_off
-	// streaming.enabled: true / false
-	// streaming.params.hd: true / false
-	// audio.enabled: true / false
-	// statusled.enabled: true / false
+// Owned Camera. SetIRLED, SetStreamingEnabled, SetHD, SetAudioEnabled and
+// SetStatusLED set the common ones with typed values. Properties needing a
+// capability the camera lacks fail with ErrUnsupportedCapability before any
+// request is made.
+func (c *Cameras) SetProperties(ctx context.Context, o *Owned, name string, value string) (bool, error) {
+
+	if err := requirePropertyCapability(o, name); err != nil {
+		return false, err
+	}
 
 	url := c.Dropcam.PropertiesPath + o.Uuid
 
@@ -295,112 +725,254 @@ func (c *Cameras) SetProperties(o *Owned, name string, value string) (bool, erro
 	props.Name = name
 	props.Value = value
 
-	resp, err := c.Dropcam.postRequest(url, o.Uuid, props)
-	if err != nil {
-		return false, errors.New("Failed postRequest ")
-	}
-
-	rc, err := getBodyRespCode(resp.Body)
-	if err != nil {
-		return false, errors.New("Failed to get Reply Response Code")
+	if err := c.Dropcam.refuseWrite("Set Property", "POST", url, o.Uuid, props); err != nil {
+		return false, err
 	}
-	if rc != 200 {
-		return false, errors.New("SeProperties Malformed Request")
+	if _, err := c.Dropcam.postRequest(ctx, "Set Property", url, o.Uuid, props); err != nil {
+		return false, err
 	}
-
 	return true, nil
 }
 
-// The GetEvents method will return an array of Events for the given timeframe
-func (c *Cameras) GetEvents(o *Owned, st time.Time, et time.Time) ([]Events, error) {
-	// Returns a list of camera events for a given time period:
-
-	//:param start: start time in seconds since epoch
-	//:param end: end time in seconds since epoch (defaults to current time)
-	//:returns: list of Event class objects
-
-	//events := new([]Events)
+// The GetEvents method returns the events recorded by camera o between st and et.
+// A zero et means now. The events of a camera that is offline are still
+// returned, as they are kept by the servers; a failure while it is offline
+// wraps ErrCameraOffline.
+func (c *Cameras) GetEvents(ctx context.Context, o *Owned, st time.Time, et time.Time) (Events, error) {
+	return c.QueryEvents(ctx, o, EventQuery{Start: st, End: et})
+}
 
-	fmt.Printf("STARTING AT: [%s]\n", st)
-	v := url.Values{}
-	v.Set("uuid", o.Uuid)
-	v.Add("start_time", fmt.Sprintf("%d", st.Unix()))
-	v.Add("end_time", fmt.Sprintf("%d", et.Unix()-60*60*24))
-	v.Add("human", "True")
+// The QueryEvents method returns the events of camera o that q selects, as
+// GetEvents does
+func (c *Cameras) QueryEvents(ctx context.Context, o *Owned, q EventQuery) (Events, error) {
 
-	response, err := c.Dropcam.getRequest(c.Dropcam.EventPath, v)
+	response, err := c.Dropcam.getRequest(ctx, c.Dropcam.nexusPath(o, c.Dropcam.EventPath), q.values(o.Uuid))
 	if err != nil {
 		Dbg("Events request failed\n")
-		return nil, errors.New("Get Visible Cameras Request Failed")
+		return nil, fmt.Errorf("Get Events Request Failed: %w", err)
 	}
+	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
+	buf, err := readPooled(response)
+	defer releaseBody(buf)
+	body := buf.Bytes()
 	if err != nil {
 		Dbg("Failed to Read Event Body\n")
-		return nil, errors.New("EVent ioutil.ReadAll failed")
+		return nil, errors.New("Event ioutil.ReadAll failed")
+	}
+	Dbg("Events Response body = [%s]\n", body)
+	if response.StatusCode != 200 {
+		e := newAPIError("Get Events", response, body)
+		if e.Err == nil && !c.Dropcam.isOnline(o) {
+			e.Err = ErrCameraOffline
+		}
+		return nil, e
 	}
-	Dbg("Camera Response body = [%s]\n", body)
 
-	var event Events
-	err = json.Unmarshal(body, &event)
+	var events Events
+	err = json.Unmarshal(body, &events)
 	if err != nil {
-		fmt.Println("Can't unmarshall Events", err)
-		return nil, err
+		return nil, fmt.Errorf("Can't unmarshal Events: %s", err)
 	}
 
-	//events.append(Event(self, item))
-	return nil, nil
+	loc := o.Zone()
+	for i := range events {
+		events[i].Camera = o.Uuid
+		events[i].loc = loc
+	}
+	return q.apply(events), nil
 }
 
-func (c *Cameras) getImage(o *Owned, width int, st time.Time) ([]byte, error) {
+func (c *Cameras) getImage(ctx context.Context, o *Owned, width int, st time.Time) ([]byte, error) {
+	img, _, err := c.fetchImage(ctx, o, width, st, imageQuery{})
+	return img, err
+}
+
+// fetchImage requests a camera image, asking for q as well. fresh is false
+// when Dedup is set and the image is the same as the one previously fetched
+// at this width.
+func (c *Cameras) fetchImage(ctx context.Context, o *Owned, width int, st time.Time, q imageQuery) (img []byte, fresh bool, err error) {
 
 	// Requests a camera image, returns response object.
 
 	v := url.Values{}
 	v.Set("uuid", o.Uuid)
 	v.Add("width", fmt.Sprintf("%d", width))
+	q.set(v)
+	if c.isShared(o.Uuid) && o.PublicToken != "" {
+		v.Add("public_token", o.PublicToken)
+	}
 
-	/*
-		if st != "" {
-			v.Add("time", fmt.Sprintf("%d", st.Unix()))
+	historical := isHistorical(st)
+	if historical && !c.Dropcam.hasCVR(o) {
+		return nil, false, c.Dropcam.requireCVR("Get Image", o)
+	}
+	if !historical {
+		if err := c.Dropcam.requireOnline("Get Image", o); err != nil {
+			return nil, false, err
 		}
-	*/
+	}
+	if historical {
+		v.Add("time", strconv.FormatFloat(float64(st.UnixNano())/1e9, 'f', 3, 64))
+	}
+
+	d := c.Dropcam
+	key := fmt.Sprintf("%s/%d%s", o.Uuid, width, q.key())
+	var last *lastFrame
+	if d.Dedup && !historical {
+		last = d.frames.get(key)
+	}
 
-	response, err := c.Dropcam.getRequest(c.Dropcam.CamerasGetImagePath, v)
+	response, err := d.getRequestHeader(withJob(ctx, JobSnapshot), d.CamerasGetImagePath, v, last.validators())
 	if err != nil {
-		return nil, errors.New("Get Image Failed")
+		return nil, false, fmt.Errorf("Get Image Failed: %w", err)
 	}
+	defer response.Body.Close()
 
-	body, err := ioutil.ReadAll(response.Body)
+	body, err := readBody(response)
 	if err != nil {
-		return nil, errors.New("Failed to get Reply Response Code")
+		return nil, false, fmt.Errorf("Get Image Failed: %w", err)
 	}
-	if response.StatusCode != 200 || (response.Header.Get("content-length") == "0") {
-		return nil, errors.New("Malformed Request or image has 0 size")
+	if response.StatusCode == http.StatusNotModified && last != nil {
+		return last.img, false, nil
+	}
+	if response.StatusCode != 200 {
+		return nil, false, newAPIError("Get Image", response, body)
+	}
+	if len(body) == 0 {
+		// nexus answers with an empty image when the camera is not connected
+		d.camStats.failure(o.Uuid)
+		return nil, false, &APIError{Op: "Get Image", HTTPStatus: response.StatusCode, Description: "image has 0 size", Err: ErrCameraOffline}
+	}
+
+	d.camStats.snapshot(o.Uuid)
+	sum := sha256.Sum256(body)
+	if last != nil && sum == last.sum {
+		return last.img, false, nil
+	}
+	var hash uint64
+	hashed := false
+	if d.Dedup && !historical && d.DedupDistance > 0 {
+		hash, hashed = jpegHash(body)
+		if hashed && last != nil && last.hashed && HashDistance(hash, last.hash) <= d.DedupDistance {
+			Dbg("%s looks unchanged, %d bits apart\n", o.Title, HashDistance(hash, last.hash))
+			return last.img, false, nil
+		}
 	}
 
-	return body, nil
+	img = body
+	taken := time.Now()
+	if historical {
+		taken = st
+	}
+	pipeline := d.Pipeline
+	if d.Overlay != nil {
+		pipeline = append(pipeline[:len(pipeline):len(pipeline)], d.Overlay.processor(o, taken))
+	}
+	if len(pipeline) > 0 || d.Compression.needed(body) {
+		if img, err = pipeline.applyBudget(body, d.Compression); err != nil {
+			return nil, false, err
+		}
+	}
+	if d.EXIF != nil {
+		img = d.EXIF.apply(img, o, taken)
+	}
+	if d.Dedup && !historical {
+		d.frames.put(key, &lastFrame{
+			etag:     response.Header.Get("ETag"),
+			modified: response.Header.Get("Last-Modified"),
+			sum:      sum,
+			img:      img,
+			hash:     hash,
+			hashed:   hashed,
+		})
+	}
+	return img, true, nil
 }
 
 // The SaveImage method retrieves an image from a specifically Owned camera
-// and writes it to disk.
-func (c *Cameras) SaveImage(o *Owned, path string, width int, st time.Time) error {
+// and writes it to disk. It is WriteImage into a file, written atomically as
+// Dropcam.Files says. With Dropcam.Uploads set the image is uploaded as well,
+// or instead. Live images of a camera the
+// camera list reports offline fail with ErrCameraOffline without a request;
+// see WaitOnline.
+func (c *Cameras) SaveImage(ctx context.Context, o *Owned, path string, width int, st time.Time) error {
+	_, err := c.SaveNewImage(ctx, o, path, width, st)
+	return err
+}
+
+// The SaveNewImage method is SaveImage reporting whether a new image was
+// written. With Dedup set, a snapshot identical to the camera's previous one,
+// or within DedupDistance of it, is not written and savedNew is false, as it
+// is when Dropcam.Files keeps an existing file.
+func (c *Cameras) SaveNewImage(ctx context.Context, o *Owned, path string, width int, st time.Time) (savedNew bool, err error) {
+	written, err := c.saveNewImage(ctx, o, path, width, st, UploadSnapshot)
+	return written != "", err
+}
+
+// saveNewImage is SaveNewImage uploading the image as kind when Uploads is
+// set. It returns the path written, which CollisionSuffix may have changed,
+// or "" when nothing was.
+func (c *Cameras) saveNewImage(ctx context.Context, o *Owned, path string, width int, st time.Time, kind string) (written string, err error) {
 	// Saves a camera image to disc.
 
 	Dbg("***** getting image *****\n")
-	img, err := c.getImage(o, width, st)
+	var img bytes.Buffer
+	fresh, err := c.writeImage(ctx, o, &img, ImageOptions{Width: width, Time: st}, true)
 	if err != nil {
 		Dbg("Failed to getImage: %s\n", err)
-		return err
+		return "", err
 	}
-
-	err = ioutil.WriteFile(path, img, 0644)
-	if err != nil {
-		Dbg("failed to write image into file: '%s', err\n", path, err)
-		return err
+	if !fresh {
+		Dbg("image unchanged, not writing \"%s\"\n", path)
+		return "", nil
 	}
+	return c.storeImage(ctx, o, path, img.Bytes(), st, kind)
+}
 
-	Dbg("wrote image to \"%s\"\n", path)
-	return nil
+// storeImage writes img, an image of camera o taken at st, to path as Files
+// says, or uploads it as kind when Uploads is set, and notifies Saved. It
+// returns the path written, or "" when Files kept an existing file.
+func (c *Cameras) storeImage(ctx context.Context, o *Owned, path string, img []byte, st time.Time, kind string) (written string, err error) {
 
+	media := path
+	if u := c.Dropcam.Uploads; u != nil {
+		taken := st
+		if taken.IsZero() || !isHistorical(taken) {
+			taken = time.Now()
+		}
+		if media, err = u.put(ctx, kind, o, filepath.Base(path), taken, bytes.NewReader(img), int64(len(img))); err != nil {
+			return "", err
+		}
+	}
+	written = path
+	if u := c.Dropcam.Uploads; u == nil || u.KeepLocal {
+		written, err = c.Dropcam.Files.writeFile(path, img)
+		if err != nil {
+			warnf("failed to write image into file: '%s', %s\n", path, err)
+			return "", err
+		}
+		if written == "" {
+			return "", nil
+		}
+		if u == nil {
+			media = written
+		}
+		Dbg("wrote image to \"%s\"\n", written)
+	}
+
+	if n := c.Dropcam.Saved; n != nil {
+		err := n.Notify(&Notification{
+			Kind:    NotifySnapshot,
+			Camera:  o.Uuid,
+			Title:   o.Title,
+			Message: "Snapshot saved",
+			Time:    time.Now(),
+			Media:   media,
+		})
+		if err != nil {
+			Dbg("snapshot notification failed: %s\n", err)
+		}
+	}
+	return written, nil
 }
@@ -0,0 +1,272 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+// count returns how many of the requests s served were to endpoint
+func count(s *dropcamtest.Server, endpoint string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if strings.HasSuffix(r, "/"+endpoint) {
+			n++
+		}
+	}
+	return n
+}
+
+// login returns the cameras of s, failing t if it cannot log in
+func login(t *testing.T, s *dropcamtest.Server) *dropcam.Cameras {
+	t.Helper()
+	c, err := s.Cameras(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep retries quick
+	c.Dropcam.Retry.Initial = time.Millisecond
+	return c
+}
+
+func TestLogin(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+
+	if _, err := s.Dropcam().Init(ctx, dropcamtest.Username, "wrong"); !errors.Is(err, dropcam.ErrAuthFailed) {
+		t.Errorf("login with a wrong password: %v, want ErrAuthFailed", err)
+	}
+	d, err := s.Dropcam().Init(ctx, dropcamtest.Username, dropcamtest.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Cameras(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelogin(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+
+	s.ExpireSessions()
+	logins := count(s, "login.login")
+	if _, err := c.Dropcam.Cameras(ctx); err != nil {
+		t.Fatalf("cameras after the session expired: %v", err)
+	}
+	if n := count(s, "login.login") - logins; n != 1 {
+		t.Errorf("logged in %d times, want once", n)
+	}
+
+	s.ExpireSessions()
+	c.Dropcam.Relogin.Disabled = true
+	if _, err := c.Dropcam.Cameras(ctx); !errors.Is(err, dropcam.ErrAuthFailed) {
+		t.Errorf("cameras without relogin: %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestPasswordZeroed(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+
+	d, err := s.Dropcam().Init(ctx, dropcamtest.Username, dropcamtest.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth := d.Auth.(*dropcam.CookieAuth); auth.Password != "" {
+		t.Errorf("password %q kept after login", auth.Password)
+	}
+
+	// without Credentials the session cannot be renewed
+	s.ExpireSessions()
+	logins := count(s, "login.login")
+	if _, err := d.Cameras(ctx); !errors.Is(err, dropcam.ErrNoCredentials) {
+		t.Errorf("cameras after the session expired: %v, want ErrNoCredentials", err)
+	}
+	if n := count(s, "login.login") - logins; n != 0 {
+		t.Errorf("logged in %d times without a password", n)
+	}
+}
+
+func TestGetCameras(t *testing.T) {
+
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+
+	if len(c.Cam) != 2 || len(c.Shared) != 1 {
+		t.Fatalf("%d owned and %d shared cameras, want 2 and 1", len(c.Cam), len(c.Shared))
+	}
+	tests := []struct {
+		uuid, title string
+		online      bool
+	}{
+		{dropcamtest.FrontDoor, "Front Door", true},
+		{dropcamtest.Garage, "Garage", false},
+	}
+	for _, tt := range tests {
+		o, err := c.ByUUID(tt.uuid)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if o.Title != tt.title || o.IsOnline != tt.online {
+			t.Errorf("%s: %q online %t, want %q online %t", tt.uuid, o.Title, o.IsOnline, tt.title, tt.online)
+		}
+	}
+	if c.Shared[0].Uuid != dropcamtest.Shared {
+		t.Errorf("shared camera %s, want %s", c.Shared[0].Uuid, dropcamtest.Shared)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	front, _ := c.ByUUID(dropcamtest.FrontDoor)
+	garage, _ := c.ByUUID(dropcamtest.Garage)
+
+	img, err := c.GetImage(ctx, front, 320, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 320 {
+		t.Errorf("image %d wide, want 320", cfg.Width)
+	}
+
+	want := []byte("\xff\xd8not quite a jpeg\xff\xd9")
+	s.SetImage(front.Uuid, want)
+	if img, err := c.GetImage(ctx, front, 720, time.Time{}); err != nil || !bytes.Equal(img, want) {
+		t.Errorf("GetImage = %q, %v, want the image set", img, err)
+	}
+
+	if _, err := c.GetImage(ctx, garage, 720, time.Time{}); !errors.Is(err, dropcam.ErrCameraOffline) {
+		t.Errorf("image of an offline camera: %v, want ErrCameraOffline", err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	o, _ := c.ByUUID(dropcamtest.FrontDoor)
+
+	props, err := c.GetProperties(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props.IRLED != dropcam.IRLEDAuto || !props.HD || props.StatusLED {
+		t.Errorf("properties %+v, want those of the fixtures", props)
+	}
+
+	if err := c.SetIRLED(ctx, o, dropcam.IRLEDOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetStatusLED(ctx, o, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.Property(o.Uuid, dropcam.PropIRLED); v != string(dropcam.IRLEDOff) {
+		t.Errorf("server has %s = %v, want %s", dropcam.PropIRLED, v, dropcam.IRLEDOff)
+	}
+	props, err = c.GetProperties(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props.IRLED != dropcam.IRLEDOff || !props.StatusLED {
+		t.Errorf("properties %+v after setting them", props)
+	}
+
+	if err := c.SetIRLED(ctx, o, "sometimes"); err == nil {
+		t.Error("SetIRLED accepted an unknown mode")
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	o, _ := c.ByUUID(dropcamtest.FrontDoor)
+
+	s.Fail("cameras.get_image", http.StatusBadGateway, http.StatusServiceUnavailable)
+	if _, err := c.GetImage(ctx, o, 720, time.Time{}); err != nil {
+		t.Fatalf("GetImage after two failures: %v", err)
+	}
+	if n := count(s, "cameras.get_image"); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+
+	// three retries, then the failure is returned
+	s.Fail("cameras.get_image", 500, 500, 500, 500, 500)
+	before := count(s, "cameras.get_image")
+	var e *dropcam.APIError
+	if _, err := c.GetImage(ctx, o, 720, time.Time{}); !errors.As(err, &e) || e.HTTPStatus != 500 {
+		t.Errorf("GetImage after retries ran out: %v, want an APIError of status 500", err)
+	}
+	if n := count(s, "cameras.get_image") - before; n != 4 {
+		t.Errorf("%d requests, want 4", n)
+	}
+
+	// posts that change state are not retried
+	s.Fail("cameras.update", 500, 500, 500, 500, 500, 500)
+	if _, err := c.UpdateCamera(ctx, o, dropcam.CameraUpdate{Title: dropcam.String("Back Door")}); err == nil {
+		t.Error("UpdateCamera succeeded against a failing server")
+	}
+	if n := count(s, "cameras.update"); n != 1 {
+		t.Errorf("update sent %d times, want once", n)
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	o, _ := c.ByUUID(dropcamtest.FrontDoor)
+
+	s.Fail("cameras.get_image", http.StatusTooManyRequests)
+	if _, err := c.GetImage(ctx, o, 720, time.Time{}); !errors.Is(err, dropcam.ErrRateLimited) {
+		t.Errorf("rate limited GetImage: %v, want ErrRateLimited", err)
+	}
+	if n := count(s, "cameras.get_image"); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+
+	c.Dropcam.Retry.WaitRateLimited = true
+	s.Fail("cameras.get_image", http.StatusTooManyRequests, http.StatusTooManyRequests)
+	if _, err := c.GetImage(ctx, o, 720, time.Time{}); err != nil {
+		t.Errorf("GetImage waiting out the rate limit: %v", err)
+	}
+	if n := count(s, "cameras.get_image"); n != 4 {
+		t.Errorf("%d requests, want 4", n)
+	}
+}
@@ -0,0 +1,629 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dropcamtest provides a fake Dropcam server for testing code built on
+// package dropcam without an account or network access.
+//
+//	srv := dropcamtest.NewServer()
+//	defer srv.Close()
+//	c, err := srv.Cameras(ctx)
+//	img, err := c.GetImage(ctx, &c.Cam[0], 720, time.Time{})
+//
+// The server starts with the canned account in fixtures: two owned cameras,
+// "Front Door" (online, recording) and "Garage" (offline), one shared
+// camera, events and properties, and a subscription for Front Door. It
+// answers logins, the account, camera listings, updates, pairing with
+// SetupToken and removal, snapshots, events, event clips set with SetClip,
+// recorded ranges, properties and stream session tokens and talk-back
+// channels, and keeps the changes made through it. Live streams and
+// talk-back audio are not served.
+package dropcamtest
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rabarar/dropcam"
+)
+
+// The account the fixtures describe
+const (
+	Username = "user@example.com"
+	Password = "password"
+
+	FrontDoor = "0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	Garage    = "a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	Shared    = "5c5c5c5c5c5c5c5c5c5c5c5c5c5c5c5c"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Fixture returns the canned JSON file name, such as "cameras.json"
+func Fixture(name string) []byte {
+	data, err := fixtures.ReadFile("fixtures/" + name)
+	if err != nil {
+		panic("dropcamtest: no fixture " + name)
+	}
+	return data
+}
+
+// The Server type is a fake of the Dropcam API and nexus servers. Its fields
+// may be changed before requests are made.
+type Server struct {
+	*httptest.Server
+
+	// Username and Password are the credentials logins must present
+	Username string
+	Password string
+
+	mu       sync.Mutex
+	owned    []dropcam.Owned
+	shared   []dropcam.Subscribed
+	events   map[string]dropcam.Events
+	props    map[string]map[string]interface{}
+	account  fixtureAccount
+	images   map[string][]byte
+	clips    map[string][]byte
+	recorded map[string][]dropcam.RecordedRange
+	failures map[string][]int
+	requests []string
+	sessions int
+}
+
+// NewServer starts a server holding the fixtures
+func NewServer() *Server {
+
+	s := &Server{
+		Username: Username,
+		Password: Password,
+		images:   make(map[string][]byte),
+		clips:    make(map[string][]byte),
+		recorded: make(map[string][]dropcam.RecordedRange),
+		failures: make(map[string][]int),
+	}
+
+	var cam dropcam.Cam
+	mustDecode("cameras.json", &cam)
+	for _, items := range cam.Items {
+		s.owned = append(s.owned, items.Owned...)
+		s.shared = append(s.shared, items.Subscribed...)
+	}
+	mustDecode("events.json", &s.events)
+	mustDecode("properties.json", &s.props)
+	mustDecode("account.json", &s.account)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// fixtureAccount is the user and subscriptions of the canned account
+type fixtureAccount struct {
+	User          map[string]interface{} `json:"user"`
+	Subscriptions []dropcam.Subscription `json:"subscriptions"`
+}
+
+func mustDecode(name string, v interface{}) {
+	if err := json.Unmarshal(Fixture(name), v); err != nil {
+		panic("dropcamtest: bad fixture " + name + ": " + err.Error())
+	}
+}
+
+// Dropcam returns a client for the server. Init still has to log it in.
+func (s *Server) Dropcam() *dropcam.Dropcam {
+	return &dropcam.Dropcam{BaseURL: s.URL, NexusURL: s.URL}
+}
+
+// Cameras logs a new client in and returns its cameras. The client is given
+// the account's credentials to log in again when its session expires.
+func (s *Server) Cameras(ctx context.Context) (*dropcam.Cameras, error) {
+	d := s.Dropcam()
+	d.Auth = &dropcam.CookieAuth{Credentials: func(context.Context) (string, string, error) {
+		return s.Username, s.Password, nil
+	}}
+	if _, err := d.Init(ctx, s.Username, ""); err != nil {
+		return nil, err
+	}
+	return d.Cameras(ctx)
+}
+
+// AddEvent adds an event to camera uuid
+func (s *Server) AddEvent(uuid string, e dropcam.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[uuid] = append(s.events[uuid], e)
+}
+
+// SetImage sets the snapshot camera uuid returns. Without one it returns a
+// generated gray JPEG of the requested width.
+func (s *Server) SetImage(uuid string, jpeg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[uuid] = jpeg
+}
+
+// SetClip sets the MP4 clip of event id on camera uuid. Events without one
+// have no clip to download.
+func (s *Server) SetClip(uuid string, id int64, mp4 []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clips[uuid+"/"+strconv.FormatInt(id, 10)] = mp4
+}
+
+// SetRecorded sets the stretches of time camera uuid has recordings of; a
+// zero End is still recording. Without any, a camera has recorded its whole
+// retention window up to now.
+func (s *Server) SetRecorded(uuid string, ranges ...dropcam.RecordedRange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded[uuid] = ranges
+}
+
+// SetOnline marks camera uuid online or offline. Offline cameras return empty
+// snapshots, as nexus does.
+func (s *Server) SetOnline(uuid string, online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o := s.camera(uuid); o != nil {
+		o.IsOnline, o.IsConnected = online, online
+	}
+}
+
+// Fail makes the next requests to endpoint, the last element of its path such
+// as "cameras.get_image" or "get_cuepoint", fail with the statuses given, in
+// turn
+func (s *Server) Fail(endpoint string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = append(s.failures[endpoint], statuses...)
+}
+
+// ExpireSessions rejects the sessions logged in so far, so clients have to
+// log in again
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions++
+}
+
+// Property returns the current value of property name of camera uuid
+func (s *Server) Property(uuid, name string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.props[uuid][name]
+}
+
+// Requests returns the requests served so far as "<method> <path>"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// camera returns the camera with uuid; s.mu must be held
+func (s *Server) camera(uuid string) *dropcam.Owned {
+	for i := range s.owned {
+		if s.owned[i].Uuid == uuid {
+			return &s.owned[i]
+		}
+	}
+	for i := range s.shared {
+		if s.shared[i].Uuid == uuid {
+			return &s.shared[i].Owned
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	endpoint := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if fs := s.failures[endpoint]; len(fs) > 0 {
+		s.failures[endpoint] = fs[1:]
+		reply(w, fs[0], nil)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == dropcam.ApiPath+"/login.login" {
+		s.login(w, r)
+		return
+	}
+	if !s.loggedIn(r) {
+		reply(w, http.StatusUnauthorized, nil)
+		return
+	}
+
+	switch {
+	case path == dropcam.ApiPath+"/cameras.get_visible":
+		s.visible(w, r)
+	case path == dropcam.ApiPath+"/cameras.get":
+		s.get(w, r)
+	case path == dropcam.ApiPath+"/cameras.update":
+		s.update(w, r)
+	case path == dropcam.ApiPath+"/cameras.add":
+		s.add(w, r)
+	case path == dropcam.ApiPath+"/cameras.delete":
+		s.remove(w, r)
+	case path == dropcam.ApiPath+"/cameras.get_image":
+		s.image(w, r)
+	case path == dropcam.ApiPath+"/users.get_current":
+		reply(w, http.StatusOK, []map[string]interface{}{s.account.User})
+	case path == dropcam.ApiPath+"/subscriptions.list":
+		reply(w, http.StatusOK, s.account.Subscriptions)
+	case path == dropcam.ApiPath+"/users.get_session_token":
+		reply(w, http.StatusOK, []string{"dropcamtest-stream-" + s.session()})
+	case path == "get_cuepoint":
+		s.cuepoints(w, r)
+	case path == "get_event_clip":
+		s.clip(w, r)
+	case path == "get_available":
+		s.available(w, r)
+	case path == "start_talkback":
+		s.talkback(w, r)
+	case strings.HasPrefix(path, "app/cameras/properties"):
+		s.setProperty(w, r, strings.TrimPrefix(path, "app/cameras/properties"))
+	case strings.HasPrefix(path, "app/cameras/") && !strings.Contains(path[len("app/cameras/"):], "/"):
+		s.info(w, r, path[len("app/cameras/"):])
+	default:
+		reply(w, http.StatusNotFound, nil)
+	}
+}
+
+// reply writes a Dropcam JSON envelope with items
+func reply(w http.ResponseWriter, status int, items interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":             status,
+		"status_description": http.StatusText(status),
+		"status_detail":      "",
+		"items":              items,
+	})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		reply(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	if r.PostFormValue("username") != s.Username || r.PostFormValue("password") != s.Password {
+		reply(w, http.StatusUnauthorized, nil)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "website_2", Value: s.session()})
+	reply(w, http.StatusOK, []map[string]string{{"session_token": s.session()}})
+}
+
+// session is the value of the current session cookie; s.mu must be held
+func (s *Server) session() string {
+	return "dropcamtest-session-" + strconv.Itoa(s.sessions)
+}
+
+func (s *Server) loggedIn(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Cookie"), "website_2="+s.session())
+}
+
+// visible lists the cameras grouped by structure, the shared cameras last,
+// in pages of page_size groups
+func (s *Server) visible(w http.ResponseWriter, r *http.Request) {
+
+	var groups []map[string]interface{}
+	byStructure := make(map[string]map[string]interface{})
+	for _, o := range s.owned {
+		id := fmt.Sprint(o.NestStructureId)
+		g := byStructure[id]
+		if g == nil {
+			g = map[string]interface{}{"owned": []dropcam.Owned{}, "subscribed": []dropcam.Subscribed{}, "nest_structure_id": o.NestStructureId}
+			byStructure[id] = g
+			groups = append(groups, g)
+		}
+		g["owned"] = append(g["owned"].([]dropcam.Owned), o)
+	}
+	if len(s.shared) > 0 {
+		groups = append(groups, map[string]interface{}{"owned": []dropcam.Owned{}, "subscribed": s.shared})
+	}
+
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	size, _ := strconv.Atoi(q.Get("page_size"))
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = len(groups)
+	}
+	pages := (len(groups) + size - 1) / size
+	from, to := (page-1)*size, page*size
+	if from > len(groups) {
+		from = len(groups)
+	}
+	if to > len(groups) {
+		to = len(groups)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":             http.StatusOK,
+		"status_description": "ok",
+		"status_detail":      "",
+		"items":              groups[from:to],
+		"total_pages":        pages,
+	})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	o := s.camera(r.URL.Query().Get("uuid"))
+	if o == nil {
+		reply(w, http.StatusOK, []dropcam.Owned{})
+		return
+	}
+	reply(w, http.StatusOK, []dropcam.Owned{*o})
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request) {
+
+	var fields map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		reply(w, http.StatusBadRequest, nil)
+		return
+	}
+	uuid, _ := fields["uuid"].(string)
+	o := s.camera(uuid)
+	if o == nil {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	for k, v := range fields {
+		switch k {
+		case "title":
+			o.Title, _ = v.(string)
+		case "description":
+			o.Description, _ = v.(string)
+		case "where":
+			o.Where, _ = v.(string)
+		case "location":
+			o.Location = v
+		case "timezone":
+			o.Timezone, _ = v.(string)
+		case "is_public":
+			o.IsPublic, _ = v.(bool)
+		}
+	}
+	reply(w, http.StatusOK, []dropcam.Owned{*o})
+}
+
+// SetupToken is the setup token cameras.add accepts
+const SetupToken = "dropcamtest-setup"
+
+// add pairs a new camera, online, with a uuid derived from its MAC address
+// or serial number
+func (s *Server) add(w http.ResponseWriter, r *http.Request) {
+
+	var fields map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		reply(w, http.StatusBadRequest, nil)
+		return
+	}
+	if fields["setup_token"] != SetupToken {
+		reply(w, http.StatusForbidden, nil)
+		return
+	}
+	id := fields["mac_address"] + fields["serial_number"]
+	uuid := fmt.Sprintf("%032x", []byte(id))
+	if len(uuid) > 32 {
+		uuid = uuid[:32]
+	}
+	if id == "" || s.camera(uuid) != nil {
+		reply(w, http.StatusConflict, nil)
+		return
+	}
+	title := fields["title"]
+	if title == "" {
+		title = "New Camera"
+	}
+	o := dropcam.Owned{
+		Uuid:            uuid,
+		Title:           title,
+		Name:            title,
+		MacAddress:      fields["mac_address"],
+		Timezone:        fields["timezone"],
+		NestStructureId: fields["nest_structure_id"],
+		IsOnline:        true,
+		IsConnected:     true,
+	}
+	s.owned = append(s.owned, o)
+	reply(w, http.StatusOK, []dropcam.Owned{o})
+}
+
+// remove unpairs an owned camera
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+
+	var fields map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		reply(w, http.StatusBadRequest, nil)
+		return
+	}
+	for i := range s.owned {
+		if s.owned[i].Uuid == fields["uuid"] {
+			s.owned = append(s.owned[:i], s.owned[i+1:]...)
+			reply(w, http.StatusOK, nil)
+			return
+		}
+	}
+	reply(w, http.StatusNotFound, nil)
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request) {
+
+	o := s.camera(r.URL.Query().Get("uuid"))
+	if o == nil {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	if !o.IsOnline {
+		return
+	}
+	if img, ok := s.images[o.Uuid]; ok {
+		w.Write(img)
+		return
+	}
+
+	width, _ := strconv.Atoi(r.URL.Query().Get("width"))
+	if width <= 0 {
+		width = 720
+	}
+	img := image.NewGray(image.Rect(0, 0, width, width*9/16))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, nil)
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) clip(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+	mp4, ok := s.clips[q.Get("uuid")+"/"+q.Get("cuepoint_id")]
+	if !ok {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(len(mp4)))
+	w.Write(mp4)
+}
+
+func (s *Server) cuepoints(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+	start, _ := strconv.ParseFloat(q.Get("start_time"), 64)
+	end, _ := strconv.ParseFloat(q.Get("end_time"), 64)
+	events := dropcam.Events{}
+	for _, e := range s.events[q.Get("uuid")] {
+		if e.StartTime >= start && (end == 0 || e.StartTime < end) {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].StartTime < events[j].StartTime })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// available lists the recorded ranges of a camera, as nexus does
+func (s *Server) available(w http.ResponseWriter, r *http.Request) {
+
+	uuid := r.URL.Query().Get("uuid")
+	o := s.camera(uuid)
+	if o == nil {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	ranges, ok := s.recorded[uuid]
+	if !ok {
+		start := time.Now().Add(-time.Duration(o.HoursOfRecordingMax * float64(time.Hour)))
+		ranges = []dropcam.RecordedRange{{Start: start}}
+	}
+	epoch := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	}
+	items := []map[string]float64{}
+	for _, rr := range ranges {
+		items = append(items, map[string]float64{"start_time": epoch(rr.Start), "stop_time": epoch(rr.End)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// talkback opens a talk-back channel to an online owned camera; the audio
+// itself is not served
+func (s *Server) talkback(w http.ResponseWriter, r *http.Request) {
+
+	var fields map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		reply(w, http.StatusBadRequest, nil)
+		return
+	}
+	o := s.camera(fields["uuid"])
+	switch {
+	case o == nil:
+		reply(w, http.StatusNotFound, nil)
+	case !o.IsOnline:
+		reply(w, http.StatusServiceUnavailable, nil)
+	default:
+		reply(w, http.StatusOK, []map[string]interface{}{{
+			"talkback_host":  "talkback.dropcamtest.invalid",
+			"talkback_token": "dropcamtest-talkback-" + s.session(),
+			"sample_rate":    dropcam.DefaultTalkbackRate,
+		}})
+	}
+}
+
+func (s *Server) setProperty(w http.ResponseWriter, r *http.Request, uuid string) {
+
+	var p dropcam.CamProp
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Name == "" {
+		reply(w, http.StatusBadRequest, nil)
+		return
+	}
+	props := s.props[uuid]
+	if s.camera(uuid) == nil || props == nil {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	// booleans are stored as booleans, as the servers report them
+	var v interface{} = p.Value
+	if b, err := strconv.ParseBool(p.Value); err == nil {
+		v = b
+	}
+	props[p.Name] = v
+	if p.Name == dropcam.PropStreamingEnabled {
+		o := s.camera(uuid)
+		o.IsStreamingEnabled, _ = v.(bool)
+		o.IsStreaming = o.IsStreamingEnabled && o.IsOnline
+	}
+	reply(w, http.StatusOK, nil)
+}
+
+func (s *Server) info(w http.ResponseWriter, r *http.Request, uuid string) {
+
+	o := s.camera(uuid)
+	if o == nil {
+		reply(w, http.StatusNotFound, nil)
+		return
+	}
+	info := dropcam.CameraInfo{Owned: *o}
+	names := make([]string, 0, len(s.props[uuid]))
+	for name := range s.props[uuid] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info.Properties = append(info.Properties, dropcam.CameraProperty{UUID: uuid, Name: name, Value: s.props[uuid][name]})
+	}
+	info.IrledState = fmt.Sprint(s.props[uuid][dropcam.PropIRLED])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
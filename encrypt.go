@@ -0,0 +1,182 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	encMagic  = "DCE1"
+	encChunk  = 64 << 10
+	encPrefix = 7
+)
+
+// The EncryptedStorage type wraps a Storage so objects are encrypted with
+// AES-GCM before they reach it and decrypted when read back. Objects are
+// split into 64KiB chunks, each sealed separately, so large clips are
+// streamed rather than held in memory; reordered, truncated or modified
+// objects fail to decrypt.
+type EncryptedStorage struct {
+	Storage Storage
+	aead    cipher.AEAD
+}
+
+// NewEncryptedStorage wraps s using key, which must be 16, 24 or 32 bytes
+// for AES-128, AES-192 or AES-256
+func NewEncryptedStorage(s Storage, key []byte) (*EncryptedStorage, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStorage{Storage: s, aead: aead}, nil
+}
+
+// Put encrypts r and stores it under key
+func (s *EncryptedStorage) Put(key string, r io.Reader) error {
+
+	prefix := make([]byte, encPrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	return s.Storage.Put(key, &encReader{
+		aead:    s.aead,
+		src:     bufio.NewReaderSize(r, encChunk+1),
+		prefix:  prefix,
+		pending: append([]byte(encMagic), prefix...),
+		buf:     make([]byte, encChunk),
+	})
+}
+
+// Get returns a reader that decrypts the object stored under key
+func (s *EncryptedStorage) Get(key string) (io.ReadCloser, error) {
+
+	rc, err := s.Storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	src := bufio.NewReaderSize(rc, encChunk+s.aead.Overhead()+1)
+	header := make([]byte, len(encMagic)+encPrefix)
+	if _, err := io.ReadFull(src, header); err != nil || string(header[:len(encMagic)]) != encMagic {
+		rc.Close()
+		return nil, errors.New("Object is not encrypted")
+	}
+
+	return &decReader{
+		aead:   s.aead,
+		src:    src,
+		closer: rc,
+		prefix: header[len(encMagic):],
+		buf:    make([]byte, encChunk+s.aead.Overhead()),
+	}, nil
+}
+
+// encNonce builds the nonce for chunk n; the last chunk is marked so that
+// truncation at a chunk boundary is detected
+func encNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefix:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encReader struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	prefix  []byte
+	n       uint32
+	pending []byte
+	buf     []byte
+	done    bool
+}
+
+func (e *encReader) Read(p []byte) (int, error) {
+
+	for len(e.pending) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(e.src, e.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := err != nil
+		if !last {
+			if _, err := e.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		e.pending = e.aead.Seal(e.pending[:0], encNonce(e.prefix, e.n, last), e.buf[:n], nil)
+		e.n++
+		e.done = last
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+type decReader struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	closer  io.Closer
+	prefix  []byte
+	n       uint32
+	pending []byte
+	buf     []byte
+	done    bool
+}
+
+func (d *decReader) Read(p []byte) (int, error) {
+
+	for len(d.pending) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.src, d.buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		last := err != nil
+		if !last {
+			if _, err := d.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		d.pending, err = d.aead.Open(d.buf[:0], encNonce(d.prefix, d.n, last), d.buf[:n], nil)
+		if err != nil {
+			return 0, errors.New("Failed to decrypt object: corrupt or wrong key")
+		}
+		d.n++
+		d.done = last
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *decReader) Close() error {
+	return d.closer.Close()
+}
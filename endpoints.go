@@ -0,0 +1,100 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"strings"
+)
+
+// An Option configures a Dropcam built with New
+type Option func(*Dropcam)
+
+// New returns a Dropcam configured by opts, ready for Init
+func New(opts ...Option) *Dropcam {
+	d := &Dropcam{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// WithAPIBase sends the www API requests, login and cameras.*, to base
+// instead of ApiBase
+func WithAPIBase(base string) Option {
+	return func(d *Dropcam) { d.BaseURL = base }
+}
+
+// WithNexusBase sends the nexus requests, events and clips, to base instead
+// of NexusBase
+func WithNexusBase(base string) Option {
+	return func(d *Dropcam) { d.NexusURL = base }
+}
+
+// WithCameraHosts replaces the hosts camera uuid reports with h
+func WithCameraHosts(uuid string, h CameraHosts) Option {
+	return func(d *Dropcam) {
+		if d.CameraHosts == nil {
+			d.CameraHosts = make(map[string]CameraHosts)
+		}
+		d.CameraHosts[uuid] = h
+	}
+}
+
+// The CameraHosts type names the servers of one camera. Migrated Nest
+// accounts serve their cameras' events and clips from regional nexus hosts,
+// which cameras.get reports as download_host, and their live streams from
+// live_stream_host. Empty fields keep what the camera reports.
+type CameraHosts struct {
+	Download   string
+	LiveStream string
+}
+
+// apiBase is where the www API requests go
+func (d *Dropcam) apiBase() string {
+	if d.BaseURL != "" {
+		return strings.TrimRight(d.BaseURL, "/")
+	}
+	return ApiBase
+}
+
+// nexusBase is where the nexus requests go
+func (d *Dropcam) nexusBase() string {
+	if d.NexusURL != "" {
+		return strings.TrimRight(d.NexusURL, "/")
+	}
+	return NexusBase
+}
+
+// liveStreamHost returns the stream host of camera o
+func (d *Dropcam) liveStreamHost(o *Owned) string {
+	if h := d.CameraHosts[o.Uuid].LiveStream; h != "" {
+		return h
+	}
+	return o.LiveStreamHost
+}
+
+// nexusPath returns path, one of the nexus paths, on the download host of
+// camera o when it has one. Overridden nexus bases, such as a fake server,
+// are left alone, unless the camera's host is overridden as well.
+func (d *Dropcam) nexusPath(o *Owned, path string) string {
+
+	host := d.CameraHosts[o.Uuid].Download
+	if host == "" && d.NexusURL == "" {
+		host = o.DownloadHost
+	}
+	if host == "" {
+		return path
+	}
+	u, err := d.endpoint(path)
+	if err != nil {
+		return path
+	}
+	if h, err := d.endpoint(host); err == nil && h.Scheme != "" && h.Host != "" {
+		u.Scheme, u.Host = h.Scheme, h.Host
+	} else {
+		u.Host = host
+	}
+	return u.String()
+}
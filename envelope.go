@@ -0,0 +1,73 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// envelopeStatus is the outcome every www API reply reports
+type envelopeStatus struct {
+	Status            int    `json:"status"`
+	StatusDescription string `json:"status_description"`
+	StatusDetail      string `json:"status_detail"`
+}
+
+// ok reports whether the reply says the request succeeded; replies without
+// a status count as successful
+func (s envelopeStatus) ok() bool {
+	return s.Status == 0 || s.Status == http.StatusOK
+}
+
+// apiError builds the error of op refused with resp and this status
+func (s envelopeStatus) apiError(op string, resp *http.Response) *APIError {
+	e := &APIError{
+		Op:          op,
+		HTTPStatus:  resp.StatusCode,
+		Status:      s.Status,
+		Description: s.StatusDescription,
+		Detail:      s.StatusDetail,
+		RetryAfter:  retryAfter(resp),
+	}
+	e.Err = classifyStatus(e.HTTPStatus, e.Status, e.Description+" "+e.Detail)
+	return e
+}
+
+// envelope is a www API reply whose payload is items of type T
+type envelope[T any] struct {
+	envelopeStatus
+	Items T `json:"items"`
+}
+
+// decodeEnvelope reads and closes resp, the reply to op, parsing it once. It
+// returns the items of a reply that succeeded, and for one that did not an
+// APIError with the status, description and detail the servers gave.
+func decodeEnvelope[T any](op string, resp *http.Response) (T, error) {
+
+	defer resp.Body.Close()
+
+	var zero T
+	buf, err := readPooled(resp)
+	defer releaseBody(buf)
+	if err != nil {
+		return zero, fmt.Errorf("%s Failed to read Reply: %w", op, err)
+	}
+	body := buf.Bytes()
+
+	var reply envelope[T]
+	if err := json.Unmarshal(body, &reply); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// the items of an error reply need not have the usual shape
+			return zero, newAPIError(op, resp, body)
+		}
+		return zero, fmt.Errorf("%s Failed: Can't unmarshal Reply: %s", op, err)
+	}
+	if resp.StatusCode != http.StatusOK || !reply.ok() {
+		return zero, reply.apiError(op, resp)
+	}
+	return reply.Items, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors an APIError can wrap, for use with errors.Is
+var (
+	ErrAuthFailed    = errors.New("Authentication Failed")
+	ErrCameraOffline = errors.New("Camera Offline")
+	ErrNotFound      = errors.New("Not Found")
+	ErrRateLimited   = errors.New("Rate Limited")
+)
+
+// The APIError type is a request the Dropcam servers refused. HTTPStatus is
+// the response's status code and Status, Description and Detail come from the
+// status, status_description and status_detail fields of its body, when present.
+// RetryAfter is the wait the response's Retry-After header asked for, mostly
+// set on rate limited requests.
+type APIError struct {
+	Op          string
+	HTTPStatus  int
+	Status      int
+	Description string
+	Detail      string
+	RetryAfter  time.Duration
+
+	// Err is one of the Err* values above, or nil if the failure did not match any
+	Err error
+}
+
+func (e *APIError) Error() string {
+
+	msg := fmt.Sprintf("%s Failed: HTTP %d", e.Op, e.HTTPStatus)
+	if e.Status != 0 && e.Status != e.HTTPStatus {
+		msg += fmt.Sprintf(", status %d", e.Status)
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error the failure was classified as
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// newAPIError builds the error for a refused request from its response and body
+func newAPIError(op string, resp *http.Response, body []byte) *APIError {
+
+	var status envelopeStatus
+	json.Unmarshal(body, &status)
+	return status.apiError(op, resp)
+}
+
+// RateLimitWait returns how long the servers asked to wait when err is a rate
+// limited request, and false for any other error
+func RateLimitWait(err error) (time.Duration, bool) {
+	var e *APIError
+	if !errors.As(err, &e) || !errors.Is(e, ErrRateLimited) {
+		return 0, false
+	}
+	return e.RetryAfter, true
+}
+
+// classifyStatus maps an HTTP status, Dropcam status and description to a sentinel error
+func classifyStatus(httpStatus, status int, text string) error {
+
+	for _, code := range []int{status, httpStatus} {
+		switch code {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrAuthFailed
+		case http.StatusNotFound:
+			return ErrNotFound
+		case http.StatusTooManyRequests:
+			return ErrRateLimited
+		}
+	}
+	if strings.Contains(strings.ToLower(text), "offline") {
+		return ErrCameraOffline
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"time"
+)
+
+// MaxEventGIFFrames bounds the frames of one event GIF
+const MaxEventGIFFrames = 100
+
+// The GIF method pulls frames recorded during the event, evenly spread from
+// its start to its end, width wide, and animates them into a GIF playing fps
+// frames a second, to show what happened in a notification or chat. Events
+// still in progress, or too short to spread the frames over, span four
+// seconds from their start. Frames that cannot be had are left out. The
+// camera, that of e.Camera among c, needs cloud recording covering the
+// event. Its requests are low priority for Dropcam.Quota.
+func (e *Event) GIF(ctx context.Context, c *Cameras, frames, fps, width int) ([]byte, error) {
+
+	if frames <= 0 || frames > MaxEventGIFFrames {
+		return nil, fmt.Errorf("Event GIF needs 1 to %d frames", MaxEventGIFFrames)
+	}
+	if fps <= 0 || fps > 100 {
+		return nil, errors.New("Event GIF needs 1 to 100 frames a second")
+	}
+	o := c.findCamera(e.Camera)
+	if o == nil {
+		return nil, fmt.Errorf("Event GIF Failed: event %d: unknown camera %q", e.Id, e.Camera)
+	}
+	if err := c.Dropcam.requireCVR("Event GIF", o); err != nil {
+		return nil, err
+	}
+
+	start, end := e.Start(), e.End()
+	if !end.After(start) {
+		end = start.Add(2 * eventSnapshotOffset)
+	}
+	step := time.Duration(0)
+	if frames > 1 {
+		step = end.Sub(start) / time.Duration(frames-1)
+	}
+
+	ctx = WithLowPriority(ctx)
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		t := start.Add(time.Duration(i) * step)
+		jpg, err := c.getImage(ctx, o, width, t)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			Dbg("event gif: %s at %s: %s\n", o.Title, t.Format(time.RFC3339), err)
+			continue
+		}
+		img, err := jpeg.Decode(bytes.NewReader(jpg))
+		if err != nil {
+			Dbg("event gif: %s at %s: %s\n", o.Title, t.Format(time.RFC3339), err)
+			continue
+		}
+		frame := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(frame, img.Bounds(), img, img.Bounds().Min)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 100/fps)
+	}
+	if len(anim.Image) == 0 {
+		return nil, fmt.Errorf("Event GIF Failed: event %d: no frames recorded", e.Id)
+	}
+
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, anim); err != nil {
+		return nil, fmt.Errorf("Event GIF Failed: %w", err)
+	}
+	return b.Bytes(), nil
+}
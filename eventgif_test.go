@@ -0,0 +1,66 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"bytes"
+	"context"
+	"image/gif"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestEventGIF(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+
+	start := time.Now().Add(-time.Hour)
+	e := dropcam.Event{
+		Id:        42,
+		Camera:    dropcamtest.FrontDoor,
+		StartTime: float64(start.Unix()),
+		EndTime:   float64(start.Add(9 * time.Second).Unix()),
+	}
+	data, err := e.GIF(ctx, c, 4, 5, 160)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 4 {
+		t.Fatalf("%d frames, want 4", len(anim.Image))
+	}
+	if w := anim.Image[0].Bounds().Dx(); w != 160 {
+		t.Errorf("frames %d wide, want 160", w)
+	}
+	if anim.Delay[0] != 20 {
+		t.Errorf("frames last %d/100 s, want 20 at 5 fps", anim.Delay[0])
+	}
+	if n := count(s, "cameras.get_image"); n != 4 {
+		t.Errorf("%d images requested, want 4", n)
+	}
+
+	// frames that cannot be had are left out
+	s.Fail("cameras.get_image", 404)
+	if data, err = e.GIF(ctx, c, 3, 5, 160); err != nil {
+		t.Fatal(err)
+	}
+	if anim, err = gif.DecodeAll(bytes.NewReader(data)); err != nil || len(anim.Image) != 2 {
+		t.Errorf("GIF of 3 frames with one missing: %v, want 2 frames", err)
+	}
+
+	e.Camera = "nonesuch"
+	if _, err := e.GIF(ctx, c, 4, 5, 160); err == nil {
+		t.Error("GIF of an event of an unknown camera")
+	}
+}
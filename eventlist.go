@@ -0,0 +1,150 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Limits of the windows ListEvents requests from the servers
+const (
+	eventWindowMin  = time.Hour
+	eventWindowMax  = 30 * 24 * time.Hour
+	eventLookback   = 30 * 24 * time.Hour
+	defaultPageSize = 50
+)
+
+// ErrBadCursor is returned by ListEvents for a cursor it did not issue
+var ErrBadCursor = errors.New("Invalid Event Cursor")
+
+// eventCursor is the position ListEvents continues from, encoded opaquely for
+// clients. Events before (Before, Id) in (start time, id) order are next; Id is
+// zero when every event starting at Before has been returned. Window is the
+// length of the next request and Floor the oldest time to look at.
+type eventCursor struct {
+	Camera string  `json:"c"`
+	Before float64 `json:"b"`
+	Id     int64   `json:"i,omitempty"`
+	Window int64   `json:"w"`
+	Floor  float64 `json:"f"`
+}
+
+func (ec *eventCursor) encode() string {
+	b, _ := json.Marshal(ec)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEventCursor(s string) (*eventCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	ec := new(eventCursor)
+	if err := json.Unmarshal(b, ec); err != nil || ec.Window <= 0 {
+		return nil, ErrBadCursor
+	}
+	return ec, nil
+}
+
+// precedes reports whether e comes after the cursor position, newest first
+func (ec *eventCursor) precedes(e *Event) bool {
+	return e.StartTime < ec.Before || (e.StartTime == ec.Before && e.Id < ec.Id)
+}
+
+// The ListEvents method pages through the events of camera o, newest first.
+// An empty cursor starts at the present; the returned next cursor continues
+// where the page ended and is empty once the camera's recording history, or
+// 30 days when the camera does not report it, is exhausted. Cursors are
+// opaque: callers pass them back unchanged, which lets a web frontend scroll
+// through history without knowing how it is fetched. At most limit events
+// are returned, 50 when limit is zero or less.
+func (c *Cameras) ListEvents(ctx context.Context, o *Owned, cursor string, limit int) (events Events, next string, err error) {
+
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	var ec *eventCursor
+	if cursor == "" {
+		now := time.Now()
+		lookback := eventLookback
+		if o.HoursOfRecordingMax > 0 {
+			lookback = time.Duration(o.HoursOfRecordingMax * float64(time.Hour))
+		}
+		ec = &eventCursor{
+			Camera: o.Uuid,
+			Before: float64(now.UnixNano()) / 1e9,
+			Window: int64(24 * time.Hour / time.Second),
+			Floor:  float64(now.Add(-lookback).UnixNano()) / 1e9,
+		}
+	} else if ec, err = decodeEventCursor(cursor); err != nil {
+		return nil, "", err
+	} else if ec.Camera != o.Uuid {
+		return nil, "", ErrBadCursor
+	}
+
+	for len(events) < limit && ec.Before > ec.Floor {
+
+		window := time.Duration(ec.Window) * time.Second
+		before := epochTime(ec.Before)
+		from := before.Add(-window)
+		if floor := epochTime(ec.Floor); from.Before(floor) {
+			from = floor
+		}
+		start := float64(from.UnixNano()) / 1e9
+
+		// the servers take whole seconds, so ask for a little more than the window
+		got, err := c.GetEvents(ctx, o, from.Add(-time.Second), before.Add(time.Second))
+		if err != nil {
+			if len(events) > 0 {
+				// return what there is; the cursor retries the window
+				break
+			}
+			return nil, "", err
+		}
+
+		var page Events
+		for i := range got {
+			if got[i].StartTime >= start && ec.precedes(&got[i]) {
+				page = append(page, got[i])
+			}
+		}
+		sort.Slice(page, func(i, j int) bool {
+			if page[i].StartTime != page[j].StartTime {
+				return page[i].StartTime > page[j].StartTime
+			}
+			return page[i].Id > page[j].Id
+		})
+
+		n := limit - len(events)
+		if len(page) > n {
+			events = append(events, page[:n]...)
+			last := events[len(events)-1]
+			ec.Before, ec.Id = last.StartTime, last.Id
+			// much busier windows are split for the next request
+			if len(page) > 4*n && window/2 >= eventWindowMin {
+				ec.Window /= 2
+			}
+			break
+		}
+		events = append(events, page...)
+		ec.Before, ec.Id = start, 0
+
+		// quiet windows are widened so sparse history takes fewer requests
+		if len(page) < n/2 && window*2 <= eventWindowMax {
+			ec.Window *= 2
+		}
+	}
+
+	if ec.Before > ec.Floor {
+		next = ec.encode()
+	}
+	return events, next, nil
+}
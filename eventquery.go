@@ -0,0 +1,128 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types get_cuepoint reports
+const (
+	EventMotion = "motion"
+	EventSound  = "sound"
+	EventPerson = "person"
+)
+
+// The EventQuery type selects the events QueryEvents returns. Events start
+// between Start and End, End being now when zero. Types limits them to those
+// types, ZoneId to those in that activity zone, Important to those marked
+// important and MinDuration to those lasting at least that long; events still
+// in progress have no duration yet and are kept. Of the events selected,
+// oldest first, Offset are skipped and at most Limit returned, every one when
+// zero.
+//
+// The filters are passed to get_cuepoint so the servers send less, and are
+// applied again to the reply, so servers that ignore some of them still give
+// the same result.
+type EventQuery struct {
+	Start       time.Time
+	End         time.Time
+	Types       []string
+	ZoneId      int64
+	Important   bool
+	MinDuration time.Duration
+	Limit       int
+	Offset      int
+}
+
+// values returns the get_cuepoint parameters asking for q from camera uuid
+func (q *EventQuery) values(uuid string) url.Values {
+
+	end := q.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	v := url.Values{}
+	v.Set("uuid", uuid)
+	v.Add("start_time", strconv.FormatInt(q.Start.Unix(), 10))
+	v.Add("end_time", strconv.FormatInt(end.Unix(), 10))
+	v.Add("human", "True")
+	if len(q.Types) > 0 {
+		v.Add("types", strings.Join(q.Types, ","))
+	}
+	if q.ZoneId != 0 {
+		v.Add("zone_id", strconv.FormatInt(q.ZoneId, 10))
+	}
+	if q.Important {
+		v.Add("is_important", "true")
+	}
+	if q.MinDuration > 0 {
+		v.Add("min_duration", strconv.FormatFloat(q.MinDuration.Seconds(), 'f', -1, 64))
+	}
+	// the offset is applied here, so servers honouring it cannot skip twice
+	if q.Limit > 0 {
+		v.Add("limit", strconv.Itoa(q.Offset+q.Limit))
+	}
+	return v
+}
+
+// matches reports whether e passes the filters of q
+func (q *EventQuery) matches(e *Event) bool {
+
+	if len(q.Types) > 0 {
+		found := false
+		for _, t := range q.Types {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.ZoneId != 0 {
+		found := false
+		for _, id := range e.ZoneIds {
+			found = found || id == q.ZoneId
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Important && !e.IsImportant {
+		return false
+	}
+	if q.MinDuration > 0 && e.EndTime != 0 && e.Duration() < q.MinDuration {
+		return false
+	}
+	return true
+}
+
+// apply filters, skips and limits events as q asks
+func (q *EventQuery) apply(events Events) Events {
+
+	kept := events[:0]
+	for i := range events {
+		if q.matches(&events[i]) {
+			kept = append(kept, events[i])
+		}
+	}
+	if q.Offset == 0 && q.Limit == 0 {
+		return kept
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].StartTime < kept[j].StartTime })
+	if q.Offset > 0 {
+		if q.Offset >= len(kept) {
+			return kept[:0]
+		}
+		kept = kept[q.Offset:]
+	}
+	if q.Limit > 0 && len(kept) > q.Limit {
+		kept = kept[:q.Limit]
+	}
+	return kept
+}
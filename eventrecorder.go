@@ -0,0 +1,179 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// Defaults of EventRecorder
+const (
+	DefaultBurstSpacing = 2 * time.Second
+	DefaultBurstAfter   = 10 * time.Second
+)
+
+// EventBurstDir is the folder EventRecorder stores the frames of event e of
+// camera uuid in, "<uuid>/events/<yyyymmdd-hhmmss>-<id>" in UTC
+func EventBurstDir(uuid string, e Event) string {
+	return fmt.Sprintf("%s/events/%s-%d", uuid, e.Start().UTC().Format("20060102-150405"), e.Id)
+}
+
+// The BurstResult type reports the frames an EventRecorder captured around
+// one event. Saved lists their keys in time order; Missing counts the times
+// no frame could be had for, such as before the event without cloud
+// recording.
+type BurstResult struct {
+	Event   Event
+	Dir     string
+	Saved   []string
+	Missing int
+}
+
+// The EventRecorder type captures a burst of snapshots around every event of
+// Camera, so each motion event is documented by more than its single
+// thumbnail. It watches the camera with Watch and, for an event of one of
+// Types (every type when empty), captures a frame every Spacing from Before
+// ahead of the event's start to After past it, DefaultBurstSpacing and
+// DefaultBurstAfter when zero. Frames are stored in Storage under
+// EventBurstDir, named by their offset from the start, e.g. "-002s.jpg" and
+// "+004s.jpg", or when Path is set as that PathTemplate names them, e.g.
+// "{camera}/{yyyymmdd}/{event}/{offset}.jpg".
+//
+// Times already past are fetched from the recording, which needs cloud
+// recording and is skipped without it; later ones are captured live as their
+// time comes. OnBurst, when set, is called with each finished burst.
+type EventRecorder struct {
+	Cameras *Cameras
+	Camera  *Owned
+	Storage Storage
+	Path    PathTemplate
+	Types   []string
+	Before  time.Duration
+	After   time.Duration
+	Spacing time.Duration
+	Width   int
+	Watch   WatchOptions
+	OnBurst func(BurstResult)
+
+	cancel  context.CancelFunc
+	watcher *Watcher
+	wg      sync.WaitGroup
+}
+
+// Start watches the camera and records bursts in the background
+func (er *EventRecorder) Start() error {
+
+	if er.Cameras == nil || er.Camera == nil || er.Storage == nil {
+		return errors.New("EventRecorder needs Cameras, a Camera and Storage")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	er.cancel = cancel
+	er.watcher = er.Cameras.Watch(ctx, er.Camera, er.Watch)
+
+	er.wg.Add(1)
+	go func() {
+		defer er.wg.Done()
+		for e := range er.watcher.C {
+			if !er.wants(e) {
+				continue
+			}
+			er.wg.Add(1)
+			go func(e Event) {
+				defer er.wg.Done()
+				res, err := er.Record(ctx, e)
+				if err != nil && ctx.Err() == nil {
+					warnf("event recorder: %s: event %d: %s\n", er.Camera.Title, e.Id, err)
+				}
+				if res != nil && er.OnBurst != nil {
+					er.OnBurst(*res)
+				}
+			}(e)
+		}
+	}()
+	return nil
+}
+
+// Stop ends watching and waits for the bursts in progress, which are cut short
+func (er *EventRecorder) Stop() {
+	er.cancel()
+	er.watcher.Stop()
+	er.wg.Wait()
+}
+
+// wants reports whether events of e's type are recorded
+func (er *EventRecorder) wants(e Event) bool {
+	if len(er.Types) == 0 {
+		return true
+	}
+	for _, t := range er.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Record captures the burst of event e now, waiting for the frames after it
+// that are still to come
+func (er *EventRecorder) Record(ctx context.Context, e Event) (*BurstResult, error) {
+
+	spacing := er.Spacing
+	if spacing <= 0 {
+		spacing = DefaultBurstSpacing
+	}
+	after := er.After
+	if after <= 0 {
+		after = DefaultBurstAfter
+	}
+	width := er.Width
+	if width <= 0 {
+		width = 720
+	}
+	cvr := er.Cameras.Dropcam.hasCVR(er.Camera)
+
+	start := e.Start()
+	res := &BurstResult{Event: e, Dir: EventBurstDir(er.Camera.Uuid, e)}
+	if er.Path != "" {
+		res.Dir = path.Dir(er.Path.Expand(er.Camera, start, PathFields{Event: e.Id}))
+	}
+	for off := -er.Before; off <= after; off += spacing {
+		at := start.Add(off)
+		if wait := time.Until(at); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return res, ctx.Err()
+			case <-time.After(wait):
+			}
+		} else if isHistorical(at) && !cvr {
+			res.Missing++
+			continue
+		}
+
+		img, err := er.Cameras.getImage(ctx, er.Camera, width, at)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if err != nil {
+			Dbg("event recorder: %s at %s: %s\n", er.Camera.Title, at.Format(time.RFC3339), err)
+			res.Missing++
+			continue
+		}
+		key := fmt.Sprintf("%s/%+04ds.jpg", res.Dir, int(off/time.Second))
+		if er.Path != "" {
+			key = er.Path.Expand(er.Camera, at, PathFields{Event: e.Id, Offset: off})
+		}
+		if err := er.Storage.Put(key, bytes.NewReader(img)); err != nil {
+			return res, fmt.Errorf("Failed to store %s: %w", key, err)
+		}
+		res.Saved = append(res.Saved, key)
+	}
+	return res, nil
+}
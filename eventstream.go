@@ -0,0 +1,165 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Messages of the event stream
+const (
+	StreamCuepoint = "cuepoint"
+	StreamStatus   = "status"
+)
+
+// EventStreamIdle is how long an event stream may stay silent, heartbeats
+// included, before it is taken for dead and reconnected
+const EventStreamIdle = 2 * time.Minute
+
+// streamStatus is the data of a status message
+type streamStatus struct {
+	IsOnline bool `json:"is_online"`
+}
+
+// The StreamEvents method subscribes to the event stream of camera o and
+// calls fn with the kind and data of every message, StreamCuepoint with an
+// Event as JSON or StreamStatus with the camera's is_online, as the servers
+// push them. It returns when ctx is done, fn fails or the stream ends, which
+// it does after EventStreamIdle without a message or heartbeat. The stream
+// is sent as server-sent events from Dropcam.EventStreamPath; servers
+// without it fail with ErrNotFound. Watch with WatchOptions.Push reconnects
+// it for you.
+func (c *Cameras) StreamEvents(ctx context.Context, o *Owned, fn func(kind string, data []byte) error) error {
+
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	hdr := http.Header{"Accept": {"text/event-stream"}}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	response, err := c.Dropcam.getRequestHeader(ctx, c.Dropcam.nexusPath(o, c.Dropcam.EventStreamPath), v, hdr)
+	if err != nil {
+		return fmt.Errorf("Event Stream Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64<<10))
+		return newAPIError("Event Stream", response, body)
+	}
+	Dbg("event stream: %s connected\n", o.Title)
+
+	// a stream gone quiet is closed so the read below returns
+	idle := time.AfterFunc(EventStreamIdle, cancel)
+	defer idle.Stop()
+
+	sc := bufio.NewScanner(response.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	var kind string
+	var data bytes.Buffer
+	for sc.Scan() {
+		idle.Reset(EventStreamIdle)
+		line := sc.Bytes()
+		switch {
+		case len(line) == 0:
+			// a blank line ends the message
+			if data.Len() > 0 {
+				if kind == "" {
+					kind = StreamCuepoint
+				}
+				if err := fn(kind, bytes.TrimSuffix(data.Bytes(), []byte("\n"))); err != nil {
+					return err
+				}
+			}
+			kind = ""
+			data.Reset()
+		case line[0] == ':':
+			// heartbeat
+		case bytes.HasPrefix(line, []byte("event:")):
+			kind = string(bytes.TrimSpace(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			data.Write(bytes.TrimPrefix(line[len("data:"):], []byte(" ")))
+			data.WriteByte('\n')
+		}
+	}
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("Event Stream Failed: %w", err)
+	}
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("Event stream of %s ended", o.Title)
+}
+
+// push follows the event stream of camera o, delivering its events, until
+// ctx is done, when it returns true, or until the servers turn out to have
+// no stream, when it returns false so the Watcher polls instead
+func (w *Watcher) push(ctx context.Context, c *Cameras, o *Owned, ws *watchState) bool {
+
+	var ps pollState
+	attempt := 0
+	for {
+		// fetch what was published while not connected; a failure is
+		// made up for by the next catch-up, which starts at the same place
+		w.poll(ctx, c, o, ws)
+
+		received := false
+		err := c.StreamEvents(ctx, o, func(kind string, data []byte) error {
+			received = true
+			switch kind {
+			case StreamCuepoint:
+				var e Event
+				if err := json.Unmarshal(data, &e); err != nil {
+					warnf("watch: %s: bad cuepoint: %s\n", o.Title, err)
+					return nil
+				}
+				e.Camera, e.loc = o.Uuid, o.Zone()
+				if !ws.deliver(ctx, Events{e}) {
+					return ctx.Err()
+				}
+			case StreamStatus:
+				var st streamStatus
+				if err := json.Unmarshal(data, &st); err != nil {
+					warnf("watch: %s: bad status: %s\n", o.Title, err)
+					return nil
+				}
+				if ws.opts.OnStatus != nil {
+					ws.opts.OnStatus(st.IsOnline)
+				}
+			}
+			ws.advance(time.Now())
+			return nil
+		})
+		if ctx.Err() != nil {
+			return true
+		}
+		if errors.Is(err, ErrNotFound) {
+			warnf("watch: %s: no event stream, polling instead\n", o.Title)
+			return false
+		}
+		w.setErr(err)
+		Dbg("watch: %s: %s, reconnecting\n", o.Title, err)
+
+		if received {
+			attempt, ps = 0, pollState{}
+		}
+		wait := c.Dropcam.Retry.backoff(attempt)
+		attempt++
+		if b := ws.opts.Backoff.next(&ps, wait, err); b > 0 {
+			wait = b
+		}
+		if sleepCtx(ctx, wait) != nil {
+			return true
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+)
+
+// The EXIF type makes snapshots self-describing once moved out of their
+// folders. Set as Dropcam.EXIF, it writes into every JPEG snapshot its
+// capture time as DateTimeOriginal, the camera title as ImageDescription,
+// the camera uuid as BodySerialNumber, and, when known, the camera position
+// as GPS coordinates. Positions gives the position of cameras by uuid, for
+// cameras whose location is only a name; cameras.get locations with
+// coordinates are used otherwise. Artist and Copyright are written when set.
+//
+// Any EXIF block the servers sent is replaced.
+type EXIF struct {
+	Artist    string
+	Copyright string
+	Positions map[string]Position
+}
+
+// The Position type is a place in decimal degrees, north and east positive
+type Position struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// The Position method returns the coordinates of camera o's location, when
+// cameras.get reports them
+func (o *Owned) Position() (Position, bool) {
+
+	m, ok := o.Location.(map[string]interface{})
+	if !ok {
+		return Position{}, false
+	}
+	coord := func(names ...string) (float64, bool) {
+		for _, n := range names {
+			if f, ok := m[n].(float64); ok {
+				return f, true
+			}
+		}
+		return 0, false
+	}
+	lat, ok1 := coord("latitude", "lat")
+	lon, ok2 := coord("longitude", "lng", "lon")
+	if !ok1 || !ok2 {
+		return Position{}, false
+	}
+	return Position{Latitude: lat, Longitude: lon}, true
+}
+
+// EXIF tags written
+const (
+	exifImageDescription = 0x010e
+	exifMake             = 0x010f
+	exifDateTime         = 0x0132
+	exifArtist           = 0x013b
+	exifCopyright        = 0x8298
+	exifIFDPointer       = 0x8769
+	exifGPSPointer       = 0x8825
+	exifDateTimeOriginal = 0x9003
+	exifOffsetOriginal   = 0x9011
+	exifBodySerialNumber = 0xa431
+	gpsVersionID         = 0x0000
+	gpsLatitudeRef       = 0x0001
+	gpsLatitude          = 0x0002
+	gpsLongitudeRef      = 0x0003
+	gpsLongitude         = 0x0004
+)
+
+// EXIF field types
+const (
+	exifByte     = 1
+	exifASCII    = 2
+	exifLong     = 4
+	exifRational = 5
+)
+
+// exifEntry is one field of an IFD, data holding its value big-endian
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func asciiEntry(tag uint16, s string) exifEntry {
+	data := append([]byte(s), 0)
+	return exifEntry{tag: tag, typ: exifASCII, count: uint32(len(data)), data: data}
+}
+
+func longEntry(tag uint16, v uint32) exifEntry {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, v)
+	return exifEntry{tag: tag, typ: exifLong, count: 1, data: data}
+}
+
+// degreesEntry writes deg as degrees, minutes and seconds rationals
+func degreesEntry(tag uint16, deg float64) exifEntry {
+	deg = math.Abs(deg)
+	d := math.Floor(deg)
+	m := math.Floor((deg - d) * 60)
+	s := math.Round(((deg-d)*60-m)*60*10000) / 10000
+	data := make([]byte, 24)
+	for i, r := range [][2]uint32{{uint32(d), 1}, {uint32(m), 1}, {uint32(s * 10000), 10000}} {
+		binary.BigEndian.PutUint32(data[i*8:], r[0])
+		binary.BigEndian.PutUint32(data[i*8+4:], r[1])
+	}
+	return exifEntry{tag: tag, typ: exifRational, count: 3, data: data}
+}
+
+// ifdSize is the number of bytes entries take as an IFD with its values
+func ifdSize(entries []exifEntry) int {
+	n := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			n += (len(e.data) + 1) &^ 1
+		}
+	}
+	return n
+}
+
+// encodeIFD writes entries as an IFD starting at offset of the TIFF data,
+// with no next IFD
+func encodeIFD(entries []exifEntry, offset int) []byte {
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	var ifd, values bytes.Buffer
+	binary.Write(&ifd, binary.BigEndian, uint16(len(entries)))
+	valueOffset := offset + 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		binary.Write(&ifd, binary.BigEndian, e.tag)
+		binary.Write(&ifd, binary.BigEndian, e.typ)
+		binary.Write(&ifd, binary.BigEndian, e.count)
+		if len(e.data) <= 4 {
+			field := make([]byte, 4)
+			copy(field, e.data)
+			ifd.Write(field)
+			continue
+		}
+		binary.Write(&ifd, binary.BigEndian, uint32(valueOffset+values.Len()))
+		values.Write(e.data)
+		if values.Len()%2 == 1 {
+			values.WriteByte(0)
+		}
+	}
+	binary.Write(&ifd, binary.BigEndian, uint32(0))
+	return append(ifd.Bytes(), values.Bytes()...)
+}
+
+// segment returns the APP1 segment describing a snapshot of camera o taken
+// at taken
+func (x *EXIF) segment(o *Owned, taken time.Time) []byte {
+
+	taken = o.In(taken)
+	stamp := taken.Format("2006:01:02 15:04:05")
+
+	ifd0 := []exifEntry{
+		asciiEntry(exifMake, "Dropcam"),
+		asciiEntry(exifDateTime, stamp),
+		longEntry(exifIFDPointer, 0),
+	}
+	if o.Title != "" {
+		ifd0 = append(ifd0, asciiEntry(exifImageDescription, o.Title))
+	}
+	if x.Artist != "" {
+		ifd0 = append(ifd0, asciiEntry(exifArtist, x.Artist))
+	}
+	if x.Copyright != "" {
+		ifd0 = append(ifd0, asciiEntry(exifCopyright, x.Copyright))
+	}
+	exif := []exifEntry{
+		asciiEntry(exifDateTimeOriginal, stamp),
+		asciiEntry(exifOffsetOriginal, taken.Format("-07:00")),
+		asciiEntry(exifBodySerialNumber, o.Uuid),
+	}
+
+	var gps []exifEntry
+	pos, ok := x.Positions[o.Uuid]
+	if !ok {
+		pos, ok = o.Position()
+	}
+	if ok {
+		latRef, lonRef := "N", "E"
+		if pos.Latitude < 0 {
+			latRef = "S"
+		}
+		if pos.Longitude < 0 {
+			lonRef = "W"
+		}
+		gps = []exifEntry{
+			{tag: gpsVersionID, typ: exifByte, count: 4, data: []byte{2, 3, 0, 0}},
+			asciiEntry(gpsLatitudeRef, latRef),
+			degreesEntry(gpsLatitude, pos.Latitude),
+			asciiEntry(gpsLongitudeRef, lonRef),
+			degreesEntry(gpsLongitude, pos.Longitude),
+		}
+		ifd0 = append(ifd0, longEntry(exifGPSPointer, 0))
+	}
+
+	// the pointers are inline, so the sizes do not depend on their values
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case exifIFDPointer:
+			binary.BigEndian.PutUint32(ifd0[i].data, uint32(exifOffset))
+		case exifGPSPointer:
+			binary.BigEndian.PutUint32(ifd0[i].data, uint32(gpsOffset))
+		}
+	}
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = append(tiff, encodeIFD(ifd0, 8)...)
+	tiff = append(tiff, encodeIFD(exif, exifOffset)...)
+	if gps != nil {
+		tiff = append(tiff, encodeIFD(gps, gpsOffset)...)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// apply returns the JPEG jpg with an EXIF block describing a snapshot of
+// camera o taken at taken, in place of any it had. Anything that is not a
+// JPEG is returned as it is.
+func (x *EXIF) apply(jpg []byte, o *Owned, taken time.Time) []byte {
+
+	if len(jpg) < 4 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return jpg
+	}
+	seg := x.segment(o, taken)
+	if len(seg) > 0xffff {
+		return jpg
+	}
+
+	out := make([]byte, 0, len(jpg)+len(seg))
+	out = append(out, 0xff, 0xd8)
+	inserted := false
+	i := 2
+	// walk the application segments; EXIF goes after a JFIF APP0
+	for i+4 <= len(jpg) && jpg[i] == 0xff && jpg[i+1] >= 0xe0 && jpg[i+1] <= 0xef {
+		end := i + 2 + int(binary.BigEndian.Uint16(jpg[i+2:]))
+		if end > len(jpg) {
+			break
+		}
+		marker := jpg[i+1]
+		if marker != 0xe0 && !inserted {
+			out = append(out, seg...)
+			inserted = true
+		}
+		if marker != 0xe1 || !bytes.HasPrefix(jpg[i+4:end], []byte("Exif\x00\x00")) {
+			out = append(out, jpg[i:end]...)
+		}
+		i = end
+	}
+	if !inserted {
+		out = append(out, seg...)
+	}
+	return append(out, jpg[i:]...)
+}
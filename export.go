@@ -0,0 +1,173 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats Events.Export writes
+const (
+	ExportJSON  = "json"
+	ExportJSONL = "jsonl"
+	ExportCSV   = "csv"
+	ExportICal  = "ics"
+)
+
+// eventColumns are the columns of Events.WriteCSV
+var eventColumns = []string{"id", "camera_uuid", "camera", "type", "start", "end", "duration", "important", "has_clip", "zones"}
+
+// The Export method writes the events to w in format, one of ExportJSON,
+// ExportJSONL, ExportCSV and ExportICal. titles names the cameras by uuid
+// where the format has room for them; it may be nil.
+func (es Events) Export(w io.Writer, format string, titles map[string]string) error {
+	switch format {
+	case ExportJSON:
+		return es.WriteJSON(w)
+	case ExportJSONL:
+		return es.WriteJSONL(w)
+	case ExportCSV:
+		return es.WriteCSV(w, titles)
+	case ExportICal:
+		return es.WriteICal(w, titles)
+	}
+	return fmt.Errorf("Unknown export format %q", format)
+}
+
+// WriteJSONL writes the events to w as JSON Lines, one EventJSON per line
+func (es Events) WriteJSONL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for i := range es {
+		if err := enc.Encode(es[i].JSON()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteCSV writes the events to w as CSV with a header row, for
+// spreadsheets. Times are RFC 3339 in UTC, durations in seconds, and zones
+// separated by semicolons; the camera column is the title titles gives.
+func (es Events) WriteCSV(w io.Writer, titles map[string]string) error {
+
+	cw := csv.NewWriter(w)
+	cw.Write(eventColumns)
+	for i := range es {
+		ej := es[i].JSON()
+		end := ""
+		if !ej.End.IsZero() {
+			end = ej.End.Format(time.RFC3339)
+		}
+		zones := make([]string, len(ej.Zones))
+		for j, z := range ej.Zones {
+			zones[j] = strconv.FormatInt(z, 10)
+		}
+		cw.Write([]string{
+			strconv.FormatInt(ej.Id, 10),
+			ej.Camera,
+			titles[ej.Camera],
+			ej.Type,
+			ej.Start.Format(time.RFC3339),
+			end,
+			strconv.FormatFloat(ej.Duration, 'f', -1, 64),
+			strconv.FormatBool(ej.Important),
+			strconv.FormatBool(ej.HasClip),
+			strings.Join(zones, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteICal writes the events to w as an iCalendar file, one VEVENT per
+// event, summarized by type and camera title, so a range of events can be
+// browsed in any calendar. Events still in progress end when they start.
+func (es Events) WriteICal(w io.Writer, titles map[string]string) error {
+
+	bw := bufio.NewWriter(w)
+	stamp := icalTime(time.Now())
+	line := func(name, value string) {
+		writeICalLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//rabarar//dropcam//EN")
+	line("CALSCALE", "GREGORIAN")
+	for i := range es {
+		e := &es[i]
+		start, end := e.Start(), e.End()
+		if end.IsZero() {
+			end = start
+		}
+		camera := titles[e.Camera]
+		if camera == "" {
+			camera = e.Camera
+		}
+		summary := "Event"
+		if e.Type != "" {
+			summary = strings.ToUpper(e.Type[:1]) + e.Type[1:]
+		}
+		if camera != "" {
+			summary += " at " + camera
+		}
+		desc := fmt.Sprintf("Event %d of camera %s, %s", e.Id, e.Camera, e.Type)
+		if e.IsImportant {
+			desc += ", important"
+		}
+		if e.HasClip {
+			desc += ", with clip"
+		}
+
+		line("BEGIN", "VEVENT")
+		line("UID", fmt.Sprintf("%d-%s@dropcam", e.Id, e.Camera))
+		line("DTSTAMP", stamp)
+		line("DTSTART", icalTime(start))
+		line("DTEND", icalTime(end))
+		line("SUMMARY", icalEscape(summary))
+		line("DESCRIPTION", icalEscape(desc))
+		if e.Type != "" {
+			line("CATEGORIES", icalEscape(e.Type))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// icalTime formats t as an iCalendar UTC time
+func icalTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// icalEscape escapes s for an iCalendar text value
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// writeICalLine writes content as an iCalendar line, folded at 75 octets
+// without splitting UTF-8 sequences
+func writeICalLine(w *bufio.Writer, content string) {
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && content[cut]&0xc0 == 0x80 {
+			cut--
+		}
+		w.WriteString(content[:cut] + "\r\n ")
+		content = content[cut:]
+		// continuation lines start with the space
+		limit = 74
+	}
+	w.WriteString(content + "\r\n")
+}
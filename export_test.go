@@ -0,0 +1,59 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Internals the tests of package dropcam_test, which can use dropcamtest,
+// reach
+
+var (
+	ReadBody    = readBody
+	ReadPooled  = readPooled
+	ReleaseBody = releaseBody
+)
+
+func (d *Dropcam) NewRequest(ctx context.Context, method, raw, query string, body io.Reader) (*http.Request, error) {
+	return d.newRequest(ctx, method, raw, query, body)
+}
+
+// Send sends an authorized GET of raw with query through d.send
+func (d *Dropcam) Send(ctx context.Context, raw string, query url.Values) (*http.Response, error) {
+	return d.send(ctx, query.Get("uuid"), func() (*http.Request, error) {
+		req, err := d.newRequest(ctx, "GET", raw, query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return req, d.authorize(ctx, req)
+	}, 3)
+}
+
+func (d *Dropcam) PostRequest(ctx context.Context, op, url, uuid string, data interface{}) (json.RawMessage, error) {
+	return d.postRequest(ctx, op, url, uuid, data)
+}
+
+// ScribblePool overwrites the whole of the buffers in the pool replies are
+// read into, so bytes read after they were released are garbage
+func ScribblePool() {
+	var bufs []*bytes.Buffer
+	for i := 0; i < 8; i++ {
+		buf := bodyBuffers.Get().(*bytes.Buffer)
+		b := buf.Bytes()[:buf.Cap()]
+		for j := range b {
+			b[j] = 'X'
+		}
+		bufs = append(bufs, buf)
+	}
+	for _, buf := range bufs {
+		bodyBuffers.Put(buf)
+	}
+}
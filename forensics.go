@@ -0,0 +1,428 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for Forensics
+const (
+	DefaultForensicsSize      = 200
+	DefaultForensicsBodyLimit = 16 << 10
+	DefaultForensicsCooldown  = time.Hour
+)
+
+// forensicsResponses is how many failed responses a bundle keeps
+const forensicsResponses = 20
+
+// The RequestRecord type describes one request made by the client, with
+// credentials removed from the URL. Status is zero when no response arrived.
+type RequestRecord struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Attempt  int           `json:"attempt"`
+	Status   int           `json:"status,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// The ResponseRecord type is a failed response, its headers and the start of
+// its body, with cookies, tokens and passwords removed
+type ResponseRecord struct {
+	Time   time.Time   `json:"time"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+// The Forensics type records the client's recent requests so a failure can
+// be investigated after the fact. Set as Dropcam.Forensics it keeps the last
+// Size requests and the last failed responses, up to BodyLimit bytes of each.
+//
+// WriteBundle writes them to a zip with the client's configuration and
+// metrics, redacted so the bundle can be attached to a bug report. When
+// Threshold requests in a row fail, a bundle is written to Dir by itself, at
+// most once per Cooldown. Served over HTTP, the handler returns a bundle to
+// callers granted the admin scope by Guard; with no Guard every request is
+// rejected.
+type Forensics struct {
+	Size      int
+	BodyLimit int
+	Threshold int
+	Dir       string
+	Cooldown  time.Duration
+	Monitor   *Monitor
+	Guard     Guard
+
+	mu          sync.Mutex
+	dropcam     *Dropcam
+	requests    []RequestRecord
+	next        int
+	responses   []ResponseRecord
+	total       int
+	failures    int
+	consecutive int
+	lastBundle  time.Time
+}
+
+// The ForensicsSummary type is the summary.json of a bundle
+type ForensicsSummary struct {
+	Created             time.Time `json:"created"`
+	Reason              string    `json:"reason"`
+	GoVersion           string    `json:"go_version"`
+	Goroutines          int       `json:"goroutines"`
+	Requests            int       `json:"requests"`
+	Failures            int       `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// record notes the outcome of req. A failed response's body is read up to
+// BodyLimit and put back, so the caller still sees all of it.
+func (f *Forensics) record(d *Dropcam, req *http.Request, resp *http.Response, err error, attempt int, took time.Duration) {
+
+	rec := RequestRecord{
+		Time:     time.Now().Add(-took),
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+		Attempt:  attempt,
+		Duration: took,
+	}
+	failed := err != nil
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Status = resp.StatusCode
+		failed = resp.StatusCode >= 400
+	}
+
+	var rr *ResponseRecord
+	if failed && resp != nil {
+		limit := f.BodyLimit
+		if limit <= 0 {
+			limit = DefaultForensicsBodyLimit
+		}
+		head, _ := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+
+		rr = &ResponseRecord{
+			Time:   rec.Time,
+			Method: rec.Method,
+			URL:    rec.URL,
+			Status: resp.StatusCode,
+			Header: redactHeader(resp.Header),
+			Body:   redactBody(string(head)),
+		}
+	}
+
+	f.mu.Lock()
+	f.dropcam = d
+	size := f.Size
+	if size <= 0 {
+		size = DefaultForensicsSize
+	}
+	if len(f.requests) < size {
+		f.requests = append(f.requests, rec)
+	} else {
+		f.requests[f.next%len(f.requests)] = rec
+		f.next++
+	}
+	if rr != nil {
+		f.responses = append(f.responses, *rr)
+		if len(f.responses) > forensicsResponses {
+			f.responses = f.responses[1:]
+		}
+	}
+	f.total++
+	if !failed {
+		f.consecutive = 0
+		f.mu.Unlock()
+		return
+	}
+	f.failures++
+	f.consecutive++
+	trigger := f.triggered()
+	f.mu.Unlock()
+
+	if trigger {
+		go f.autoBundle()
+	}
+}
+
+// triggered reports whether a bundle is due, noting it as written if so.
+// It must be called with f.mu held.
+func (f *Forensics) triggered() bool {
+
+	if f.Threshold <= 0 || f.Dir == "" || f.consecutive < f.Threshold {
+		return false
+	}
+	cooldown := f.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultForensicsCooldown
+	}
+	if !f.lastBundle.IsZero() && time.Since(f.lastBundle) < cooldown {
+		return false
+	}
+	f.lastBundle = time.Now()
+	return true
+}
+
+func (f *Forensics) autoBundle() {
+
+	var buf bytes.Buffer
+	reason := fmt.Sprintf("%d consecutive failed requests", f.Threshold)
+	if err := f.WriteBundle(&buf, reason); err != nil {
+		warnf("forensics bundle failed: %s\n", err)
+		return
+	}
+	name := "forensics-" + time.Now().UTC().Format("20060102T150405Z") + ".zip"
+	if err := (DirStorage{Root: f.Dir}).Put(name, &buf); err != nil {
+		warnf("forensics bundle failed: %s\n", err)
+		return
+	}
+	Dbg("wrote forensics bundle %s after %s\n", name, reason)
+}
+
+// Requests returns the recorded requests, oldest first
+func (f *Forensics) Requests() []RequestRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	recs := make([]RequestRecord, 0, len(f.requests))
+	for i := range f.requests {
+		recs = append(recs, f.requests[(f.next+i)%len(f.requests)])
+	}
+	return recs
+}
+
+// The WriteBundle method writes a zip to w holding summary.json,
+// requests.json, responses.json, config.json and metrics.txt. Reason says
+// why the bundle was made.
+func (f *Forensics) WriteBundle(w io.Writer, reason string) error {
+
+	requests := f.Requests()
+
+	f.mu.Lock()
+	summary := ForensicsSummary{
+		Created:             time.Now().UTC(),
+		Reason:              reason,
+		GoVersion:           runtime.Version(),
+		Goroutines:          runtime.NumGoroutine(),
+		Requests:            f.total,
+		Failures:            f.failures,
+		ConsecutiveFailures: f.consecutive,
+	}
+	responses := append([]ResponseRecord(nil), f.responses...)
+	d := f.dropcam
+	f.mu.Unlock()
+
+	var metrics bytes.Buffer
+	writeMetricHeader(&metrics, "dropcam_requests_total", "Requests made by the client.", "counter")
+	fmt.Fprintf(&metrics, "dropcam_requests_total %d\n", summary.Requests)
+	writeMetricHeader(&metrics, "dropcam_request_failures_total", "Requests that failed or returned an error status.", "counter")
+	fmt.Fprintf(&metrics, "dropcam_request_failures_total %d\n", summary.Failures)
+	writeGauge(&metrics, "dropcam_request_consecutive_failures", "Requests failed since the last success.", float64(summary.ConsecutiveFailures))
+	if f.Monitor != nil {
+		f.Monitor.writeMetrics(&metrics, nil)
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"summary.json", summary},
+		{"requests.json", requests},
+		{"responses.json", responses},
+		{"config.json", forensicsConfig(d)},
+	}
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeZipFile(zw, file.name, summary.Created, data); err != nil {
+			return err
+		}
+	}
+	if err := writeZipFile(zw, "metrics.txt", summary.Created, metrics.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, mod time.Time, data []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mod})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (f *Forensics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.Guard == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	Protect(f.Guard, ScopeAdmin, http.HandlerFunc(f.serve)).ServeHTTP(w, r)
+}
+
+func (f *Forensics) serve(w http.ResponseWriter, r *http.Request) {
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "requested"
+	}
+	var buf bytes.Buffer
+	if err := f.WriteBundle(&buf, reason); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := "forensics-" + time.Now().UTC().Format("20060102T150405Z") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Write(buf.Bytes())
+}
+
+// forensicsConfig describes the configuration of d without its credentials
+func forensicsConfig(d *Dropcam) map[string]interface{} {
+
+	if d == nil {
+		return nil
+	}
+	typeOf := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%T", v)
+	}
+	proxy := "environment"
+	switch {
+	case d.Dial.NoProxy:
+		proxy = "none"
+	case d.Dial.Proxy != nil:
+		proxy = redactURL(d.Dial.Proxy)
+	}
+	return map[string]interface{}{
+		"paths": map[string]string{
+			"login":           redactPath(d.LoginPath),
+			"cameras_get":     redactPath(d.CamerasGet),
+			"cameras_update":  redactPath(d.CamerasUpdate),
+			"cameras_add":     redactPath(d.CamerasAdd),
+			"cameras_delete":  redactPath(d.CamerasDelete),
+			"cameras_visible": redactPath(d.CamerasGetVisible),
+			"cameras_public":  redactPath(d.CamerasGetPublic),
+			"cameras_image":   redactPath(d.CamerasGetImagePath),
+			"events":          redactPath(d.EventPath),
+			"event_clip":      redactPath(d.EventGetClipPath),
+			"clip_request":    redactPath(d.ClipRequestPath),
+			"clip_status":     redactPath(d.ClipStatusPath),
+			"available":       redactPath(d.AvailablePath),
+			"properties":      redactPath(d.PropertiesPath),
+			"camera_info":     redactPath(d.CameraInfoPath),
+			"session_token":   redactPath(d.SessionTokenPath),
+			"talkback":        redactPath(d.TalkbackPath),
+			"user":            redactPath(d.UsersGetCurrent),
+			"subscriptions":   redactPath(d.SubscriptionsList),
+		},
+		"logged_in":            d.SessionCookie() != "",
+		"auth":                 typeOf(d.Auth),
+		"relogin":              d.Relogin,
+		"sessions":             typeOf(d.Sessions),
+		"dial_hosts":           d.Dial.Hosts,
+		"dial_prefer_ipv4":     d.Dial.PreferIPv4,
+		"dial_timeout":         d.Dial.Timeout.String(),
+		"dial_proxy":           proxy,
+		"dial_root_cas":        d.Dial.RootCAs != nil,
+		"dial_insecure":        d.Dial.InsecureSkipVerify,
+		"camera_limits":        d.CameraLimits,
+		"default_camera_limit": d.DefaultCameraLimit,
+		"pipeline_stages":      len(d.Pipeline),
+		"compression":          d.Compression,
+		"custom_http_client":   d.HTTPClient != nil,
+		"retry":                d.Retry,
+		"retry_budget":         d.RetryBudget != nil,
+		"latest_dir":           d.LatestDir,
+		"saved_notifier":       typeOf(d.Saved),
+		"dedup":                d.Dedup,
+		"dedup_distance":       d.DedupDistance,
+		"logger":               d.Logger != nil,
+	}
+}
+
+const redacted = "REDACTED"
+
+// secretName matches the names of parameters, headers and fields holding credentials
+var secretName = regexp.MustCompile(`(?i)pass|token|secret|cookie|auth|session|key|sig`)
+
+// secretField matches JSON string fields with credential names
+var secretField = regexp.MustCompile(`(?i)("[^"]*(?:pass|token|secret|cookie|auth|session|key|sig)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+func redactURL(u *url.URL) string {
+	r := *u
+	r.User = nil
+	q := r.Query()
+	for k := range q {
+		if secretName.MatchString(k) {
+			q[k] = []string{redacted}
+		}
+	}
+	r.RawQuery = q.Encode()
+	return r.String()
+}
+
+func redactPath(path string) string {
+	u, err := url.Parse(path)
+	if err != nil {
+		return redacted
+	}
+	return redactURL(u)
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		if secretName.MatchString(k) {
+			v = []string{redacted}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func redactBody(body string) string {
+	body = secretField.ReplaceAllString(body, `$1"`+redacted+`"`)
+	// form-encoded bodies
+	if !strings.ContainsAny(body, "{<") && strings.Contains(body, "=") {
+		if q, err := url.ParseQuery(body); err == nil {
+			for k := range q {
+				if secretName.MatchString(k) {
+					q[k] = []string{redacted}
+				}
+			}
+			body = q.Encode()
+		}
+	}
+	return body
+}
@@ -0,0 +1,60 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"net/http"
+	"sync"
+)
+
+// lastFrame is the most recent snapshot of a camera at one width, kept when
+// Dropcam.Dedup is set
+type lastFrame struct {
+	etag     string
+	modified string
+	sum      [32]byte
+	img      []byte
+
+	// hash is the perceptual hash of the frame as received, when
+	// Dropcam.DedupDistance asked for one
+	hash   uint64
+	hashed bool
+}
+
+// validators returns the conditional request headers for f, or nil
+func (f *lastFrame) validators() http.Header {
+	if f == nil || (f.etag == "" && f.modified == "") {
+		return nil
+	}
+	h := make(http.Header)
+	if f.etag != "" {
+		h.Set("If-None-Match", f.etag)
+	}
+	if f.modified != "" {
+		h.Set("If-Modified-Since", f.modified)
+	}
+	return h
+}
+
+// frameMemo holds the last frame per camera and width
+type frameMemo struct {
+	mu     sync.Mutex
+	frames map[string]*lastFrame
+}
+
+func (m *frameMemo) get(key string) *lastFrame {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.frames[key]
+}
+
+func (m *frameMemo) put(key string, f *lastFrame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.frames == nil {
+		m.frames = make(map[string]*lastFrame)
+	}
+	m.frames[key] = f
+}
@@ -0,0 +1,266 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultThumbWidth is the width of gallery thumbnails when Gallery.ThumbWidth is zero
+const DefaultThumbWidth = 240
+
+// The Gallery type is an http.Handler for browsing archived snapshots. Listings
+// come from Catalog and images from Storage; only keys recorded in the catalog
+// are served.
+//
+//	/                           cameras
+//	/<uuid>/                    days with snapshots
+//	/<uuid>/<yyyy-mm-dd>/       thumbnails for the day
+//	/media/<key>                the stored image
+//	/thumb/<key>                a thumbnail of it
+//
+// When Guard is set, callers need the read scope; a *Users guard gives basic auth.
+type Gallery struct {
+	Catalog    *Catalog
+	Storage    Storage
+	Cameras    *Cameras
+	Guard      Guard
+	ThumbWidth int
+}
+
+type galleryLink struct {
+	Href  string
+	Title string
+}
+
+type galleryDay struct {
+	Camera string
+	Day    string
+	Items  []MediaRecord
+}
+
+func (g *Gallery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.Guard != nil {
+		Protect(g.Guard, ScopeRead, http.HandlerFunc(g.serve)).ServeHTTP(w, r)
+		return
+	}
+	g.serve(w, r)
+}
+
+func (g *Gallery) serve(w http.ResponseWriter, r *http.Request) {
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case strings.HasPrefix(path, "media/"):
+		g.media(w, r, strings.TrimPrefix(path, "media/"), false)
+		return
+	case strings.HasPrefix(path, "thumb/"):
+		g.media(w, r, strings.TrimPrefix(path, "thumb/"), true)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "":
+		g.index(w, r)
+	case len(parts) == 1 && strings.HasSuffix(path, "/"):
+		g.days(w, r, parts[0])
+	case len(parts) == 2 && strings.HasSuffix(path, "/"):
+		g.day(w, r, parts[0], parts[1])
+	case len(parts) <= 2:
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allowed reports whether the caller of r may see camera uuid
+func (g *Gallery) allowed(r *http.Request, uuid string) bool {
+	p := RequestPrincipal(r)
+	return p == nil || p.AllowsCamera(uuid)
+}
+
+// title returns the camera's name if it is known
+func (g *Gallery) title(uuid string) string {
+	if g.Cameras != nil {
+		if o := g.Cameras.findCamera(uuid); o != nil && o.Title != "" {
+			return o.Title
+		}
+	}
+	return uuid
+}
+
+func (g *Gallery) index(w http.ResponseWriter, r *http.Request) {
+
+	cams, err := g.Catalog.Cameras(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var links []galleryLink
+	for _, uuid := range cams {
+		if g.allowed(r, uuid) {
+			links = append(links, galleryLink{Href: uuid + "/", Title: g.title(uuid)})
+		}
+	}
+	g.render(w, "list", struct {
+		Title string
+		Links []galleryLink
+	}{"Cameras", links})
+}
+
+func (g *Gallery) days(w http.ResponseWriter, r *http.Request, uuid string) {
+
+	if !g.allowed(r, uuid) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	days, err := g.Catalog.Days(r.Context(), uuid, MediaSnapshot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(days) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	links := []galleryLink{{Href: "../", Title: "All cameras"}}
+	for _, day := range days {
+		links = append(links, galleryLink{Href: day + "/", Title: day})
+	}
+	g.render(w, "list", struct {
+		Title string
+		Links []galleryLink
+	}{g.title(uuid), links})
+}
+
+func (g *Gallery) day(w http.ResponseWriter, r *http.Request, uuid, day string) {
+
+	if !g.allowed(r, uuid) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	start, err := time.ParseInLocation("2006-01-02", day, time.Local)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	items, err := g.Catalog.Query(r.Context(), CatalogQuery{
+		Camera: uuid,
+		Kind:   MediaSnapshot,
+		From:   start,
+		To:     start.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	g.render(w, "day", galleryDay{Camera: g.title(uuid), Day: day, Items: items})
+}
+
+func (g *Gallery) media(w http.ResponseWriter, r *http.Request, key string, thumb bool) {
+
+	rec, err := g.Catalog.Lookup(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !g.allowed(r, rec.Camera) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	f, err := g.Storage.Get(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", "max-age=86400")
+	if !thumb {
+		if rec.Kind == MediaClip {
+			w.Header().Set("Content-Type", "video/mp4")
+		} else {
+			w.Header().Set("Content-Type", "image/jpeg")
+		}
+		io.Copy(w, f)
+		return
+	}
+
+	if rec.Kind != MediaSnapshot {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	width := g.ThumbWidth
+	if width <= 0 {
+		width = DefaultThumbWidth
+	}
+	data, err = Pipeline{Resize(width, 0)}.apply(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(data)
+}
+
+func (g *Gallery) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := galleryTemplate.ExecuteTemplate(w, name, data); err != nil {
+		warnf("gallery: %s\n", err)
+	}
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Funcs(template.FuncMap{
+	"clock": func(t time.Time) string {
+		return t.Format("15:04:05")
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>
+body{font-family:sans-serif;background:#222;color:#eee;margin:1em}
+a{color:#9cf}
+.grid{display:flex;flex-wrap:wrap;gap:.5em}
+.grid figure{margin:0;text-align:center;font-size:small}
+.grid img{display:block;background:#000}
+</style>
+</head><body>{{end}}
+
+{{define "list"}}{{template "head" .Title}}
+<h1>{{.Title}}</h1>
+<ul>
+{{range .Links}}<li><a href="{{.Href}}">{{.Title}}</a></li>
+{{else}}<li>Nothing archived yet.</li>
+{{end}}</ul>
+</body></html>{{end}}
+
+{{define "day"}}{{template "head" .Camera}}
+<p><a href="../">{{.Camera}}</a></p>
+<h1>{{.Camera}}, {{.Day}}</h1>
+<div class="grid">
+{{range .Items}}<figure><a href="../../media/{{.Key}}"><img src="../../thumb/{{.Key}}" loading="lazy" alt="{{clock .Time}}"></a>{{clock .Time}}</figure>
+{{else}}<p>No snapshots.</p>
+{{end}}</div>
+</body></html>{{end}}
+`))
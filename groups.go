@@ -0,0 +1,205 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The Groups type holds named groups of cameras defined by the user, such as
+// "outside" or "downstairs", independent of the Nest structures. Members are
+// camera uuids. A camera may be in any number of groups.
+//
+// Groups are kept in Path by Save; LoadGroups reads them back.
+type Groups struct {
+	Path string
+
+	mu     sync.Mutex
+	groups map[string][]string
+}
+
+// LoadGroups reads the groups saved at path; a missing file yields no groups
+func LoadGroups(path string) (*Groups, error) {
+
+	g := &Groups{Path: path, groups: make(map[string][]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &g.groups); err != nil {
+		return nil, errors.New("Failed to read camera groups: " + err.Error())
+	}
+	return g, nil
+}
+
+// Save writes the groups to Path
+func (g *Groups) Save() error {
+
+	if g.Path == "" {
+		return nil
+	}
+	g.mu.Lock()
+	data, err := json.MarshalIndent(g.groups, "", "  ")
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return (DirStorage{Root: filepath.Dir(g.Path)}).Put(filepath.Base(g.Path), bytes.NewReader(data))
+}
+
+// Set defines group name as exactly the cameras uuids, replacing any members
+func (g *Groups) Set(name string, uuids ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	g.groups[name] = nil
+	g.add(name, uuids)
+}
+
+// Add adds the cameras uuids to group name, creating it if needed
+func (g *Groups) Add(name string, uuids ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.init()
+	g.add(name, uuids)
+}
+
+func (g *Groups) add(name string, uuids []string) {
+	members := g.groups[name]
+	for _, u := range uuids {
+		if !containsString(members, u) {
+			members = append(members, u)
+		}
+	}
+	sort.Strings(members)
+	g.groups[name] = members
+}
+
+// Remove takes the cameras uuids out of group name. The group remains, empty
+// if it has no members left.
+func (g *Groups) Remove(name string, uuids ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members, ok := g.groups[name]
+	if !ok {
+		return
+	}
+	kept := members[:0]
+	for _, u := range members {
+		if !containsString(uuids, u) {
+			kept = append(kept, u)
+		}
+	}
+	g.groups[name] = kept
+}
+
+// Delete removes group name
+func (g *Groups) Delete(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.groups, name)
+}
+
+// Members returns the uuids of the cameras in group name and whether it exists
+func (g *Groups) Members(name string) ([]string, bool) {
+	if g == nil {
+		return nil, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members, ok := g.groups[name]
+	return append([]string(nil), members...), ok
+}
+
+// Names returns the group names in sorted order
+func (g *Groups) Names() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	names := make([]string, 0, len(g.groups))
+	for name := range g.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Filter returns a CameraFilter selecting the cameras of group name, for
+// ApplyToAll, Profiles.Apply and SaveAllImages
+func (g *Groups) Filter(name string) (CameraFilter, error) {
+	members, ok := g.Members(name)
+	if !ok {
+		return nil, fmt.Errorf("No camera group %q: %w", name, ErrNotFound)
+	}
+	return CameraUUIDs(members...), nil
+}
+
+func (g *Groups) init() {
+	if g.groups == nil {
+		g.groups = make(map[string][]string)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// The Group method returns the cameras of group name in g that are in the
+// camera list. Members no longer on the account are left out.
+func (c *Cameras) Group(g *Groups, name string) ([]*Owned, error) {
+
+	filter, err := g.Filter(name)
+	if err != nil {
+		return nil, err
+	}
+	var cams []*Owned
+	for _, o := range c.all() {
+		if filter(o) {
+			cams = append(cams, o)
+		}
+	}
+	return cams, nil
+}
+
+// The Select method returns the cameras name stands for: the camera with
+// uuid name, the cameras of group name in g, or the camera titled name, as
+// ByTitle finds it, in that order. A name of the form "<title> at
+// <structure>" is looked up with ByTitleAt. g may be nil.
+func (c *Cameras) Select(g *Groups, name string) ([]*Owned, error) {
+
+	if o, err := c.ByUUID(name); err == nil {
+		return []*Owned{o}, nil
+	}
+	if _, ok := g.Members(name); ok {
+		return c.Group(g, name)
+	}
+	o, err := c.ByTitle(name)
+	if err != nil {
+		// "<title> at <structure>"
+		if i := strings.LastIndex(strings.ToLower(name), " at "); i > 0 {
+			if o, aerr := c.ByTitleAt(name[:i], name[i+4:]); aerr == nil {
+				return []*Owned{o}, nil
+			}
+		}
+		return nil, err
+	}
+	return []*Owned{o}, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"net/http"
+)
+
+// Scopes granted to server callers, from least to most privileged.
+// Each scope implies the ones before it.
+const (
+	ScopeRead    = "read"
+	ScopeControl = "control"
+	ScopeAdmin   = "admin"
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeControl: 2, ScopeAdmin: 3}
+
+// The Principal type is an authenticated caller of the server endpoints.
+// Cameras, if not empty, is the allowlist of camera uuids it may address.
+type Principal struct {
+	Name    string
+	Scopes  []string
+	Cameras []string
+
+	// the guard of a Guards that authenticated the principal, which audits
+	// its requests
+	by Guard
+}
+
+// HasScope reports whether the principal was granted scope or a scope implying it
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCamera reports whether the principal may address camera uuid
+func (p *Principal) AllowsCamera(uuid string) bool {
+	if len(p.Cameras) == 0 {
+		return true
+	}
+	for _, c := range p.Cameras {
+		if c == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// The Guard interface authenticates server requests
+type Guard interface {
+	Authorize(r *http.Request) (*Principal, bool)
+}
+
+// Guards tries each guard in turn and accepts the first that authenticates
+// the request. Access decisions are audited by that guard, as Users does.
+type Guards []Guard
+
+// Authorize implements Guard
+func (gs Guards) Authorize(r *http.Request) (*Principal, bool) {
+	for _, g := range gs {
+		if p, ok := g.Authorize(r); ok {
+			if p.by == nil {
+				cp := *p
+				cp.by = g
+				p = &cp
+			}
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// audit passes the decision on to the guard that authenticated p
+func (gs Guards) audit(r *http.Request, p *Principal, allowed bool) {
+	if a, ok := p.by.(auditor); ok {
+		a.audit(r, p, allowed)
+	}
+}
+
+// auditor is implemented by guards that record access decisions
+type auditor interface {
+	audit(r *http.Request, p *Principal, allowed bool)
+}
+
+type principalContext struct{}
+
+// RequestPrincipal returns the principal that authenticated r, if any
+func RequestPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(principalContext{}).(*Principal)
+	return p
+}
+
+// Protect wraps h so that requests must be authenticated by g with the given
+// scope. Every "camera" parameter of the request must be allowed for the caller.
+func Protect(g Guard, scope string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		p, ok := g.Authorize(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="dropcam"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		allowed := p.HasScope(scope)
+		r.ParseForm()
+		for _, uuid := range r.Form["camera"] {
+			allowed = allowed && p.AllowsCamera(uuid)
+		}
+		if a, ok := g.(auditor); ok {
+			a.audit(r, p, allowed)
+		}
+		if !allowed {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContext{}, p)))
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGuardsAudit(t *testing.T) {
+
+	var audit bytes.Buffer
+	users := &Users{Account: "owner@example.com", Audit: &audit}
+	if err := users.Set("alice", "secret", RoleOperator, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("bob", "secret", RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+	keys := &APIKeys{}
+	token, _, err := keys.Create("ci", []string{ScopeControl}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Protect(Guards{keys, users}, ScopeControl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		auth   func(r *http.Request)
+		status int
+		audit  string
+	}{
+		{"operator", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, http.StatusOK,
+			" allow user=alice account=owner@example.com POST /jobs?camera=1\n"},
+		{"viewer", func(r *http.Request) { r.SetBasicAuth("bob", "secret") }, http.StatusForbidden,
+			" deny user=bob account=owner@example.com POST /jobs?camera=1\n"},
+		{"api key", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, http.StatusOK, ""},
+		{"stranger", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		audit.Reset()
+		r := httptest.NewRequest("POST", "/jobs?camera=1", nil)
+		tt.auth(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if got := audit.String(); tt.audit == "" && got != "" || !strings.HasSuffix(got, tt.audit) {
+			t.Errorf("%s: audited %q, want %q", tt.name, got, tt.audit)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health statuses
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// The SessionError method returns why the servers last rejected the session,
+// or nil once a request has succeeded since
+func (d *Dropcam) SessionError() error {
+	d.stats.mu.Lock()
+	defer d.stats.mu.Unlock()
+	return d.stats.sessionErr
+}
+
+// The LastSuccess method returns when a request last succeeded, or the zero
+// time if none has
+func (d *Dropcam) LastSuccess() time.Time {
+	d.stats.mu.Lock()
+	defer d.stats.mu.Unlock()
+	return d.stats.lastSuccess
+}
+
+// The HealthReport type is what Health found of a service. Problems says
+// why it is not ready, or degraded.
+type HealthReport struct {
+	Status        string         `json:"status"`
+	Ready         bool           `json:"ready"`
+	Uptime        float64        `json:"uptime"`
+	Session       bool           `json:"session"`
+	SessionError  string         `json:"session_error,omitempty"`
+	LastSuccess   time.Time      `json:"last_success,omitempty"`
+	Jobs          int            `json:"jobs"`
+	Failing       int            `json:"failing"`
+	Backlog       int            `json:"backlog"`
+	StaleCameras  int            `json:"stale_cameras"`
+	Problems      []string       `json:"problems,omitempty"`
+	CameraReports []CameraHealth `json:"cameras,omitempty"`
+}
+
+// The CameraHealth type is the health of one camera: whether the Monitor
+// last saw it online, and the age in seconds of its last successful
+// snapshot, -1 if it has none
+type CameraHealth struct {
+	Camera       string    `json:"camera_uuid"`
+	Title        string    `json:"title,omitempty"`
+	Online       bool      `json:"online"`
+	Seen         time.Time `json:"seen,omitempty"`
+	LastSnapshot time.Time `json:"last_snapshot,omitempty"`
+	SnapshotAge  float64   `json:"snapshot_age"`
+	Stale        bool      `json:"stale"`
+}
+
+// The Health type serves liveness and readiness probes for a service built
+// on this package, so Kubernetes or a systemd watchdog can restart it when
+// its session dies:
+//
+//	/healthz  200 while the session is valid, 503 once the servers reject it
+//	          and it cannot be renewed
+//	/readyz   200 once a request has succeeded, while the session is valid
+//	          and at most MaxBacklog jobs, 0 meaning any, are behind schedule
+//
+// Both reply with a HealthReport. Cameras the Monitor sees online whose last
+// snapshot by Jobs is older than MaxSnapshotAge, three intervals of their
+// job when zero, are stale and make the status degraded. The report lists
+// every camera only to callers Guard grants the read scope; the probes
+// themselves need no authentication. Monitor and Jobs are optional.
+type Health struct {
+	Dropcam        *Dropcam
+	Monitor        *Monitor
+	Jobs           *Jobs
+	MaxSnapshotAge time.Duration
+	MaxBacklog     int
+	Guard          Guard
+	Started        time.Time
+}
+
+// Check reports the health of the service now
+func (h *Health) Check() *HealthReport {
+
+	now := time.Now()
+	rep := &HealthReport{Status: HealthOK}
+	if !h.Started.IsZero() {
+		rep.Uptime = now.Sub(h.Started).Round(time.Second).Seconds()
+	}
+
+	rep.LastSuccess = h.Dropcam.LastSuccess()
+	rep.Session = true
+	if err := h.Dropcam.SessionError(); err != nil {
+		rep.Session = false
+		rep.SessionError = err.Error()
+		rep.Problems = append(rep.Problems, "session: "+err.Error())
+	}
+	if rep.LastSuccess.IsZero() {
+		rep.Problems = append(rep.Problems, "no request has succeeded yet")
+	}
+
+	// the last successful snapshot of each camera, and the interval it is due
+	type snapshot struct {
+		last     time.Time
+		interval time.Duration
+	}
+	snapshots := make(map[string]snapshot)
+	if h.Jobs != nil {
+		states := h.Jobs.States()
+		rep.Jobs = len(states)
+		for _, st := range states {
+			if st.LastError != "" {
+				rep.Failing++
+			}
+			if st.Camera == "" {
+				continue
+			}
+			s := snapshots[st.Camera]
+			if st.LastSuccess.After(s.last) {
+				s.last = st.LastSuccess
+			}
+			if s.interval == 0 || st.Interval < s.interval {
+				s.interval = st.Interval
+			}
+			snapshots[st.Camera] = s
+		}
+		rep.Backlog = h.Jobs.Backlog()
+		if h.MaxBacklog > 0 && rep.Backlog > h.MaxBacklog {
+			rep.Problems = append(rep.Problems, "jobs are behind schedule")
+		}
+	}
+
+	if h.Monitor != nil {
+		for _, st := range h.Monitor.States() {
+			ch := CameraHealth{Camera: st.Camera, Title: st.Title, Online: st.IsOnline, Seen: st.Updated, SnapshotAge: -1}
+			if s, ok := snapshots[st.Camera]; ok {
+				maxAge := h.MaxSnapshotAge
+				if maxAge <= 0 {
+					maxAge = 3 * s.interval
+				}
+				ch.LastSnapshot = s.last
+				if !s.last.IsZero() {
+					ch.SnapshotAge = now.Sub(s.last).Round(time.Second).Seconds()
+				}
+				// snapshots of offline cameras are expected to fail
+				ch.Stale = st.IsOnline && (s.last.IsZero() || now.Sub(s.last) > maxAge) && now.Sub(h.Started) > maxAge
+			}
+			if ch.Stale {
+				rep.StaleCameras++
+			}
+			rep.CameraReports = append(rep.CameraReports, ch)
+		}
+	}
+
+	rep.Ready = rep.Session && !rep.LastSuccess.IsZero() && (h.MaxBacklog <= 0 || rep.Backlog <= h.MaxBacklog)
+	switch {
+	case !rep.Session:
+		rep.Status = HealthUnavailable
+	case !rep.Ready || rep.Failing > 0 || rep.StaleCameras > 0:
+		rep.Status = HealthDegraded
+	}
+	return rep
+}
+
+// ServeHTTP implements http.Handler, answering /readyz with readiness and
+// any other path with liveness
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	rep := h.Check()
+	status := http.StatusOK
+	if strings.HasSuffix(r.URL.Path, "/readyz") {
+		if !rep.Ready {
+			status = http.StatusServiceUnavailable
+		}
+	} else if !rep.Session {
+		status = http.StatusServiceUnavailable
+	}
+
+	p, ok := (*Principal)(nil), false
+	if h.Guard != nil {
+		p, ok = h.Guard.Authorize(r)
+	}
+	if !ok || !p.HasScope(ScopeRead) {
+		rep.CameraReports = nil
+	} else {
+		visible := rep.CameraReports[:0]
+		for _, ch := range rep.CameraReports {
+			if p.AllowsCamera(ch.Camera) {
+				visible = append(visible, ch)
+			}
+		}
+		rep.CameraReports = visible
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rep)
+}
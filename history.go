@@ -0,0 +1,189 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// liveSlack is how far in the past a snapshot time may be and still be taken
+// as a request for the live image
+const liveSlack = 10 * time.Second
+
+// isHistorical reports whether an image time asks for a recorded frame rather
+// than the live image. Zero and recent times mean live.
+func isHistorical(t time.Time) bool {
+	return !t.IsZero() && time.Since(t) > liveSlack
+}
+
+// The ImageFrame type is one frame returned by GetImageRange. Err is set when
+// no frame could be had for Time, e.g. during a gap in the recording.
+type ImageFrame struct {
+	Time  time.Time
+	Image []byte
+	Err   error
+}
+
+// The GetImage method returns a snapshot of camera o. A zero or recent t asks
+// for the live image; an earlier t for the recorded frame at that time, which
+// needs the camera to have cloud recording covering it.
+func (c *Cameras) GetImage(ctx context.Context, o *Owned, width int, t time.Time) ([]byte, error) {
+	return c.getImage(ctx, o, width, t)
+}
+
+// eventSnapshotOffset is how far into an event its snapshot is taken, past
+// the first moments when the motion has often barely begun
+const eventSnapshotOffset = 2 * time.Second
+
+// eventLiveWindow is how long after it ends an event of a camera without
+// cloud recording is still shown by the live image
+const eventLiveWindow = 2 * time.Minute
+
+// eventSnapshotTime returns the time of the frame representing e: a little
+// into it, or its middle when it is shorter than that
+func eventSnapshotTime(e *Event) time.Time {
+	start := e.Start()
+	t := start.Add(eventSnapshotOffset)
+	if end := e.End(); !end.IsZero() && t.After(end) {
+		t = start.Add(end.Sub(start) / 2)
+	}
+	return t
+}
+
+// The GetEventSnapshot method returns the image representing event of
+// camera o, at width, so a notification can carry a picture of it: the
+// recorded frame a couple of seconds into the event, or from its middle if
+// it is shorter. Cameras without cloud recording have no recorded frames;
+// for them the live image stands in while the event is recent, and older
+// events fail with ErrNoCVR.
+func (c *Cameras) GetEventSnapshot(ctx context.Context, o *Owned, event Event, width int) ([]byte, error) {
+
+	if event.Camera != "" && event.Camera != o.Uuid {
+		return nil, fmt.Errorf("Event %d is of camera %s, not %s", event.Id, event.Camera, o.Uuid)
+	}
+	t := eventSnapshotTime(&event)
+	if !c.Dropcam.hasCVR(o) {
+		last := event.End()
+		if last.IsZero() {
+			last = event.Start()
+		}
+		if time.Since(last) > eventLiveWindow {
+			return nil, c.Dropcam.requireCVR("Get Event Snapshot", o)
+		}
+		t = time.Time{}
+	}
+	img, err := c.getImage(ctx, o, width, t)
+	if err != nil {
+		return nil, fmt.Errorf("Get Event Snapshot Failed: event %d: %w", event.Id, err)
+	}
+	return img, nil
+}
+
+// The GetImageRange method retrieves the recorded frames of camera o from
+// from up to, but not including, to, one every step. Frames that fail are
+// returned with Err set; only a cancelled ctx ends the retrieval early.
+func (c *Cameras) GetImageRange(ctx context.Context, o *Owned, width int, from, to time.Time, step time.Duration) ([]ImageFrame, error) {
+
+	if step <= 0 {
+		return nil, errors.New("GetImageRange needs a positive step")
+	}
+	if !to.After(from) {
+		return nil, nil
+	}
+
+	var frames []ImageFrame
+	for t := from; t.Before(to); t = t.Add(step) {
+		img, err := c.getImage(ctx, o, width, t)
+		if ctx.Err() != nil {
+			return frames, ctx.Err()
+		}
+		frames = append(frames, ImageFrame{Time: t, Image: img, Err: err})
+	}
+	return frames, nil
+}
+
+// The BackfillResult type reports what a Backfill did. Saved lists the keys
+// stored, in time order; Existing counts frames already in storage from an
+// earlier run, Duplicate frames identical to the one before and Missing the
+// times no frame could be had for.
+type BackfillResult struct {
+	Saved     []string
+	Existing  int
+	Duplicate int
+	Missing   int
+}
+
+// BackfillKey is the key Backfill stores the frame of camera uuid at t
+// under, "<uuid>/backfill/<yyyymmdd-hhmmss>.jpg" in UTC, so keys sort in
+// time order
+func BackfillKey(uuid string, t time.Time) string {
+	return uuid + "/backfill/" + t.UTC().Format("20060102-150405") + ".jpg"
+}
+
+// The Backfill method pulls the recorded frames of camera o from from up to,
+// but not including, to, one every step, into storage, e.g. every minute of
+// the last week to make up a timelapse for a time nothing was capturing.
+// Frames are stored under BackfillKey. Frames already stored are not fetched
+// again, so an interrupted backfill resumes where it stopped, and frames
+// identical to the previous one, as during a gap in the recording, are not
+// stored. The camera needs cloud recording covering the range. Its requests
+// are low priority for Dropcam.Quota.
+func (c *Cameras) Backfill(ctx context.Context, o *Owned, from, to time.Time, step time.Duration, storage Storage) (*BackfillResult, error) {
+
+	if step <= 0 {
+		return nil, errors.New("Backfill needs a positive step")
+	}
+	if err := c.Dropcam.requireCVR("Backfill", o); err != nil {
+		return nil, err
+	}
+
+	ctx = WithLowPriority(ctx)
+	res := &BackfillResult{}
+	var last [sha256.Size]byte
+	for t := from; t.Before(to); t = t.Add(step) {
+		key := BackfillKey(o.Uuid, t)
+		if rc, err := storage.Get(key); err == nil {
+			h := sha256.New()
+			_, err = io.Copy(h, rc)
+			rc.Close()
+			if err == nil {
+				copy(last[:], h.Sum(nil))
+				res.Existing++
+				continue
+			}
+		}
+
+		img, err := c.getImage(ctx, o, 720, t)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if errors.Is(err, ErrNoCVR) || errors.Is(err, ErrAuthFailed) {
+			return res, err
+		}
+		if err != nil {
+			Dbg("backfill: %s at %s: %s\n", o.Title, t.Format(time.RFC3339), err)
+			res.Missing++
+			continue
+		}
+		sum := sha256.Sum256(img)
+		if sum == last {
+			res.Duplicate++
+			continue
+		}
+		last = sum
+
+		if err := storage.Put(key, bytes.NewReader(img)); err != nil {
+			return res, fmt.Errorf("Backfill Failed: %w", err)
+		}
+		res.Saved = append(res.Saved, key)
+	}
+	return res, nil
+}
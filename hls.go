@@ -0,0 +1,262 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of HLSServer
+const (
+	DefaultHLSSegment = 2 * time.Second
+	DefaultHLSList    = 6
+	DefaultHLSIdle    = 30 * time.Second
+	hlsStartTimeout   = 20 * time.Second
+)
+
+// hlsFile matches the names of the files an HLS session serves
+var hlsFile = regexp.MustCompile(`^(index\.m3u8|seg[0-9]+\.ts)$`)
+
+// The HLSServer type is an http.Handler serving the live streams of the
+// cameras as HTTP Live Streaming, which browsers play natively or through
+// hls.js, without Flash or the Nest app:
+//
+//	/cameras/<uuid>/index.m3u8
+//
+// The first request for a camera negotiates its stream and starts ffmpeg,
+// which must be installed, remuxing it without re-encoding into Segment long
+// segments, DefaultHLSSegment when zero, of which the playlist lists the
+// last List, DefaultHLSList when zero. Segments are written below Dir, a
+// temporary directory when empty. A camera's stream is stopped once nobody
+// has requested it for Idle, DefaultHLSIdle when zero, and restarted by the
+// next request. When Guard is set, callers need the read scope.
+type HLSServer struct {
+	Cameras *Cameras
+	Dir     string
+	Segment time.Duration
+	List    int
+	Idle    time.Duration
+	FFmpeg  string
+	Guard   Guard
+
+	mu       sync.Mutex
+	sessions map[string]*hlsSession
+}
+
+// hlsSession is the transmuxing of one camera's stream
+type hlsSession struct {
+	dir    string
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan struct{}
+	err    error
+
+	mu   sync.Mutex
+	used time.Time
+}
+
+func (h *HLSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Guard != nil {
+		Protect(h.Guard, ScopeRead, http.HandlerFunc(h.serve)).ServeHTTP(w, r)
+		return
+	}
+	h.serve(w, r)
+}
+
+func (h *HLSServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "cameras" || !hlsFile.MatchString(parts[2]) {
+		http.NotFound(w, r)
+		return
+	}
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	o := h.Cameras.findCamera(parts[1])
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	s, err := h.session(r.Context(), o)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.mu.Lock()
+	s.used = time.Now()
+	s.mu.Unlock()
+
+	if strings.HasSuffix(parts[2], ".m3u8") {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	http.ServeFile(w, r, filepath.Join(s.dir, parts[2]))
+}
+
+// session returns the running session of camera o, starting one if needed,
+// once its playlist exists
+func (h *HLSServer) session(ctx context.Context, o *Owned) (*hlsSession, error) {
+
+	h.mu.Lock()
+	if h.sessions == nil {
+		h.sessions = make(map[string]*hlsSession)
+	}
+	s := h.sessions[o.Uuid]
+	if s != nil {
+		select {
+		case <-s.done:
+			// ffmpeg exited; start over
+			s = nil
+		default:
+		}
+	}
+	if s == nil {
+		var err error
+		if s, err = h.start(o); err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		h.sessions[o.Uuid] = s
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-s.done:
+		return nil, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// start negotiates the stream of o and runs ffmpeg on it in the background
+func (h *HLSServer) start(o *Owned) (*hlsSession, error) {
+
+	segment := h.Segment
+	if segment <= 0 {
+		segment = DefaultHLSSegment
+	}
+	list := h.List
+	if list <= 0 {
+		list = DefaultHLSList
+	}
+	idle := h.Idle
+	if idle <= 0 {
+		idle = DefaultHLSIdle
+	}
+	bin := h.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+
+	if h.Dir != "" {
+		if err := os.MkdirAll(h.Dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := ioutil.TempDir(h.Dir, "hls-"+o.Uuid+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &hlsSession{dir: dir, cancel: cancel, done: make(chan struct{}), ready: make(chan struct{}), used: time.Now()}
+
+	go func() {
+		defer close(s.done)
+		defer os.RemoveAll(dir)
+		defer cancel()
+
+		stream, err := h.Cameras.Stream(ctx, o)
+		if err != nil {
+			s.err = err
+			return
+		}
+		cmd := exec.CommandContext(ctx, bin, "-loglevel", "error", "-i", stream.URL, "-c", "copy",
+			"-f", "hls", "-hls_time", fmt.Sprint(segment.Seconds()), "-hls_list_size", fmt.Sprint(list),
+			"-hls_flags", "delete_segments+omit_endlist",
+			"-hls_segment_filename", filepath.Join(dir, "seg%05d.ts"), filepath.Join(dir, "index.m3u8"))
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			s.err = fmt.Errorf("Failed to start %s: %s", bin, err)
+			return
+		}
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		started := time.Now()
+		tick := time.NewTicker(250 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case err := <-exited:
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					err = errors.New("ffmpeg: " + msg)
+				}
+				if err == nil {
+					err = errors.New("Stream ended")
+				}
+				s.err = fmt.Errorf("%s: %w", o.Title, err)
+				return
+			case <-tick.C:
+			}
+
+			select {
+			case <-s.ready:
+				s.mu.Lock()
+				unused := time.Since(s.used)
+				s.mu.Unlock()
+				if unused > idle {
+					Dbg("hls: %s idle, stopping\n", o.Title)
+					cancel()
+					<-exited
+					s.err = errors.New("Stream stopped")
+					return
+				}
+			default:
+				if _, err := os.Stat(filepath.Join(dir, "index.m3u8")); err == nil {
+					close(s.ready)
+				} else if time.Since(started) > hlsStartTimeout {
+					cancel()
+					<-exited
+					s.err = fmt.Errorf("%s: stream did not start within %s", o.Title, hlsStartTimeout)
+					return
+				}
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Close stops every stream
+func (h *HLSServer) Close() error {
+	h.mu.Lock()
+	sessions := h.sessions
+	h.sessions = nil
+	h.mu.Unlock()
+	for _, s := range sessions {
+		s.cancel()
+		<-s.done
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hook names, each run for notifications of one kind. Other kinds run the
+// hook "on-<kind>", e.g. "on-digest".
+const (
+	HookEvent         = "on-event"
+	HookSnapshotSaved = "on-snapshot-saved"
+	HookCameraOffline = "on-camera-offline"
+	HookCameraOnline  = "on-camera-online"
+)
+
+// DefaultHookTimeout bounds a hook command when ExecHooks.Timeout is zero
+const DefaultHookTimeout = 30 * time.Second
+
+var hookNames = map[string]string{
+	NotifyEvent:    HookEvent,
+	NotifySnapshot: HookSnapshotSaved,
+	NotifyOffline:  HookCameraOffline,
+	NotifyOnline:   HookCameraOnline,
+}
+
+// HookName returns the name of the hook run for notifications of kind
+func HookName(kind string) string {
+	if name, ok := hookNames[kind]; ok {
+		return name
+	}
+	return "on-" + kind
+}
+
+// The ExecHooks type is a Notifier running external commands, so behaviour
+// can be added without recompiling. Hooks maps a hook name to the commands
+// run for it, in order; a command is a program followed by its arguments,
+// split on spaces, and is not passed through a shell. Each command gets the
+// notification as JSON on stdin and in these environment variables:
+//
+//	DROPCAM_HOOK        the hook name
+//	DROPCAM_KIND        the notification kind
+//	DROPCAM_CAMERA      the camera uuid
+//	DROPCAM_TITLE       the camera title
+//	DROPCAM_MESSAGE     the message
+//	DROPCAM_TIME        the time, RFC 3339
+//	DROPCAM_EVENT_TYPE  the event type of on-event hooks
+//	DROPCAM_MEDIA       the saved file of on-snapshot-saved hooks
+//	DROPCAM_CLIP_URL    a link to the event's clip, if shared
+//	DROPCAM_IMAGE       a temporary JPEG of the snapshot attached, if any
+type ExecHooks struct {
+	Hooks   map[string][]string `json:"hooks"`
+	Timeout time.Duration       `json:"-"`
+}
+
+// LoadExecHooks reads hooks saved at path as a JSON object of hook names to
+// lists of commands. A missing file yields no hooks.
+func LoadExecHooks(path string) (*ExecHooks, error) {
+	h := &ExecHooks{Hooks: make(map[string][]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &h.Hooks); err != nil {
+		return nil, errors.New("Failed to read hooks: " + err.Error())
+	}
+	return h, nil
+}
+
+// Add appends command to the hook name
+func (h *ExecHooks) Add(name, command string) {
+	if h.Hooks == nil {
+		h.Hooks = make(map[string][]string)
+	}
+	h.Hooks[name] = append(h.Hooks[name], command)
+}
+
+// Has reports whether any command is set for the hook name
+func (h *ExecHooks) Has(name string) bool {
+	return h != nil && len(h.Hooks[name]) > 0
+}
+
+// Notify implements Notifier. Every command of the hook is run; the first
+// error is returned.
+func (h *ExecHooks) Notify(n *Notification) error {
+
+	name := HookName(n.Kind)
+	commands := h.Hooks[name]
+	if len(commands) == 0 {
+		return nil
+	}
+
+	input, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(),
+		"DROPCAM_HOOK="+name,
+		"DROPCAM_KIND="+n.Kind,
+		"DROPCAM_CAMERA="+n.Camera,
+		"DROPCAM_TITLE="+n.Title,
+		"DROPCAM_MESSAGE="+n.Message,
+		"DROPCAM_TIME="+n.Time.Format(time.RFC3339),
+		"DROPCAM_EVENT_TYPE="+n.EventType,
+		"DROPCAM_MEDIA="+n.Media,
+		"DROPCAM_CLIP_URL="+n.ClipURL,
+	)
+	if len(n.Image) > 0 {
+		f, err := ioutil.TempFile("", "dropcam-hook-*.jpg")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(n.Image)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		env = append(env, "DROPCAM_IMAGE="+f.Name())
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	var first error
+	for _, command := range commands {
+		if err := runHook(command, env, input, timeout); err != nil {
+			warnf("hook %s: %s\n", name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func runHook(command string, env []string, input []byte, timeout time.Duration) error {
+
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return fmt.Errorf("Hook %s failed: %s", args[0], err)
+		}
+		return fmt.Errorf("Hook %s failed: %s: %s", args[0], err, msg)
+	}
+	os.Stderr.Write(stderr.Bytes())
+	return nil
+}
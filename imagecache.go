@@ -0,0 +1,167 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultImageCacheTTL is how long a cached snapshot is served before refetching
+const DefaultImageCacheTTL = 5 * time.Second
+
+type imageEntry struct {
+	img     []byte
+	fetched time.Time
+}
+
+type imageCall struct {
+	done chan struct{}
+	img  []byte
+	err  error
+}
+
+// The ImageCache type serves snapshots from memory, and optionally from disk
+// under Dir, for TTL before asking the API again. Concurrent requests for the
+// same camera and width share a single upstream fetch, so many dashboard viewers
+// cost one get_image per interval.
+//
+// ImageCache is also an http.Handler serving /cameras/<uuid>/snapshot.jpg?width=<px>.
+// When Guard is set, callers need the read scope.
+type ImageCache struct {
+	Cameras *Cameras
+	TTL     time.Duration
+	Dir     string
+	Width   int
+	Guard   Guard
+
+	mu       sync.Mutex
+	entries  map[string]imageEntry
+	inflight map[string]*imageCall
+}
+
+func (ic *ImageCache) ttl() time.Duration {
+	if ic.TTL <= 0 {
+		return DefaultImageCacheTTL
+	}
+	return ic.TTL
+}
+
+// Get returns a snapshot of camera o at most TTL old
+func (ic *ImageCache) Get(ctx context.Context, o *Owned, width int) ([]byte, error) {
+
+	key := fmt.Sprintf("%s/%d", o.Uuid, width)
+	now := time.Now()
+
+	ic.mu.Lock()
+	if ic.entries == nil {
+		ic.entries = make(map[string]imageEntry)
+		ic.inflight = make(map[string]*imageCall)
+	}
+	if e, ok := ic.entries[key]; ok && now.Sub(e.fetched) < ic.ttl() {
+		ic.mu.Unlock()
+		return e.img, nil
+	}
+	if call, ok := ic.inflight[key]; ok {
+		ic.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.img, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &imageCall{done: make(chan struct{})}
+	ic.inflight[key] = call
+	ic.mu.Unlock()
+
+	call.img, call.err = ic.fetch(ctx, o, width, key)
+
+	ic.mu.Lock()
+	delete(ic.inflight, key)
+	if call.err == nil {
+		ic.entries[key] = imageEntry{img: call.img, fetched: time.Now()}
+	}
+	ic.mu.Unlock()
+	close(call.done)
+
+	return call.img, call.err
+}
+
+func (ic *ImageCache) fetch(ctx context.Context, o *Owned, width int, key string) ([]byte, error) {
+
+	if ic.Dir != "" {
+		fn := filepath.Join(ic.Dir, filepath.FromSlash(key)+".jpg")
+		if fi, err := os.Stat(fn); err == nil && time.Since(fi.ModTime()) < ic.ttl() {
+			if img, err := ioutil.ReadFile(fn); err == nil {
+				return img, nil
+			}
+		}
+	}
+
+	img, err := ic.Cameras.getImage(ctx, o, width, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if ic.Dir != "" {
+		if err := (DirStorage{Root: ic.Dir}).Put(key+".jpg", bytes.NewReader(img)); err != nil {
+			warnf("image cache: failed to write %s: %s\n", key, err)
+		}
+	}
+	return img, nil
+}
+
+func (ic *ImageCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ic.Guard != nil {
+		Protect(ic.Guard, ScopeRead, http.HandlerFunc(ic.serve)).ServeHTTP(w, r)
+		return
+	}
+	ic.serve(w, r)
+}
+
+func (ic *ImageCache) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "cameras" || parts[2] != "snapshot.jpg" {
+		http.NotFound(w, r)
+		return
+	}
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	o := ic.Cameras.findCamera(parts[1])
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	width := ic.Width
+	if v, err := strconv.Atoi(r.FormValue("width")); err == nil && v > 0 {
+		width = v
+	}
+	if width <= 0 {
+		width = 720
+	}
+
+	img, err := ic.Get(r.Context(), o, width)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ic.ttl().Seconds())))
+	w.Write(img)
+}
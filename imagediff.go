@@ -0,0 +1,79 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+)
+
+// hashSize is the side of the grid PerceptualHash shrinks an image to
+const hashSize = 8
+
+// PerceptualHash returns a 64-bit difference hash of img: the image is
+// shrunk to 9x8 luminance cells and each bit tells whether a cell is brighter
+// than its right neighbour. Frames that look alike, despite JPEG noise,
+// a changed timestamp or a slight exposure change, get hashes a few bits
+// apart; see HashDistance.
+func PerceptualHash(img image.Image) uint64 {
+
+	gray := toGray(img)
+	b := gray.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return 0
+	}
+
+	// average the luminance of each cell of a (hashSize+1) x hashSize grid
+	var cells [hashSize][hashSize + 1]float64
+	for y := 0; y < hashSize; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/hashSize, b.Min.Y+(y+1)*b.Dy()/hashSize
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x <= hashSize; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/(hashSize+1), b.Min.X+(x+1)*b.Dx()/(hashSize+1)
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var sum, n int
+			for py := y0; py < y1 && py < b.Max.Y; py++ {
+				for px := x0; px < x1 && px < b.Max.X; px++ {
+					sum += int(gray.GrayAt(px, py).Y)
+					n++
+				}
+			}
+			if n > 0 {
+				cells[y][x] = float64(sum) / float64(n)
+			}
+		}
+	}
+
+	var h uint64
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			h <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// HashDistance returns the number of bits two perceptual hashes differ in,
+// 0 for frames that look the same and up to 64
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// jpegHash returns the perceptual hash of the encoded image data
+func jpegHash(data []byte) (uint64, bool) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, false
+	}
+	return PerceptualHash(img), true
+}
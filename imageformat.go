@@ -0,0 +1,246 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image formats snapshots can be saved in
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// DetectImageType returns the MIME type of an image from its first bytes,
+// e.g. "image/jpeg", or "application/octet-stream" if it is not recognised
+func DetectImageType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// The GetImageDecoded method is GetImage returning the decoded image and the
+// content type detected from its data, ready for overlays or thumbnails
+func (c *Cameras) GetImageDecoded(ctx context.Context, o *Owned, width int, t time.Time) (image.Image, string, error) {
+
+	data, err := c.getImage(ctx, o, width, t)
+	if err != nil {
+		return nil, "", err
+	}
+	ctype := DetectImageType(data)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ctype, fmt.Errorf("Failed to decode %s snapshot: %w", ctype, err)
+	}
+	return img, ctype, nil
+}
+
+// The ImageOptions type controls how WriteImage and SaveImageAs produce a
+// snapshot. The snapshot is requested Width pixels wide, 720 when zero, from
+// Time, or live when Time is zero or recent. Without Format, Height or
+// Quality, and unless Crop or Aspect must be applied locally, the image is
+// passed on exactly as the servers send it.
+//
+// Otherwise it is encoded in Format, one of FormatJPEG, FormatPNG and
+// FormatWebP (SaveImageAs takes it from the file extension when empty), and,
+// since the servers only offer some widths, scaled locally when it comes back
+// another size; a Height as well fixes both dimensions. Quality applies to
+// JPEG and WebP. WebP is encoded by ffmpeg, the binary FFmpeg or "ffmpeg"
+// from the PATH.
+//
+// Quality, Crop and Aspect are also passed to get_image, so the servers can
+// send small thumbnails or just the part wanted. Crop is a rectangle of the
+// image at Width; Aspect, such as "16:9", is cut from the middle of the
+// image, or of Crop. Servers that ignore them are made up for locally, and
+// the image is then kept at the size of the cut rather than scaled to Width.
+//
+// Fallback, or else Dropcam.WidthFallback, retries at narrower widths when a
+// request times out or is too large; the image is then kept at the width it
+// came back at. Result, when set, receives the dimensions returned.
+type ImageOptions struct {
+	Format   string
+	Width    int
+	Height   int
+	Quality  int
+	Crop     image.Rectangle
+	Aspect   string
+	Time     time.Time
+	FFmpeg   string
+	Fallback *WidthFallback
+	Result   *ImageResult
+}
+
+// The WriteImage method writes a snapshot of camera o to w as described by
+// opts, so it can be streamed into an HTTP response, an upload or a pipe
+func (c *Cameras) WriteImage(ctx context.Context, o *Owned, w io.Writer, opts ImageOptions) error {
+	_, err := c.writeImage(ctx, o, w, opts, false)
+	return err
+}
+
+// writeImage is WriteImage reporting whether the snapshot is new. When
+// skipUnchanged is set and Dedup finds the snapshot identical to the
+// camera's previous one, nothing is written and fresh is false.
+func (c *Cameras) writeImage(ctx context.Context, o *Owned, w io.Writer, opts ImageOptions, skipUnchanged bool) (fresh bool, err error) {
+
+	width := opts.Width
+	if width <= 0 {
+		width = 720
+	}
+	fallback := opts.Fallback
+	if fallback == nil {
+		fallback = c.Dropcam.WidthFallback
+	}
+	if opts.Aspect != "" {
+		if _, err := parseAspect(opts.Aspect); err != nil {
+			return false, err
+		}
+	}
+	requested := width
+	data, fresh, width, err := c.fetchImageFallback(ctx, o, width, opts.Time, opts.query(), fallback, opts.Result)
+	if err != nil {
+		return false, err
+	}
+	if !fresh && skipUnchanged {
+		return false, nil
+	}
+	if fresh {
+		c.Dropcam.updateLatest(o, data)
+	}
+
+	q := opts.query().at(requested, width)
+	shaped := true
+	if !q.crop.Empty() || q.aspect != "" {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		shaped = err == nil && q.shaped(image.Rect(0, 0, cfg.Width, cfg.Height))
+	}
+	if opts.Format != "" || opts.Height > 0 || opts.Quality > 0 || !shaped {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return false, fmt.Errorf("Failed to decode %s snapshot: %w", DetectImageType(data), err)
+		}
+		img = q.apply(img)
+		b := img.Bounds()
+		cut := !q.crop.Empty() || q.aspect != ""
+		if opts.Height > 0 && (b.Dx() != width || b.Dy() != opts.Height) {
+			img = resize(toRGBA(img), width, opts.Height)
+		} else if opts.Width > 0 && !cut && b.Dx() != width {
+			img = resize(toRGBA(img), width, b.Dy()*width/b.Dx())
+		}
+
+		format := opts.Format
+		if format == "" {
+			format = FormatJPEG
+		}
+		if data, err = EncodeImage(ctx, img, format, opts); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return false, err
+	}
+	return fresh, nil
+}
+
+// The SaveImageAs method saves a snapshot of camera o to path as described by
+// opts, written as Dropcam.Files says. A zero or recent st asks for the live image, an earlier one for the
+// recorded frame at that time.
+func (c *Cameras) SaveImageAs(ctx context.Context, o *Owned, path string, st time.Time, opts ImageOptions) error {
+
+	if opts.Format == "" {
+		opts.Format = formatOf(path)
+	}
+	opts.Time = st
+
+	var buf bytes.Buffer
+	if err := c.WriteImage(ctx, o, &buf, opts); err != nil {
+		return err
+	}
+	written, err := c.Dropcam.Files.writeFile(path, buf.Bytes())
+	if err != nil || written == "" {
+		return err
+	}
+	Dbg("wrote %s image to \"%s\"\n", opts.Format, written)
+	return nil
+}
+
+// formatOf returns the image format implied by the extension of path
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return FormatPNG
+	case ".webp":
+		return FormatWebP
+	}
+	return FormatJPEG
+}
+
+// EncodeImage encodes img in format, using the Quality and FFmpeg of opts
+func EncodeImage(ctx context.Context, img image.Image, format string, opts ImageOptions) ([]byte, error) {
+
+	switch format {
+	case FormatJPEG, "jpg":
+		quality := opts.Quality
+		if quality <= 0 {
+			quality = DefaultJPEGQuality
+		}
+		return encodeJPEG(img, quality)
+
+	case FormatPNG:
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case FormatWebP:
+		return encodeWebP(ctx, img, opts)
+	}
+	return nil, errors.New("Unknown image format: " + format)
+}
+
+// encodeWebP has ffmpeg convert img, passed as PNG, to WebP
+func encodeWebP(ctx context.Context, img image.Image, opts ImageOptions) ([]byte, error) {
+
+	var in bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		return nil, err
+	}
+	bin := opts.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	quality := opts.Quality
+	if quality <= 0 {
+		quality = 80
+	}
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "-loglevel", "error", "-f", "png_pipe", "-i", "pipe:0",
+		"-c:v", "libwebp", "-quality", strconv.Itoa(quality), "-f", "webp", "pipe:1")
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("WebP encoding failed: %w", err)
+		}
+		return nil, errors.New("WebP encoding failed: ffmpeg: " + msg)
+	}
+	return out.Bytes(), nil
+}
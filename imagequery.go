@@ -0,0 +1,116 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"fmt"
+	"image"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// imageQuery is what get_image is asked for besides the width and time: the
+// ImageOptions the servers can apply themselves
+type imageQuery struct {
+	quality int
+	crop    image.Rectangle
+	aspect  string
+}
+
+// query returns what opts asks the servers for
+func (opts ImageOptions) query() imageQuery {
+	return imageQuery{quality: opts.Quality, crop: opts.Crop, aspect: opts.Aspect}
+}
+
+// at returns q for an image width pixels wide rather than requested, with
+// the crop rectangle scaled to match
+func (q imageQuery) at(requested, width int) imageQuery {
+	if q.crop.Empty() || requested <= 0 || requested == width {
+		return q
+	}
+	scale := func(n int) int { return n * width / requested }
+	q.crop = image.Rect(scale(q.crop.Min.X), scale(q.crop.Min.Y), scale(q.crop.Max.X), scale(q.crop.Max.Y))
+	return q
+}
+
+// set adds q to the parameters v of a get_image request
+func (q imageQuery) set(v url.Values) {
+	if q.quality > 0 {
+		v.Set("quality", strconv.Itoa(q.quality))
+	}
+	if !q.crop.Empty() {
+		v.Set("crop", fmt.Sprintf("%d,%d,%d,%d", q.crop.Min.X, q.crop.Min.Y, q.crop.Dx(), q.crop.Dy()))
+	}
+	if q.aspect != "" {
+		v.Set("aspect", q.aspect)
+	}
+}
+
+// key tells apart the images of different queries remembered for Dedup
+func (q imageQuery) key() string {
+	if q == (imageQuery{}) {
+		return ""
+	}
+	return fmt.Sprintf("/%d/%v/%s", q.quality, q.crop, q.aspect)
+}
+
+// parseAspect parses an aspect ratio such as "16:9" or "1.5"
+func parseAspect(s string) (float64, error) {
+
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		h = "1"
+	}
+	fw, err1 := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	fh, err2 := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if err1 != nil || err2 != nil || fw <= 0 || fh <= 0 {
+		return 0, fmt.Errorf("Bad aspect ratio %q", s)
+	}
+	return fw / fh, nil
+}
+
+// shaped reports whether an image of size b already has the crop size and
+// aspect of q
+func (q imageQuery) shaped(b image.Rectangle) bool {
+
+	if !q.crop.Empty() && b.Size() != q.crop.Size() {
+		return false
+	}
+	if ratio, err := parseAspect(q.aspect); err == nil {
+		want := int(float64(b.Dy())*ratio + 0.5)
+		if want < b.Dx()-1 || want > b.Dx()+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// apply crops img to the crop rectangle and aspect of q, for servers that
+// did not. The aspect is cut from the middle of the image.
+func (q imageQuery) apply(img image.Image) image.Image {
+
+	if q.shaped(img.Bounds()) {
+		return img
+	}
+	if b := img.Bounds(); !q.crop.Empty() && b.Size() != q.crop.Size() {
+		if r := q.crop.Add(b.Min).Intersect(b); !r.Empty() {
+			img = toRGBA(img).SubImage(r)
+		}
+	}
+	ratio, err := parseAspect(q.aspect)
+	if err != nil {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if want := int(float64(h)*ratio + 0.5); want < w {
+		w = want
+	} else {
+		h = int(float64(w)/ratio + 0.5)
+	}
+	x, y := b.Min.X+(b.Dx()-w)/2, b.Min.Y+(b.Dy()-h)/2
+	return toRGBA(img).SubImage(image.Rect(x, y, x+w, y+h))
+}
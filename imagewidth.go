@@ -0,0 +1,96 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"net"
+	"time"
+)
+
+// The WidthFallback type lets snapshots be requested at smaller widths when
+// the link cannot carry the larger ones. After the requested width fails,
+// each of Widths narrower than it is tried in turn, e.g. 720 and 480 after
+// 1080. An attempt fails over when it takes longer than Timeout or, when
+// MaxBytes is set, returns a larger image; the last width's image is kept
+// whatever its size.
+type WidthFallback struct {
+	Widths   []int
+	Timeout  time.Duration
+	MaxBytes int
+}
+
+// The ImageResult type reports what a snapshot request returned: the width
+// first asked for, the width of the attempt that succeeded, the decoded
+// dimensions of the image and the number of attempts made
+type ImageResult struct {
+	Requested int
+	Width     int
+	Height    int
+	Attempts  int
+	Bytes     int
+}
+
+// widths returns the widths to try for a request of width
+func (wf *WidthFallback) widths(width int) []int {
+	widths := []int{width}
+	if wf == nil {
+		return widths
+	}
+	for _, w := range wf.Widths {
+		if w > 0 && w < widths[len(widths)-1] {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// fetchImageFallback fetches a snapshot of o at width, falling back to the
+// narrower widths of wf, asking for q scaled to each. It returns the width
+// the image was fetched at.
+func (c *Cameras) fetchImageFallback(ctx context.Context, o *Owned, width int, st time.Time, q imageQuery, wf *WidthFallback, res *ImageResult) (img []byte, fresh bool, used int, err error) {
+
+	widths := wf.widths(width)
+	for i, w := range widths {
+		last := i == len(widths)-1
+
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if wf != nil && wf.Timeout > 0 && !last {
+			actx, cancel = context.WithTimeout(ctx, wf.Timeout)
+		}
+		img, fresh, err = c.fetchImage(actx, o, w, st, q.at(width, w))
+		cancel()
+		used = w
+
+		switch {
+		case err != nil && !last && ctx.Err() == nil && isTimeout(err):
+			Dbg("%s: snapshot at width %d timed out, trying %d\n", o.Title, w, widths[i+1])
+			continue
+		case err == nil && !last && wf.MaxBytes > 0 && len(img) > wf.MaxBytes:
+			Dbg("%s: snapshot at width %d is %d bytes, trying %d\n", o.Title, w, len(img), widths[i+1])
+			continue
+		}
+		if res != nil {
+			*res = ImageResult{Requested: width, Width: w, Attempts: i + 1, Bytes: len(img)}
+			if cfg, _, cerr := image.DecodeConfig(bytes.NewReader(img)); err == nil && cerr == nil {
+				res.Width, res.Height = cfg.Width, cfg.Height
+			}
+		}
+		return img, fresh, used, err
+	}
+	return img, fresh, used, err
+}
+
+// isTimeout reports whether err is a request that ran out of time
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,322 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// ErrCorrupt is wrapped by the errors of objects that fail verification
+var ErrCorrupt = errors.New("Corrupt Object")
+
+// DefaultVerifyBuffer is the largest object VerifiedStorage checks before
+// returning it when VerifiedStorage.MaxBuffer is zero
+const DefaultVerifyBuffer = 8 << 20
+
+// The CorruptError type is an object whose size or content does not match
+// what was recorded when it was stored
+type CorruptError struct {
+	Key    string
+	Reason string
+	Report CorruptionReport
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("%s is corrupt: %s", e.Key, e.Reason)
+}
+
+// Unwrap returns ErrCorrupt
+func (e *CorruptError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// The CorruptionReport type records a corrupt object found by a
+// VerifiedStorage. Quarantined is false when the object could not be moved
+// aside, with the reason in QuarantineError.
+type CorruptionReport struct {
+	Key             string    `json:"key"`
+	Camera          string    `json:"camera,omitempty"`
+	Taken           time.Time `json:"taken,omitempty"`
+	Reason          string    `json:"reason"`
+	WantSize        int64     `json:"want_size"`
+	WantSHA256      string    `json:"want_sha256"`
+	Found           time.Time `json:"found"`
+	Quarantined     bool      `json:"quarantined"`
+	QuarantineError string    `json:"quarantine_error,omitempty"`
+}
+
+// The VerifiedStorage type is a Storage that checks the objects read from it
+// against the size and SHA-256 recorded in Catalog, or against the digest in
+// the name of ContentStore keys, so bit rot and truncated writes are caught
+// before they reach a gallery or timelapse. Wrap the storage whose bytes the
+// catalog hashed; for an EncryptedStorage that is the one below it.
+//
+// Objects up to MaxBuffer bytes, DefaultVerifyBuffer when zero, are checked
+// before Get returns, so a corrupt snapshot is never handed out. Larger
+// objects are checked as they are read and the last Read returns the
+// *CorruptError instead of io.EOF; a reader closed early is not checked.
+//
+// A corrupt object is copied to Quarantine under its own key, with the report
+// as "<key>.report.json", then deleted from Storage and Catalog so it is not
+// served again. Without Quarantine it is left in place. Every report is passed
+// to OnCorrupt when set and kept for Reports.
+type VerifiedStorage struct {
+	Storage    Storage
+	Catalog    *Catalog
+	Quarantine Storage
+	MaxBuffer  int64
+	OnCorrupt  func(CorruptionReport)
+
+	mu      sync.Mutex
+	reports []CorruptionReport
+}
+
+// Put stores r under key unchanged
+func (vs *VerifiedStorage) Put(key string, r io.Reader) error {
+	return vs.Storage.Put(key, r)
+}
+
+// Get opens the object stored under key, verifying it when its size or hash
+// is known
+func (vs *VerifiedStorage) Get(key string) (io.ReadCloser, error) {
+
+	rec, err := vs.record(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := vs.Storage.Get(key)
+	if err != nil || rec == nil {
+		return rc, err
+	}
+
+	max := vs.MaxBuffer
+	if max <= 0 {
+		max = DefaultVerifyBuffer
+	}
+	if rec.Size > max {
+		return &verifyReader{vs: vs, rec: rec, r: rc, c: rc, h: sha256.New()}, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, max+1))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		// larger than recorded, or of unknown size: check the rest as it is read
+		r := io.MultiReader(bytes.NewReader(data), rc)
+		return &verifyReader{vs: vs, rec: rec, r: r, c: rc, h: sha256.New()}, nil
+	}
+	rc.Close()
+	if err := vs.check(rec, int64(len(data)), sha256.Sum256(data)); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete removes the object stored under key
+func (vs *VerifiedStorage) Delete(key string) error {
+	return deleteKey(vs.Storage, key)
+}
+
+// Verify reads the object stored under key and checks it, quarantining it if
+// it is corrupt
+func (vs *VerifiedStorage) Verify(ctx context.Context, key string) error {
+
+	rec, err := vs.record(ctx, key)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return fmt.Errorf("%s has no catalog record or content hash", key)
+	}
+	rc, err := vs.Storage.Get(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return err
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return vs.check(rec, n, sum)
+}
+
+// Scan verifies every object in Catalog matching q and returns the reports of
+// those found corrupt. Objects missing from Storage are reported as corrupt
+// too. It stops at the first other error.
+func (vs *VerifiedStorage) Scan(ctx context.Context, q CatalogQuery) ([]CorruptionReport, error) {
+
+	if vs.Catalog == nil {
+		return nil, errors.New("Scan needs a Catalog")
+	}
+	recs, err := vs.Catalog.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []CorruptionReport
+	for _, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+		err := vs.Verify(ctx, rec.Key)
+		if errors.Is(err, fs.ErrNotExist) {
+			rec := rec
+			err = vs.corrupt(&rec, "missing from storage")
+		}
+		var ce *CorruptError
+		if errors.As(err, &ce) {
+			found = append(found, ce.Report)
+			continue
+		}
+		if err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// Reports returns the reports of the corrupt objects found so far, oldest first
+func (vs *VerifiedStorage) Reports() []CorruptionReport {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	return append([]CorruptionReport(nil), vs.reports...)
+}
+
+// record returns what key should hold, from Catalog or its content-addressed
+// name, or nil if nothing is known about it
+func (vs *VerifiedStorage) record(ctx context.Context, key string) (*MediaRecord, error) {
+
+	if vs.Catalog != nil {
+		rec, err := vs.Catalog.Lookup(ctx, key)
+		if err != nil || rec != nil {
+			return rec, err
+		}
+	}
+	if h := contentHash(key); h != "" {
+		return &MediaRecord{Key: key, Size: -1, SHA256: h}, nil
+	}
+	return nil, nil
+}
+
+// check compares an object read back with its record
+func (vs *VerifiedStorage) check(rec *MediaRecord, size int64, sum [sha256.Size]byte) error {
+
+	switch got := hex.EncodeToString(sum[:]); {
+	case rec.Size >= 0 && size < rec.Size:
+		return vs.corrupt(rec, fmt.Sprintf("truncated to %d of %d bytes", size, rec.Size))
+	case rec.Size >= 0 && size > rec.Size:
+		return vs.corrupt(rec, fmt.Sprintf("%d bytes, %d were stored", size, rec.Size))
+	case rec.SHA256 != "" && got != rec.SHA256:
+		return vs.corrupt(rec, "content hashes to "+got)
+	}
+	return nil
+}
+
+// corrupt reports and quarantines the object of rec and returns its error
+func (vs *VerifiedStorage) corrupt(rec *MediaRecord, reason string) error {
+
+	report := CorruptionReport{
+		Key:        rec.Key,
+		Camera:     rec.Camera,
+		Taken:      rec.Time,
+		Reason:     reason,
+		WantSize:   rec.Size,
+		WantSHA256: rec.SHA256,
+		Found:      time.Now(),
+	}
+	if vs.Quarantine != nil {
+		if err := vs.quarantine(&report); err != nil {
+			report.Quarantined, report.QuarantineError = false, err.Error()
+		}
+	}
+	warnf("integrity: %s is corrupt: %s\n", rec.Key, reason)
+
+	vs.mu.Lock()
+	vs.reports = append(vs.reports, report)
+	vs.mu.Unlock()
+	if vs.OnCorrupt != nil {
+		vs.OnCorrupt(report)
+	}
+	return &CorruptError{Key: rec.Key, Reason: reason, Report: report}
+}
+
+// quarantine moves the object of report to Quarantine and drops its record
+func (vs *VerifiedStorage) quarantine(report *CorruptionReport) error {
+
+	if rc, err := vs.Storage.Get(report.Key); err == nil {
+		err = vs.Quarantine.Put(report.Key, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	report.Quarantined = true
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := vs.Quarantine.Put(report.Key+".report.json", bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := vs.Delete(report.Key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if vs.Catalog != nil {
+		return vs.Catalog.Remove(context.Background(), report.Key)
+	}
+	return nil
+}
+
+// verifyReader checks a large object as it is read
+type verifyReader struct {
+	vs   *VerifiedStorage
+	rec  *MediaRecord
+	r    io.Reader
+	c    io.Closer
+	h    hash.Hash
+	n    int64
+	done bool
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+	if err == io.EOF && !v.done {
+		v.done = true
+		var sum [sha256.Size]byte
+		copy(sum[:], v.h.Sum(nil))
+		if cerr := v.vs.check(v.rec, v.n, sum); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+func (v *verifyReader) Close() error {
+	return v.c.Close()
+}
@@ -0,0 +1,240 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// InventoryVersion is the version of the inventory documents ExportInventory
+// writes
+const InventoryVersion = 1
+
+// The InventoryCamera type is the configuration of one camera in an
+// Inventory. Zones is nil for a camera without activity zones, and empty for
+// one that has none set.
+type InventoryCamera struct {
+	UUID          string                 `json:"camera_uuid"`
+	Title         string                 `json:"title"`
+	MAC           string                 `json:"mac_address,omitempty"`
+	Serial        string                 `json:"serial_number,omitempty"`
+	Timezone      string                 `json:"timezone,omitempty"`
+	Properties    map[string]interface{} `json:"properties"`
+	Zones         []ActivityZone         `json:"zones"`
+	Schedules     []Schedule             `json:"schedules,omitempty"`
+	Notifications []NotificationTarget   `json:"notifications,omitempty"`
+}
+
+// The Inventory type is the configuration of every camera of an account, as
+// ExportInventory writes it for backups and ImportInventory applies it
+type Inventory struct {
+	Version  int               `json:"version"`
+	Exported time.Time         `json:"exported"`
+	Cameras  []InventoryCamera `json:"cameras"`
+}
+
+// The InventoryCodec interface serializes an Inventory. JSONInventory is the
+// one used by default; a YAML codec can convert the JSON form, as
+// sigs.k8s.io/yaml does, so the field names stay the same.
+type InventoryCodec interface {
+	Encode(w io.Writer, inv *Inventory) error
+	Decode(r io.Reader, inv *Inventory) error
+}
+
+type jsonInventory struct{}
+
+func (jsonInventory) Encode(w io.Writer, inv *Inventory) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inv)
+}
+
+func (jsonInventory) Decode(r io.Reader, inv *Inventory) error {
+	return json.NewDecoder(r).Decode(inv)
+}
+
+// JSONInventory is the InventoryCodec writing indented JSON
+var JSONInventory InventoryCodec = jsonInventory{}
+
+// The Inventory method reads the configuration of every owned camera
+// selected by filter, or of all of them when filter is nil: properties,
+// activity zones, schedules and notification targets
+func (c *Cameras) Inventory(ctx context.Context, filter CameraFilter) (*Inventory, error) {
+
+	inv := &Inventory{Version: InventoryVersion, Exported: time.Now()}
+	for i := range c.Cam {
+		o := &c.Cam[i]
+		if filter != nil && !filter(o) {
+			continue
+		}
+		info, err := c.CameraInfo(ctx, o)
+		if err != nil {
+			return nil, fmt.Errorf("Inventory of %s Failed: %w", o.Title, err)
+		}
+		ic := InventoryCamera{
+			UUID:          o.Uuid,
+			Title:         info.Title,
+			MAC:           o.MacAddress,
+			Serial:        info.SerialNumber,
+			Timezone:      o.Timezone,
+			Properties:    make(map[string]interface{}, len(info.Properties)),
+			Schedules:     info.Schedules,
+			Notifications: info.Notifications,
+		}
+		for _, p := range info.Properties {
+			ic.Properties[p.Name] = p.Value
+		}
+
+		zones, err := c.GetActivityZones(ctx, o)
+		switch {
+		case errors.Is(err, ErrUnsupportedCapability), errors.Is(err, ErrNotFound):
+			// no zones on this camera or backend
+		case err != nil:
+			return nil, fmt.Errorf("Inventory of %s Failed: %w", o.Title, err)
+		default:
+			// zone ids are the camera's own; zones are matched by name
+			ic.Zones = make([]ActivityZone, len(zones))
+			for j, z := range zones {
+				z.Id = 0
+				ic.Zones[j] = z
+			}
+		}
+		inv.Cameras = append(inv.Cameras, ic)
+	}
+	return inv, nil
+}
+
+// The ExportInventory method writes the Inventory of the owned cameras
+// selected by filter, or of all of them when filter is nil, to w with codec,
+// JSONInventory when nil
+func (c *Cameras) ExportInventory(ctx context.Context, w io.Writer, codec InventoryCodec, filter CameraFilter) error {
+
+	inv, err := c.Inventory(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if codec == nil {
+		codec = JSONInventory
+	}
+	return codec.Encode(w, inv)
+}
+
+// The InventoryImport type controls ImportInventory. Targets clones the
+// configuration of a camera of the inventory, by uuid, to the cameras listed
+// instead, each a uuid, MAC address, title or name of a group in Groups.
+// With DryRun set nothing is changed.
+type InventoryImport struct {
+	Codec   InventoryCodec
+	Targets map[string][]string
+	Groups  *Groups
+	DryRun  bool
+}
+
+// The ImportInventory method reads an inventory from r and applies it:
+// each camera's writable properties and, for cameras with activity zones,
+// its zones, matched by name, replacing any others. A camera of the
+// inventory is applied to the cameras opts.Targets lists for it, or else to
+// the camera with its uuid or, failing that, its MAC address, so settings
+// survive a camera being replaced. It returns what differed, as a Reconciler
+// would, fixed unless opts.DryRun is set. Schedules and notification targets
+// are kept in inventories for reference only, as they cannot be set here.
+// Cameras of the inventory matching none are reported in the error after the
+// rest are applied.
+func (c *Cameras) ImportInventory(ctx context.Context, r io.Reader, opts InventoryImport) ([]Drift, error) {
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSONInventory
+	}
+	inv := new(Inventory)
+	if err := codec.Decode(r, inv); err != nil {
+		return nil, errors.New("Failed to read inventory: " + err.Error())
+	}
+	if inv.Version > InventoryVersion {
+		return nil, fmt.Errorf("Inventory version %d is newer than %d", inv.Version, InventoryVersion)
+	}
+
+	// clones come last, to override the cameras' own entries
+	state := &DesiredState{}
+	var clones []DesiredCamera
+	var unmatched []string
+	for _, ic := range inv.Cameras {
+		targets, err := c.inventoryTargets(ic, opts)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) == 0 {
+			unmatched = append(unmatched, fmt.Sprintf("%s (%s)", ic.Title, ic.UUID))
+			continue
+		}
+		dc := DesiredCamera{Properties: make(map[string]string, len(ic.Properties)), Zones: ic.Zones}
+		for name, v := range ic.Properties {
+			if !readOnlyProperties[name] {
+				dc.Properties[name] = propertyString(v)
+			}
+		}
+		_, cloned := opts.Targets[ic.UUID]
+		for _, o := range targets {
+			tc := dc
+			tc.Camera = o.Uuid
+			if tc.Zones != nil && requireCapability("Import Inventory", o, "activity zones", Capabilities.SupportsZones) != nil {
+				Dbg("inventory: %s has no activity zones, not importing those of %s\n", o.Title, ic.Title)
+				tc.Zones = nil
+			}
+			if cloned {
+				clones = append(clones, tc)
+			} else {
+				state.Cameras = append(state.Cameras, tc)
+			}
+		}
+	}
+	state.Cameras = append(state.Cameras, clones...)
+
+	rc := &Reconciler{Cameras: c, State: state, Correct: !opts.DryRun}
+	drift, err := rc.Reconcile(ctx)
+	if err == nil && len(unmatched) > 0 {
+		err = fmt.Errorf("No camera matches %d cameras of the inventory: %v", len(unmatched), unmatched)
+	}
+	return drift, err
+}
+
+// inventoryTargets returns the owned cameras ic of an inventory applies to
+func (c *Cameras) inventoryTargets(ic InventoryCamera, opts InventoryImport) ([]*Owned, error) {
+
+	if names, ok := opts.Targets[ic.UUID]; ok {
+		var targets []*Owned
+		for _, name := range names {
+			if o, err := c.ByMAC(name); err == nil {
+				targets = append(targets, o)
+				continue
+			}
+			cams, err := c.Select(opts.Groups, name)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, cams...)
+		}
+		return targets, nil
+	}
+
+	for i := range c.Cam {
+		if c.Cam[i].Uuid == ic.UUID {
+			return []*Owned{&c.Cam[i]}, nil
+		}
+	}
+	if want := normalizeMAC(ic.MAC); want != "" {
+		for i := range c.Cam {
+			if normalizeMAC(c.Cam[i].MacAddress) == want {
+				return []*Owned{&c.Cam[i]}, nil
+			}
+		}
+	}
+	return nil, nil
+}
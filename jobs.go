@@ -0,0 +1,376 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Job type is a task a Jobs runs every Interval, such as capturing a
+// camera's snapshot. Runs land on the phase of Camera, or of Name when Camera
+// is empty, within each interval, as PollSchedule places them, so jobs for
+// different cameras do not all fire together.
+type Job struct {
+	Name     string
+	Camera   string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// The JobState type is what a Jobs knows about one of its jobs. LastError is
+// the error of the last run, empty if it succeeded, and LastSuccess when a
+// run last succeeded.
+type JobState struct {
+	Name         string        `json:"name"`
+	Camera       string        `json:"camera_uuid,omitempty"`
+	Interval     time.Duration `json:"interval"`
+	Paused       bool          `json:"paused"`
+	Running      bool          `json:"running"`
+	LastRun      time.Time     `json:"last_run,omitempty"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastError    string        `json:"last_error,omitempty"`
+	LastSuccess  time.Time     `json:"last_success,omitempty"`
+	NextRun      time.Time     `json:"next_run,omitempty"`
+	Backoff      time.Duration `json:"backoff,omitempty"`
+	Runs         int64         `json:"runs"`
+	Failures     int64         `json:"failures"`
+}
+
+// The Jobs type runs capture jobs on their schedules until stopped, and lets
+// them be paused, resumed and run on demand without a restart. A paused job
+// is skipped at its scheduled times but still runs when triggered.
+//
+// Jobs is also an http.Handler serving the admin API, with paths relative to
+// where it is mounted:
+//
+//	GET  /jobs               the state of every job
+//	GET  /jobs/<name>        the state of one job
+//	POST /jobs/<name>/pause  stop running the job on schedule
+//	POST /jobs/<name>/resume run it on schedule again
+//	POST /jobs/<name>/run    run it now
+//
+// Reading needs the read scope and changing the admin scope from Guard; with
+// no Guard every request is refused.
+//
+// With Backoff set, before Start, jobs for a camera that fail because it is
+// offline, or keep failing, run less often until they succeed again; the
+// stretched interval is in JobState.Backoff.
+type Jobs struct {
+	Guard   Guard
+	Backoff *PollBackoff
+
+	mu      sync.Mutex
+	jobs    map[string]*jobEntry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+}
+
+type jobEntry struct {
+	job     Job
+	state   JobState
+	trigger chan struct{}
+}
+
+// Add adds job, starting it at once if the Jobs is running. Names must be unique.
+func (js *Jobs) Add(job Job) error {
+
+	if job.Name == "" || job.Run == nil || job.Interval <= 0 {
+		return errors.New("Job needs a Name, a Run function and a positive Interval")
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.jobs == nil {
+		js.jobs = make(map[string]*jobEntry)
+	}
+	if _, ok := js.jobs[job.Name]; ok {
+		return fmt.Errorf("Job %q already exists", job.Name)
+	}
+	e := &jobEntry{
+		job:     job,
+		state:   JobState{Name: job.Name, Camera: job.Camera, Interval: job.Interval},
+		trigger: make(chan struct{}, 1),
+	}
+	js.jobs[job.Name] = e
+	if js.started {
+		js.wg.Add(1)
+		go js.run(js.ctx, e)
+	}
+	return nil
+}
+
+// Start runs every job on its schedule in the background
+func (js *Jobs) Start() error {
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.started {
+		return errors.New("Jobs already started")
+	}
+	js.ctx, js.cancel = context.WithCancel(context.Background())
+	js.started = true
+	for _, e := range js.jobs {
+		js.wg.Add(1)
+		go js.run(js.ctx, e)
+	}
+	return nil
+}
+
+// Stop cancels the running jobs and waits for them to return
+func (js *Jobs) Stop() {
+	js.mu.Lock()
+	if !js.started {
+		js.mu.Unlock()
+		return
+	}
+	js.cancel()
+	js.started = false
+	js.mu.Unlock()
+	js.wg.Wait()
+}
+
+// Pause stops job name from running on its schedule
+func (js *Jobs) Pause(name string) error {
+	return js.setPaused(name, true)
+}
+
+// Resume runs job name on its schedule again
+func (js *Jobs) Resume(name string) error {
+	return js.setPaused(name, false)
+}
+
+func (js *Jobs) setPaused(name string, paused bool) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	e, err := js.entry(name)
+	if err != nil {
+		return err
+	}
+	e.state.Paused = paused
+	return nil
+}
+
+// Trigger runs job name as soon as it is not already running, paused or not.
+// Triggers while a run is pending are merged into it.
+func (js *Jobs) Trigger(name string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	e, err := js.entry(name)
+	if err != nil {
+		return err
+	}
+	if !js.started {
+		return errors.New("Jobs not started")
+	}
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// State returns the state of job name
+func (js *Jobs) State(name string) (JobState, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	e, err := js.entry(name)
+	if err != nil {
+		return JobState{}, err
+	}
+	return e.state, nil
+}
+
+// States returns the state of every job, by name
+func (js *Jobs) States() []JobState {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	states := make([]JobState, 0, len(js.jobs))
+	for _, e := range js.jobs {
+		states = append(states, e.state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Backlog returns the number of jobs behind schedule: those still running,
+// or not yet started, a whole interval after their scheduled time
+func (js *Jobs) Backlog() int {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for _, e := range js.jobs {
+		if !e.state.NextRun.IsZero() && now.Sub(e.state.NextRun) > e.job.Interval {
+			n++
+		}
+	}
+	return n
+}
+
+// entry returns the job called name; js.mu must be held
+func (js *Jobs) entry(name string) (*jobEntry, error) {
+	e, ok := js.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("No job %q: %w", name, ErrNotFound)
+	}
+	return e, nil
+}
+
+func (js *Jobs) run(ctx context.Context, e *jobEntry) {
+	defer js.wg.Done()
+
+	key := e.job.Camera
+	if key == "" {
+		key = e.job.Name
+	}
+	sched := PollSchedule{UUID: key, Interval: e.job.Interval}
+	var backoff *PollBackoff
+	if e.job.Camera != "" {
+		backoff = js.Backoff
+	}
+	var ps pollState
+	var wait time.Duration
+
+	for {
+		next := sched.Next(time.Now())
+		if wait > 0 {
+			next = time.Now().Add(wait)
+		}
+		js.mu.Lock()
+		e.state.NextRun = next
+		e.state.Backoff = wait
+		js.mu.Unlock()
+
+		t := time.NewTimer(time.Until(next))
+		triggered := false
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		case <-e.trigger:
+			t.Stop()
+			triggered = true
+		}
+
+		js.mu.Lock()
+		if e.state.Paused && !triggered {
+			js.mu.Unlock()
+			continue
+		}
+		e.state.Running = true
+		js.mu.Unlock()
+
+		start := time.Now()
+		err := e.job.Run(ctx)
+		if ctx.Err() == nil {
+			wait = backoff.next(&ps, e.job.Interval, err)
+		}
+
+		js.mu.Lock()
+		e.state.Running = false
+		e.state.LastRun = start
+		e.state.LastDuration = time.Since(start)
+		e.state.Runs++
+		e.state.LastError = ""
+		if err == nil {
+			e.state.LastSuccess = start
+		} else {
+			e.state.Failures++
+			e.state.LastError = err.Error()
+			warnf("job %s: %s\n", e.job.Name, err)
+		}
+		js.mu.Unlock()
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (js *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if js.Guard == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	scope := ScopeRead
+	if r.Method != "GET" && r.Method != "HEAD" {
+		scope = ScopeAdmin
+	}
+	Protect(js.Guard, scope, http.HandlerFunc(js.serve)).ServeHTTP(w, r)
+}
+
+func (js *Jobs) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		writeJSON(w, js.States())
+		return
+	}
+
+	name := parts[1]
+	if len(parts) == 3 {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var err error
+		switch parts[2] {
+		case "pause":
+			err = js.Pause(name)
+		case "resume":
+			err = js.Resume(name)
+		case "run":
+			err = js.Trigger(name)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	st, err := js.State(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, st)
+}
+
+// SnapshotJob returns a job saving a snapshot of camera o width pixels wide
+// into dir every interval, named <uuid>-<yyyymmdd-hhmmss>.jpg. With Dedup
+// set on the Dropcam, unchanged frames are not saved.
+func SnapshotJob(c *Cameras, o *Owned, dir string, width int, interval time.Duration) Job {
+	return Job{
+		Name:     "snapshot-" + o.Uuid,
+		Camera:   o.Uuid,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			now := time.Now()
+			fn := filepath.Join(dir, o.Uuid+"-"+now.Format("20060102-150405")+".jpg")
+			_, err := c.SaveNewImage(ctx, o, fn, width, now)
+			return err
+		},
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"path/filepath"
+)
+
+// LatestName is the file under Dropcam.LatestDir/<uuid> holding the newest snapshot
+const LatestName = "latest.jpg"
+
+// The LatestPath method returns the path of camera o's newest snapshot, or ""
+// when LatestDir is not set
+func (d *Dropcam) LatestPath(o *Owned) string {
+	if d.LatestDir == "" {
+		return ""
+	}
+	return filepath.Join(d.LatestDir, o.Uuid, LatestName)
+}
+
+// updateLatest replaces camera o's latest snapshot with img. Readers see
+// either the old or the new file, never a partial one.
+func (d *Dropcam) updateLatest(o *Owned, img []byte) {
+	if d.LatestDir == "" {
+		return
+	}
+	if err := (DirStorage{Root: d.LatestDir}).Put(o.Uuid+"/"+LatestName, bytes.NewReader(img)); err != nil {
+		warnf("failed to update latest image for %s: %s\n", o.Title, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The CameraLimit type bounds how hard requests drive a single camera.
+// MaxConcurrent caps the number of in-flight requests for the camera (0 is
+// unlimited) and MinInterval is the minimum spacing between request starts.
+type CameraLimit struct {
+	MaxConcurrent int
+	MinInterval   time.Duration
+}
+
+type cameraState struct {
+	sem  chan struct{}
+	next time.Time
+}
+
+// cameraLimiter enforces CameraLimits for every request issued by a Dropcam
+type cameraLimiter struct {
+	mu     sync.Mutex
+	states map[string]*cameraState
+}
+
+func newCameraLimiter() *cameraLimiter {
+	return &cameraLimiter{states: make(map[string]*cameraState)}
+}
+
+// acquire blocks until a request to camera uuid is allowed under lim and
+// returns the function that must be called once the request completes.
+// It fails if ctx is done first.
+func (l *cameraLimiter) acquire(ctx context.Context, uuid string, lim CameraLimit) (func(), error) {
+
+	if l == nil || uuid == "" || (lim.MaxConcurrent <= 0 && lim.MinInterval <= 0) {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	st, ok := l.states[uuid]
+	if !ok {
+		st = new(cameraState)
+		if lim.MaxConcurrent > 0 {
+			st.sem = make(chan struct{}, lim.MaxConcurrent)
+		}
+		l.states[uuid] = st
+	}
+	l.mu.Unlock()
+
+	release := func() {
+		if st.sem != nil {
+			<-st.sem
+		}
+	}
+
+	if st.sem != nil {
+		select {
+		case st.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// the slot is only taken once it has come, so a request given up while
+	// waiting does not hold back the ones after it
+	for lim.MinInterval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		wait := st.next.Sub(now)
+		if wait <= 0 {
+			st.next = now.Add(lim.MinInterval)
+			l.mu.Unlock()
+			break
+		}
+		l.mu.Unlock()
+
+		if err := sleepCtx(ctx, wait); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// cameraLimit returns the limit that applies to camera uuid
+func (d *Dropcam) cameraLimit(uuid string) CameraLimit {
+	if lim, ok := d.CameraLimits[uuid]; ok {
+		return lim
+	}
+	return d.DefaultCameraLimit
+}
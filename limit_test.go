@@ -0,0 +1,87 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCameraLimiterSpacing(t *testing.T) {
+
+	l := newCameraLimiter()
+	lim := CameraLimit{MinInterval: 50 * time.Millisecond}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := l.acquire(ctx, "cam", lim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if took := time.Since(start); took < 100*time.Millisecond {
+		t.Errorf("3 requests started within %s, want at least 100ms", took)
+	}
+}
+
+func TestCameraLimiterCancelledWaitFreesSlot(t *testing.T) {
+
+	l := newCameraLimiter()
+	lim := CameraLimit{MinInterval: 100 * time.Millisecond}
+
+	release, err := l.acquire(context.Background(), "cam", lim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// a request given up while waiting for its slot...
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "cam", lim); err == nil {
+		t.Fatal("acquire succeeded before the interval passed")
+	}
+
+	// ...must not push the next one back by another interval
+	start := time.Now()
+	release, err = l.acquire(context.Background(), "cam", lim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if took := time.Since(start); took > 150*time.Millisecond {
+		t.Errorf("next request waited %s after a cancelled one, want under 100ms", took)
+	}
+}
+
+func TestCameraLimiterConcurrency(t *testing.T) {
+
+	l := newCameraLimiter()
+	lim := CameraLimit{MaxConcurrent: 1}
+
+	release, err := l.acquire(context.Background(), "cam", lim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "cam", lim); err == nil {
+		t.Fatal("second request ran while the first was in flight")
+	}
+	// other cameras are not held back
+	other, err := l.acquire(context.Background(), "other", lim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+	release()
+	if release, err = l.acquire(context.Background(), "cam", lim); err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
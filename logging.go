@@ -0,0 +1,149 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	logMu     sync.Mutex
+	pkgLogger *slog.Logger
+)
+
+// secretParam matches query and form parameters with credential names
+var secretParam = regexp.MustCompile(`(?i)([^\s&?=]*(?:pass|token|secret|cookie|auth|session|key|sig)[^\s&=]*=)[^\s&]+`)
+
+// SetLogger sets the logger of the package and of every Dropcam without a
+// Logger of its own. Messages are logged at debug level, what Dbg printed
+// when Devel was set, and failures of background work at warn level.
+// Credentials in messages and attributes, such as passwords in URLs and
+// cookies in headers, are redacted before they reach l. A nil l restores the
+// default, which writes warnings and errors to stderr, and debug messages too
+// when Devel is set.
+func SetLogger(l *slog.Logger) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if l != nil {
+		l = slog.New(redactHandler{l.Handler()})
+	}
+	pkgLogger = l
+}
+
+// logger returns the package logger
+func logger() *slog.Logger {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if pkgLogger == nil {
+		level := slog.LevelWarn
+		if Devel {
+			level = slog.LevelDebug
+		}
+		pkgLogger = slog.New(redactHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})})
+	}
+	return pkgLogger
+}
+
+// logger returns the logger of d, or the package logger
+func (d *Dropcam) logger() *slog.Logger {
+	if d != nil && d.Logger != nil {
+		return slog.New(redactHandler{d.Logger.Handler()})
+	}
+	return logger()
+}
+
+// Dbg logs a debug message through the package logger
+func Dbg(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// warnf logs the failure of background work through the package logger
+func warnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+func logf(level slog.Level, format string, args ...interface{}) {
+	l := logger()
+	if !l.Enabled(context.Background(), level) {
+		return
+	}
+	l.Log(context.Background(), level, strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+}
+
+// redactHandler removes credentials from the records passed to a handler
+type redactHandler struct {
+	h slog.Handler
+}
+
+func (rh redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return rh.h.Enabled(ctx, level)
+}
+
+func (rh redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, redactText(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(redactAttr(a))
+		return true
+	})
+	return rh.h.Handle(ctx, nr)
+}
+
+func (rh redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return redactHandler{rh.h.WithAttrs(out)}
+}
+
+func (rh redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{rh.h.WithGroup(name)}
+}
+
+// redactAttr removes credentials from a log attribute
+func redactAttr(a slog.Attr) slog.Attr {
+
+	if secretName.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactText(v.String()))
+	case slog.KindGroup:
+		as := v.Group()
+		out := make([]any, len(as))
+		for i, ga := range as {
+			out[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, out...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case *url.URL:
+			return slog.String(a.Key, redactURL(x))
+		case http.Header:
+			return slog.Any(a.Key, redactHeader(x))
+		case error:
+			return slog.String(a.Key, redactText(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redactText(x.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// redactText removes credentials written as JSON fields or URL parameters
+func redactText(s string) string {
+	s = secretField.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return secretParam.ReplaceAllString(s, "${1}"+redacted)
+}
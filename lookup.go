@@ -0,0 +1,100 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The CameraNotFoundError type is returned by the camera lookups when no
+// camera, or more than one, matches. It wraps ErrNotFound. Matches lists the
+// titles of the cameras a fuzzy title matched ambiguously.
+type CameraNotFoundError struct {
+	By      string
+	Value   string
+	Matches []string
+}
+
+func (e *CameraNotFoundError) Error() string {
+	if len(e.Matches) > 0 {
+		return fmt.Sprintf("Camera %s %q is ambiguous: %s", e.By, e.Value, strings.Join(e.Matches, ", "))
+	}
+	return fmt.Sprintf("No camera with %s %q", e.By, e.Value)
+}
+
+func (e *CameraNotFoundError) Unwrap() error {
+	return ErrNotFound
+}
+
+// all returns the owned cameras followed by the shared ones
+func (c *Cameras) all() []*Owned {
+	cams := make([]*Owned, 0, len(c.Cam)+len(c.Shared))
+	for i := range c.Cam {
+		cams = append(cams, &c.Cam[i])
+	}
+	for i := range c.Shared {
+		cams = append(cams, &c.Shared[i].Owned)
+	}
+	return cams
+}
+
+// ByUUID returns the owned or shared camera with uuid
+func (c *Cameras) ByUUID(uuid string) (*Owned, error) {
+	if o := c.findCamera(uuid); o != nil {
+		return o, nil
+	}
+	return nil, &CameraNotFoundError{By: "uuid", Value: uuid}
+}
+
+// ByTitle returns the camera titled title, ignoring case. When no title is
+// equal, a camera whose title contains title is returned if it is the only one.
+func (c *Cameras) ByTitle(title string) (*Owned, error) {
+	return byTitle(c.all(), title)
+}
+
+// byTitle returns the camera of cams titled title, as ByTitle
+func byTitle(cams []*Owned, title string) (*Owned, error) {
+
+	var found []*Owned
+	for _, o := range cams {
+		if strings.EqualFold(o.Title, title) {
+			found = append(found, o)
+		}
+	}
+	if len(found) == 0 && title != "" {
+		lower := strings.ToLower(title)
+		for _, o := range cams {
+			if strings.Contains(strings.ToLower(o.Title), lower) {
+				found = append(found, o)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, &CameraNotFoundError{By: "title", Value: title}
+	case 1:
+		return found[0], nil
+	}
+	e := &CameraNotFoundError{By: "title", Value: title}
+	for _, o := range found {
+		e.Matches = append(e.Matches, o.Title)
+	}
+	return nil, e
+}
+
+// ByMAC returns the camera with MAC address mac, in any of the usual notations
+func (c *Cameras) ByMAC(mac string) (*Owned, error) {
+	want := normalizeMAC(mac)
+	if want != "" {
+		for _, o := range c.all() {
+			if normalizeMAC(o.MacAddress) == want {
+				return o, nil
+			}
+		}
+	}
+	return nil, &CameraNotFoundError{By: "MAC address", Value: mac}
+}
@@ -0,0 +1,269 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout bounds how long Manager.Stop waits for the services to
+// finish what they are doing
+const DefaultStopTimeout = 30 * time.Second
+
+// ErrStopTimeout is returned by Manager.Stop when services did not stop in time
+var ErrStopTimeout = errors.New("Services did not stop in time")
+
+// The Service interface is a background routine started and stopped as a
+// whole, as Monitor, Dispatcher, Jobs, EventRecorder and the other Start and
+// Stop types of this package are. Stop returns once the routine has ended.
+type Service interface {
+	Start() error
+	Stop()
+}
+
+// The ServiceFuncs type adapts a pair of functions to Service, for types
+// whose Start takes a context or whose Stop returns an error, such as
+// CameraRegistry and Timelapse. The error of StopFunc is reported by
+// Manager.Stop.
+type ServiceFuncs struct {
+	StartFunc func() error
+	StopFunc  func() error
+
+	err error
+}
+
+// Start implements Service
+func (s *ServiceFuncs) Start() error {
+	if s.StartFunc == nil {
+		return nil
+	}
+	return s.StartFunc()
+}
+
+// Stop implements Service
+func (s *ServiceFuncs) Stop() {
+	if s.StopFunc != nil {
+		s.err = s.StopFunc()
+	}
+}
+
+// The Manager type runs the background routines of an application as one,
+// so they can be shut down together, in-flight captures and downloads
+// included, on a signal:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+//	defer stop()
+//	m := &dropcam.Manager{}
+//	m.Add("monitor", monitor)
+//	m.Add("timelapse", &dropcam.ServiceFuncs{StartFunc: tl.Start, StopFunc: tl.Stop})
+//	m.Go("watch", func(ctx context.Context) error { ... })
+//	err := m.Run(ctx)
+//
+// Services are started in the order they were added and stopped in reverse.
+// Routines added with Go run until their context is cancelled; as with an
+// errgroup, the first to fail stops everything, and its error is what Wait
+// and Run return. Stop waits up to StopTimeout, DefaultStopTimeout when zero,
+// for everything to end.
+type Manager struct {
+	StopTimeout time.Duration
+
+	mu       sync.Mutex
+	services []managedService
+	routines []managedRoutine
+	started  []managedService
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	err      error
+	failed   chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	stopErr  error
+}
+
+type managedService struct {
+	name string
+	s    Service
+}
+
+type managedRoutine struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Add registers service s under name, starting it right away if the Manager
+// is running
+func (m *Manager) Add(name string, s Service) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := managedService{name: name, s: s}
+	if m.ctx == nil {
+		m.services = append(m.services, ms)
+		return nil
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("Failed to start %s: %w", name, err)
+	}
+	m.started = append(m.started, ms)
+	return nil
+}
+
+// Go registers fn, run under name until the context it is given is
+// cancelled, starting it right away if the Manager is running
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mr := managedRoutine{name: name, fn: fn}
+	if m.ctx == nil {
+		m.routines = append(m.routines, mr)
+		return
+	}
+	m.run(mr)
+}
+
+// Start starts the services, then the routines. When a service fails to
+// start, those already started are stopped again and its error returned.
+func (m *Manager) Start() error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx != nil {
+		return errors.New("Manager already started")
+	}
+
+	for i, ms := range m.services {
+		if err := ms.s.Start(); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				m.services[j].s.Stop()
+			}
+			return fmt.Errorf("Failed to start %s: %w", ms.name, err)
+		}
+		m.started = append(m.started, ms)
+	}
+
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.failed = make(chan struct{})
+	m.stopped = make(chan struct{})
+	for _, mr := range m.routines {
+		m.run(mr)
+	}
+	return nil
+}
+
+// run starts routine mr; m.mu is held
+func (m *Manager) run(mr managedRoutine) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := mr.fn(m.ctx)
+		if err == nil || (m.ctx.Err() != nil && errors.Is(err, context.Canceled)) {
+			return
+		}
+		warnf("manager: %s: %s\n", mr.name, err)
+		m.mu.Lock()
+		if m.err == nil {
+			m.err = fmt.Errorf("%s: %w", mr.name, err)
+			close(m.failed)
+		}
+		m.mu.Unlock()
+	}()
+}
+
+// Stop cancels the routines and stops the services, last started first, and
+// waits for all of them. It returns ErrStopTimeout if they take longer than
+// StopTimeout, or else the first error a ServiceFuncs stop reported.
+func (m *Manager) Stop() error {
+
+	m.mu.Lock()
+	started := m.ctx != nil
+	m.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	m.stopOnce.Do(func() {
+		timeout := m.StopTimeout
+		if timeout <= 0 {
+			timeout = DefaultStopTimeout
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			m.cancel()
+			m.mu.Lock()
+			services := append([]managedService(nil), m.started...)
+			m.mu.Unlock()
+
+			var first error
+			for i := len(services) - 1; i >= 0; i-- {
+				ms := services[i]
+				ms.s.Stop()
+				if sf, ok := ms.s.(*ServiceFuncs); ok && sf.err != nil && first == nil {
+					first = fmt.Errorf("%s: %w", ms.name, sf.err)
+				}
+			}
+			m.wg.Wait()
+			done <- first
+		}()
+
+		select {
+		case m.stopErr = <-done:
+		case <-time.After(timeout):
+			m.stopErr = ErrStopTimeout
+		}
+		close(m.stopped)
+	})
+	return m.stopErr
+}
+
+// Wait blocks until the Manager is stopped, or a routine fails, and returns
+// the error of the routine that failed first
+func (m *Manager) Wait() error {
+
+	m.mu.Lock()
+	failed, stopped := m.failed, m.stopped
+	m.mu.Unlock()
+	if stopped == nil {
+		return errors.New("Manager not started")
+	}
+
+	select {
+	case <-failed:
+	case <-stopped:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Run starts the Manager, runs it until ctx is done or a routine fails, then
+// stops it. It returns the error of the routine that failed, or else that of
+// stopping.
+func (m *Manager) Run(ctx context.Context) error {
+
+	if err := m.Start(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	failed := m.failed
+	m.mu.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-failed:
+	}
+
+	stopErr := m.Stop()
+	if err := m.Wait(); err != nil {
+		return err
+	}
+	return stopErr
+}
@@ -0,0 +1,212 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// MDNSServices are the services SearchMDNS asks for: the one Dropcam
+// cameras announce, and the web server of their setup page
+var MDNSServices = []string{"_dropcam._tcp.local.", "_http._tcp.local."}
+
+// DNS record types read from mDNS answers
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+)
+
+// mdnsQuery returns a DNS query asking for the PTR records of services. It
+// is sent from a port other than 5353, so responders answer to it directly.
+func mdnsQuery(services []string) []byte {
+
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(services)))
+	for _, s := range services {
+		for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+		msg = append(msg, 0, 0, dnsTypePTR, 0, 1)
+	}
+	return msg
+}
+
+// SearchMDNS multicasts an mDNS query for MDNSServices and collects the
+// devices that answer within timeout, named after the service instance
+// they announce. A MAC address in their TXT record, as mac=..., is kept.
+func SearchMDNS(timeout time.Duration) ([]LANDevice, error) {
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	if _, err := conn.WriteTo(mdnsQuery(MDNSServices), dst); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	byIP := make(map[string]*LANDevice)
+	var order []string
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		ans, err := parseMDNS(buf[:n])
+		if err != nil || ans.name == "" {
+			continue
+		}
+		ip := from.(*net.UDPAddr).IP.String()
+		if ans.ip != "" {
+			ip = ans.ip
+		}
+		dev := byIP[ip]
+		if dev == nil {
+			dev = &LANDevice{IP: ip}
+			byIP[ip] = dev
+			order = append(order, ip)
+		}
+		if dev.Name == "" {
+			dev.Name = ans.name
+		}
+		if dev.MAC == "" && ans.mac != "" {
+			dev.MAC = normalizeMAC(ans.mac)
+		}
+	}
+
+	devices := make([]LANDevice, 0, len(order))
+	for _, ip := range order {
+		devices = append(devices, *byIP[ip])
+	}
+	return devices, nil
+}
+
+// mdnsAnswer is what an mDNS response tells of the device sending it
+type mdnsAnswer struct {
+	name string
+	ip   string
+	mac  string
+}
+
+// parseMDNS reads the instance name, address and TXT mac of an mDNS response
+// to a query for MDNSServices
+func parseMDNS(msg []byte) (mdnsAnswer, error) {
+
+	var ans mdnsAnswer
+	if len(msg) < 12 || msg[2]&0x80 == 0 {
+		return ans, errors.New("not a DNS response")
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, next, err := dnsName(msg, off)
+		if err != nil {
+			return ans, err
+		}
+		off = next + 4
+	}
+	for i := 0; i < rr; i++ {
+		name, next, err := dnsName(msg, off)
+		if err != nil {
+			return ans, err
+		}
+		if next+10 > len(msg) {
+			return ans, errors.New("short DNS record")
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+size > len(msg) {
+			return ans, errors.New("short DNS record")
+		}
+
+		switch typ {
+		case dnsTypePTR:
+			if isMDNSService(name) && ans.name == "" {
+				target, _, err := dnsName(msg, data)
+				if err == nil {
+					ans.name = strings.TrimSuffix(strings.TrimSuffix(target, "."+name), ".")
+				}
+			}
+		case dnsTypeA:
+			if size == 4 && ans.ip == "" {
+				ans.ip = net.IP(msg[data : data+4]).String()
+			}
+		case dnsTypeTXT:
+			for p := data; p < data+size; {
+				l := int(msg[p])
+				p++
+				if p+l > data+size {
+					break
+				}
+				if k, v, ok := strings.Cut(string(msg[p:p+l]), "="); ok && (strings.EqualFold(k, "mac") || strings.EqualFold(k, "macaddress")) {
+					ans.mac = v
+				}
+				p += l
+			}
+		}
+		off = data + size
+	}
+	return ans, nil
+}
+
+// isMDNSService reports whether name is one of MDNSServices
+func isMDNSService(name string) bool {
+	for _, s := range MDNSServices {
+		if strings.EqualFold(strings.TrimSuffix(s, "."), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsName reads the possibly compressed name at off in msg, and returns it
+// without its final dot and the offset after it
+func dnsName(msg []byte, off int) (string, int, error) {
+
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("short DNS name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad DNS name pointer")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("short DNS name")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
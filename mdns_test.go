@@ -0,0 +1,83 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// dnsLabels encodes name as uncompressed DNS labels
+func dnsLabels(name string) []byte {
+	var b []byte
+	for _, l := range bytes.Split([]byte(name), []byte(".")) {
+		b = append(b, byte(len(l)))
+		b = append(b, l...)
+	}
+	return append(b, 0)
+}
+
+// dnsRecord encodes a record of typ for the name already encoded in owner
+func dnsRecord(owner []byte, typ uint16, data []byte) []byte {
+	b := append([]byte(nil), owner...)
+	var fixed [10]byte
+	binary.BigEndian.PutUint16(fixed[0:], typ)
+	binary.BigEndian.PutUint16(fixed[2:], 0x8001)
+	binary.BigEndian.PutUint32(fixed[4:], 120)
+	binary.BigEndian.PutUint16(fixed[8:], uint16(len(data)))
+	b = append(b, fixed[:]...)
+	return append(b, data...)
+}
+
+func TestParseMDNS(t *testing.T) {
+
+	msg := []byte{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 2}
+	// the service name sits at offset 12, where the instance points back to
+	ptr := append([]byte{10}, "Front Door"...)
+	ptr = append(ptr, 0xc0, 12)
+	msg = append(msg, dnsRecord(dnsLabels("_dropcam._tcp.local"), dnsTypePTR, ptr)...)
+	instance := []byte{0xc0, byte(12 + len(dnsLabels("_dropcam._tcp.local")) + 10)}
+	txt := append([]byte{21}, "mac=30:8C:FB:01:02:03"...)
+	msg = append(msg, dnsRecord(instance, dnsTypeTXT, txt)...)
+	msg = append(msg, dnsRecord(dnsLabels("frontdoor.local"), dnsTypeA, []byte{192, 168, 1, 20})...)
+
+	ans, err := parseMDNS(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ans.name != "Front Door" || ans.ip != "192.168.1.20" || normalizeMAC(ans.mac) != "308cfb010203" {
+		t.Errorf("parseMDNS = %+v", ans)
+	}
+}
+
+func TestParseMDNSRejects(t *testing.T) {
+
+	for name, msg := range map[string][]byte{
+		"short":        {0, 0, 0x84},
+		"query":        mdnsQuery(MDNSServices),
+		"truncated":    {0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 3, 'a', 'b'},
+		"pointer loop": {0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0xc0, 12},
+	} {
+		if _, err := parseMDNS(msg); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestMDNSQuery(t *testing.T) {
+
+	q := mdnsQuery([]string{"_dropcam._tcp.local."})
+	if n := binary.BigEndian.Uint16(q[4:]); n != 1 {
+		t.Fatalf("%d questions, want 1", n)
+	}
+	name, off, err := dnsName(q, 12)
+	if err != nil || name != "_dropcam._tcp.local" {
+		t.Fatalf("question for %q: %v", name, err)
+	}
+	if typ := binary.BigEndian.Uint16(q[off:]); typ != dnsTypePTR || off+4 != len(q) {
+		t.Errorf("question type %d, length %d", typ, len(q))
+	}
+}
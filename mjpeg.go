@@ -0,0 +1,153 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMJPEGFPS is the frame rate of an MJPEGServer stream when neither the
+// server nor the request sets one
+const DefaultMJPEGFPS = 1
+
+// The MJPEGServer type is an http.Handler re-streaming camera snapshots as
+// Motion JPEG, the multipart/x-mixed-replace format understood by browsers,
+// Home Assistant, Blue Iris and most NVR software.
+//
+//	/cameras/<uuid>/stream.mjpeg?fps=<n>&width=<px>
+//
+// Snapshots are taken through Images so that any number of viewers of the same
+// camera cost one get_image per frame. When Images is nil a cache is created
+// that refreshes for every frame. When Guard is set, callers need the read scope.
+type MJPEGServer struct {
+	Cameras *Cameras
+	Images  *ImageCache
+	FPS     float64
+	Width   int
+	Guard   Guard
+
+	once   sync.Once
+	images *ImageCache
+}
+
+func (m *MJPEGServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.Guard != nil {
+		Protect(m.Guard, ScopeRead, http.HandlerFunc(m.serve)).ServeHTTP(w, r)
+		return
+	}
+	m.serve(w, r)
+}
+
+func (m *MJPEGServer) fps() float64 {
+	if m.FPS <= 0 {
+		return DefaultMJPEGFPS
+	}
+	return m.FPS
+}
+
+func (m *MJPEGServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	m.once.Do(func() {
+		m.images = m.Images
+		if m.images == nil {
+			m.images = &ImageCache{Cameras: m.Cameras, TTL: time.Duration(float64(time.Second) / m.fps() / 2)}
+		}
+	})
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "cameras" || parts[2] != "stream.mjpeg" {
+		http.NotFound(w, r)
+		return
+	}
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	o := m.Cameras.findCamera(parts[1])
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	fps := m.fps()
+	if v, err := strconv.ParseFloat(r.FormValue("fps"), 64); err == nil && v > 0 && v <= 30 {
+		fps = v
+	}
+	width := m.Width
+	if v, err := strconv.Atoi(r.FormValue("width")); err == nil && v > 0 {
+		width = v
+	}
+	if width <= 0 {
+		width = 720
+	}
+
+	// the first frame is fetched before any headers go out so errors get a status
+	ctx := r.Context()
+	img, err := m.images.Get(ctx, o, width)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	mw := newMJPEGWriter(w)
+	tick := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer tick.Stop()
+
+	for {
+		if img != nil {
+			if err := mw.frame(img); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if img, err = m.images.Get(ctx, o, width); err != nil {
+			warnf("mjpeg: %s: %s\n", o.Title, err)
+		}
+	}
+}
+
+// mjpegWriter writes JPEG frames as a multipart/x-mixed-replace response
+type mjpegWriter struct {
+	mw      *multipart.Writer
+	flusher http.Flusher
+}
+
+// newMJPEGWriter sends the headers of a Motion JPEG stream to w
+func newMJPEGWriter(w http.ResponseWriter) *mjpegWriter {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	flusher, _ := w.(http.Flusher)
+	return &mjpegWriter{mw: mw, flusher: flusher}
+}
+
+// frame writes img as the next frame and flushes it to the viewer
+func (m *mjpegWriter) frame(img []byte) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "image/jpeg")
+	h.Set("Content-Length", strconv.Itoa(len(img)))
+	part, err := m.mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(img); err != nil {
+		return err
+	}
+	if m.flusher != nil {
+		m.flusher.Flush()
+	}
+	return nil
+}
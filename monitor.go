@@ -0,0 +1,314 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The CameraState type is what a Monitor last saw of a camera. Since is when
+// the camera last went online or offline, or when the Monitor first saw it.
+type CameraState struct {
+	Camera      string
+	Title       string
+	IsOnline    bool
+	IsStreaming bool
+	IsConnected bool
+	LastLocalIp string
+	Updated     time.Time
+	Since       time.Time
+
+	IsTrialMode   bool
+	TrialDaysLeft int64
+	CVR           bool
+}
+
+// The StateChange type is a camera whose state differs between two polls.
+// Old is the zero CameraState when the camera was not known before.
+type StateChange struct {
+	Old CameraState
+	New CameraState
+}
+
+// WentOffline reports whether the camera was online and no longer is
+func (sc StateChange) WentOffline() bool {
+	return sc.Old.IsOnline && !sc.New.IsOnline
+}
+
+// WentOnline reports whether a known camera that was offline is online again
+func (sc StateChange) WentOnline() bool {
+	return sc.Old.Camera != "" && !sc.Old.IsOnline && sc.New.IsOnline
+}
+
+// The Monitor type polls the state of every camera of a Dropcam each Interval.
+// Changes in a camera's online, streaming or connected state, its local
+// address or its trial are passed to OnChange, and cameras going offline or
+// coming back online are sent to Notifier. So are NotifyTrial warnings on each
+// of the last TrialWarnDays days of a trial, DefaultTrialWarnDays when zero,
+// and when a camera's cloud recording stops. A Monitor is also an http.Handler serving the
+// states as Prometheus metrics, so remote sites can be scraped and alerted on:
+//
+//	dropcam_camera_online{camera="<uuid>",title="<title>"} 1
+//
+// The state of the Dropcam's RetryBudget, when set, is served as well. When
+// Guard is set, scraping needs the read scope.
+type Monitor struct {
+	Dropcam  *Dropcam
+	Interval time.Duration
+	OnChange func(StateChange)
+	Notifier Notifier
+	Guard    Guard
+
+	TrialWarnDays int
+
+	mu       sync.Mutex
+	states   map[string]CameraState
+	order    []string
+	polls    int64
+	failures int64
+	lastPoll time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Start begins polling in the background, the first time right away
+func (m *Monitor) Start() error {
+
+	if m.Dropcam == nil {
+		return errors.New("Monitor needs a Dropcam")
+	}
+	if m.Interval <= 0 {
+		m.Interval = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx)
+	return nil
+}
+
+// Stop ends polling
+func (m *Monitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+func (m *Monitor) run(ctx context.Context) {
+	defer close(m.done)
+
+	tick := time.NewTicker(m.Interval)
+	defer tick.Stop()
+	for {
+		if err := m.Poll(ctx); err != nil && ctx.Err() == nil {
+			warnf("monitor: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Poll refreshes the camera states now
+func (m *Monitor) Poll(ctx context.Context) error {
+
+	c, err := m.Dropcam.RefreshCameras(ctx)
+
+	m.mu.Lock()
+	m.polls++
+	if err != nil {
+		m.failures++
+		m.mu.Unlock()
+		return err
+	}
+	m.lastPoll = time.Now()
+	if m.states == nil {
+		m.states = make(map[string]CameraState)
+	}
+
+	cams := append([]Owned(nil), c.Cam...)
+	for _, s := range c.Shared {
+		cams = append(cams, s.Owned)
+	}
+
+	var changes []StateChange
+	seen := make(map[string]bool, len(cams))
+	m.order = m.order[:0]
+	for _, o := range cams {
+		if seen[o.Uuid] {
+			continue
+		}
+		seen[o.Uuid] = true
+		m.order = append(m.order, o.Uuid)
+
+		old, known := m.states[o.Uuid]
+		st := CameraState{
+			Camera:      o.Uuid,
+			Title:       o.Title,
+			IsOnline:    o.IsOnline,
+			IsStreaming: o.IsStreaming,
+			IsConnected: o.IsConnected,
+			LastLocalIp: o.LastLocalIp,
+			Updated:     m.lastPoll,
+			Since:       old.Since,
+
+			IsTrialMode:   o.IsTrialMode,
+			TrialDaysLeft: o.TrialDaysLeft,
+			CVR:           o.HasCVR(),
+		}
+		if !known || old.IsOnline != st.IsOnline {
+			st.Since = m.lastPoll
+		}
+		m.states[o.Uuid] = st
+
+		if !known || old.IsOnline != st.IsOnline || old.IsStreaming != st.IsStreaming ||
+			old.IsConnected != st.IsConnected || old.LastLocalIp != st.LastLocalIp ||
+			old.TrialDaysLeft != st.TrialDaysLeft || old.CVR != st.CVR {
+			changes = append(changes, StateChange{Old: old, New: st})
+		}
+	}
+	for uuid := range m.states {
+		if !seen[uuid] {
+			delete(m.states, uuid)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sc := range changes {
+		m.changed(sc)
+	}
+	return nil
+}
+
+func (m *Monitor) changed(sc StateChange) {
+
+	if m.OnChange != nil {
+		m.OnChange(sc)
+	}
+	if m.Notifier == nil {
+		return
+	}
+	warnDays := m.TrialWarnDays
+	if warnDays <= 0 {
+		warnDays = DefaultTrialWarnDays
+	}
+	if n := trialNotice(sc, warnDays); n != nil {
+		if err := m.Notifier.Notify(n); err != nil {
+			warnf("monitor: %s\n", err)
+		}
+	}
+	if !(sc.WentOffline() || sc.WentOnline()) {
+		return
+	}
+	n := &Notification{Kind: NotifyOnline, Camera: sc.New.Camera, Title: sc.New.Title, Message: "Camera is back online", Time: sc.New.Since}
+	if sc.WentOffline() {
+		n.Kind, n.Message = NotifyOffline, "Camera went offline"
+	}
+	if err := m.Notifier.Notify(n); err != nil {
+		warnf("monitor: %s\n", err)
+	}
+}
+
+// States returns the camera states as of the last successful poll
+func (m *Monitor) States() []CameraState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	states := make([]CameraState, 0, len(m.order))
+	for _, uuid := range m.order {
+		states = append(states, m.states[uuid])
+	}
+	return states
+}
+
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.Guard != nil {
+		Protect(m.Guard, ScopeRead, http.HandlerFunc(m.serve)).ServeHTTP(w, r)
+		return
+	}
+	m.serve(w, r)
+}
+
+func (m *Monitor) serve(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	m.writeMetrics(&b, RequestPrincipal(r))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+// writeMetrics writes the metrics of the cameras p may see, all when p is nil
+func (m *Monitor) writeMetrics(b *bytes.Buffer, p *Principal) {
+
+	var states []CameraState
+	for _, st := range m.States() {
+		if p == nil || p.AllowsCamera(st.Camera) {
+			states = append(states, st)
+		}
+	}
+
+	m.mu.Lock()
+	polls, failures, last := m.polls, m.failures, m.lastPoll
+	m.mu.Unlock()
+
+	gauges := []struct {
+		name, help string
+		value      func(CameraState) bool
+	}{
+		{"dropcam_camera_online", "Whether the camera is online.", func(st CameraState) bool { return st.IsOnline }},
+		{"dropcam_camera_streaming", "Whether the camera is streaming.", func(st CameraState) bool { return st.IsStreaming }},
+		{"dropcam_camera_connected", "Whether the camera is connected to the servers.", func(st CameraState) bool { return st.IsConnected }},
+	}
+	for _, g := range gauges {
+		writeMetricHeader(b, g.name, g.help, "gauge")
+		for _, st := range states {
+			v := 0
+			if g.value(st) {
+				v = 1
+			}
+			fmt.Fprintf(b, "%s{%s} %d\n", g.name, cameraLabels(st), v)
+		}
+	}
+	writeMetricHeader(b, "dropcam_camera_info", "Camera details, always 1.", "gauge")
+	for _, st := range states {
+		fmt.Fprintf(b, "dropcam_camera_info{%s,local_ip=\"%s\"} 1\n", cameraLabels(st), escapeLabel(st.LastLocalIp))
+	}
+	writeMetricHeader(b, "dropcam_camera_state_since_timestamp_seconds", "Unix time the camera last went online or offline.", "gauge")
+	for _, st := range states {
+		fmt.Fprintf(b, "dropcam_camera_state_since_timestamp_seconds{%s} %g\n", cameraLabels(st), float64(st.Since.UnixNano())/1e9)
+	}
+
+	writeMetricHeader(b, "dropcam_monitor_polls_total", "Polls of the camera list.", "counter")
+	fmt.Fprintf(b, "dropcam_monitor_polls_total %d\n", polls)
+	writeMetricHeader(b, "dropcam_monitor_poll_errors_total", "Polls of the camera list that failed.", "counter")
+	fmt.Fprintf(b, "dropcam_monitor_poll_errors_total %d\n", failures)
+	if !last.IsZero() {
+		writeGauge(b, "dropcam_monitor_last_poll_timestamp_seconds", "Unix time of the last successful poll.", float64(last.UnixNano())/1e9)
+	}
+	writeRetryBudget(b, m.Dropcam.RetryBudget)
+}
+
+func writeMetricHeader(b *bytes.Buffer, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func cameraLabels(st CameraState) string {
+	return fmt.Sprintf("camera=\"%s\",title=\"%s\"", escapeLabel(st.Camera), escapeLabel(st.Title))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sync"
+	"time"
+)
+
+// The MontageLayout type controls how frames are tiled by Montage.
+// Columns of 0 picks a near-square grid. TileWidth is both the snapshot width
+// requested from each camera and the width every tile is scaled to.
+type MontageLayout struct {
+	Columns    int
+	TileWidth  int
+	Gap        int
+	Labels     bool
+	Background color.Color
+}
+
+// The Montage method grabs the current frame from each camera in cams and tiles
+// them into a single grid image, optionally labeled with each camera's title.
+// Cameras that fail to return a frame are shown as an empty tile marked OFFLINE.
+func (c *Cameras) Montage(ctx context.Context, cams []Owned, layout MontageLayout) (image.Image, error) {
+
+	if len(cams) == 0 {
+		return nil, errors.New("Montage needs at least one camera")
+	}
+	if layout.TileWidth <= 0 {
+		layout.TileWidth = 320
+	}
+	if layout.Background == nil {
+		layout.Background = color.Black
+	}
+
+	frames := make([]image.Image, len(cams))
+	var wg sync.WaitGroup
+	for i := range cams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			jpg, err := c.getImage(ctx, &cams[i], layout.TileWidth, time.Now())
+			if err != nil {
+				Dbg("montage: %s: %s\n", cams[i].Title, err)
+				return
+			}
+			img, _, err := image.Decode(bytes.NewReader(jpg))
+			if err != nil {
+				Dbg("montage: %s: %s\n", cams[i].Title, err)
+				return
+			}
+			frames[i] = img
+		}(i)
+	}
+	wg.Wait()
+
+	// Tiles take the aspect ratio of the first frame that arrived
+	tileH := layout.TileWidth * 9 / 16
+	ok := false
+	for _, f := range frames {
+		if f != nil {
+			b := f.Bounds()
+			tileH = layout.TileWidth * b.Dy() / b.Dx()
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return nil, errors.New("Montage failed to get a frame from any camera")
+	}
+
+	cols := layout.Columns
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(cams)))))
+	}
+	rows := (len(cams) + cols - 1) / cols
+
+	gap := layout.Gap
+	out := image.NewRGBA(image.Rect(0, 0, cols*layout.TileWidth+(cols+1)*gap, rows*tileH+(rows+1)*gap))
+	draw.Draw(out, out.Bounds(), image.NewUniform(layout.Background), image.Point{}, draw.Src)
+
+	for i, f := range frames {
+		x := gap + (i%cols)*(layout.TileWidth+gap)
+		y := gap + (i/cols)*(tileH+gap)
+		tile := image.Rect(x, y, x+layout.TileWidth, y+tileH)
+
+		label := cams[i].Title
+		if f != nil {
+			scaled := resize(toRGBA(f), layout.TileWidth, tileH)
+			draw.Draw(out, tile, scaled, image.Point{}, draw.Src)
+		} else {
+			label += " OFFLINE"
+		}
+
+		if layout.Labels || f == nil {
+			drawLabel(out, tile, label)
+		}
+	}
+	return out, nil
+}
+
+// drawLabel writes text on a dark strip along the bottom of r
+func drawLabel(dst draw.Image, r image.Rectangle, text string) {
+	size := textSize(text, 1)
+	strip := image.Rect(r.Min.X, r.Max.Y-size.Y-4, r.Max.X, r.Max.Y).Intersect(r)
+	draw.Draw(dst, strip, image.NewUniform(color.RGBA{0, 0, 0, 160}), image.Point{}, draw.Over)
+	drawText(dst, image.Pt(strip.Min.X+3, strip.Min.Y+2), text, color.White, 1)
+}
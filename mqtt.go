@@ -0,0 +1,717 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of MQTT
+const (
+	DefaultMQTTPrefix          = "dropcam"
+	DefaultMQTTDiscoveryPrefix = "homeassistant"
+	DefaultMQTTKeepAlive       = 60 * time.Second
+	DefaultMQTTMotionReset     = 30 * time.Second
+	mqttTimeout                = 10 * time.Second
+	mqttCommandTimeout         = time.Minute
+)
+
+// MQTT 3.1.1 packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// The MQTT type is a Notifier publishing to an MQTT broker, for home
+// automation hubs such as Home Assistant. Pair it with a Dispatcher. Broker
+// is "tcp://host:1883", or "ssl://host:8883" for TLS; Username and Password
+// are sent when set, a Password only with a Username, and ClientID, "dropcam-<prefix>" when empty, names the
+// connection. For each camera it publishes under Prefix, DefaultMQTTPrefix
+// when empty:
+//
+//	<prefix>/<uuid>/availability  "online" or "offline", retained
+//	<prefix>/<uuid>/motion        "ON" for every event
+//	<prefix>/<uuid>/event         the notification as JSON
+//	<prefix>/<uuid>/snapshot      the event's image as base64, with Snapshots
+//
+// and "online" on <prefix>/status, which the broker changes to "offline" when
+// the connection drops. Messages are sent with QoS, 0 or 1.
+//
+// With Cameras set it also subscribes to commands on the cameras:
+//
+//	<prefix>/<uuid>/cmd/snapshot  takes a snapshot, Width wide or as many
+//	                              pixels as the payload gives, and publishes
+//	                              it to <prefix>/<uuid>/snapshot
+//	<prefix>/<uuid>/cmd/profile   applies the profile of Profiles the payload
+//	                              names to the camera
+//
+// and publishes how each went to <prefix>/<uuid>/result as JSON, such as
+// {"command": "profile", "ok": false, "failed": {"hd": "..."}}. Anyone who
+// can publish to the command topics can run them, so restrict them with the
+// broker's access control. A connection that drops while subscribed is
+// opened again, backing off while the broker is unreachable.
+//
+// With Discovery set, Announce publishes Home Assistant discovery configs
+// under DiscoveryPrefix, DefaultMQTTDiscoveryPrefix when empty, so each
+// camera appears as a device with motion and connectivity sensors, and a
+// camera entity with Snapshots. Motion sensors turn off MotionReset,
+// DefaultMQTTMotionReset when zero, after the last event.
+//
+// The connection is opened on first use, kept alive every KeepAlive,
+// DefaultMQTTKeepAlive when zero, and opened again after it fails.
+type MQTT struct {
+	Broker          string
+	ClientID        string
+	Username        string
+	Password        string
+	Prefix          string
+	QoS             byte
+	Snapshots       bool
+	Discovery       bool
+	DiscoveryPrefix string
+	MotionReset     time.Duration
+	KeepAlive       time.Duration
+
+	Cameras  *Cameras
+	Profiles *Profiles
+	Width    int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	acks   chan mqttPacket
+	nextID uint16
+	stop   chan struct{}
+	closed bool
+}
+
+// mqttPacket is a packet read from the broker
+type mqttPacket struct {
+	typ  byte
+	body []byte
+}
+
+// mqttResult is published to <prefix>/<uuid>/result after a command
+type mqttResult struct {
+	Command string            `json:"command"`
+	OK      bool              `json:"ok"`
+	Error   string            `json:"error,omitempty"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+func (m *MQTT) prefix() string {
+	if m.Prefix == "" {
+		return DefaultMQTTPrefix
+	}
+	return strings.TrimSuffix(m.Prefix, "/")
+}
+
+// Notify implements Notifier
+func (m *MQTT) Notify(n *Notification) error {
+
+	base := m.prefix() + "/" + n.Camera
+	switch n.Kind {
+	case NotifyOnline, NotifyOffline:
+		return m.Publish(base+"/availability", []byte(n.Kind), true)
+	case NotifyEvent, NotifyChange:
+		if err := m.Publish(base+"/motion", []byte("ON"), false); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if err := m.Publish(base+"/event", body, false); err != nil {
+		return err
+	}
+	if m.Snapshots && len(n.Image) > 0 {
+		img := []byte(base64.StdEncoding.EncodeToString(n.Image))
+		return m.Publish(base+"/snapshot", img, true)
+	}
+	return nil
+}
+
+// Announce publishes the Home Assistant discovery configs of the cameras of
+// c, and their availability, when Discovery is set
+func (m *MQTT) Announce(c *Cameras) error {
+
+	if !m.Discovery {
+		return nil
+	}
+	disc := m.DiscoveryPrefix
+	if disc == "" {
+		disc = DefaultMQTTDiscoveryPrefix
+	}
+	reset := m.MotionReset
+	if reset <= 0 {
+		reset = DefaultMQTTMotionReset
+	}
+
+	for _, o := range c.all() {
+		base := m.prefix() + "/" + o.Uuid
+		id := "dropcam_" + o.Uuid
+		device := map[string]interface{}{
+			"identifiers":  []string{id},
+			"name":         o.Title,
+			"manufacturer": "Dropcam",
+		}
+		if o.MacAddress != "" {
+			device["connections"] = [][]string{{"mac", o.MacAddress}}
+		}
+		configs := map[string]map[string]interface{}{
+			"binary_sensor/" + id + "/motion": {
+				"name":                  "Motion",
+				"unique_id":             id + "_motion",
+				"device_class":          "motion",
+				"state_topic":           base + "/motion",
+				"off_delay":             int(reset / time.Second),
+				"availability_topic":    base + "/availability",
+				"json_attributes_topic": base + "/event",
+			},
+			"binary_sensor/" + id + "/connectivity": {
+				"name":         "Connectivity",
+				"unique_id":    id + "_connectivity",
+				"device_class": "connectivity",
+				"state_topic":  base + "/availability",
+				"payload_on":   NotifyOnline,
+				"payload_off":  NotifyOffline,
+			},
+		}
+		if m.Snapshots {
+			configs["camera/"+id+"/snapshot"] = map[string]interface{}{
+				"name":           "Snapshot",
+				"unique_id":      id + "_snapshot",
+				"topic":          base + "/snapshot",
+				"image_encoding": "b64",
+			}
+		}
+		for path, cfg := range configs {
+			cfg["device"] = device
+			body, err := json.Marshal(cfg)
+			if err != nil {
+				return err
+			}
+			if err := m.Publish(disc+"/"+path+"/config", body, true); err != nil {
+				return err
+			}
+		}
+
+		state := NotifyOffline
+		if c.Dropcam.isOnline(o) {
+			state = NotifyOnline
+		}
+		if err := m.Publish(base+"/availability", []byte(state), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publish sends payload to topic, retained when retain is set, connecting
+// first if needed. With QoS 1 it waits for the broker's acknowledgement.
+func (m *MQTT) Publish(topic string, payload []byte, retain bool) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errors.New("MQTT connection closed")
+	}
+	if err := m.connect(); err != nil {
+		return err
+	}
+	if err := m.publish(topic, payload, retain); err != nil {
+		m.drop()
+		return fmt.Errorf("MQTT publish to %s failed: %w", topic, err)
+	}
+	return nil
+}
+
+// Close says goodbye to the broker and stops keeping the connection alive
+func (m *MQTT) Close() error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	if m.conn == nil {
+		return nil
+	}
+	m.publish(m.prefix()+"/status", []byte("offline"), true)
+	m.conn.Write([]byte{mqttDisconnect << 4, 0})
+	m.drop()
+	return nil
+}
+
+// connect opens the connection if there is none; m.mu is held
+func (m *MQTT) connect() error {
+
+	if m.conn != nil {
+		return nil
+	}
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("Bad MQTT broker %q", m.Broker)
+	}
+	if m.Password != "" && m.Username == "" {
+		// MQTT 3.1.1 forbids the password flag without the username flag
+		return errors.New("MQTT Password needs a Username")
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.Dial("tcp", hostPort(u.Host, "1883"))
+	case "ssl", "tls", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u.Host, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return fmt.Errorf("Bad MQTT broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return fmt.Errorf("MQTT connect failed: %w", err)
+	}
+
+	keepAlive := m.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultMQTTKeepAlive
+	}
+	clientID := m.ClientID
+	if clientID == "" {
+		clientID = "dropcam-" + m.prefix()
+	}
+	status := m.prefix() + "/status"
+
+	// clean session, with a retained QoS 0 will marking the bridge offline
+	flags := byte(0x02 | 0x04 | 0x20)
+	var payload []byte
+	payload = mqttString(payload, clientID)
+	payload = mqttString(payload, status)
+	payload = mqttString(payload, "offline")
+	if m.Username != "" {
+		flags |= 0x80
+		payload = mqttString(payload, m.Username)
+		if m.Password != "" {
+			flags |= 0x40
+			payload = mqttString(payload, m.Password)
+		}
+	}
+	var vh []byte
+	vh = mqttString(vh, "MQTT")
+	vh = append(vh, 4, flags, 0, 0)
+	binary.BigEndian.PutUint16(vh[len(vh)-2:], uint16(keepAlive/time.Second))
+
+	m.conn, m.r = conn, bufio.NewReader(conn)
+	if err := m.write(mqttConnect<<4, append(vh, payload...)); err != nil {
+		m.drop()
+		return fmt.Errorf("MQTT connect failed: %w", err)
+	}
+	m.conn.SetReadDeadline(time.Now().Add(mqttTimeout))
+	header, body, err := readMQTT(m.r)
+	if err != nil {
+		m.drop()
+		return fmt.Errorf("MQTT connect failed: %w", err)
+	}
+	if header>>4 != mqttConnack || len(body) < 2 {
+		m.drop()
+		return errors.New("MQTT connect failed: no CONNACK")
+	}
+	if body[1] != 0 {
+		m.drop()
+		return fmt.Errorf("MQTT connect refused: %s", mqttRefusal(body[1]))
+	}
+
+	m.stop, m.acks = make(chan struct{}), make(chan mqttPacket, 16)
+	go m.readLoop(m.conn, m.r, m.acks)
+	go m.keepAlive(m.conn, m.stop, keepAlive)
+	Dbg("mqtt: connected to %s\n", u.Host)
+	if m.Cameras != nil {
+		if err := m.subscribe(m.prefix() + "/+/cmd/+"); err != nil {
+			m.drop()
+			return fmt.Errorf("MQTT subscribe failed: %w", err)
+		}
+	}
+	return m.publish(status, []byte("online"), true)
+}
+
+// readLoop reads the packets of conn until it fails, passing the broker's
+// answers to acks and running the commands it forwards
+func (m *MQTT) readLoop(conn net.Conn, r *bufio.Reader, acks chan<- mqttPacket) {
+
+	// the keepalive pings find out when the broker stops answering
+	conn.SetReadDeadline(time.Time{})
+	for {
+		header, body, err := readMQTT(r)
+		if err != nil {
+			close(acks)
+			m.lost(conn)
+			return
+		}
+		if header>>4 == mqttPublish {
+			go m.command(conn, header, body)
+			continue
+		}
+		select {
+		case acks <- mqttPacket{header >> 4, body}:
+		default:
+			// nobody waits for it any longer
+		}
+	}
+}
+
+// lost drops conn once it failed and, while subscribed to commands,
+// connects again
+func (m *MQTT) lost(conn net.Conn) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == conn {
+		warnf("mqtt: connection lost\n")
+		m.drop()
+	}
+	if m.Cameras != nil && !m.closed {
+		go m.redial()
+	}
+}
+
+// redial connects again unless another use already has, waiting longer
+// after each failure up to a minute
+func (m *MQTT) redial() {
+
+	wait := time.Second
+	for {
+		time.Sleep(wait)
+		m.mu.Lock()
+		if m.closed || m.conn != nil {
+			m.mu.Unlock()
+			return
+		}
+		err := m.connect()
+		m.mu.Unlock()
+		if err == nil {
+			return
+		}
+		warnf("mqtt: %s\n", err)
+		if wait *= 2; wait > time.Minute {
+			wait = time.Minute
+		}
+	}
+}
+
+// subscribe asks for the messages published to filter, at QoS; m.mu is held
+// and the connection open
+func (m *MQTT) subscribe(filter string) error {
+
+	qos := m.QoS
+	if qos > 1 {
+		qos = 1
+	}
+	id := m.packetID()
+	body := []byte{byte(id >> 8), byte(id)}
+	body = append(mqttString(body, filter), qos)
+	if err := m.write(mqttSubscribe<<4|0x02, body); err != nil {
+		return err
+	}
+	ack, err := m.await(mqttSuback, id)
+	if err != nil {
+		return err
+	}
+	if len(ack) < 3 || ack[2] == 0x80 {
+		return fmt.Errorf("%s refused", filter)
+	}
+	return nil
+}
+
+// command acknowledges the PUBLISH packet the broker forwarded on conn, runs
+// the command it carries and publishes the result
+func (m *MQTT) command(conn net.Conn, header byte, body []byte) {
+
+	if len(body) < 2 || len(body) < 2+int(binary.BigEndian.Uint16(body)) {
+		return
+	}
+	n := 2 + int(binary.BigEndian.Uint16(body))
+	topic, payload := string(body[2:n]), body[n:]
+	if header>>1&0x03 > 0 {
+		if len(payload) < 2 {
+			return
+		}
+		id := payload[:2]
+		payload = payload[2:]
+		m.mu.Lock()
+		if m.conn == conn {
+			if err := m.write(mqttPuback<<4, id); err != nil {
+				m.drop()
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	parts := strings.Split(strings.TrimPrefix(topic, m.prefix()+"/"), "/")
+	if len(parts) != 3 || parts[1] != "cmd" {
+		return
+	}
+	uuid, res := parts[0], mqttResult{Command: parts[2]}
+	ctx, cancel := context.WithTimeout(context.Background(), mqttCommandTimeout)
+	defer cancel()
+	if err := m.run(ctx, uuid, &res, strings.TrimSpace(string(payload))); err != nil {
+		res.Error = err.Error()
+	}
+	res.OK = res.Error == "" && len(res.Failed) == 0
+	out, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	if err := m.Publish(m.prefix()+"/"+uuid+"/result", out, false); err != nil {
+		warnf("mqtt: %s result not published: %s\n", res.Command, err)
+	}
+}
+
+// run carries out the command res names on camera uuid with the argument arg
+func (m *MQTT) run(ctx context.Context, uuid string, res *mqttResult, arg string) error {
+
+	o := m.Cameras.findCamera(uuid)
+	if o == nil {
+		return errors.New("Unknown camera: " + uuid)
+	}
+	switch res.Command {
+	case "snapshot":
+		width := m.Width
+		if v, err := strconv.Atoi(arg); err == nil && v > 0 {
+			width = v
+		}
+		if width <= 0 {
+			width = 720
+		}
+		img, err := m.Cameras.getImage(ctx, o, width, time.Now())
+		if err != nil {
+			return err
+		}
+		return m.Publish(m.prefix()+"/"+uuid+"/snapshot", []byte(base64.StdEncoding.EncodeToString(img)), true)
+	case "profile":
+		if m.Profiles == nil {
+			return errors.New("No profiles configured")
+		}
+		results, err := m.Profiles.Apply(ctx, m.Cameras, CameraUUIDs(uuid), arg)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			for name, err := range r.Failed {
+				if res.Failed == nil {
+					res.Failed = make(map[string]string)
+				}
+				res.Failed[name] = err.Error()
+			}
+		}
+		return nil
+	}
+	return errors.New("Unknown command: " + res.Command)
+}
+
+// keepAlive pings the broker on conn every interval/2 until stop is closed
+func (m *MQTT) keepAlive(conn net.Conn, stop chan struct{}, interval time.Duration) {
+
+	tick := time.NewTicker(interval / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		m.mu.Lock()
+		if m.conn != conn {
+			m.mu.Unlock()
+			return
+		}
+		err := m.write(mqttPingreq<<4, nil)
+		if err == nil {
+			_, err = m.await(mqttPingresp, 0)
+		}
+		if err != nil {
+			warnf("mqtt: keepalive failed: %s\n", err)
+			m.drop()
+		}
+		m.mu.Unlock()
+	}
+}
+
+// publish sends a PUBLISH packet; m.mu is held and the connection open
+func (m *MQTT) publish(topic string, payload []byte, retain bool) error {
+
+	qos := m.QoS
+	if qos > 1 {
+		qos = 1
+	}
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 1
+	}
+	body := mqttString(nil, topic)
+	var id uint16
+	if qos > 0 {
+		id = m.packetID()
+		body = append(body, byte(id>>8), byte(id))
+	}
+	if err := m.write(header, append(body, payload...)); err != nil {
+		return err
+	}
+	if qos > 0 {
+		_, err := m.await(mqttPuback, id)
+		return err
+	}
+	return nil
+}
+
+// packetID returns the next packet identifier, which is never 0
+func (m *MQTT) packetID() uint16 {
+	m.nextID++
+	if m.nextID == 0 {
+		m.nextID = 1
+	}
+	return m.nextID
+}
+
+// await waits for the broker's packet of type typ, for packet id when not
+// zero, and returns its body
+func (m *MQTT) await(typ byte, id uint16) ([]byte, error) {
+
+	timeout := time.NewTimer(mqttTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case p, ok := <-m.acks:
+			if !ok {
+				return nil, errors.New("connection lost")
+			}
+			if p.typ != typ {
+				continue
+			}
+			if id != 0 && (len(p.body) < 2 || binary.BigEndian.Uint16(p.body) != id) {
+				continue
+			}
+			return p.body, nil
+		case <-timeout.C:
+			return nil, errors.New("no answer from the broker")
+		}
+	}
+}
+
+// write sends one packet with the fixed header byte header
+func (m *MQTT) write(header byte, body []byte) error {
+
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+	_, err := m.conn.Write(append(pkt, body...))
+	return err
+}
+
+// readMQTT receives one packet from r, returning its fixed header byte and
+// body
+func readMQTT(r *bufio.Reader) (byte, []byte, error) {
+
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// drop closes the connection, to be opened again on next use
+func (m *MQTT) drop() {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn, m.r, m.acks = nil, nil, nil
+	}
+}
+
+// mqttString appends s to b as an MQTT length-prefixed string
+func mqttString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s)>>8), byte(len(s))), s...)
+}
+
+// mqttRefusal describes a CONNACK return code
+func mqttRefusal(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// hostPort adds port to host when it has none
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
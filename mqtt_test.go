@@ -0,0 +1,93 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// fakeBroker accepts one connection, hands its CONNECT packet to connects
+// and accepts the connection
+func fakeBroker(t *testing.T) (string, <-chan []byte) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	connects := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if _, err := r.ReadByte(); err != nil {
+			return
+		}
+		n, mult := 0, 1
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			n += int(b&0x7f) * mult
+			if b&0x80 == 0 {
+				break
+			}
+			mult *= 128
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		connects <- body
+		conn.Write([]byte{mqttConnack << 4, 2, 0, 0})
+		io.Copy(ioutil.Discard, r)
+	}()
+	return "tcp://" + ln.Addr().String(), connects
+}
+
+func TestMQTTConnectCredentials(t *testing.T) {
+
+	broker, connects := fakeBroker(t)
+	m := &MQTT{Broker: broker, Username: "hass", Password: "secret"}
+	if err := m.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	body := <-connects
+	// protocol name "MQTT", level 4, then the flags
+	flags := body[7]
+	if flags&0xc0 != 0xc0 {
+		t.Errorf("CONNECT flags %#x lack the username and password flags", flags)
+	}
+	tail := string(body[len(body)-len("hass")-2-len("secret")-2:])
+	if tail != "\x00\x04hass\x00\x06secret" {
+		t.Errorf("CONNECT payload ends %q", tail)
+	}
+}
+
+func TestMQTTPasswordNeedsUsername(t *testing.T) {
+
+	broker, connects := fakeBroker(t)
+	m := &MQTT{Broker: broker, Password: "secret"}
+	if err := m.connect(); err == nil {
+		m.drop()
+		t.Fatal("connected with a password and no username")
+	}
+	select {
+	case <-connects:
+		t.Error("sent a CONNECT with a password and no username")
+	default:
+	}
+}
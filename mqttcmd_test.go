@@ -0,0 +1,185 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+// commandBroker is an MQTT broker for one client that records what it
+// subscribes to and publishes, and forwards commands to it
+type commandBroker struct {
+	connected  chan net.Conn
+	conn       net.Conn
+	subscribed chan string
+	published  chan [2]string
+	acked      chan uint16
+}
+
+func newCommandBroker(t *testing.T) (string, *commandBroker) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	b := &commandBroker{
+		connected:  make(chan net.Conn, 1),
+		subscribed: make(chan string, 1),
+		published:  make(chan [2]string, 16),
+		acked:      make(chan uint16, 16),
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b.connected <- conn
+		b.serve(conn)
+	}()
+	return "tcp://" + ln.Addr().String(), b
+}
+
+func (b *commandBroker) serve(conn net.Conn) {
+
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		n, mult := 0, 1
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			n += int(c&0x7f) * mult
+			if c&0x80 == 0 {
+				break
+			}
+			mult *= 128
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			l := int(binary.BigEndian.Uint16(body))
+			topic, payload := string(body[2:2+l]), body[2+l:]
+			if header&0x06 != 0 {
+				conn.Write([]byte{0x40, 2, payload[0], payload[1]})
+				payload = payload[2:]
+			}
+			b.published <- [2]string{topic, string(payload)}
+		case 4: // PUBACK
+			b.acked <- binary.BigEndian.Uint16(body)
+		case 8: // SUBSCRIBE
+			l := int(binary.BigEndian.Uint16(body[2:]))
+			conn.Write([]byte{0x90, 3, body[0], body[1], body[4+l]})
+			b.subscribed <- string(body[4 : 4+l])
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0})
+		}
+	}
+}
+
+// send forwards payload on topic at QoS 1 with packet id
+func (b *commandBroker) send(t *testing.T, topic, payload string, id uint16) {
+	t.Helper()
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(append(body, byte(id>>8), byte(id)), payload...)
+	if _, err := b.conn.Write(append([]byte{0x32, byte(len(body))}, body...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// await returns the payload next published on topic
+func (b *commandBroker) await(t *testing.T, topic string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-b.published:
+			if msg[0] == topic {
+				return msg[1]
+			}
+		case <-timeout:
+			t.Fatalf("nothing published on %s", topic)
+		}
+	}
+}
+
+func TestMQTTCommands(t *testing.T) {
+
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	want := []byte("\xff\xd8front door\xff\xd9")
+	s.SetImage(dropcamtest.FrontDoor, want)
+
+	addr, b := newCommandBroker(t)
+	profiles := dropcam.NewProfiles(dropcam.Profile{Name: "night", Properties: map[string]string{dropcam.PropIRLED: string(dropcam.IRLEDOn)}})
+	m := &dropcam.MQTT{Broker: addr, Cameras: c, Profiles: profiles}
+	defer m.Close()
+	if err := m.Publish("dropcam/status", []byte("online"), true); err != nil {
+		t.Fatal(err)
+	}
+	b.conn = <-b.connected
+	if filter := <-b.subscribed; filter != "dropcam/+/cmd/+" {
+		t.Fatalf("subscribed to %s", filter)
+	}
+
+	base := "dropcam/" + dropcamtest.FrontDoor
+	result := func() map[string]interface{} {
+		t.Helper()
+		var res map[string]interface{}
+		if err := json.Unmarshal([]byte(b.await(t, base+"/result")), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	b.send(t, base+"/cmd/snapshot", "", 7)
+	if id := <-b.acked; id != 7 {
+		t.Errorf("acknowledged packet %d, want 7", id)
+	}
+	img, err := base64.StdEncoding.DecodeString(b.await(t, base+"/snapshot"))
+	if err != nil || !bytes.Equal(img, want) {
+		t.Errorf("snapshot %q, %v, want the camera's image", img, err)
+	}
+	if res := result(); res["command"] != "snapshot" || res["ok"] != true {
+		t.Errorf("snapshot result %v", res)
+	}
+
+	b.send(t, base+"/cmd/profile", "night", 8)
+	if res := result(); res["command"] != "profile" || res["ok"] != true {
+		t.Errorf("profile result %v", res)
+	}
+	if v := s.Property(dropcamtest.FrontDoor, dropcam.PropIRLED); v != string(dropcam.IRLEDOn) {
+		t.Errorf("server has %s = %v after the profile, want %s", dropcam.PropIRLED, v, dropcam.IRLEDOn)
+	}
+
+	b.send(t, base+"/cmd/profile", "away", 9)
+	if res := result(); res["ok"] != false || res["error"] != "Unknown profile: away" {
+		t.Errorf("result of an unknown profile %v", res)
+	}
+}
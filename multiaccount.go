@@ -0,0 +1,251 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The AccountCamera type is a camera of one account of a MultiAccount. ID
+// names it across accounts: its uuid, or <account>/<uuid> when the uuid is
+// also listed by another account, as a camera shared between them is.
+// Cameras is the list of its account, for calls MultiAccount does not route.
+type AccountCamera struct {
+	ID      string
+	Account string
+	Camera  *Owned
+	Cameras *Cameras
+}
+
+// The MultiAccountError type is returned by MultiAccount.Cameras when the
+// camera list of some accounts could not be fetched; Failed maps their names
+// to the error. The cameras of the other accounts are returned with it.
+type MultiAccountError struct {
+	Failed map[string]error
+}
+
+func (e *MultiAccountError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e.Failed[name])
+	}
+	return "Cameras Failed for " + strings.Join(msgs, "; ")
+}
+
+// The MultiAccount type merges the cameras of several accounts, each a
+// Dropcam logged in separately, so one tool can manage the cameras of many
+// logins, as installers do for their customers. Each Dropcam keeps its own
+// session and settings. Calls for a camera, named by its AccountCamera ID or
+// as <account>/<uuid|title>, go to the session of the account it is in.
+//
+// The zero value is ready to use. Methods may be called concurrently.
+type MultiAccount struct {
+	mu       sync.Mutex
+	names    []string
+	accounts map[string]*Dropcam
+}
+
+// The Add method adds the logged in Dropcam d as the account name, which
+// must not contain a slash
+func (m *MultiAccount) Add(name string, d *Dropcam) error {
+
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("Bad account name %q", name)
+	}
+	if d == nil {
+		return errors.New("MultiAccount needs a Dropcam")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.accounts == nil {
+		m.accounts = make(map[string]*Dropcam)
+	}
+	if _, ok := m.accounts[name]; ok {
+		return fmt.Errorf("Account %q already added", name)
+	}
+	m.accounts[name] = d
+	m.names = append(m.names, name)
+	return nil
+}
+
+// The Remove method removes the account name
+func (m *MultiAccount) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.accounts[name]; !ok {
+		return
+	}
+	delete(m.accounts, name)
+	for i, n := range m.names {
+		if n == name {
+			m.names = append(m.names[:i:i], m.names[i+1:]...)
+			break
+		}
+	}
+}
+
+// The Account method returns the Dropcam of the account name
+func (m *MultiAccount) Account(name string) (*Dropcam, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.accounts[name]
+	return d, ok
+}
+
+// The Names method returns the names of the accounts in the order they were
+// added
+func (m *MultiAccount) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.names...)
+}
+
+// The Cameras method fetches the camera lists of every account at once and
+// merges them, in the order the accounts were added, owned cameras before
+// shared ones. When some accounts fail, the cameras of the others are
+// returned with a *MultiAccountError.
+func (m *MultiAccount) Cameras(ctx context.Context) ([]AccountCamera, error) {
+
+	m.mu.Lock()
+	names := append([]string(nil), m.names...)
+	accounts := make([]*Dropcam, len(names))
+	for i, name := range names {
+		accounts[i] = m.accounts[name]
+	}
+	m.mu.Unlock()
+
+	lists := make([]*Cameras, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, d := range accounts {
+		wg.Add(1)
+		go func(i int, d *Dropcam) {
+			defer wg.Done()
+			lists[i], errs[i] = d.Cameras(ctx)
+		}(i, d)
+	}
+	wg.Wait()
+
+	var cams []AccountCamera
+	seen := make(map[string]int)
+	failed := make(map[string]error)
+	for i, name := range names {
+		if errs[i] != nil {
+			failed[name] = errs[i]
+			continue
+		}
+		for _, o := range lists[i].all() {
+			cams = append(cams, AccountCamera{ID: o.Uuid, Account: name, Camera: o, Cameras: lists[i]})
+			seen[o.Uuid]++
+		}
+	}
+	for i := range cams {
+		if seen[cams[i].Camera.Uuid] > 1 {
+			cams[i].ID = cams[i].Account + "/" + cams[i].Camera.Uuid
+		}
+	}
+	if len(failed) > 0 {
+		return cams, &MultiAccountError{Failed: failed}
+	}
+	return cams, nil
+}
+
+// The Camera method returns the camera id names: an AccountCamera ID, a uuid
+// listed by one account only, <account>/<uuid|title>, or a title matched as
+// ByTitle does across every account. A uuid or title matching cameras of
+// several accounts fails with a *CameraNotFoundError listing their IDs.
+func (m *MultiAccount) Camera(ctx context.Context, id string) (*AccountCamera, error) {
+
+	if name, rest, ok := strings.Cut(id, "/"); ok {
+		d, found := m.Account(name)
+		if !found {
+			return nil, &CameraNotFoundError{By: "account", Value: name}
+		}
+		c, err := d.Cameras(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o := c.findCamera(rest)
+		if o == nil {
+			if o, err = c.ByTitle(rest); err != nil {
+				return nil, err
+			}
+		}
+		cam := &AccountCamera{ID: id, Account: name, Camera: o, Cameras: c}
+		if o.Uuid != rest {
+			cam.ID = name + "/" + o.Uuid
+		}
+		return cam, nil
+	}
+
+	cams, err := m.Cameras(ctx)
+	if err != nil && len(cams) == 0 {
+		return nil, err
+	}
+	var matches []*AccountCamera
+	for i := range cams {
+		if cams[i].Camera.Uuid == id {
+			matches = append(matches, &cams[i])
+		}
+	}
+	if len(matches) == 0 {
+		all := make([]*Owned, len(cams))
+		for i := range cams {
+			all[i] = cams[i].Camera
+		}
+		o, terr := byTitle(all, id)
+		if terr != nil {
+			if err != nil {
+				// the camera may be in an account that failed
+				return nil, err
+			}
+			return nil, terr
+		}
+		for i := range cams {
+			if cams[i].Camera == o {
+				return &cams[i], nil
+			}
+		}
+	}
+	if len(matches) > 1 {
+		ids := make([]string, len(matches))
+		for i, cam := range matches {
+			ids[i] = cam.ID
+		}
+		return nil, &CameraNotFoundError{By: "uuid", Value: id, Matches: ids}
+	}
+	return matches[0], nil
+}
+
+// The GetImage method returns the image of camera id, named as Camera names
+// it, through the session of its account, as Cameras.GetImage does
+func (m *MultiAccount) GetImage(ctx context.Context, id string, width int, t time.Time) ([]byte, error) {
+	cam, err := m.Camera(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return cam.Cameras.GetImage(ctx, cam.Camera, width, t)
+}
+
+// The GetEvents method returns the events of camera id, named as Camera
+// names it, through the session of its account, as Cameras.GetEvents does
+func (m *MultiAccount) GetEvents(ctx context.Context, id string, st, et time.Time) (Events, error) {
+	cam, err := m.Camera(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return cam.Cameras.GetEvents(ctx, cam.Camera, st, et)
+}
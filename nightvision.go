@@ -0,0 +1,323 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The NightVision type switches the night vision of the cameras in UUIDs,
+// every owned camera when empty, between Night and Day modes: at sunset and
+// sunrise where the camera is, or at the times of the cron expressions
+// NightCron and DayCron, such as "0 19 * * *" and "30 6 * * 1-5", in the
+// camera's zone. Night defaults to IRLEDOn and Day to IRLEDOff.
+//
+// Cameras are placed by the coordinates of their location, or by Position
+// when it has none; cameras with neither are left alone unless the cron
+// expressions are set. A camera is only changed when its mode is due to
+// change, so manual changes stand until the next sunrise or sunset.
+type NightVision struct {
+	Cameras   *Cameras
+	UUIDs     []string
+	Position  *Position
+	Night     IRLEDMode
+	Day       IRLEDMode
+	NightCron string
+	DayCron   string
+	Interval  time.Duration
+
+	mu      sync.Mutex
+	night   *cronSchedule
+	day     *cronSchedule
+	applied map[string]IRLEDMode
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Start checks the configuration and begins switching in the background
+func (nv *NightVision) Start() error {
+
+	if nv.Cameras == nil {
+		return errors.New("NightVision needs Cameras")
+	}
+	if (nv.NightCron == "") != (nv.DayCron == "") {
+		return errors.New("NightVision needs both NightCron and DayCron, or neither")
+	}
+	if nv.NightCron != "" {
+		var err error
+		if nv.night, err = parseCron(nv.NightCron); err != nil {
+			return fmt.Errorf("NightCron: %w", err)
+		}
+		if nv.day, err = parseCron(nv.DayCron); err != nil {
+			return fmt.Errorf("DayCron: %w", err)
+		}
+	}
+	if nv.Night == "" {
+		nv.Night = IRLEDOn
+	}
+	if nv.Day == "" {
+		nv.Day = IRLEDOff
+	}
+	for _, mode := range []IRLEDMode{nv.Night, nv.Day} {
+		switch mode {
+		case IRLEDAuto, IRLEDOn, IRLEDOff:
+		default:
+			return fmt.Errorf("Invalid IR LED mode %q", mode)
+		}
+	}
+	if nv.Interval <= 0 {
+		nv.Interval = time.Minute
+	}
+
+	nv.applied = make(map[string]IRLEDMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	nv.cancel = cancel
+	nv.done = make(chan struct{})
+	go nv.run(ctx)
+	return nil
+}
+
+// Stop ends the background loop. Cameras are left in their current mode.
+func (nv *NightVision) Stop() {
+	nv.cancel()
+	<-nv.done
+}
+
+func (nv *NightVision) run(ctx context.Context) {
+	defer close(nv.done)
+
+	tick := time.NewTicker(nv.Interval)
+	defer tick.Stop()
+
+	for {
+		nv.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Mode returns the mode camera o is due to be in at t, or false when o
+// cannot be placed
+func (nv *NightVision) Mode(o *Owned, t time.Time) (IRLEDMode, bool) {
+
+	night, ok := nv.isNight(o, t)
+	if !ok {
+		return "", false
+	}
+	if night {
+		return nv.Night, true
+	}
+	return nv.Day, true
+}
+
+func (nv *NightVision) isNight(o *Owned, t time.Time) (bool, bool) {
+
+	if nv.night != nil {
+		local := o.In(t)
+		lastNight, okNight := nv.night.prev(local)
+		lastDay, okDay := nv.day.prev(local)
+		if !okNight && !okDay {
+			return false, false
+		}
+		return okNight && (!okDay || lastNight.After(lastDay)), true
+	}
+
+	pos, ok := o.Position()
+	if !ok {
+		if nv.Position == nil {
+			return false, false
+		}
+		pos = *nv.Position
+	}
+	return !Daylight(pos, t), true
+}
+
+// covers reports whether camera uuid is switched
+func (nv *NightVision) covers(uuid string) bool {
+	if len(nv.UUIDs) == 0 {
+		return true
+	}
+	for _, u := range nv.UUIDs {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func (nv *NightVision) check(ctx context.Context, now time.Time) {
+
+	nv.mu.Lock()
+	defer nv.mu.Unlock()
+	for i := range nv.Cameras.Cam {
+		o := &nv.Cameras.Cam[i]
+		if !nv.covers(o.Uuid) {
+			continue
+		}
+		mode, ok := nv.Mode(o, now)
+		if !ok {
+			if _, warned := nv.applied[o.Uuid]; !warned {
+				warnf("nightvision: %s has no position; set NightVision.Position or cron times\n", o.Title)
+				nv.applied[o.Uuid] = ""
+			}
+			continue
+		}
+		if nv.applied[o.Uuid] == mode {
+			continue
+		}
+		if err := nv.Cameras.SetIRLED(ctx, o, mode); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			warnf("nightvision: failed to set %s on %s: %s\n", mode, o.Title, err)
+			continue
+		}
+		Dbg("nightvision: %s set to %s\n", o.Title, mode)
+		nv.applied[o.Uuid] = mode
+	}
+}
+
+// cronSchedule is a five field cron expression: minute, hour, day of month,
+// month and day of week, each a '*', or a list of values and ranges with
+// optional steps, such as "1-5" or "*/15"
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s", spec, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	// days of the month that none of its months have never come
+	if fields[2] != "*" && fields[4] == "*" && !cronDaysExist(sets[2], sets[3]) {
+		return nil, fmt.Errorf("cron expression %q never fires", spec)
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		anyDom: fields[2] == "*", anyDow: fields[4] == "*",
+	}, nil
+}
+
+// CheckCron reports whether spec is a cron expression NightVision accepts,
+// failing too for ones that never fire, such as "0 0 31 2 *"
+func CheckCron(spec string) error {
+	_, err := parseCron(spec)
+	return err
+}
+
+// cronDaysExist reports whether any month in months has a day in days
+func cronDaysExist(days, months uint64) bool {
+	length := [13]uint{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+	for m := 1; m <= 12; m++ {
+		if months&(1<<uint(m)) != 0 && days&(1<<(length[m]+1)-1) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// match reports whether the minute of t is one of the schedule's
+func (s *cronSchedule) match(t time.Time) bool {
+
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	// as in cron, restricting both days matches either
+	switch {
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// prev returns the last minute of the schedule at or before t, looking back
+// up to a year
+func (s *cronSchedule) prev(t time.Time) (time.Time, bool) {
+
+	t = t.Truncate(time.Minute)
+	limit := t.AddDate(-1, 0, 0)
+	for ; t.After(limit); t = t.Add(-time.Minute) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			// skip to the last minute of the month before
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+			continue
+		}
+		if s.match(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
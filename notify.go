@@ -0,0 +1,115 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"sync"
+	"time"
+)
+
+// Notification kinds
+const (
+	NotifyChange   = "change"
+	NotifyDigest   = "digest"
+	NotifyDrift    = "drift"
+	NotifyReport   = "report"
+	NotifyEvent    = "event"
+	NotifyOffline  = "offline"
+	NotifyOnline   = "online"
+	NotifySnapshot = "snapshot"
+	NotifyTrial    = "trial"
+)
+
+// The Notification type is a single alert delivered through a Notifier
+type Notification struct {
+	Kind    string    `json:"kind"`
+	Camera  string    `json:"camera_uuid"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Labels  []string  `json:"labels,omitempty"`
+	Image   []byte    `json:"-"`
+
+	// EventType is the Dropcam event type, e.g. "motion", of NotifyEvent notifications
+	EventType string `json:"event_type,omitempty"`
+
+	// HTML is an optional rich rendering of Message for sinks that can show it
+	HTML string `json:"-"`
+
+	// Media is the path or storage key of a video the notification refers to
+	Media string `json:"media,omitempty"`
+
+	// ClipURL is a link to the event's clip that opens without logging in
+	ClipURL string `json:"clip_url,omitempty"`
+}
+
+// The Notifier interface is implemented by anything that can deliver a Notification
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// NotifierFunc adapts an ordinary function to the Notifier interface
+type NotifierFunc func(n *Notification) error
+
+// Notify calls f(n)
+func (f NotifierFunc) Notify(n *Notification) error {
+	return f(n)
+}
+
+// Notifiers fans a Notification out to every notifier in the list.
+// All notifiers are tried; the first error encountered is returned.
+type Notifiers []Notifier
+
+// Notify delivers n to each notifier in turn
+func (ns Notifiers) Notify(n *Notification) error {
+	var first error
+	for _, nf := range ns {
+		if err := nf.Notify(n); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// The Mute type silences notifications for all cameras or for single cameras
+// until a deadline
+type Mute struct {
+	mu    sync.Mutex
+	all   time.Time
+	until map[string]time.Time
+}
+
+// MuteFor silences camera uuid for d; an empty uuid silences every camera
+func (m *Mute) MuteFor(uuid string, d time.Duration) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until := time.Now().Add(d)
+	if uuid == "" {
+		m.all = until
+		return until
+	}
+	if m.until == nil {
+		m.until = make(map[string]time.Time)
+	}
+	m.until[uuid] = until
+	return until
+}
+
+// Muted reports whether notifications for camera uuid are silenced at time t
+func (m *Mute) Muted(uuid string, t time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return t.Before(m.all) || t.Before(m.until[uuid])
+}
+
+// Notifier wraps n so notifications are dropped while muted
+func (m *Mute) Notifier(n Notifier) Notifier {
+	return NotifierFunc(func(note *Notification) error {
+		if m.Muted(note.Camera, note.Time) {
+			return nil
+		}
+		return n.Notify(note)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultWaitOnlineInterval is how often WaitOnline asks whether a camera is back
+const DefaultWaitOnlineInterval = 10 * time.Second
+
+// onlineState remembers which cameras were online in the latest camera list,
+// so jobs holding an older copy of a camera notice it going and coming back
+type onlineState struct {
+	mu     sync.Mutex
+	online map[string]bool
+}
+
+func (s *onlineState) note(c *Cameras) {
+	for _, o := range c.all() {
+		s.set(o.Uuid, o.IsOnline)
+	}
+}
+
+func (s *onlineState) set(uuid string, online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.online == nil {
+		s.online = make(map[string]bool)
+	}
+	s.online[uuid] = online
+}
+
+// isOnline reports whether camera o is online, as of the latest camera list
+// when o is in it
+func (d *Dropcam) isOnline(o *Owned) bool {
+	d.online.mu.Lock()
+	online, ok := d.online.online[o.Uuid]
+	d.online.mu.Unlock()
+	if ok {
+		return online
+	}
+	return o.IsOnline
+}
+
+// requireOnline returns ErrCameraOffline, naming op, unless camera o is online
+func (d *Dropcam) requireOnline(op string, o *Owned) error {
+	if d.isOnline(o) {
+		return nil
+	}
+	return fmt.Errorf("%s Failed: %s is offline: %w", op, o.Title, ErrCameraOffline)
+}
+
+// The WaitOnline method waits until camera o is online again, asking
+// cameras.get every DefaultWaitOnlineInterval, for up to timeout (no limit
+// when zero) or until ctx is done. The state seen is stored in o, so calls
+// that failed with ErrCameraOffline can be retried with o once it returns.
+func (c *Cameras) WaitOnline(ctx context.Context, o *Owned, timeout time.Duration) error {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tick := time.NewTicker(DefaultWaitOnlineInterval)
+	defer tick.Stop()
+
+	for {
+		cur, err := c.GetCamera(ctx, o.Uuid)
+		if err == nil {
+			o.IsOnline = cur.IsOnline
+			o.IsConnected = cur.IsConnected
+			o.IsStreaming = cur.IsStreaming
+			c.Dropcam.online.set(o.Uuid, o.IsOnline)
+			if o.IsOnline {
+				return nil
+			}
+		} else if ctx.Err() == nil {
+			Dbg("wait online: %s: %s\n", o.Title, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not come back online: %w", o.Title, ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsDiscoveryAddr is the multicast group ONVIF clients probe for devices
+const wsDiscoveryAddr = "239.255.255.250:3702"
+
+// The ONVIFServer type makes the cameras look like standard IP cameras to
+// network video recorders such as ZoneMinder, Frigate or Synology Surveillance
+// Station. Each camera is an ONVIF Profile S device answering the device and
+// media services at
+//
+//	/onvif/<uuid>/device_service
+//	/onvif/<uuid>/media_service
+//
+// whose stream URI is a Motion JPEG stream served by MJPEG and whose snapshot
+// URI a JPEG served by Images, both below /cameras/<uuid>/. There is no RTSP:
+// recorders must be set to take the stream over HTTP, as most can for MJPEG.
+// BaseURL is the address recorders reach the server at, e.g.
+// "http://192.168.1.10:8080"; it is advertised in every URI.
+//
+// Discover answers WS-Discovery probes so the cameras appear in the
+// recorder's device search. When Guard is set, callers need the read scope;
+// WS-Security headers in the SOAP requests are not checked.
+type ONVIFServer struct {
+	Cameras *Cameras
+	BaseURL string
+	MJPEG   *MJPEGServer
+	Images  *ImageCache
+	Guard   Guard
+
+	once   sync.Once
+	mjpeg  *MJPEGServer
+	images *ImageCache
+}
+
+func (s *ONVIFServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Guard != nil {
+		Protect(s.Guard, ScopeRead, http.HandlerFunc(s.serve)).ServeHTTP(w, r)
+		return
+	}
+	s.serve(w, r)
+}
+
+func (s *ONVIFServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.once.Do(func() {
+		s.images = s.Images
+		if s.images == nil {
+			s.images = &ImageCache{Cameras: s.Cameras}
+		}
+		s.mjpeg = s.MJPEG
+		if s.mjpeg == nil {
+			s.mjpeg = &MJPEGServer{Cameras: s.Cameras, Images: s.images}
+		}
+	})
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || (parts[0] != "onvif" && parts[0] != "cameras") {
+		http.NotFound(w, r)
+		return
+	}
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	o := s.Cameras.findCamera(parts[1])
+	if o == nil {
+		http.Error(w, "unknown camera", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case parts[0] == "cameras" && parts[2] == "stream.mjpeg":
+		s.mjpeg.serve(w, r)
+	case parts[0] == "cameras" && parts[2] == "snapshot.jpg":
+		s.images.serve(w, r)
+	case parts[0] == "onvif" && (parts[2] == "device_service" || parts[2] == "media_service"):
+		s.soap(w, r, o)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// soap answers an ONVIF request for camera o
+func (s *ONVIFServer) soap(w http.ResponseWriter, r *http.Request, o *Owned) {
+
+	if r.Method != "POST" {
+		http.Error(w, "SOAP requests are POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	action, err := soapAction(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		soapReply(w, http.StatusBadRequest, soapFault("env:Sender", "ter:WellFormed", err.Error()))
+		return
+	}
+
+	base := strings.TrimRight(s.BaseURL, "/")
+	device := base + "/onvif/" + o.Uuid + "/device_service"
+	media := base + "/onvif/" + o.Uuid + "/media_service"
+
+	var body string
+	switch action {
+	case "GetSystemDateAndTime":
+		now := time.Now().UTC()
+		body = fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime><tt:DateTimeType>NTP</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings><tt:UTCDateTime><tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time><tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date></tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+			now.Hour(), now.Minute(), now.Second(), now.Year(), int(now.Month()), now.Day())
+	case "GetDeviceInformation":
+		body = fmt.Sprintf(`<tds:GetDeviceInformationResponse><tds:Manufacturer>Dropcam</tds:Manufacturer><tds:Model>%s</tds:Model><tds:FirmwareVersion>dropcam-onvif</tds:FirmwareVersion><tds:SerialNumber>%s</tds:SerialNumber><tds:HardwareId>%s</tds:HardwareId></tds:GetDeviceInformationResponse>`,
+			xmlEscape(o.Title), xmlEscape(o.Uuid), xmlEscape(o.MacAddress))
+	case "GetCapabilities":
+		body = fmt.Sprintf(`<tds:GetCapabilitiesResponse><tds:Capabilities><tt:Device><tt:XAddr>%s</tt:XAddr></tt:Device><tt:Media><tt:XAddr>%s</tt:XAddr><tt:StreamingCapabilities><tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>false</tt:RTP_TCP><tt:RTP_RTSP_TCP>false</tt:RTP_RTSP_TCP></tt:StreamingCapabilities></tt:Media></tds:Capabilities></tds:GetCapabilitiesResponse>`,
+			xmlEscape(device), xmlEscape(media))
+	case "GetServices":
+		body = fmt.Sprintf(`<tds:GetServicesResponse><tds:Service><tds:Namespace>http://www.onvif.org/ver10/device/wsdl</tds:Namespace><tds:XAddr>%s</tds:XAddr><tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service><tds:Service><tds:Namespace>http://www.onvif.org/ver10/media/wsdl</tds:Namespace><tds:XAddr>%s</tds:XAddr><tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service></tds:GetServicesResponse>`,
+			xmlEscape(device), xmlEscape(media))
+	case "GetScopes":
+		body = `<tds:GetScopesResponse>`
+		for _, sc := range onvifScopes(o) {
+			body += `<tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>` + xmlEscape(sc) + `</tt:ScopeItem></tds:Scopes>`
+		}
+		body += `</tds:GetScopesResponse>`
+	case "GetVideoSources":
+		body = `<trt:GetVideoSourcesResponse><trt:VideoSources token="source"><tt:Framerate>1</tt:Framerate><tt:Resolution><tt:Width>1280</tt:Width><tt:Height>720</tt:Height></tt:Resolution></trt:VideoSources></trt:GetVideoSourcesResponse>`
+	case "GetProfiles", "GetProfile":
+		elem := "Profile"
+		if action == "GetProfiles" {
+			elem = "Profiles"
+		}
+		profile := fmt.Sprintf(`<trt:%s token="main" fixed="true"><tt:Name>%s</tt:Name><tt:VideoSourceConfiguration token="source"><tt:Name>source</tt:Name><tt:UseCount>1</tt:UseCount><tt:SourceToken>source</tt:SourceToken><tt:Bounds x="0" y="0" width="1280" height="720"/></tt:VideoSourceConfiguration><tt:VideoEncoderConfiguration token="mjpeg"><tt:Name>mjpeg</tt:Name><tt:UseCount>1</tt:UseCount><tt:Encoding>JPEG</tt:Encoding><tt:Resolution><tt:Width>1280</tt:Width><tt:Height>720</tt:Height></tt:Resolution><tt:Quality>5</tt:Quality><tt:RateControl><tt:FrameRateLimit>1</tt:FrameRateLimit><tt:EncodingInterval>1</tt:EncodingInterval><tt:BitrateLimit>0</tt:BitrateLimit></tt:RateControl><tt:SessionTimeout>PT60S</tt:SessionTimeout></tt:VideoEncoderConfiguration></trt:%s>`,
+			elem, xmlEscape(o.Title), elem)
+		body = `<trt:` + action + `Response>` + profile + `</trt:` + action + `Response>`
+	case "GetStreamUri":
+		body = fmt.Sprintf(`<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri>%s</tt:Uri><tt:InvalidAfterConnect>false</tt:InvalidAfterConnect><tt:InvalidAfterReboot>false</tt:InvalidAfterReboot><tt:Timeout>PT0S</tt:Timeout></trt:MediaUri></trt:GetStreamUriResponse>`,
+			xmlEscape(base+"/cameras/"+o.Uuid+"/stream.mjpeg"))
+	case "GetSnapshotUri":
+		body = fmt.Sprintf(`<trt:GetSnapshotUriResponse><trt:MediaUri><tt:Uri>%s</tt:Uri><tt:InvalidAfterConnect>false</tt:InvalidAfterConnect><tt:InvalidAfterReboot>false</tt:InvalidAfterReboot><tt:Timeout>PT0S</tt:Timeout></trt:MediaUri></trt:GetSnapshotUriResponse>`,
+			xmlEscape(base+"/cameras/"+o.Uuid+"/snapshot.jpg"))
+	default:
+		soapReply(w, http.StatusBadRequest, soapFault("env:Receiver", "ter:ActionNotSupported", action+" is not supported"))
+		return
+	}
+	soapReply(w, http.StatusOK, body)
+}
+
+// soapAction returns the name of the operation in a SOAP request: the first
+// element of its Body
+func soapAction(r io.Reader) (string, error) {
+
+	dec := xml.NewDecoder(r)
+	inBody := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", fmt.Errorf("Malformed SOAP request: %s", err)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			if inBody {
+				return se.Name.Local, nil
+			}
+			inBody = se.Name.Local == "Body"
+		}
+	}
+}
+
+func soapFault(code, subcode, reason string) string {
+	return fmt.Sprintf(`<env:Fault><env:Code><env:Value>%s</env:Value><env:Subcode><env:Value>%s</env:Value></env:Subcode></env:Code><env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason></env:Fault>`, code, subcode, xmlEscape(reason))
+}
+
+func xmlEscape(v string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(v))
+	return b.String()
+}
+
+func soapReply(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:ter="http://www.onvif.org/ver10/error"><env:Body>%s</env:Body></env:Envelope>`, body)
+}
+
+// onvifScopes returns the discovery scopes of camera o
+func onvifScopes(o *Owned) []string {
+	scopes := []string{
+		"onvif://www.onvif.org/Profile/Streaming",
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/hardware/Dropcam",
+		"onvif://www.onvif.org/name/" + strings.Replace(o.Title, " ", "_", -1),
+	}
+	if loc := o.LocationName(); loc != "" {
+		scopes = append(scopes, "onvif://www.onvif.org/location/"+strings.Replace(loc, " ", "_", -1))
+	}
+	return scopes
+}
+
+// onvifUUID formats a camera uuid as the URN of its ONVIF endpoint
+func onvifUUID(uuid string) string {
+	if len(uuid) == 32 {
+		uuid = uuid[:8] + "-" + uuid[8:12] + "-" + uuid[12:16] + "-" + uuid[16:20] + "-" + uuid[20:]
+	}
+	return "urn:uuid:" + uuid
+}
+
+// Discover answers WS-Discovery probes for ONVIF devices on the local network
+// with every camera, until ctx is done
+func (s *ONVIFServer) Discover(ctx context.Context) error {
+
+	group, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("Failed to join WS-Discovery group: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		msgID, ok := wsProbe(buf[:n])
+		if !ok {
+			continue
+		}
+		for _, o := range s.Cameras.all() {
+			if _, err := conn.WriteToUDP(s.probeMatch(o, msgID), from); err != nil {
+				Dbg("onvif: probe reply to %s: %s\n", from, err)
+			}
+		}
+	}
+}
+
+// wsProbe reports whether msg is a WS-Discovery Probe and returns its MessageID
+func wsProbe(msg []byte) (string, bool) {
+
+	dec := xml.NewDecoder(bytes.NewReader(msg))
+	var id string
+	probe := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return id, probe
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "Probe":
+			probe = true
+		case "MessageID":
+			var v string
+			if dec.DecodeElement(&v, &se) == nil {
+				id = strings.TrimSpace(v)
+			}
+		}
+	}
+}
+
+// probeMatch is the reply to probe msgID announcing camera o
+func (s *ONVIFServer) probeMatch(o *Owned, msgID string) []byte {
+
+	var b [16]byte
+	rand.Read(b[:])
+	id := fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	xaddr := strings.TrimRight(s.BaseURL, "/") + "/onvif/" + o.Uuid + "/device_service"
+
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">`+
+		`<env:Header><wsa:MessageID>%s</wsa:MessageID><wsa:RelatesTo>%s</wsa:RelatesTo><wsa:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</wsa:To><wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action></env:Header>`+
+		`<env:Body><d:ProbeMatches><d:ProbeMatch><wsa:EndpointReference><wsa:Address>%s</wsa:Address></wsa:EndpointReference><d:Types>dn:NetworkVideoTransmitter</d:Types><d:Scopes>%s</d:Scopes><d:XAddrs>%s</d:XAddrs><d:MetadataVersion>1</d:MetadataVersion></d:ProbeMatch></d:ProbeMatches></env:Body></env:Envelope>`,
+		id, xmlEscape(msgID), onvifUUID(o.Uuid), xmlEscape(strings.Join(onvifScopes(o), " ")), xmlEscape(xaddr)))
+}
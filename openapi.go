@@ -0,0 +1,161 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// OpenAPI returns the OpenAPI 3 document of the endpoints of APIHandler,
+// which it serves as /openapi.json. The schemas of CameraJSON and EventJSON
+// are generated from their fields, so the document follows them as they
+// change; feed it to a generator for a client in another language.
+func OpenAPI() map[string]interface{} {
+
+	ref := func(name string) map[string]interface{} {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	uuid := map[string]interface{}{
+		"name": "uuid", "in": "path", "required": true,
+		"description": "camera uuid",
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+	query := func(name, typ, desc string) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name, "in": "query", "description": desc,
+			"schema": map[string]interface{}{"type": typ},
+		}
+	}
+	content := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+	}
+	ok := func(desc string, schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"description": desc, "content": content(schema)}
+	}
+	failed := map[string]interface{}{"description": "the error", "content": content(ref("Error"))}
+	op := func(id, summary string, params []interface{}, success map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"operationId": id,
+			"summary":     summary,
+			"parameters":  params,
+			"responses":   map[string]interface{}{"200": success, "default": failed},
+		}
+	}
+	array := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+
+	jpeg := map[string]interface{}{
+		"description": "the current JPEG",
+		"content": map[string]interface{}{"image/jpeg": map[string]interface{}{
+			"schema": map[string]interface{}{"type": "string", "format": "binary"},
+		}},
+	}
+	enabled := query("enabled", "boolean", "whether the camera streams")
+	enabled["required"] = true
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "dropcam",
+			"version": "1",
+			"description": "The cameras of a Dropcam account. GET requests need the read scope " +
+				"and POST requests the control scope.",
+		},
+		"paths": map[string]interface{}{
+			"/cameras": map[string]interface{}{
+				"get": op("listCameras", "the cameras", []interface{}{}, ok("the cameras", array(ref("Camera")))),
+			},
+			"/cameras/{uuid}": map[string]interface{}{
+				"get": op("getCamera", "one camera", []interface{}{uuid}, ok("the camera", ref("Camera"))),
+			},
+			"/cameras/{uuid}/snapshot": map[string]interface{}{
+				"get": op("getSnapshot", "the current image", []interface{}{uuid,
+					query("width", "integer", "width in pixels")}, jpeg),
+			},
+			"/cameras/{uuid}/events": map[string]interface{}{
+				"get": op("listEvents", "the events, the last day by default", []interface{}{uuid,
+					query("since", "string", `a duration back from now, like "2h", or an RFC 3339 time`),
+					query("type", "string", "comma-separated event types"),
+					query("important", "boolean", "only important events")},
+					ok("the events", array(ref("Event")))),
+			},
+			"/cameras/{uuid}/streaming": map[string]interface{}{
+				"post": op("setStreaming", "turns the camera on or off", []interface{}{uuid, enabled},
+					ok("the camera", ref("Camera"))),
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Camera": jsonSchema(reflect.TypeOf(CameraJSON{})),
+				"Event":  jsonSchema(reflect.TypeOf(EventJSON{})),
+				"Error": map[string]interface{}{
+					"type":       "object",
+					"required":   []string{"error"},
+					"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"key":  map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"user": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"key": []string{}},
+			map[string]interface{}{"user": []string{}},
+		},
+	}
+}
+
+// jsonSchema returns the schema of the JSON encoding of values of type t
+func jsonSchema(t reflect.Type) map[string]interface{} {
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			name, opts := tag, ""
+			if i := strings.Index(tag, ","); i >= 0 {
+				name, opts = tag[:i], tag[i:]
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchema(f.Type)
+			if !strings.Contains(opts, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": props, "required": required}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,74 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestOpenAPI(t *testing.T) {
+
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	h := &dropcam.APIHandler{Cameras: login(t, s), Guard: &dropcam.APIKeys{}}
+
+	// served without a key
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Type   string `json:"type"`
+					Format string `json:"format"`
+				} `json:"properties"`
+				Required []string `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi %q, want 3.x", doc.OpenAPI)
+	}
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	want := "/cameras /cameras/{uuid} /cameras/{uuid}/events /cameras/{uuid}/snapshot /cameras/{uuid}/streaming"
+	if got := strings.Join(paths, " "); got != want {
+		t.Errorf("paths %s, want %s", got, want)
+	}
+
+	// the schemas follow the JSON forms
+	camera, event := doc.Components.Schemas["Camera"], doc.Components.Schemas["Event"]
+	if p := camera.Properties["uuid"]; p.Type != "string" {
+		t.Errorf("Camera uuid is %+v", p)
+	}
+	if p := event.Properties["start"]; p.Type != "string" || p.Format != "date-time" {
+		t.Errorf("Event start is %+v", p)
+	}
+	if p := event.Properties["zones"]; p.Type != "array" {
+		t.Errorf("Event zones is %+v", p)
+	}
+	if _, ok := camera.Properties["mac_address"]; !ok || strings.Contains(strings.Join(camera.Required, " "), "mac_address") {
+		t.Errorf("Camera mac_address missing or required: %v", camera.Required)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"time"
+)
+
+// Corners of the image an Overlay can be drawn in
+const (
+	OverlayTopLeft     = "top-left"
+	OverlayTopRight    = "top-right"
+	OverlayBottomLeft  = "bottom-left"
+	OverlayBottomRight = "bottom-right"
+)
+
+// DefaultOverlayTimeFormat is how an Overlay writes the capture time when
+// Overlay.TimeFormat is empty
+const DefaultOverlayTimeFormat = "2006-01-02 15:04:05 MST"
+
+// The Overlay type stamps snapshots with when and where they were taken, so
+// archived frames carry the evidence themselves. Each line set is drawn in
+// the built-in bitmap font: the camera title with Title, the capture time in
+// Location (the local zone when nil) and TimeFormat with Time, and Text. The
+// lines are drawn in the corner Position, bottom-left when empty, in Color,
+// white when nil, on Background, translucent black when nil, magnified by
+// Scale.
+//
+// Set as Dropcam.Overlay it is drawn on every snapshot after Pipeline; the
+// capture time is the time asked for, or the time of the request for live
+// images.
+type Overlay struct {
+	Title      bool
+	Time       bool
+	Text       string
+	TimeFormat string
+	Location   *time.Location
+	Position   string
+	Color      color.Color
+	Background color.Color
+	Scale      int
+}
+
+// lines returns the text the overlay draws for camera o at t
+func (ov *Overlay) lines(o *Owned, t time.Time) []string {
+
+	var lines []string
+	if ov.Title && o != nil && o.Title != "" {
+		lines = append(lines, o.Title)
+	}
+	if ov.Time && !t.IsZero() {
+		loc := ov.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		layout := ov.TimeFormat
+		if layout == "" {
+			layout = DefaultOverlayTimeFormat
+		}
+		lines = append(lines, t.In(loc).Format(layout))
+	}
+	if ov.Text != "" {
+		lines = append(lines, strings.Split(ov.Text, "\n")...)
+	}
+	return lines
+}
+
+// Draw returns img stamped for camera o, which may be nil, captured at t
+func (ov *Overlay) Draw(img image.Image, o *Owned, t time.Time) image.Image {
+
+	lines := ov.lines(o, t)
+	if len(lines) == 0 {
+		return img
+	}
+	scale := ov.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	fg, bg := ov.Color, ov.Background
+	if fg == nil {
+		fg = color.White
+	}
+	if bg == nil {
+		bg = color.RGBA{0, 0, 0, 160}
+	}
+
+	// the box around the lines, with a margin of 2 pixels per scale
+	pad := 2 * scale
+	var box image.Point
+	for _, l := range lines {
+		if sz := textSize(l, scale); sz.X > box.X {
+			box.X = sz.X
+		}
+		box.Y += glyphHeight * scale
+	}
+	box = box.Add(image.Pt(2*pad, 2*pad))
+
+	dst := toRGBA(img)
+	b := dst.Bounds()
+	at := image.Pt(b.Min.X, b.Max.Y-box.Y)
+	switch ov.Position {
+	case OverlayTopLeft:
+		at = b.Min
+	case OverlayTopRight:
+		at = image.Pt(b.Max.X-box.X, b.Min.Y)
+	case OverlayBottomRight:
+		at = b.Max.Sub(box)
+	}
+	r := image.Rectangle{at, at.Add(box)}.Intersect(b)
+
+	draw.Draw(dst, r, image.NewUniform(bg), image.Point{}, draw.Over)
+	for i, l := range lines {
+		drawText(dst, r.Min.Add(image.Pt(pad, pad+i*glyphHeight*scale)), l, fg, scale)
+	}
+	return dst
+}
+
+// processor returns a pipeline stage drawing the overlay for o at t
+func (ov *Overlay) processor(o *Owned, t time.Time) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+		return ov.Draw(img, o, t), nil
+	})
+}
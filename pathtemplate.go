@@ -0,0 +1,210 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPathTemplate lays captures out by camera and day
+const DefaultPathTemplate PathTemplate = "{camera}/{yyyy}/{mm}/{dd}/{hhmmss}.jpg"
+
+// pathField matches the fields of a PathTemplate
+var pathField = regexp.MustCompile(`\{[a-z]+\}`)
+
+// unsafePath matches what is replaced in titles used as path elements
+var unsafePath = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// A PathTemplate names a capture relative to a root folder, with these
+// fields replaced, times being in the camera's zone:
+//
+//	{camera} {uuid}  the camera uuid
+//	{title}          the camera title, with anything but letters, digits, '.', '_' and '-' as '_'
+//	{yyyy} {mm} {dd} the date
+//	{yyyymmdd}       the date as one field
+//	{hh} {min} {ss}  the time of day
+//	{hhmmss}         the time of day as one field
+//	{seq}            the number of the capture, for Timelapse
+//	{event}          the event id, for EventRecorder
+//	{offset}         the capture's offset from the event's start, e.g. "+004s"
+//
+// Unknown fields are left as they are. Folders are created as needed.
+type PathTemplate string
+
+// The PathFields type holds the values of a PathTemplate's fields besides
+// the camera and time
+type PathFields struct {
+	Seq    int
+	Event  int64
+	Offset time.Duration
+}
+
+// The Expand method returns the name of the capture of camera o at t, with
+// forward slashes
+func (p PathTemplate) Expand(o *Owned, t time.Time, f PathFields) string {
+
+	t = o.In(t)
+	name := pathField.ReplaceAllStringFunc(string(p), func(field string) string {
+		switch field {
+		case "{camera}", "{uuid}":
+			return o.Uuid
+		case "{title}":
+			return strings.Trim(unsafePath.ReplaceAllString(o.Title, "_"), "_")
+		case "{yyyy}":
+			return t.Format("2006")
+		case "{mm}":
+			return t.Format("01")
+		case "{dd}":
+			return t.Format("02")
+		case "{yyyymmdd}":
+			return t.Format("20060102")
+		case "{hh}":
+			return t.Format("15")
+		case "{min}":
+			return t.Format("04")
+		case "{ss}":
+			return t.Format("05")
+		case "{hhmmss}":
+			return t.Format("150405")
+		case "{seq}":
+			return fmt.Sprintf("%06d", f.Seq)
+		case "{event}":
+			return fmt.Sprint(f.Event)
+		case "{offset}":
+			return fmt.Sprintf("%+04ds", int(f.Offset/time.Second))
+		}
+		return field
+	})
+	return path.Clean("/" + name)[1:]
+}
+
+// The File method is Expand below root, as a file path
+func (p PathTemplate) File(root string, o *Owned, t time.Time, f PathFields) string {
+	return filepath.Join(root, filepath.FromSlash(p.Expand(o, t, f)))
+}
+
+// dayDir returns the folder p puts camera o's captures of day in, or false
+// when p's folders do not divide captures by day alone
+func (p PathTemplate) dayDir(o *Owned, day time.Time) (string, bool) {
+
+	dir := path.Dir(string(p))
+	daily := false
+	for _, field := range pathField.FindAllString(dir, -1) {
+		switch field {
+		case "{camera}", "{uuid}", "{title}", "{yyyy}", "{mm}":
+		case "{dd}", "{yyyymmdd}":
+			daily = true
+		default:
+			return "", false
+		}
+	}
+	if !daily {
+		return "", false
+	}
+	return PathTemplate(dir).Expand(o, day, PathFields{}), true
+}
+
+// The SaveImageTo method saves a snapshot of camera o, taken at st as
+// SaveImage does, at the name p gives it below root, and returns its path
+func (c *Cameras) SaveImageTo(ctx context.Context, o *Owned, root string, p PathTemplate, width int, st time.Time) (string, error) {
+
+	taken := st
+	if taken.IsZero() {
+		taken = time.Now()
+	}
+	fn := p.File(root, o, taken, PathFields{})
+	written, err := c.saveNewImage(ctx, o, fn, width, st, UploadSnapshot)
+	if err != nil {
+		return "", err
+	}
+	if written != "" {
+		fn = written
+	}
+	return fn, nil
+}
+
+// SnapshotPathJob is SnapshotJob naming the snapshots with p below root
+func SnapshotPathJob(c *Cameras, o *Owned, root string, p PathTemplate, width int, interval time.Duration) Job {
+	return Job{
+		Name:     "snapshot-" + o.Uuid,
+		Camera:   o.Uuid,
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			now := time.Now()
+			_, err := c.SaveNewImage(ctx, o, p.File(root, o, now, PathFields{}), width, now)
+			return err
+		},
+	}
+}
+
+// The Rotation type bundles each finished day of captures laid out by Path
+// below Root, DefaultPathTemplate when empty, into one Archive per camera and
+// day, in Format, stored in Storage. With Remove set the day's folder is
+// deleted once archived. Path must have a folder per day, such as
+// "{camera}/{yyyymmdd}/{hhmmss}.jpg".
+type Rotation struct {
+	Cameras *Cameras
+	Root    string
+	Path    PathTemplate
+	Format  string
+	Storage Storage
+	Remove  bool
+}
+
+// Day archives every camera's captures of day, in the camera's zone, and
+// returns the manifests of the archives made
+func (r *Rotation) Day(day time.Time) ([]*ArchiveManifest, error) {
+
+	p := r.Path
+	if p == "" {
+		p = DefaultPathTemplate
+	}
+	var made []*ArchiveManifest
+	for _, o := range r.Cameras.all() {
+		rel, ok := p.dayDir(o, day)
+		if !ok {
+			return made, fmt.Errorf("Path template %q has no folder per day", p)
+		}
+		dir := filepath.Join(r.Root, filepath.FromSlash(rel))
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+
+		a := &Archive{Dir: dir, Camera: o.Uuid, Format: r.Format, Storage: r.Storage}
+		m, err := a.Day(o.In(day))
+		if err != nil {
+			return made, fmt.Errorf("%s: %w", o.Title, err)
+		}
+		if m == nil {
+			continue
+		}
+		made = append(made, m)
+		if r.Remove {
+			if err := os.RemoveAll(dir); err != nil {
+				return made, err
+			}
+		}
+	}
+	return made, nil
+}
+
+// Job returns a daily Job archiving the day before
+func (r *Rotation) Job() Job {
+	return Job{
+		Name:     "rotation",
+		Interval: 24 * time.Hour,
+		Run: func(ctx context.Context) error {
+			_, err := r.Day(time.Now().AddDate(0, 0, -1))
+			return err
+		},
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// maxPrealloc bounds the buffer allocated up front for a body of announced
+// length, so a lying Content-Length cannot exhaust memory
+const maxPrealloc = 32 << 20
+
+var (
+	// sharedTransport serves every Dropcam with the default DialConfig, so
+	// clients of several accounts or public cameras share their connections
+	sharedTransport     *http.Transport
+	sharedTransportOnce sync.Once
+
+	// bodyBuffers holds the buffers replies are read into when only decoded
+	bodyBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// isDefault reports whether dc is the zero DialConfig
+func (dc *DialConfig) isDefault() bool {
+	return dc.Resolver == nil && dc.Hosts == nil && !dc.PreferIPv4 && dc.Timeout == 0 &&
+		dc.MaxIdlePerHost == 0 && dc.Proxy == nil && !dc.NoProxy && dc.RootCAs == nil && !dc.InsecureSkipVerify
+}
+
+// transport returns the Transport for dc, the shared one when dc is the
+// default
+func (dc *DialConfig) transport() *http.Transport {
+	if !dc.isDefault() {
+		return dc.Transport()
+	}
+	sharedTransportOnce.Do(func() {
+		sharedTransport = dc.Transport()
+	})
+	return sharedTransport
+}
+
+// endpoint returns a copy of the URL raw, parsing it only the first time it
+// is asked for, as the same few endpoints are requested over and over
+func (d *Dropcam) endpoint(raw string) (*url.URL, error) {
+
+	if u, ok := d.urls.Load(raw); ok {
+		c := *u.(*url.URL)
+		return &c, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	d.urls.Store(raw, u)
+	c := *u
+	return &c, nil
+}
+
+// newRequest returns a request of method for the endpoint raw with the
+// encoded query
+func (d *Dropcam) newRequest(ctx context.Context, method, raw, query string, body io.Reader) (*http.Request, error) {
+
+	u, err := d.endpoint(raw)
+	if err != nil {
+		return nil, err
+	}
+	if query != "" {
+		u.RawQuery = query
+	}
+	// the URL is already parsed; set it rather than format and parse it again
+	req, err := http.NewRequestWithContext(ctx, method, "", body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL, req.Host = u, u.Host
+	return req, nil
+}
+
+// readBody reads the body of resp into a buffer sized by its Content-Length,
+// when given, rather than grown as it arrives. The room for one more read
+// is what lets the buffer see the end of the body without growing.
+func readBody(resp *http.Response) ([]byte, error) {
+
+	if n := resp.ContentLength; n > 0 && n <= maxPrealloc {
+		buf := bytes.NewBuffer(make([]byte, 0, n+bytes.MinRead))
+		_, err := buf.ReadFrom(resp.Body)
+		return buf.Bytes(), err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// readPooled reads the body of resp into a pooled buffer, for replies that
+// are decoded and dropped. The caller must hand the buffer back with
+// releaseBody once done with its bytes.
+func readPooled(resp *http.Response) (*bytes.Buffer, error) {
+	buf := bodyBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	if n := resp.ContentLength; n > 0 && n <= maxPrealloc {
+		buf.Grow(int(n) + bytes.MinRead)
+	}
+	_, err := buf.ReadFrom(resp.Body)
+	return buf, err
+}
+
+// releaseBody returns buf to the pool, unless an unusually large reply grew
+// it past what is worth keeping
+func releaseBody(buf *bytes.Buffer) {
+	if buf.Cap() <= 1<<20 {
+		bodyBuffers.Put(buf)
+	}
+}
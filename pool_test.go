@@ -0,0 +1,172 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+// The replies decoded from pooled buffers must not point into them once
+// they are back in the pool
+func TestReleasedBodyNotRead(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c := login(t, s)
+	d := c.Dropcam
+	o, _ := c.ByUUID(dropcamtest.FrontDoor)
+
+	// read through QueryEvents
+	events, err := c.GetEvents(ctx, o, time.Unix(0, 0), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEvents := append(dropcam.Events(nil), events...)
+
+	// read through decodeEnvelope
+	cam, err := c.GetCamera(ctx, o.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCam := *cam
+	token, err := d.SessionToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantToken := string(append([]byte(nil), token...))
+	items, err := d.PostRequest(ctx, "Talkback", s.URL+"/start_talkback", o.Uuid, map[string]string{"uuid": o.Uuid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantItems := string(items)
+
+	s.Fail("cameras.get", http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	_, err = c.GetCamera(ctx, o.Uuid)
+	var e *dropcam.APIError
+	if !errors.As(err, &e) {
+		t.Fatalf("GetCamera of a failing server: %v, want an APIError", err)
+	}
+	wantErr := *e
+
+	dropcam.ScribblePool()
+	// and a reply read into a released buffer over the ones above
+	if _, err := c.GetCamera(ctx, dropcamtest.Garage); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("events changed after their buffer was released:\n%+v\nwant\n%+v", events, wantEvents)
+	}
+	if !reflect.DeepEqual(*cam, wantCam) {
+		t.Errorf("camera changed after its buffer was released:\n%+v\nwant\n%+v", *cam, wantCam)
+	}
+	if token != wantToken {
+		t.Errorf("session token %q changed after its buffer was released, want %q", token, wantToken)
+	}
+	if string(items) != wantItems {
+		t.Errorf("items %s changed after their buffer was released, want %s", items, wantItems)
+	}
+	if *e != wantErr {
+		t.Errorf("error %+v changed after its buffer was released, want %+v", *e, wantErr)
+	}
+}
+
+func BenchmarkNewRequest(b *testing.B) {
+
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	d := s.Dropcam()
+	ctx := context.Background()
+	raw := s.URL + "/" + dropcam.ApiPath + "/cameras.get"
+	query := url.Values{"uuid": {dropcamtest.FrontDoor}}.Encode()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.NewRequest(ctx, "GET", raw, query, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	d, err := s.Dropcam().Init(ctx, dropcamtest.Username, dropcamtest.Password)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query := url.Values{"uuid": {dropcamtest.FrontDoor}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := d.Send(ctx, d.CamerasGet, query)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf, err := dropcam.ReadPooled(resp)
+		resp.Body.Close()
+		dropcam.ReleaseBody(buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkBody reads a snapshot of 256kB with read
+func benchmarkBody(b *testing.B, read func(*http.Response) error) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	s.SetImage(dropcamtest.FrontDoor, bytes.Repeat([]byte{0xff}, 256<<10))
+	d, err := s.Dropcam().Init(ctx, dropcamtest.Username, dropcamtest.Password)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query := url.Values{"uuid": {dropcamtest.FrontDoor}, "width": {"720"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := d.Send(ctx, d.CamerasGetImagePath, query)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = read(resp)
+		resp.Body.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadBody(b *testing.B) {
+	benchmarkBody(b, func(resp *http.Response) error {
+		_, err := dropcam.ReadBody(resp)
+		return err
+	})
+}
+
+func BenchmarkReadPooled(b *testing.B) {
+	benchmarkBody(b, func(resp *http.Response) error {
+		buf, err := dropcam.ReadPooled(resp)
+		dropcam.ReleaseBody(buf)
+		return err
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Presence is the diagnosed reachability of a camera from the local network
+type Presence int
+
+// Presence values
+const (
+	PresenceUnknown      Presence = iota
+	PresenceOnline                // the API reports the camera online
+	PresenceLANOnly               // the camera answers on the LAN but is not connected to the API
+	PresenceOffline               // the API is reachable and the camera does not answer on the LAN
+	PresenceInternetDown          // the API itself is unreachable from here
+)
+
+func (p Presence) String() string {
+	switch p {
+	case PresenceOnline:
+		return "online"
+	case PresenceLANOnly:
+		return "lan-only"
+	case PresenceOffline:
+		return "offline"
+	case PresenceInternetDown:
+		return "internet-down"
+	}
+	return "unknown"
+}
+
+// lanProbePorts are tried in turn when probing a camera on the LAN
+var lanProbePorts = []string{"80", "443"}
+
+// OnLAN reports whether a host answers at the camera's LastLocalIp. A refused
+// connection counts as an answer: it proves the host is up.
+func (o *Owned) OnLAN(timeout time.Duration) bool {
+	if o.LastLocalIp == "" {
+		return false
+	}
+	for _, port := range lanProbePorts {
+		if probe(net.JoinHostPort(o.LastLocalIp, port), timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+func probe(addr string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// The Presence method distinguishes a camera that is really offline from an
+// internet outage at the site, by probing both the Dropcam API and the camera's
+// last known LAN address. It is only meaningful when run on the camera's network.
+func (c *Cameras) Presence(o *Owned, timeout time.Duration) Presence {
+
+	api, err := url.Parse(c.Dropcam.apiBase())
+	if err != nil {
+		return PresenceUnknown
+	}
+	if !probe(net.JoinHostPort(api.Hostname(), "443"), timeout) {
+		return PresenceInternetDown
+	}
+	if o.IsOnline {
+		return PresenceOnline
+	}
+	if o.OnLAN(timeout) {
+		return PresenceLANOnly
+	}
+	return PresenceOffline
+}
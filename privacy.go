@@ -0,0 +1,276 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Window type is a span of time, typically one calendar event
+type Window struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Contains reports whether t falls inside the window
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// ParseICS reads the events of an iCalendar feed. Only DTSTART, DTEND and
+// SUMMARY are used; recurrence rules are not expanded.
+func ParseICS(r io.Reader) ([]Window, error) {
+
+	// Unfold continuation lines first
+	var lines []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var windows []Window
+	var cur *Window
+	for _, l := range lines {
+		name, value := l, ""
+		if i := strings.Index(l, ":"); i >= 0 {
+			name, value = l[:i], l[i+1:]
+		}
+		params := strings.Split(name, ";")
+
+		switch params[0] {
+		case "BEGIN":
+			if value == "VEVENT" {
+				cur = new(Window)
+			}
+		case "END":
+			if value == "VEVENT" && cur != nil {
+				if cur.End.IsZero() {
+					cur.End = cur.Start.AddDate(0, 0, 1)
+				}
+				windows = append(windows, *cur)
+				cur = nil
+			}
+		case "SUMMARY":
+			if cur != nil {
+				cur.Summary = value
+			}
+		case "DTSTART", "DTEND":
+			if cur == nil {
+				continue
+			}
+			t, err := parseICSTime(params[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			if params[0] == "DTSTART" {
+				cur.Start = t
+			} else {
+				cur.End = t
+			}
+		}
+	}
+	return windows, nil
+}
+
+func parseICSTime(params []string, value string) (time.Time, error) {
+
+	loc := time.Local
+	for _, p := range params {
+		if strings.HasPrefix(p, "TZID=") {
+			if l, err := time.LoadLocation(strings.Trim(p[5:], `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	default:
+		t, err := time.ParseInLocation("20060102T150405", value, loc)
+		if err != nil {
+			return t, errors.New("Bad iCalendar time: " + value)
+		}
+		return t, nil
+	}
+}
+
+// FetchICS downloads and parses the iCalendar feed at url
+func FetchICS(ctx context.Context, url string) ([]Window, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Calendar feed returned %s", resp.Status)
+	}
+	return ParseICS(resp.Body)
+}
+
+// The PrivacyMode type turns streaming off for the cameras in UUIDs while a
+// calendar window is active, and back on once it ends. Windows is an inline
+// schedule; Feed, if set, is an iCalendar URL whose events are added to it and
+// which is re-fetched every FeedRefresh.
+type PrivacyMode struct {
+	Cameras     *Cameras
+	UUIDs       []string
+	Windows     []Window
+	Feed        string
+	FeedRefresh time.Duration
+	Interval    time.Duration
+
+	mu      sync.Mutex
+	feed    []Window
+	fetched time.Time
+	active  bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Active reports whether privacy mode applies at time t
+func (p *PrivacyMode) Active(t time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range p.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	for _, w := range p.feed {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether camera uuid is subject to privacy mode
+func (p *PrivacyMode) covers(uuid string) bool {
+	for _, u := range p.UUIDs {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifier wraps n so notifications for covered cameras are dropped while
+// privacy mode is active
+func (p *PrivacyMode) Notifier(n Notifier) Notifier {
+	return NotifierFunc(func(note *Notification) error {
+		if p.covers(note.Camera) && p.Active(note.Time) {
+			Dbg("privacy: dropping %s notification for %s\n", note.Kind, note.Camera)
+			return nil
+		}
+		return n.Notify(note)
+	})
+}
+
+// Start begins checking the schedule in the background
+func (p *PrivacyMode) Start() error {
+
+	if p.Cameras == nil {
+		return errors.New("PrivacyMode needs Cameras")
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Minute
+	}
+	if p.FeedRefresh <= 0 {
+		p.FeedRefresh = 15 * time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx)
+	return nil
+}
+
+// Stop ends the background loop. Cameras are left in their current state.
+func (p *PrivacyMode) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+func (p *PrivacyMode) run(ctx context.Context) {
+	defer close(p.done)
+
+	tick := time.NewTicker(p.Interval)
+	defer tick.Stop()
+
+	for {
+		p.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (p *PrivacyMode) check(ctx context.Context, now time.Time) {
+
+	if p.Feed != "" && now.Sub(p.fetched) >= p.FeedRefresh {
+		windows, err := FetchICS(ctx, p.Feed)
+		if err != nil {
+			warnf("privacy: failed to fetch calendar: %s\n", err)
+		} else {
+			p.mu.Lock()
+			p.feed = windows
+			p.fetched = now
+			p.mu.Unlock()
+		}
+	}
+
+	active := p.Active(now)
+	if active == p.active {
+		return
+	}
+
+	value := "true"
+	if active {
+		value = "false"
+	}
+	failed := false
+	for i := range p.Cameras.Cam {
+		o := &p.Cameras.Cam[i]
+		if !p.covers(o.Uuid) {
+			continue
+		}
+		if _, err := p.Cameras.SetProperties(ctx, o, "streaming.enabled", value); err != nil {
+			warnf("privacy: failed to set streaming on %s: %s\n", o.Title, err)
+			failed = true
+		}
+	}
+
+	// Retry on the next tick if any camera did not switch
+	if !failed {
+		p.active = active
+	}
+}
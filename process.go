@@ -0,0 +1,283 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// The Processor interface is a single image post-processing stage
+type Processor interface {
+	Process(img image.Image) (image.Image, error)
+}
+
+// ProcessorFunc adapts an ordinary function to the Processor interface
+type ProcessorFunc func(img image.Image) (image.Image, error)
+
+// Process calls f(img)
+func (f ProcessorFunc) Process(img image.Image) (image.Image, error) {
+	return f(img)
+}
+
+// A Pipeline chains Processors, feeding the output of each stage into the next
+type Pipeline []Processor
+
+// Process runs img through every stage in order
+func (p Pipeline) Process(img image.Image) (image.Image, error) {
+	var err error
+	for _, stage := range p {
+		img, err = stage.Process(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// apply decodes a JPEG snapshot, runs it through the pipeline and re-encodes it
+func (p Pipeline) apply(jpg []byte) ([]byte, error) {
+	return p.applyBudget(jpg, nil)
+}
+
+// applyBudget is apply re-encoding within budget b
+func (p Pipeline) applyBudget(jpg []byte, b *JPEGBudget) ([]byte, error) {
+
+	img, _, err := image.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		return nil, errors.New("Failed to decode snapshot: " + err.Error())
+	}
+	img, err = p.Process(img)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Encode(img)
+}
+
+// Resize returns a stage that scales images to width x height using bilinear
+// interpolation. A zero width or height is derived from the other to keep the
+// aspect ratio.
+func Resize(width, height int) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+
+		b := img.Bounds()
+		w, h := width, height
+		switch {
+		case w <= 0 && h <= 0:
+			return nil, errors.New("Resize needs a width or a height")
+		case w <= 0:
+			w = b.Dx() * h / b.Dy()
+		case h <= 0:
+			h = b.Dy() * w / b.Dx()
+		}
+		return resize(toRGBA(img), w, h), nil
+	})
+}
+
+// Crop returns a stage that keeps only the part of the image inside r
+func Crop(r image.Rectangle) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+
+		src := toRGBA(img)
+		r := r.Add(src.Bounds().Min).Intersect(src.Bounds())
+		if r.Empty() {
+			return nil, errors.New("Crop rectangle lies outside the image")
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+		return dst, nil
+	})
+}
+
+// Blur returns a stage that box-blurs the given regions with the given radius
+func Blur(radius int, regions ...image.Rectangle) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+
+		dst := toRGBA(img)
+		for _, r := range regions {
+			boxBlur(dst, r.Add(dst.Bounds().Min).Intersect(dst.Bounds()), radius)
+		}
+		return dst, nil
+	})
+}
+
+// Annotate returns a stage that draws text at pt in color c using the built-in
+// bitmap font, magnified by an integer scale
+func Annotate(text string, pt image.Point, c color.Color, scale int) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+
+		dst := toRGBA(img)
+		drawText(dst, pt.Add(dst.Bounds().Min), text, c, scale)
+		return dst, nil
+	})
+}
+
+// toRGBA returns img as an *image.RGBA, copying it if necessary
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	for y := 0; y < h; y++ {
+		fy := (float64(y)+0.5)*float64(sh)/float64(h) - 0.5
+		y0 := clampInt(int(fy), 0, sh-1)
+		y1 := clampInt(y0+1, 0, sh-1)
+		wy := fy - float64(y0)
+		if wy < 0 {
+			wy = 0
+		}
+		for x := 0; x < w; x++ {
+			fx := (float64(x)+0.5)*float64(sw)/float64(w) - 0.5
+			x0 := clampInt(int(fx), 0, sw-1)
+			x1 := clampInt(x0+1, 0, sw-1)
+			wx := fx - float64(x0)
+			if wx < 0 {
+				wx = 0
+			}
+
+			i00 := src.PixOffset(sb.Min.X+x0, sb.Min.Y+y0)
+			i10 := src.PixOffset(sb.Min.X+x1, sb.Min.Y+y0)
+			i01 := src.PixOffset(sb.Min.X+x0, sb.Min.Y+y1)
+			i11 := src.PixOffset(sb.Min.X+x1, sb.Min.Y+y1)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				top := float64(src.Pix[i00+c])*(1-wx) + float64(src.Pix[i10+c])*wx
+				bot := float64(src.Pix[i01+c])*(1-wx) + float64(src.Pix[i11+c])*wx
+				dst.Pix[o+c] = uint8(top*(1-wy) + bot*wy + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// boxBlur averages each pixel of r with its neighbours within radius, using a
+// summed-area table so the cost does not grow with the radius
+func boxBlur(img *image.RGBA, r image.Rectangle, radius int) {
+
+	if radius < 1 || r.Empty() {
+		return
+	}
+	w, h := r.Dx(), r.Dy()
+	sat := make([][4]uint64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var row [4]uint64
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				row[c] += uint64(img.Pix[i+c])
+				sat[(y+1)*(w+1)+x+1][c] = sat[y*(w+1)+x+1][c] + row[c]
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		y0, y1 := clampInt(y-radius, 0, h), clampInt(y+radius+1, 0, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := clampInt(x-radius, 0, w), clampInt(x+radius+1, 0, w)
+			n := uint64((x1 - x0) * (y1 - y0))
+			i := img.PixOffset(r.Min.X+x, r.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				sum := sat[y1*(w+1)+x1][c] - sat[y0*(w+1)+x1][c] - sat[y1*(w+1)+x0][c] + sat[y0*(w+1)+x0][c]
+				img.Pix[i+c] = uint8(sum / n)
+			}
+		}
+	}
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// A Region is a polygon in image pixel coordinates
+type Region []image.Point
+
+// RectRegion returns the Region covering r
+func RectRegion(r image.Rectangle) Region {
+	return Region{r.Min, {r.Max.X, r.Min.Y}, r.Max, {r.Min.X, r.Max.Y}}
+}
+
+func (rg Region) bounds() image.Rectangle {
+	if len(rg) == 0 {
+		return image.Rectangle{}
+	}
+	b := image.Rectangle{Min: rg[0], Max: rg[0]}
+	for _, p := range rg[1:] {
+		b = b.Union(image.Rectangle{Min: p, Max: p.Add(image.Pt(1, 1))})
+	}
+	return b
+}
+
+// contains reports whether the centre of pixel (x, y) lies inside the polygon
+func (rg Region) contains(x, y int) bool {
+	px, py := float64(x)+0.5, float64(y)+0.5
+	inside := false
+	for i, j := 0, len(rg)-1; i < len(rg); j, i = i, i+1 {
+		xi, yi := float64(rg[i].X), float64(rg[i].Y)
+		xj, yj := float64(rg[j].X), float64(rg[j].Y)
+		if (yi > py) != (yj > py) && px < (xj-xi)*(py-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// PrivacyMask returns a stage that hides regions such as a neighbour's window.
+// With a positive radius the regions are blurred; with radius 0 they are blacked out.
+// Set it on Dropcam.Pipeline so it applies before snapshots are stored, served
+// or attached to notifications.
+func PrivacyMask(radius int, regions ...Region) Processor {
+	return ProcessorFunc(func(img image.Image) (image.Image, error) {
+
+		dst := toRGBA(img)
+		for _, rg := range regions {
+			b := rg.bounds().Add(dst.Bounds().Min).Intersect(dst.Bounds())
+			if b.Empty() {
+				continue
+			}
+
+			var blurred *image.RGBA
+			if radius > 0 {
+				blurred = image.NewRGBA(b)
+				draw.Draw(blurred, b, dst, b.Min, draw.Src)
+				boxBlur(blurred, b, radius)
+			}
+
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if !rg.contains(x-dst.Bounds().Min.X, y-dst.Bounds().Min.Y) {
+						continue
+					}
+					if blurred != nil {
+						dst.Set(x, y, blurred.At(x, y))
+					} else {
+						dst.Set(x, y, color.Black)
+					}
+				}
+			}
+		}
+		return dst, nil
+	})
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// The Profile type is a named bundle of property values such as "night" or "away"
+type Profile struct {
+	Name       string            `json:"name"`
+	Properties map[string]string `json:"properties"`
+}
+
+// The Profiles type holds profile definitions and tracks which profile was last
+// applied to each camera
+type Profiles struct {
+	mu     sync.Mutex
+	defs   map[string]Profile
+	active map[string]string
+}
+
+// NewProfiles returns a Profiles holding the given definitions
+func NewProfiles(profiles ...Profile) *Profiles {
+	p := &Profiles{
+		defs:   make(map[string]Profile),
+		active: make(map[string]string),
+	}
+	for _, pr := range profiles {
+		p.defs[pr.Name] = pr
+	}
+	return p
+}
+
+// Define adds or replaces a profile
+func (p *Profiles) Define(pr Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.defs[pr.Name] = pr
+}
+
+// Get returns the named profile
+func (p *Profiles) Get(name string) (Profile, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr, ok := p.defs[name]
+	return pr, ok
+}
+
+// Names returns the defined profile names in sorted order
+func (p *Profiles) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.defs))
+	for name := range p.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Active returns the profile last applied in full to camera uuid, or "" if none
+func (p *Profiles) Active(uuid string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active[uuid]
+}
+
+// Apply sets the named profile's properties on every camera selected by filter.
+// Cameras that accept every property are recorded as running the profile;
+// cameras with failures keep their previous active profile.
+func (p *Profiles) Apply(ctx context.Context, c *Cameras, filter CameraFilter, name string) ([]BulkResult, error) {
+
+	pr, ok := p.Get(name)
+	if !ok {
+		return nil, errors.New("Unknown profile: " + name)
+	}
+
+	results := c.ApplyToAll(ctx, filter, pr.Properties)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range results {
+		if results[i].OK() {
+			p.active[results[i].Camera.Uuid] = name
+		}
+	}
+	return results, nil
+}
+
+// CameraUUIDs is a CameraFilter that selects the cameras with the given uuids
+func CameraUUIDs(uuids ...string) CameraFilter {
+	set := make(map[string]bool, len(uuids))
+	for _, u := range uuids {
+		set[u] = true
+	}
+	return func(o *Owned) bool {
+		return set[o.Uuid]
+	}
+}
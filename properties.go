@@ -0,0 +1,157 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Names of the camera properties with typed accessors
+const (
+	PropIRLED            = "irled.state"
+	PropStreamingEnabled = "streaming.enabled"
+	PropHD               = "streaming.params.hd"
+	PropAudioEnabled     = "audio.enabled"
+	PropStatusLED        = "statusled.enabled"
+)
+
+// The IRLEDMode type is the state of a camera's infrared night vision LEDs
+type IRLEDMode string
+
+// IR LED modes
+const (
+	IRLEDAuto IRLEDMode = "auto_on"
+	IRLEDOn   IRLEDMode = "always_on"
+	IRLEDOff  IRLEDMode = "always_off"
+)
+
+// The CameraProperties type is a camera's property map decoded. All holds
+// every property as reported, including those without a field here.
+type CameraProperties struct {
+	IRLED            IRLEDMode
+	StreamingEnabled bool
+	HD               bool
+	AudioEnabled     bool
+	StatusLED        bool
+
+	All map[string]interface{}
+}
+
+// The GetProperties method fetches and decodes the current properties of camera o
+func (c *Cameras) GetProperties(ctx context.Context, o *Owned) (*CameraProperties, error) {
+
+	info, err := c.CameraInfo(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	props := &CameraProperties{All: make(map[string]interface{}, len(info.Properties))}
+	for _, p := range info.Properties {
+		props.All[p.Name] = p.Value
+	}
+	props.IRLED = IRLEDMode(propertyString(props.All[PropIRLED]))
+	props.StreamingEnabled = propertyBool(props.All[PropStreamingEnabled])
+	props.HD = propertyBool(props.All[PropHD])
+	props.AudioEnabled = propertyBool(props.All[PropAudioEnabled])
+	props.StatusLED = propertyBool(props.All[PropStatusLED])
+	return props, nil
+}
+
+// The SetIRLED method sets camera o's night vision mode
+func (c *Cameras) SetIRLED(ctx context.Context, o *Owned, mode IRLEDMode) error {
+	switch mode {
+	case IRLEDAuto, IRLEDOn, IRLEDOff:
+	default:
+		return fmt.Errorf("Invalid IR LED mode %q", mode)
+	}
+	_, err := c.SetProperties(ctx, o, PropIRLED, string(mode))
+	return err
+}
+
+// The SetStreamingEnabled method turns camera o on or off
+func (c *Cameras) SetStreamingEnabled(ctx context.Context, o *Owned, on bool) error {
+	_, err := c.SetProperties(ctx, o, PropStreamingEnabled, strconv.FormatBool(on))
+	return err
+}
+
+// Defaults for confirming a change of streaming
+const (
+	DefaultStreamingTimeout  = 30 * time.Second
+	DefaultStreamingInterval = 2 * time.Second
+)
+
+// The EnableStreaming method turns camera o on and waits until cameras.get
+// shows streaming enabled, for up to DefaultStreamingTimeout or until ctx is
+// done. It returns the last state seen, which is also stored in o, and an
+// error if the camera did not change in time.
+func (c *Cameras) EnableStreaming(ctx context.Context, o *Owned) (bool, error) {
+	return c.setStreamingConfirmed(ctx, o, true)
+}
+
+// The DisableStreaming method turns camera o off and waits until cameras.get
+// shows streaming disabled, as EnableStreaming does
+func (c *Cameras) DisableStreaming(ctx context.Context, o *Owned) (bool, error) {
+	return c.setStreamingConfirmed(ctx, o, false)
+}
+
+func (c *Cameras) setStreamingConfirmed(ctx context.Context, o *Owned, on bool) (bool, error) {
+
+	if err := c.SetStreamingEnabled(ctx, o, on); err != nil {
+		return o.IsStreamingEnabled, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultStreamingTimeout)
+	defer cancel()
+	tick := time.NewTicker(DefaultStreamingInterval)
+	defer tick.Stop()
+
+	state := o.IsStreamingEnabled
+	for {
+		cur, err := c.GetCamera(ctx, o.Uuid)
+		if err == nil {
+			state = cur.IsStreamingEnabled
+			o.IsStreamingEnabled = state
+			o.IsStreaming = cur.IsStreaming
+			if state == on {
+				return state, nil
+			}
+		} else if ctx.Err() == nil {
+			Dbg("streaming: %s: %s\n", o.Title, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, fmt.Errorf("%s did not confirm streaming.enabled=%t: %w", o.Title, on, ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
+
+// The SetHD method switches camera o between HD and SD video
+func (c *Cameras) SetHD(ctx context.Context, o *Owned, on bool) error {
+	_, err := c.SetProperties(ctx, o, PropHD, strconv.FormatBool(on))
+	return err
+}
+
+// The SetAudioEnabled method turns camera o's microphone on or off
+func (c *Cameras) SetAudioEnabled(ctx context.Context, o *Owned, on bool) error {
+	_, err := c.SetProperties(ctx, o, PropAudioEnabled, strconv.FormatBool(on))
+	return err
+}
+
+// The SetStatusLED method turns camera o's status light on or off
+func (c *Cameras) SetStatusLED(ctx context.Context, o *Owned, on bool) error {
+	_, err := c.SetProperties(ctx, o, PropStatusLED, strconv.FormatBool(on))
+	return err
+}
+
+// propertyBool decodes a property value that may be a boolean or a string
+func propertyBool(v interface{}) bool {
+	b, _ := strconv.ParseBool(propertyString(v))
+	return b
+}
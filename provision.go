@@ -0,0 +1,119 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// The CameraPairing type identifies a new camera to add to the account: its
+// MAC address or serial number, and the setup token the camera shows while
+// it is being set up. Title, Timezone and StructureId are optional settings
+// for the camera once added.
+type CameraPairing struct {
+	MacAddress  string
+	Serial      string
+	SetupToken  string
+	Title       string
+	Timezone    string
+	StructureId string
+}
+
+// fields returns the cameras.add request of p, checking it first
+func (p CameraPairing) fields() (map[string]interface{}, error) {
+
+	if p.MacAddress == "" && p.Serial == "" {
+		return nil, errors.New("Add Camera: need a MAC address or serial number")
+	}
+	if p.SetupToken == "" {
+		return nil, errors.New("Add Camera: need the setup token")
+	}
+	fields := map[string]interface{}{"setup_token": p.SetupToken}
+	if p.MacAddress != "" {
+		mac, err := net.ParseMAC(p.MacAddress)
+		if err != nil {
+			return nil, fmt.Errorf("Add Camera: bad MAC address %q", p.MacAddress)
+		}
+		fields["mac_address"] = mac.String()
+	}
+	if p.Serial != "" {
+		fields["serial_number"] = p.Serial
+	}
+	if p.Title != "" {
+		fields["title"] = p.Title
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return nil, fmt.Errorf("Add Camera: unknown timezone %q", p.Timezone)
+		}
+		fields["timezone"] = p.Timezone
+	}
+	if p.StructureId != "" {
+		fields["nest_structure_id"] = p.StructureId
+	}
+	return fields, nil
+}
+
+// The AddCamera method associates the camera p identifies with the account,
+// and returns it as the servers now list it. The camera is added to c.Cam.
+func (c *Cameras) AddCamera(ctx context.Context, p CameraPairing) (*Owned, error) {
+
+	fields, err := p.fields()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Dropcam.refuseWrite("Add Camera", "POST", c.Dropcam.CamerasAdd, "", fields); err != nil {
+		return nil, err
+	}
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", c.Dropcam.CamerasAdd, "", fields)
+	if err != nil {
+		return nil, fmt.Errorf("Add Camera Request Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]Owned]("Add Camera", response)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("Add Camera: reply has no camera")
+	}
+
+	c.Dropcam.cameras.invalidate()
+	c.Cam = append(c.Cam, items[0])
+	return &c.Cam[len(c.Cam)-1], nil
+}
+
+// The RemoveCamera method disassociates camera o from the account. Its
+// recordings are deleted by the servers, and it must be paired again to be
+// used. The camera is removed from c.Cam, so pointers into c.Cam taken
+// before may no longer point at the same cameras.
+func (c *Cameras) RemoveCamera(ctx context.Context, o *Owned) error {
+
+	uuid := o.Uuid
+	data := map[string]interface{}{"uuid": uuid}
+	if err := c.Dropcam.refuseWrite("Remove Camera", "POST", c.Dropcam.CamerasDelete, uuid, data); err != nil {
+		return err
+	}
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", c.Dropcam.CamerasDelete, uuid, data)
+	if err != nil {
+		return fmt.Errorf("Remove Camera Request Failed: %w", err)
+	}
+	if _, err := decodeEnvelope[json.RawMessage]("Remove Camera", response); err != nil {
+		return err
+	}
+
+	c.Dropcam.cameras.invalidate()
+	for i := range c.Cam {
+		if c.Cam[i].Uuid == uuid {
+			c.Cam = append(c.Cam[:i], c.Cam[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
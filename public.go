@@ -0,0 +1,101 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+// The PublicCamera type is anonymous, read-only access to a camera its owner
+// has made public, by the camera's public token. No account is needed, so
+// dashboards can show public cameras without holding a password. Dropcam
+// sends the requests; its HTTPClient, Dial, Retry and RateLimit apply, but
+// it never logs in.
+type PublicCamera struct {
+	Token   string
+	Dropcam *Dropcam
+
+	camera *Owned
+}
+
+// Public returns access to the public camera with token
+func Public(token string) *PublicCamera {
+	d := new(Dropcam)
+	d.defaultPaths()
+	d.limiter = newCameraLimiter()
+	return &PublicCamera{Token: token, Dropcam: d}
+}
+
+// The Info method fetches the camera's title, description, location and
+// state. The camera stops being available once its owner makes it private.
+func (p *PublicCamera) Info(ctx context.Context) (*Owned, error) {
+
+	v := url.Values{}
+	v.Set("token", p.Token)
+	response, err := p.Dropcam.getRequest(ctx, p.Dropcam.CamerasGetPublic, v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Public Camera Request Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]Owned]("Get Public Camera", response)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("No public camera for token: %w", ErrNotFound)
+	}
+	o := items[0]
+	p.camera = &o
+	return &o, nil
+}
+
+// The GetImage method returns a live snapshot width pixels wide, 720 when
+// zero. Public cameras share no recordings, so only the live image is had.
+func (p *PublicCamera) GetImage(ctx context.Context, width int) ([]byte, error) {
+
+	if p.camera == nil {
+		if _, err := p.Info(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if width <= 0 {
+		width = 720
+	}
+
+	v := url.Values{}
+	v.Set("uuid", p.camera.Uuid)
+	v.Add("width", fmt.Sprintf("%d", width))
+	v.Add("public_token", p.Token)
+	response, err := p.Dropcam.getRequest(withJob(ctx, JobSnapshot), p.Dropcam.CamerasGetImagePath, v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Image Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Get Image Failed: %w", err)
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Get Image", response, body)
+	}
+	if len(body) == 0 {
+		return nil, &APIError{Op: "Get Image", HTTPStatus: response.StatusCode, Description: "image has 0 size", Err: ErrCameraOffline}
+	}
+	return body, nil
+}
+
+// The SaveImage method writes a live snapshot width pixels wide to path, as
+// Dropcam.Files says
+func (p *PublicCamera) SaveImage(ctx context.Context, path string, width int) error {
+	img, err := p.GetImage(ctx, width)
+	if err != nil {
+		return err
+	}
+	_, err = p.Dropcam.Files.writeFile(path, img)
+	return err
+}
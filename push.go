@@ -0,0 +1,237 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The Priority type is how urgently a push notification is delivered. Each
+// push service maps it onto its own scale.
+type Priority int
+
+// Push priorities, from quietest to most intrusive
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+	PriorityUrgent
+)
+
+// DefaultPriorities maps notification kinds to the priority they are pushed
+// with; kinds not listed are pushed at PriorityNormal
+var DefaultPriorities = map[string]Priority{
+	NotifyChange:  PriorityHigh,
+	NotifyDigest:  PriorityLow,
+	NotifyReport:  PriorityLow,
+	NotifyOffline: PriorityHigh,
+}
+
+// priorityOf returns the priority of n according to m, or DefaultPriorities when m is nil
+func priorityOf(m map[string]Priority, n *Notification) Priority {
+	if m == nil {
+		m = DefaultPriorities
+	}
+	p, ok := m[n.Kind]
+	switch {
+	case !ok:
+		return PriorityNormal
+	case p < PriorityLow:
+		return PriorityLow
+	case p > PriorityUrgent:
+		return PriorityUrgent
+	}
+	return p
+}
+
+// postPush sends a request to a push service and checks the reply
+func postPush(client *http.Client, service string, req *http.Request) error {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", service, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// The Ntfy type pushes notifications to a topic on ntfy.sh or a self-hosted
+// ntfy server. The snapshot, when there is one, is uploaded as an attachment.
+// Token is an access token for protected topics.
+type Ntfy struct {
+	Server     string
+	Topic      string
+	Token      string
+	Priorities map[string]Priority
+	Client     *http.Client
+}
+
+// Notify implements Notifier
+func (nt *Ntfy) Notify(n *Notification) error {
+
+	server := nt.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	var body io.Reader
+	if len(n.Image) > 0 {
+		body = bytes.NewReader(n.Image)
+	}
+	req, err := http.NewRequest("PUT", strings.TrimRight(server, "/")+"/"+url.PathEscape(nt.Topic), body)
+	if err != nil {
+		return err
+	}
+
+	// headers must be ASCII; ntfy decodes RFC 2047 words
+	enc := func(s string) string { return mime.BEncoding.Encode("utf-8", s) }
+	req.Header.Set("Title", enc(n.Title))
+	req.Header.Set("Message", enc(n.Message))
+	if len(n.Image) > 0 {
+		req.Header.Set("Filename", "snapshot.jpg")
+	}
+	if len(n.Labels) > 0 {
+		req.Header.Set("Tags", enc(strings.Join(n.Labels, ",")))
+	}
+	if n.ClipURL != "" {
+		req.Header.Set("Click", n.ClipURL)
+	}
+	// ntfy priorities run from 1 (min) to 5 (max)
+	req.Header.Set("Priority", strconv.Itoa(int(priorityOf(nt.Priorities, n))+3))
+	if nt.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+nt.Token)
+	}
+	return postPush(nt.Client, "ntfy", req)
+}
+
+// PushoverURL is the Pushover message endpoint
+const PushoverURL = "https://api.pushover.net/1/messages.json"
+
+// The Pushover type pushes notifications through Pushover. Token is the
+// application token and User the user or group key. The snapshot is attached
+// to the message. Urgent notifications repeat every minute for an hour until
+// acknowledged, as Pushover's emergency priority requires.
+type Pushover struct {
+	Token      string
+	User       string
+	Device     string
+	Priorities map[string]Priority
+	Client     *http.Client
+	URL        string
+}
+
+// Notify implements Notifier
+func (po *Pushover) Notify(n *Notification) error {
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fields := [][2]string{
+		{"token", po.Token},
+		{"user", po.User},
+		{"title", n.Title},
+		{"message", n.Message},
+	}
+	if po.Device != "" {
+		fields = append(fields, [2]string{"device", po.Device})
+	}
+	if n.ClipURL != "" {
+		fields = append(fields, [2]string{"url", n.ClipURL}, [2]string{"url_title", "Watch the clip"})
+	}
+	if !n.Time.IsZero() {
+		fields = append(fields, [2]string{"timestamp", strconv.FormatInt(n.Time.Unix(), 10)})
+	}
+	// Pushover priorities run from -2 (silent) to 2 (emergency)
+	p := priorityOf(po.Priorities, n)
+	fields = append(fields, [2]string{"priority", strconv.Itoa(int(p))})
+	if p == PriorityUrgent {
+		fields = append(fields, [2]string{"retry", "60"}, [2]string{"expire", "3600"})
+	}
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	if len(n.Image) > 0 {
+		part, err := mw.CreateFormFile("attachment", "snapshot.jpg")
+		if err != nil {
+			return err
+		}
+		part.Write(n.Image)
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	endpoint := po.URL
+	if endpoint == "" {
+		endpoint = PushoverURL
+	}
+	req, err := http.NewRequest("POST", endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return postPush(po.Client, "Pushover", req)
+}
+
+// The Gotify type pushes notifications to a Gotify server using an application
+// Token. Gotify cannot store attachments, so the snapshot is only shown when
+// ImageURL is set and returns an address the phone can load it from, such as
+// the snapshot URL of an ImageCache or Gallery.
+type Gotify struct {
+	Server     string
+	Token      string
+	ImageURL   func(n *Notification) string
+	Priorities map[string]Priority
+	Client     *http.Client
+}
+
+// Notify implements Notifier
+func (g *Gotify) Notify(n *Notification) error {
+
+	// Gotify priorities run from 0 (silent) to 10
+	msg := map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.Message,
+		"priority": []int{2, 5, 8, 10}[priorityOf(g.Priorities, n)-PriorityLow],
+	}
+	if g.ImageURL != nil && len(n.Image) > 0 {
+		if u := g.ImageURL(n); u != "" {
+			msg["message"] = fmt.Sprintf("%s\n\n![snapshot](%s)", n.Message, u)
+			msg["extras"] = map[string]interface{}{
+				"client::display":      map[string]string{"contentType": "text/markdown"},
+				"client::notification": map[string]string{"bigImageUrl": u},
+			}
+		}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(g.Server, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return postPush(g.Client, "Gotify", req)
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The JobMetrics type records the outcome of a short-lived job (a single snapshot,
+// a nightly archive) so it can be pushed to a Prometheus Pushgateway
+type JobMetrics struct {
+	Job      string
+	Instance string
+	Start    time.Time
+	Duration time.Duration
+	Success  bool
+	Bytes    int64
+}
+
+// StartJob returns a JobMetrics for the named job with its start time set to now
+func StartJob(job string) *JobMetrics {
+	return &JobMetrics{Job: job, Start: time.Now()}
+}
+
+// Finish records the job duration and whether it succeeded
+func (m *JobMetrics) Finish(err error) {
+	m.Duration = time.Since(m.Start)
+	m.Success = err == nil
+}
+
+// The PushMetrics method sends m to the Pushgateway at gateway (e.g.
+// "http://localhost:9091"), replacing any metrics previously pushed for the
+// same job and instance, along with the state of d.RetryBudget when set.
+// The push goes through the client's Dial settings, so it reaches the
+// gateway through the same proxy as the API calls.
+func (d *Dropcam) PushMetrics(ctx context.Context, gateway string, m *JobMetrics) error {
+
+	if m.Job == "" {
+		return errors.New("Pushgateway job name is required")
+	}
+
+	target := strings.TrimRight(gateway, "/") + "/metrics/job/" + url.PathEscape(m.Job)
+	if m.Instance != "" {
+		target += "/instance/" + url.PathEscape(m.Instance)
+	}
+
+	success := 0
+	if m.Success {
+		success = 1
+	}
+
+	var body bytes.Buffer
+	writeGauge(&body, "dropcam_job_success", "Whether the last run of the job succeeded.", float64(success))
+	writeGauge(&body, "dropcam_job_duration_seconds", "Duration of the last run of the job.", m.Duration.Seconds())
+	writeGauge(&body, "dropcam_job_bytes", "Bytes transferred by the last run of the job.", float64(m.Bytes))
+	writeGauge(&body, "dropcam_job_last_completion_timestamp_seconds", "Unix time the last run of the job completed.",
+		float64(m.Start.Add(m.Duration).UnixNano())/1e9)
+	writeRetryBudget(&body, d.RetryBudget)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return errors.New("Pushgateway Request Failed: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+func writeGauge(b *bytes.Buffer, name, help string, v float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+}
+
+// writeRetryBudget writes the state of budget, if any, over its window
+func writeRetryBudget(b *bytes.Buffer, budget *RetryBudget) {
+	if budget == nil {
+		return
+	}
+	st := budget.Stats()
+	writeGauge(b, "dropcam_retry_budget_requests", "Requests made over the retry budget window.", float64(st.Requests))
+	writeGauge(b, "dropcam_retry_budget_retries", "Retries spent over the retry budget window.", float64(st.Retries))
+	writeGauge(b, "dropcam_retry_budget_denied", "Retries the budget denied over its window.", float64(st.Denied))
+	writeGauge(b, "dropcam_retry_budget_ratio", "Retries per request over the retry budget window.", st.Ratio)
+	writeGauge(b, "dropcam_retry_budget_max_ratio", "Retries per request the budget allows.", budget.Ratio)
+}
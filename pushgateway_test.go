@@ -0,0 +1,55 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushMetricsThroughProxy(t *testing.T) {
+
+	var got *http.Request
+	var body string
+	// a plain HTTP proxy sees the absolute URL of the gateway
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, body = r, string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	d := new(Dropcam)
+	if err := d.Dial.SetProxy(proxy.URL); err != nil {
+		t.Fatal(err)
+	}
+	m := &JobMetrics{Job: "dropcam_snapshot", Instance: "cron", Start: time.Now(), Duration: 2 * time.Second, Success: true, Bytes: 1234}
+	if err := d.PushMetrics(context.Background(), "http://pushgateway.invalid:9091/", m); err != nil {
+		t.Fatal(err)
+	}
+
+	if got == nil {
+		t.Fatal("push did not go through the proxy")
+	}
+	if got.Method != "PUT" || got.URL.Host != "pushgateway.invalid:9091" || got.URL.Path != "/metrics/job/dropcam_snapshot/instance/cron" {
+		t.Errorf("pushed %s %s", got.Method, got.URL)
+	}
+	for _, want := range []string{"dropcam_job_success 1\n", "dropcam_job_duration_seconds 2\n", "dropcam_job_bytes 1234\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestPushMetricsNeedsJob(t *testing.T) {
+	if err := new(Dropcam).PushMetrics(context.Background(), "http://localhost:9091", &JobMetrics{}); err == nil {
+		t.Error("pushed metrics without a job name")
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultQuotaReserve is the part of each quota kept for normal requests
+// when QuotaTracker.Reserve is zero
+const DefaultQuotaReserve = 0.2
+
+// The Quota type is an assumed allowance of Limit requests in any Window
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+// The QuotaUsage type is the use of one quota. Endpoint is the URL path the
+// quota is for, empty for the quota on all requests together.
+type QuotaUsage struct {
+	Endpoint  string        `json:"endpoint"`
+	Window    time.Duration `json:"window"`
+	Limit     int           `json:"limit"`
+	Used      int           `json:"used"`
+	Remaining int           `json:"remaining"`
+}
+
+// The QuotaTracker type counts the requests of a Dropcam per endpoint over
+// rolling windows and compares them with the quotas the servers are assumed
+// to enforce. Quotas are keyed by URL path such as "/get_image"; the key ""
+// is the quota on all requests together. Endpoints without a quota are
+// counted against "" only.
+//
+// Requests whose context was marked by WithLowPriority, such as those of a
+// Backfill, wait while an applicable quota has less than Reserve of its limit
+// left, DefaultQuotaReserve when zero, so background jobs give way before
+// the quota runs out. They wait at most MaxWait, when set, and then go ahead.
+type QuotaTracker struct {
+	Quotas  map[string]Quota
+	Reserve float64
+	MaxWait time.Duration
+
+	mu    sync.Mutex
+	calls map[string][]time.Time
+}
+
+type lowPriorityContext struct{}
+
+// WithLowPriority marks the requests made with ctx as low priority for a
+// QuotaTracker
+func WithLowPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lowPriorityContext{}, true)
+}
+
+func isLowPriority(ctx context.Context) bool {
+	low, _ := ctx.Value(lowPriorityContext{}).(bool)
+	return low
+}
+
+// keys returns the quota keys a request to path counts against
+func (q *QuotaTracker) keys(path string) []string {
+	if _, ok := q.Quotas[path]; ok && path != "" {
+		return []string{"", path}
+	}
+	return []string{""}
+}
+
+// record counts a request to path sent at t
+func (q *QuotaTracker) record(path string, t time.Time) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.calls == nil {
+		q.calls = make(map[string][]time.Time)
+	}
+	for _, k := range q.keys(path) {
+		q.calls[k] = append(q.prune(k, t), t)
+	}
+}
+
+// prune drops the calls under key older than its window and returns the
+// rest; q.mu must be held
+func (q *QuotaTracker) prune(key string, now time.Time) []time.Time {
+	calls := q.calls[key]
+	quota, ok := q.Quotas[key]
+	window := quota.Window
+	if !ok || window <= 0 {
+		window = time.Hour
+	}
+	i := sort.Search(len(calls), func(i int) bool { return now.Sub(calls[i]) < window })
+	calls = append(calls[:0], calls[i:]...)
+	q.calls[key] = calls
+	return calls
+}
+
+// usage returns the use of the quota under key; q.mu must be held
+func (q *QuotaTracker) usage(key string, now time.Time) QuotaUsage {
+	quota := q.Quotas[key]
+	u := QuotaUsage{Endpoint: key, Window: quota.Window, Limit: quota.Limit, Used: len(q.prune(key, now))}
+	if u.Remaining = u.Limit - u.Used; u.Remaining < 0 {
+		u.Remaining = 0
+	}
+	return u
+}
+
+// Usage returns the use of every quota, the one on all requests first
+func (q *QuotaTracker) Usage() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.calls == nil {
+		q.calls = make(map[string][]time.Time)
+	}
+	now := time.Now()
+	keys := make([]string, 0, len(q.Quotas))
+	for k := range q.Quotas {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var usage []QuotaUsage
+	for _, k := range keys {
+		usage = append(usage, q.usage(k, now))
+	}
+	return usage
+}
+
+// Headroom returns how many more requests to path the quotas allow now, the
+// least over the quotas it counts against, or -1 if it has no quota
+func (q *QuotaTracker) Headroom(path string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.calls == nil {
+		q.calls = make(map[string][]time.Time)
+	}
+	return q.headroom(path, time.Now())
+}
+
+// headroomFraction returns the least headroom of the quotas of path as a
+// fraction of their limit, and when the oldest call of that quota leaves its
+// window; q.mu must be held
+func (q *QuotaTracker) headroomFraction(path string, now time.Time) (float64, time.Duration) {
+	least, wait := 1.0, time.Duration(0)
+	for _, k := range q.keys(path) {
+		quota, ok := q.Quotas[k]
+		if !ok || quota.Limit <= 0 {
+			continue
+		}
+		u := q.usage(k, now)
+		if f := float64(u.Remaining) / float64(quota.Limit); f < least {
+			least = f
+			// the oldest call leaves the window first
+			if calls := q.calls[k]; len(calls) > 0 {
+				wait = quota.Window - now.Sub(calls[0])
+			}
+		}
+	}
+	return least, wait
+}
+
+func (q *QuotaTracker) headroom(path string, now time.Time) int {
+	least := -1
+	for _, k := range q.keys(path) {
+		quota, ok := q.Quotas[k]
+		if !ok || quota.Limit <= 0 {
+			continue
+		}
+		if r := q.usage(k, now).Remaining; least < 0 || r < least {
+			least = r
+		}
+	}
+	return least
+}
+
+// wait delays a low priority request to path while its quotas are within
+// the reserve
+func (q *QuotaTracker) wait(ctx context.Context, path string) error {
+
+	if q == nil || !isLowPriority(ctx) {
+		return nil
+	}
+	reserve := q.Reserve
+	if reserve <= 0 {
+		reserve = DefaultQuotaReserve
+	}
+	var deadline <-chan time.Time
+	if q.MaxWait > 0 {
+		t := time.NewTimer(q.MaxWait)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	for {
+		q.mu.Lock()
+		if q.calls == nil {
+			q.calls = make(map[string][]time.Time)
+		}
+		left, wait := q.headroomFraction(path, time.Now())
+		q.mu.Unlock()
+		if left > reserve {
+			return nil
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+		Dbg("quota: low priority request to %s waits %s\n", path, wait)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-deadline:
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
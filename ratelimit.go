@@ -0,0 +1,169 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The RateLimit type is a token bucket: requests are allowed at Rate per
+// second on average, with bursts of up to Burst, 1 when zero. A zero Rate is
+// unlimited.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// The RateLimiter type paces every request of a Dropcam, retries included,
+// so aggressive polling loops do not get the account banned. Requests to an
+// endpoint listed in Endpoints, by URL path such as "/get_image", follow its
+// limit and have their own bucket; the others share Default.
+//
+// A request waits for its turn unless that would take longer than MaxWait,
+// when set; it then fails with ErrRateLimited without being sent.
+type RateLimiter struct {
+	Default   RateLimit
+	Endpoints map[string]RateLimit
+	MaxWait   time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket for path and returns how long the
+// request must wait for it. It fails, taking nothing, if that exceeds MaxWait.
+func (l *RateLimiter) reserve(path string) (time.Duration, error) {
+
+	lim, ok := l.Endpoints[path]
+	key := path
+	if !ok {
+		lim, key = l.Default, ""
+	}
+	if lim.Rate <= 0 {
+		return 0, nil
+	}
+	burst := float64(lim.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	now := time.Now()
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, nil
+	}
+	wait := time.Duration(-b.tokens / lim.Rate * float64(time.Second))
+	if l.MaxWait > 0 && wait > l.MaxWait {
+		b.tokens++
+		return wait, fmt.Errorf("Request to %s would wait %s for the rate limit: %w", path, wait.Round(time.Millisecond), ErrRateLimited)
+	}
+	return wait, nil
+}
+
+// wait blocks until a request to path may be sent, counting it as
+// throttled in st if it has to wait or is refused
+func (l *RateLimiter) wait(ctx context.Context, path string, st *requestStats) error {
+
+	if l == nil {
+		return nil
+	}
+	wait, err := l.reserve(path)
+	if wait > 0 || err != nil {
+		st.add(&st.Throttled)
+	}
+	if err != nil || wait <= 0 {
+		return err
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The RequestStats type counts the requests of a Dropcam. Requests counts
+// every request sent, retries included; Throttled those the RateLimiter
+// delayed or refused; Failed the calls that ended in a transport error or an
+// error status after any retries.
+type RequestStats struct {
+	Requests  int64 `json:"requests"`
+	Throttled int64 `json:"throttled"`
+	Failed    int64 `json:"failed"`
+}
+
+type requestStats struct {
+	mu sync.Mutex
+	RequestStats
+
+	// lastSuccess is when a call last succeeded, and sessionErr why the
+	// session was last rejected, nil once a call succeeds again
+	lastSuccess time.Time
+	sessionErr  error
+}
+
+// outcome notes what a call ending with resp or err says of the session
+func (s *requestStats) outcome(resp *http.Response, err error) {
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case sessionRejected(resp):
+		s.sessionErr = fmt.Errorf("Session rejected: %s", resp.Status)
+	case resp.StatusCode < 400:
+		s.lastSuccess = time.Now()
+		s.sessionErr = nil
+	}
+}
+
+// sessionFailed notes that renewing the session failed with err
+func (s *requestStats) sessionFailed(err error) {
+	s.mu.Lock()
+	s.sessionErr = err
+	s.mu.Unlock()
+}
+
+// add increments the counter c of s
+func (s *requestStats) add(c *int64) {
+	s.mu.Lock()
+	*c++
+	s.mu.Unlock()
+}
+
+// Stats returns the request counts since the Dropcam was created
+func (d *Dropcam) Stats() RequestStats {
+	d.stats.mu.Lock()
+	defer d.stats.mu.Unlock()
+	return d.stats.RequestStats
+}
@@ -0,0 +1,179 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Defaults for ReadCache
+const (
+	DefaultReadCacheFresh = 30 * time.Second
+	DefaultReadCacheStale = 10 * time.Minute
+)
+
+// The ReadCache type serves API reads stale-while-revalidate: a result younger
+// than Fresh is returned as is; one younger than Fresh+Stale is returned at
+// once while a refresh runs in the background; older results, and the first
+// read, wait for the API. A failed background refresh keeps the old result.
+// This keeps dashboards responsive when the API is slow.
+type ReadCache struct {
+	Dropcam *Dropcam
+	Fresh   time.Duration
+	Stale   time.Duration
+
+	// RefreshTimeout bounds background refreshes, one minute when zero
+	RefreshTimeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*readEntry
+}
+
+type readEntry struct {
+	value   interface{}
+	fetched time.Time
+	loading chan struct{}
+	err     error
+}
+
+// Cameras is Dropcam.Cameras served from the cache
+func (rc *ReadCache) Cameras(ctx context.Context) (*Cameras, error) {
+	v, err := rc.get(ctx, "cameras", func(ctx context.Context) (interface{}, error) {
+		return rc.Dropcam.RefreshCameras(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Cameras), nil
+}
+
+// CameraInfo is Cameras.CameraInfo served from the cache
+func (rc *ReadCache) CameraInfo(ctx context.Context, o *Owned) (*CameraInfo, error) {
+	v, err := rc.get(ctx, "info/"+o.Uuid, func(ctx context.Context) (interface{}, error) {
+		return rc.cameras().CameraInfo(ctx, o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*CameraInfo), nil
+}
+
+// GetProperties is Cameras.GetProperties served from the cache
+func (rc *ReadCache) GetProperties(ctx context.Context, o *Owned) (*CameraProperties, error) {
+	v, err := rc.get(ctx, "properties/"+o.Uuid, func(ctx context.Context) (interface{}, error) {
+		return rc.cameras().GetProperties(ctx, o)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*CameraProperties), nil
+}
+
+// Invalidate drops every cached result, e.g. after changing camera properties
+func (rc *ReadCache) Invalidate() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for key, e := range rc.entries {
+		if e.loading == nil {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+// cameras returns a Cameras for per-camera reads, which need only the Dropcam
+func (rc *ReadCache) cameras() *Cameras {
+	return &Cameras{Dropcam: rc.Dropcam}
+}
+
+func (rc *ReadCache) get(ctx context.Context, key string, load func(context.Context) (interface{}, error)) (interface{}, error) {
+
+	fresh, stale := rc.Fresh, rc.Stale
+	if fresh <= 0 {
+		fresh = DefaultReadCacheFresh
+	}
+	if stale <= 0 {
+		stale = DefaultReadCacheStale
+	}
+
+	rc.mu.Lock()
+	if rc.entries == nil {
+		rc.entries = make(map[string]*readEntry)
+	}
+	e := rc.entries[key]
+	if e == nil {
+		e = &readEntry{}
+		rc.entries[key] = e
+	}
+
+	age := time.Since(e.fetched)
+	switch {
+	case e.value != nil && age < fresh:
+		v := e.value
+		rc.mu.Unlock()
+		return v, nil
+
+	case e.value != nil && age < fresh+stale:
+		v := e.value
+		if e.loading == nil {
+			e.loading = make(chan struct{})
+			go rc.refresh(e, load)
+		}
+		rc.mu.Unlock()
+		return v, nil
+	}
+
+	if e.loading == nil {
+		e.loading = make(chan struct{})
+		rc.mu.Unlock()
+		rc.load(ctx, e, load)
+	} else {
+		loading := e.loading
+		rc.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if e.value == nil || time.Since(e.fetched) >= fresh+stale {
+		return nil, e.err
+	}
+	return e.value, nil
+}
+
+// refresh reloads e in the background
+func (rc *ReadCache) refresh(e *readEntry, load func(context.Context) (interface{}, error)) {
+
+	timeout := rc.RefreshTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := rc.load(ctx, e, load); err != nil {
+		warnf("read cache: background refresh failed: %s\n", err)
+	}
+}
+
+// load calls load and stores the result in e, then wakes its waiters
+func (rc *ReadCache) load(ctx context.Context, e *readEntry, load func(context.Context) (interface{}, error)) error {
+
+	v, err := load(ctx)
+
+	rc.mu.Lock()
+	e.err = err
+	if err == nil {
+		e.value, e.fetched = v, time.Now()
+	}
+	close(e.loading)
+	e.loading = nil
+	rc.mu.Unlock()
+	return err
+}
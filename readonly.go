@@ -0,0 +1,32 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned by the calls that would change the account or its
+// cameras while Dropcam.ReadOnly is set
+var ErrReadOnly = errors.New("Read Only")
+
+// refuseWrite fails op with ErrReadOnly when d is read-only, logging the
+// request it would have sent: method, url, camera uuid and data as JSON
+func (d *Dropcam) refuseWrite(op, method, url, uuid string, data interface{}) error {
+
+	if !d.ReadOnly {
+		return nil
+	}
+	body := ""
+	if data != nil {
+		if b, err := json.Marshal(data); err == nil {
+			body = string(b)
+		}
+	}
+	d.logger().Info("read-only, not sent", "op", op, "method", method, "url", url, "camera", uuid, "body", body)
+	return fmt.Errorf("%s: %w", op, ErrReadOnly)
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+func TestReadOnly(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	c, err := s.Cameras(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := c.ByUUID(dropcamtest.FrontDoor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Dropcam.ReadOnly = true
+
+	zone := dropcam.ActivityZone{Name: "porch", Polygon: []dropcam.ZonePoint{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 1, Y: 1}}}
+	now := time.Now()
+	calls := map[string]func() error{
+		"SetProperties": func() error {
+			_, err := c.SetProperties(ctx, o, dropcam.PropHD, "true")
+			return err
+		},
+		"SetIRLED": func() error { return c.SetIRLED(ctx, o, dropcam.IRLEDOn) },
+		"SetStreamingEnabled": func() error {
+			return c.SetStreamingEnabled(ctx, o, false)
+		},
+		"SetHD":           func() error { return c.SetHD(ctx, o, false) },
+		"SetAudioEnabled": func() error { return c.SetAudioEnabled(ctx, o, false) },
+		"SetStatusLED":    func() error { return c.SetStatusLED(ctx, o, false) },
+		"UpdateCamera": func() error {
+			_, err := c.UpdateCamera(ctx, o, dropcam.CameraUpdate{Title: dropcam.String("Back Door")})
+			return err
+		},
+		"SetActivityZone": func() error {
+			_, err := c.SetActivityZone(ctx, o, zone)
+			return err
+		},
+		"DeleteActivityZone": func() error { return c.DeleteActivityZone(ctx, o, 1) },
+		"AddCamera": func() error {
+			_, err := c.AddCamera(ctx, dropcam.CameraPairing{MacAddress: "00:11:22:33:44:55", SetupToken: "token"})
+			return err
+		},
+		"RemoveCamera": func() error { return c.RemoveCamera(ctx, o) },
+		"RequestClip": func() error {
+			_, err := c.RequestClip(ctx, o, now.Add(-time.Hour), now, "last hour")
+			return err
+		},
+		"Talkback": func() error {
+			_, err := c.Talkback(ctx, o)
+			return err
+		},
+		"GetClipShareURL": func() error {
+			_, err := c.GetClipShareURL(ctx, o, dropcam.Event{Id: 42}, time.Hour)
+			return err
+		},
+	}
+
+	sent := len(s.Requests())
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, dropcam.ErrReadOnly) {
+			t.Errorf("%s: %v, want ErrReadOnly", name, err)
+		}
+		if reqs := s.Requests(); len(reqs) != sent {
+			t.Errorf("%s sent %v", name, reqs[sent:])
+			sent = len(reqs)
+		}
+	}
+	if o.Title != "Front Door" || len(c.Cam) != 2 {
+		t.Errorf("read-only calls changed the cameras: %q, %d owned", o.Title, len(c.Cam))
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultReconcileInterval is how often a Reconciler checks the cameras when
+// Reconciler.Interval is zero
+const DefaultReconcileInterval = 15 * time.Minute
+
+// The DesiredCamera type is the state a camera should be kept in. Camera is
+// a uuid, a title or a group name, as for Cameras.Select. Only what is set is
+// managed: Notifications sets notify.<kind>.enabled for the kinds listed, and
+// Zones, when not nil, are the camera's only activity zones, matched by name.
+type DesiredCamera struct {
+	Camera        string            `json:"camera"`
+	Streaming     *bool             `json:"streaming,omitempty"`
+	IRLED         IRLEDMode         `json:"irled,omitempty"`
+	Notifications map[string]bool   `json:"notifications,omitempty"`
+	Properties    map[string]string `json:"properties,omitempty"`
+	Zones         []ActivityZone    `json:"zones,omitempty"`
+}
+
+// The DesiredState type is the state of a fleet of cameras, as kept in a
+// JSON file. A camera matched by several entries takes each in turn, the
+// later overriding the earlier.
+type DesiredState struct {
+	Cameras []DesiredCamera `json:"cameras"`
+}
+
+// LoadDesiredState reads the desired state at path. A missing file is an
+// empty state.
+func LoadDesiredState(path string) (*DesiredState, error) {
+
+	s := &DesiredState{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.New("Failed to read desired state: " + err.Error())
+	}
+	return s, nil
+}
+
+// properties returns the properties d sets, by name
+func (d DesiredCamera) properties() map[string]string {
+
+	props := make(map[string]string, len(d.Properties)+len(d.Notifications)+2)
+	for k, v := range d.Properties {
+		props[k] = v
+	}
+	for kind, on := range d.Notifications {
+		props["notify."+kind+".enabled"] = strconv.FormatBool(on)
+	}
+	if d.Streaming != nil {
+		props[PropStreamingEnabled] = strconv.FormatBool(*d.Streaming)
+	}
+	if d.IRLED != "" {
+		props[PropIRLED] = string(d.IRLED)
+	}
+	return props
+}
+
+// The Drift type is one way a camera differs from its desired state. Kind
+// is "property" or "zone"; an empty Actual is a zone the camera lacks, an
+// empty Desired one it should not have. Fixed reports whether a Reconciler
+// corrected it, or Error why it could not.
+type Drift struct {
+	Camera  string `json:"camera_uuid"`
+	Title   string `json:"title"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Actual  string `json:"actual"`
+	Desired string `json:"desired"`
+	Fixed   bool   `json:"fixed"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (d Drift) String() string {
+	s := fmt.Sprintf("%s: %s %s is %q, want %q", d.Title, d.Kind, d.Name, d.Actual, d.Desired)
+	switch {
+	case d.Fixed:
+		s += " (fixed)"
+	case d.Error != "":
+		s += " (" + d.Error + ")"
+	}
+	return s
+}
+
+// The Reconciler type keeps cameras in the state described by State,
+// GitOps-style. Every Interval it reads each camera's properties and
+// activity zones and reports where they drifted; with Correct set it also
+// changes them back. Drift is sent to Notifier, when set, as NotifyDrift
+// notifications. Groups resolves group names in State.
+type Reconciler struct {
+	Cameras  *Cameras
+	Groups   *Groups
+	State    *DesiredState
+	Correct  bool
+	Interval time.Duration
+	Notifier Notifier
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	last []Drift
+}
+
+// Reconcile compares every camera in State with its desired state once and
+// returns the drift found, corrected when Correct is set. Cameras whose state
+// cannot be read are reported in the error after the rest are checked.
+func (rc *Reconciler) Reconcile(ctx context.Context) ([]Drift, error) {
+
+	if rc.Cameras == nil || rc.State == nil {
+		return nil, errors.New("Reconciler needs Cameras and a State")
+	}
+
+	// merge the entries of each camera in order
+	var order []*Owned
+	desired := make(map[string]*DesiredCamera)
+	for _, dc := range rc.State.Cameras {
+		cams, err := rc.Cameras.Select(rc.Groups, dc.Camera)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range cams {
+			cur := desired[o.Uuid]
+			if cur == nil {
+				cur = &DesiredCamera{Camera: o.Uuid}
+				desired[o.Uuid] = cur
+				order = append(order, o)
+			}
+			cur.merge(dc)
+		}
+	}
+
+	var drift []Drift
+	var failed []string
+	for _, o := range order {
+		d, err := rc.reconcile(ctx, o, desired[o.Uuid])
+		if err != nil {
+			if ctx.Err() != nil {
+				return drift, ctx.Err()
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", o.Title, err))
+			continue
+		}
+		drift = append(drift, d...)
+	}
+
+	rc.mu.Lock()
+	rc.last = drift
+	rc.mu.Unlock()
+	rc.notify(drift)
+
+	if len(failed) > 0 {
+		return drift, fmt.Errorf("Reconcile failed for %d cameras: %v", len(failed), failed)
+	}
+	return drift, nil
+}
+
+// merge applies the settings of e over d
+func (d *DesiredCamera) merge(e DesiredCamera) {
+	if e.Streaming != nil {
+		d.Streaming = e.Streaming
+	}
+	if e.IRLED != "" {
+		d.IRLED = e.IRLED
+	}
+	for k, v := range e.Notifications {
+		if d.Notifications == nil {
+			d.Notifications = make(map[string]bool)
+		}
+		d.Notifications[k] = v
+	}
+	for k, v := range e.Properties {
+		if d.Properties == nil {
+			d.Properties = make(map[string]string)
+		}
+		d.Properties[k] = v
+	}
+	if e.Zones != nil {
+		d.Zones = e.Zones
+	}
+}
+
+// reconcile checks, and corrects, camera o against d
+func (rc *Reconciler) reconcile(ctx context.Context, o *Owned, d *DesiredCamera) ([]Drift, error) {
+
+	var drift []Drift
+
+	want := d.properties()
+	if len(want) > 0 {
+		current, err := rc.Cameras.propertyMap(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(want))
+		for k := range want {
+			keys = append(keys, k)
+		}
+		for _, pd := range diffProperties(current, want, keys) {
+			dr := Drift{Camera: o.Uuid, Title: o.Title, Kind: "property", Name: pd.Name, Actual: pd.A, Desired: pd.B}
+			if rc.Correct {
+				rc.fix(&dr, func() error {
+					_, err := rc.Cameras.SetProperties(ctx, o, pd.Name, pd.B)
+					return err
+				})
+			}
+			drift = append(drift, dr)
+		}
+	}
+
+	if d.Zones != nil {
+		zones, err := rc.Cameras.GetActivityZones(ctx, o)
+		if err != nil {
+			return drift, err
+		}
+		drift = append(drift, rc.reconcileZones(ctx, o, zones, d.Zones)...)
+	}
+	return drift, nil
+}
+
+// reconcileZones compares the zones of camera o with want by name
+func (rc *Reconciler) reconcileZones(ctx context.Context, o *Owned, have ActivityZones, want []ActivityZone) []Drift {
+
+	byName := make(map[string]ActivityZone, len(have))
+	for _, z := range have {
+		byName[z.Name] = z
+	}
+
+	var drift []Drift
+	wanted := make(map[string]bool, len(want))
+	for _, z := range want {
+		wanted[z.Name] = true
+		cur, ok := byName[z.Name]
+		if ok && samePolygon(cur.Polygon, z.Polygon) {
+			continue
+		}
+		dr := Drift{Camera: o.Uuid, Title: o.Title, Kind: "zone", Name: z.Name, Desired: polygonString(z.Polygon)}
+		if ok {
+			dr.Actual = polygonString(cur.Polygon)
+		}
+		if rc.Correct {
+			z := z
+			z.Id = cur.Id
+			rc.fix(&dr, func() error {
+				_, err := rc.Cameras.SetActivityZone(ctx, o, z)
+				return err
+			})
+		}
+		drift = append(drift, dr)
+	}
+
+	for _, z := range have {
+		if wanted[z.Name] {
+			continue
+		}
+		dr := Drift{Camera: o.Uuid, Title: o.Title, Kind: "zone", Name: z.Name, Actual: polygonString(z.Polygon)}
+		if rc.Correct {
+			id := z.Id
+			rc.fix(&dr, func() error {
+				return rc.Cameras.DeleteActivityZone(ctx, o, id)
+			})
+		}
+		drift = append(drift, dr)
+	}
+	return drift
+}
+
+// fix runs the correction of dr and records how it went
+func (rc *Reconciler) fix(dr *Drift, correct func() error) {
+	if err := correct(); err != nil {
+		dr.Error = err.Error()
+		Dbg("reconcile: %s\n", dr)
+		return
+	}
+	dr.Fixed = true
+}
+
+// samePolygon reports whether a and b have the same corners, to within
+// rounding
+func samePolygon(a, b []ZonePoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i].X-b[i].X) > 1e-3 || math.Abs(a[i].Y-b[i].Y) > 1e-3 {
+			return false
+		}
+	}
+	return true
+}
+
+func polygonString(p []ZonePoint) string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// notify sends one NotifyDrift notification per camera with drift
+func (rc *Reconciler) notify(drift []Drift) {
+
+	if rc.Notifier == nil || len(drift) == 0 {
+		return
+	}
+	byCamera := make(map[string][]Drift)
+	var uuids []string
+	for _, d := range drift {
+		if byCamera[d.Camera] == nil {
+			uuids = append(uuids, d.Camera)
+		}
+		byCamera[d.Camera] = append(byCamera[d.Camera], d)
+	}
+	sort.Strings(uuids)
+
+	for _, uuid := range uuids {
+		ds := byCamera[uuid]
+		msg := fmt.Sprintf("%d settings drifted from the desired state", len(ds))
+		for _, d := range ds {
+			msg += "\n" + d.String()
+		}
+		n := &Notification{Kind: NotifyDrift, Camera: uuid, Title: ds[0].Title, Message: msg, Time: time.Now()}
+		if err := rc.Notifier.Notify(n); err != nil {
+			warnf("reconcile: %s\n", err)
+		}
+	}
+}
+
+// Drift returns the drift found by the last Reconcile
+func (rc *Reconciler) Drift() []Drift {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Drift(nil), rc.last...)
+}
+
+// Start reconciles now and then every Interval in the background
+func (rc *Reconciler) Start() error {
+
+	if rc.Cameras == nil || rc.State == nil {
+		return errors.New("Reconciler needs Cameras and a State")
+	}
+	if rc.Interval <= 0 {
+		rc.Interval = DefaultReconcileInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc.cancel = cancel
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		tick := time.NewTicker(rc.Interval)
+		defer tick.Stop()
+		for {
+			if _, err := rc.Reconcile(ctx); err != nil {
+				warnf("reconcile: %s\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends reconciling
+func (rc *Reconciler) Stop() {
+	rc.cancel()
+	rc.wg.Wait()
+}
@@ -0,0 +1,169 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrOutsideRecordingWindow is returned for recorded frames asked for before
+// the oldest recording a camera keeps
+var ErrOutsideRecordingWindow = errors.New("Time is outside the cloud recording window")
+
+// The RecordedRange type is a stretch of time a camera recorded to the cloud
+// without a gap
+type RecordedRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Contains reports whether t is within the range
+func (r RecordedRange) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && !t.After(r.End)
+}
+
+// availableRange is a range as get_available lists it
+type availableRange struct {
+	StartTime float64 `json:"start_time"`
+	StopTime  float64 `json:"stop_time"`
+}
+
+// recordingWindowStart returns the oldest time camera o keeps recordings
+// of, by its hours_of_recording_max, or the zero time if it does not say
+func recordingWindowStart(o *Owned, now time.Time) time.Time {
+	if o.HoursOfRecordingMax <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(o.HoursOfRecordingMax * float64(time.Hour)))
+}
+
+// The GetAvailableRanges method returns the stretches of time camera o has
+// recordings of, oldest first, without overlaps: the Start of the first and
+// the End of the last bound its recorded history, and the times between
+// ranges are gaps, as while the camera was offline or streaming was off.
+// Ranges are clipped to the camera's retention window.
+func (c *Cameras) GetAvailableRanges(ctx context.Context, o *Owned) ([]RecordedRange, error) {
+
+	if err := c.Dropcam.requireCVR("Get Available Ranges", o); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	windowStart := recordingWindowStart(o, now)
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	if !windowStart.IsZero() {
+		v.Set("start_time", strconv.FormatInt(windowStart.Unix(), 10))
+	}
+
+	response, err := c.Dropcam.getRequest(ctx, c.Dropcam.nexusPath(o, c.Dropcam.AvailablePath), v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Available Ranges Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Get Available Ranges", response, body)
+	}
+	var avail []availableRange
+	if err := json.Unmarshal(body, &avail); err != nil {
+		return nil, fmt.Errorf("Can't unmarshal Available Ranges: %s", err)
+	}
+
+	var ranges []RecordedRange
+	for _, a := range avail {
+		r := RecordedRange{Start: epochTime(a.StartTime).In(o.Zone()), End: epochTime(a.StopTime).In(o.Zone())}
+		if a.StopTime == 0 {
+			// still recording
+			r.End = now.In(o.Zone())
+		}
+		if !windowStart.IsZero() && r.Start.Before(windowStart) {
+			r.Start = windowStart.In(o.Zone())
+		}
+		if r.End.After(r.Start) {
+			ranges = append(ranges, r)
+		}
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges sorts ranges and joins those that overlap
+func mergeRanges(ranges []RecordedRange) []RecordedRange {
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start.Before(ranges[j].Start) })
+	var merged []RecordedRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !r.Start.After(merged[n-1].End) {
+			if r.End.After(merged[n-1].End) {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// nearestRecorded returns the recorded time in ranges closest to t
+func nearestRecorded(ranges []RecordedRange, t time.Time) time.Time {
+
+	best, bestDist := time.Time{}, time.Duration(-1)
+	for _, r := range ranges {
+		if r.Contains(t) {
+			return t
+		}
+		edge := r.Start
+		if t.After(r.End) {
+			edge = r.End
+		}
+		dist := t.Sub(edge)
+		if dist < 0 {
+			dist = -dist
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = edge, dist
+		}
+	}
+	return best
+}
+
+// The GetFrameAt method returns the recorded frame of camera o nearest to
+// t, at width, and the time it was taken from: t itself when it was
+// recorded, or else the nearest edge of the recordings around it, so a seek
+// into a gap lands on the last frame before or the first after it. Times
+// before the oldest recording the camera keeps fail with
+// ErrOutsideRecordingWindow without fetching a frame.
+func (c *Cameras) GetFrameAt(ctx context.Context, o *Owned, t time.Time, width int) ([]byte, time.Time, error) {
+
+	if windowStart := recordingWindowStart(o, time.Now()); t.Before(windowStart) {
+		return nil, time.Time{}, fmt.Errorf("Get Frame Failed: %s at %s: %w", o.Title, o.In(t).Format(time.RFC3339), ErrOutsideRecordingWindow)
+	}
+	ranges, err := c.GetAvailableRanges(ctx, o)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(ranges) == 0 || t.Before(ranges[0].Start) {
+		return nil, time.Time{}, fmt.Errorf("Get Frame Failed: %s at %s: %w", o.Title, o.In(t).Format(time.RFC3339), ErrOutsideRecordingWindow)
+	}
+
+	at := nearestRecorded(ranges, t)
+	img, err := c.getImage(ctx, o, width, at)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return img, at.In(o.Zone()), nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of CameraChange, also used as notification kinds
+const (
+	CameraAdded   = "camera_added"
+	CameraRemoved = "camera_removed"
+	CameraRenamed = "camera_renamed"
+)
+
+// The CameraChange type is a difference between two refreshes of the camera list
+type CameraChange struct {
+	Kind     string
+	Camera   Owned
+	OldTitle string
+}
+
+func (ch CameraChange) String() string {
+	switch ch.Kind {
+	case CameraAdded:
+		return "Camera " + ch.Camera.Title + " added"
+	case CameraRemoved:
+		return "Camera " + ch.Camera.Title + " removed"
+	case CameraRenamed:
+		return fmt.Sprintf("Camera %s renamed to %s", ch.OldTitle, ch.Camera.Title)
+	}
+	return ch.Kind
+}
+
+// The CameraRegistry type keeps the camera list of a Dropcam fresh, refreshing
+// it every Interval in the background. Concurrent calls to Refresh share one
+// request. Cameras added, removed or renamed between refreshes are passed to
+// OnChange and, as notifications of the same kinds, to Notifier, so schedulers
+// and servers can pick up new cameras without restarting.
+type CameraRegistry struct {
+	Dropcam  *Dropcam
+	Interval time.Duration
+	Notifier Notifier
+	OnChange func(CameraChange)
+
+	mu     sync.Mutex
+	cams   *Cameras
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start loads the camera list and begins refreshing it in the background
+func (r *CameraRegistry) Start(ctx context.Context) error {
+
+	if r.Dropcam == nil {
+		return errors.New("CameraRegistry needs a Dropcam")
+	}
+	if r.Interval <= 0 {
+		r.Interval = 5 * time.Minute
+	}
+	if _, err := r.Refresh(ctx); err != nil {
+		return err
+	}
+
+	bg, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(bg)
+	return nil
+}
+
+// Stop ends the background refresh
+func (r *CameraRegistry) Stop() {
+	r.cancel()
+	<-r.done
+}
+
+func (r *CameraRegistry) run(ctx context.Context) {
+	defer close(r.done)
+
+	tick := time.NewTicker(r.Interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if _, err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			warnf("registry: refresh failed: %s\n", err)
+		}
+	}
+}
+
+// Cameras returns the camera list as of the last successful refresh, or nil
+// before the first one
+func (r *CameraRegistry) Cameras() *Cameras {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cams
+}
+
+// Find returns the camera with uuid, owned or shared, or nil
+func (r *CameraRegistry) Find(uuid string) *Owned {
+	if c := r.Cameras(); c != nil {
+		return c.findCamera(uuid)
+	}
+	return nil
+}
+
+// Refresh fetches the camera list now, through RefreshCameras, so concurrent
+// calls share one request and a caller giving up does not fail the others.
+// The list and the changes since the last refresh are only taken from a
+// refresh that succeeded.
+func (r *CameraRegistry) Refresh(ctx context.Context) (*Cameras, error) {
+
+	cams, err := r.Dropcam.RefreshCameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	var changes []CameraChange
+	if r.cams != nil {
+		changes = diffCameras(r.cams, cams)
+	}
+	r.cams = cams
+	r.mu.Unlock()
+
+	for _, ch := range changes {
+		r.changed(ch)
+	}
+	return cams, nil
+}
+
+func (r *CameraRegistry) changed(ch CameraChange) {
+
+	Dbg("registry: %s\n", ch)
+	if r.OnChange != nil {
+		r.OnChange(ch)
+	}
+	if r.Notifier != nil {
+		err := r.Notifier.Notify(&Notification{
+			Kind:    ch.Kind,
+			Camera:  ch.Camera.Uuid,
+			Title:   ch.Camera.Title,
+			Message: ch.String(),
+			Time:    time.Now(),
+		})
+		if err != nil {
+			warnf("registry: %s\n", err)
+		}
+	}
+}
+
+// diffCameras lists the cameras added, removed and renamed from prev to cur
+func diffCameras(prev, cur *Cameras) []CameraChange {
+
+	index := func(c *Cameras) (map[string]Owned, []string) {
+		m := make(map[string]Owned)
+		var order []string
+		add := func(o Owned) {
+			if _, ok := m[o.Uuid]; !ok {
+				order = append(order, o.Uuid)
+			}
+			m[o.Uuid] = o
+		}
+		for _, o := range c.Cam {
+			add(o)
+		}
+		for _, s := range c.Shared {
+			add(s.Owned)
+		}
+		return m, order
+	}
+	before, beforeOrder := index(prev)
+	after, afterOrder := index(cur)
+
+	var changes []CameraChange
+	for _, uuid := range afterOrder {
+		o := after[uuid]
+		old, ok := before[uuid]
+		switch {
+		case !ok:
+			changes = append(changes, CameraChange{Kind: CameraAdded, Camera: o})
+		case old.Title != o.Title:
+			changes = append(changes, CameraChange{Kind: CameraRenamed, Camera: o, OldTitle: old.Title})
+		}
+	}
+	for _, uuid := range beforeOrder {
+		if _, ok := after[uuid]; !ok {
+			changes = append(changes, CameraChange{Kind: CameraRemoved, Camera: before[uuid]})
+		}
+	}
+	return changes
+}
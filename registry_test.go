@@ -0,0 +1,172 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabarar/dropcam"
+	"github.com/rabarar/dropcam/dropcamtest"
+)
+
+// startRegistry starts a registry of the cameras of d that appends the
+// changes it reports to changes. It only refreshes when asked to.
+func startRegistry(t *testing.T, d *dropcam.Dropcam, changes *[]string) *dropcam.CameraRegistry {
+	t.Helper()
+	r := &dropcam.CameraRegistry{Dropcam: d, Interval: time.Hour, OnChange: func(ch dropcam.CameraChange) {
+		*changes = append(*changes, ch.String())
+	}}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRegistryChanges(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+	d := login(t, s).Dropcam
+	var changes []string
+	r := startRegistry(t, d, &changes)
+	defer r.Stop()
+
+	// another client changes the account
+	c := login(t, s)
+	front, _ := c.ByUUID(dropcamtest.FrontDoor)
+	if _, err := c.UpdateCamera(ctx, front, dropcam.CameraUpdate{Title: dropcam.String("Porch")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddCamera(ctx, dropcam.CameraPairing{MacAddress: "00:11:22:33:44:55", SetupToken: dropcamtest.SetupToken, Title: "Attic"}); err != nil {
+		t.Fatal(err)
+	}
+	garage, _ := c.ByUUID(dropcamtest.Garage)
+	if err := c.RemoveCamera(ctx, garage); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(changes)
+	want := []string{"Camera Attic added", "Camera Front Door renamed to Porch", "Camera Garage removed"}
+	if strings.Join(changes, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes %q, want %q", changes, want)
+	}
+	if o := r.Find(dropcamtest.FrontDoor); o == nil || o.Title != "Porch" {
+		t.Errorf("registry has %+v for Front Door, want it renamed", o)
+	}
+
+	// nothing changed since
+	changes = nil
+	if _, err := r.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes %q without any", changes)
+	}
+}
+
+func TestRegistryKeepsListWhenRefreshFails(t *testing.T) {
+
+	ctx := context.Background()
+	s := dropcamtest.NewServer()
+	defer s.Close()
+
+	// camera listings can be held, signalling arrived, until released
+	var mu sync.Mutex
+	hold := false
+	arrived, release := make(chan struct{}), make(chan struct{})
+	target, _ := url.Parse(s.URL)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	rp.ErrorLog = log.New(ioutil.Discard, "", 0)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		held := hold && strings.HasSuffix(r.URL.Path, "cameras.get_visible")
+		mu.Unlock()
+		if held {
+			arrived <- struct{}{}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		rp.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	d := s.Dropcam()
+	d.BaseURL, d.NexusURL = proxy.URL, proxy.URL
+	d.Retry.Initial = time.Millisecond
+	if _, err := d.Init(ctx, s.Username, s.Password); err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	r := startRegistry(t, d, &changes)
+	defer r.Stop()
+
+	c := login(t, s)
+	front, _ := c.ByUUID(dropcamtest.FrontDoor)
+	if _, err := c.UpdateCamera(ctx, front, dropcam.CameraUpdate{Title: dropcam.String("Porch")}); err != nil {
+		t.Fatal(err)
+	}
+	unchanged := func(when string) {
+		t.Helper()
+		if o := r.Find(dropcamtest.FrontDoor); o == nil || o.Title != "Front Door" {
+			t.Errorf("%s: registry has %+v for Front Door, want the list before", when, o)
+		}
+		if len(changes) != 0 {
+			t.Errorf("%s: changes %q", when, changes)
+		}
+	}
+
+	// the servers fail past the retries
+	s.Fail("cameras.get_visible", 500, 500, 500, 500)
+	if _, err := r.Refresh(ctx); err == nil {
+		t.Error("Refresh succeeded against a failing server")
+	}
+	unchanged("after a failed refresh")
+
+	// the only caller gives up while the list is fetched
+	mu.Lock()
+	hold = true
+	mu.Unlock()
+	cctx, cancel := context.WithCancel(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.Refresh(cctx)
+		errc <- err
+	}()
+	<-arrived
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("cancelled Refresh: %v, want context.Canceled", err)
+	}
+	unchanged("after a cancelled refresh")
+
+	mu.Lock()
+	hold = false
+	mu.Unlock()
+	close(release)
+	if _, err := r.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != "Camera Front Door renamed to Porch" {
+		t.Errorf("changes %q, want the rename once", changes)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// The CameraJSON type is the stable, machine-readable form of a camera
+// written by Cameras.WriteJSON. Unlike Owned its fields do not follow the
+// servers' responses, so scripts built on it keep working.
+type CameraJSON struct {
+	Uuid        string `json:"uuid"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Where       string `json:"where,omitempty"`
+	Shared      bool   `json:"shared"`
+	Online      bool   `json:"online"`
+	Streaming   bool   `json:"streaming"`
+	Connected   bool   `json:"connected"`
+	LocalIP     string `json:"local_ip,omitempty"`
+	MacAddress  string `json:"mac_address,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+}
+
+// The EventJSON type is the stable, machine-readable form of an event written
+// by Events.WriteJSON. Times are RFC 3339 and Duration is in seconds.
+type EventJSON struct {
+	Id        int64     `json:"id"`
+	Camera    string    `json:"camera_uuid,omitempty"`
+	Type      string    `json:"type"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  float64   `json:"duration"`
+	Important bool      `json:"important"`
+	HasClip   bool      `json:"has_clip"`
+	Zones     []int64   `json:"zones,omitempty"`
+}
+
+func cameraJSON(o *Owned, shared bool) CameraJSON {
+	return CameraJSON{
+		Uuid:        o.Uuid,
+		Title:       o.Title,
+		Description: o.Description,
+		Where:       o.Where,
+		Shared:      shared,
+		Online:      o.IsOnline,
+		Streaming:   o.IsStreaming,
+		Connected:   o.IsConnected,
+		LocalIP:     o.LastLocalIp,
+		MacAddress:  o.MacAddress,
+		Timezone:    o.Timezone,
+	}
+}
+
+// JSON returns the cameras, owned then shared, in their stable JSON form
+func (c *Cameras) JSON() []CameraJSON {
+	cams := make([]CameraJSON, 0, len(c.Cam)+len(c.Shared))
+	for i := range c.Cam {
+		cams = append(cams, cameraJSON(&c.Cam[i], false))
+	}
+	for i := range c.Shared {
+		cams = append(cams, cameraJSON(&c.Shared[i].Owned, true))
+	}
+	return cams
+}
+
+// WriteJSON writes the cameras to w as a JSON array of CameraJSON
+func (c *Cameras) WriteJSON(w io.Writer) error {
+	return writeIndented(w, c.JSON())
+}
+
+// JSON returns the event in its stable JSON form
+func (e *Event) JSON() EventJSON {
+	ej := EventJSON{
+		Id:        e.Id,
+		Camera:    e.Camera,
+		Type:      e.Type,
+		Start:     e.Start().UTC(),
+		End:       e.End().UTC(),
+		Important: e.IsImportant,
+		HasClip:   e.HasClip,
+		Zones:     e.ZoneIds,
+	}
+	if e.EndTime > e.StartTime {
+		ej.Duration = e.EndTime - e.StartTime
+	}
+	return ej
+}
+
+// JSON returns the events in their stable JSON form
+func (es Events) JSON() []EventJSON {
+	out := make([]EventJSON, len(es))
+	for i := range es {
+		out[i] = es[i].JSON()
+	}
+	return out
+}
+
+// WriteJSON writes the events to w as a JSON array of EventJSON
+func (es Events) WriteJSON(w io.Writer) error {
+	return writeIndented(w, es.JSON())
+}
+
+func writeIndented(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
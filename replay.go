@@ -0,0 +1,308 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReplayMaxGap is the longest a replay waits between two frames when
+// ReplayServer.MaxGap is zero, so gaps in the captures are skipped over
+const DefaultReplayMaxGap = 5 * time.Second
+
+// The ReplayFile type is a snapshot or clip of a replayed archive
+type ReplayFile struct {
+	Name string
+	Time time.Time
+	Data []byte
+}
+
+// The Replay type is an archived day of one camera loaded for playback:
+// its snapshots and clips in the order they were captured
+type Replay struct {
+	Manifest *ArchiveManifest
+	Frames   []ReplayFile
+	Clips    []ReplayFile
+}
+
+// LoadReplay reads the archive stored under key, as written by Archive.Day
+func LoadReplay(st Storage, key string) (*Replay, error) {
+
+	rc, err := st.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &Replay{}
+	add := func(name string, mod time.Time, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if name == ManifestName {
+			rp.Manifest = new(ArchiveManifest)
+			return json.Unmarshal(b, rp.Manifest)
+		}
+		f := ReplayFile{Name: name, Time: mod, Data: b}
+		switch strings.ToLower(path.Ext(name)) {
+		case ".jpg", ".jpeg":
+			rp.Frames = append(rp.Frames, f)
+		case ".mp4":
+			rp.Clips = append(rp.Clips, f)
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(key, "."+ArchiveZip) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			f, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = add(zf.Name, zf.Modified, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := add(hdr.Name, hdr.ModTime, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	byTime := func(fs []ReplayFile) {
+		sort.SliceStable(fs, func(i, j int) bool { return fs[i].Time.Before(fs[j].Time) })
+	}
+	byTime(rp.Frames)
+	byTime(rp.Clips)
+	return rp, nil
+}
+
+// The ReplayServer type is an http.Handler playing back archived days, as
+// stored by Archive in Storage, with the same tools used for live view:
+//
+//	/replay/<uuid>/<yyyy-mm-dd>/stream.mjpeg?speed=<n>&start=<hh:mm>
+//	/replay/<uuid>/<yyyy-mm-dd>/clips.json
+//	/replay/<uuid>/<yyyy-mm-dd>/clips/<name>
+//
+// The stream shows the day's snapshots as Motion JPEG, spaced as they were
+// captured divided by speed, Speed or 1 when not given, so speed=60 plays an
+// hour a minute. Waits are capped at MaxGap. Clips are served as MP4 files
+// with range requests, which HTML5 video players seek in. The latest archives
+// played are kept in memory. When Guard is set, callers need the read scope.
+type ReplayServer struct {
+	Storage Storage
+	Speed   float64
+	MaxGap  time.Duration
+	Guard   Guard
+
+	mu     sync.Mutex
+	loaded []loadedReplay
+}
+
+type loadedReplay struct {
+	key    string
+	replay *Replay
+}
+
+// replayCacheSize is how many archives a ReplayServer keeps loaded
+const replayCacheSize = 2
+
+func (s *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Guard != nil {
+		Protect(s.Guard, ScopeRead, http.HandlerFunc(s.serve)).ServeHTTP(w, r)
+		return
+	}
+	s.serve(w, r)
+}
+
+// replay returns the archive of camera uuid for day, loading it if needed
+func (s *ReplayServer) replay(uuid, day string) (*Replay, error) {
+
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return nil, fmt.Errorf("Bad day %q: %w", day, ErrNotFound)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.loaded {
+		if strings.HasPrefix(l.key, uuid+"/"+day+".") {
+			return l.replay, nil
+		}
+	}
+
+	var rp *Replay
+	var key string
+	err := ErrNotFound
+	for _, format := range []string{ArchiveTarGz, ArchiveZip} {
+		key = uuid + "/" + day + "." + format
+		if rp, err = LoadReplay(s.Storage, key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("No archive of %s for %s: %w", uuid, day, ErrNotFound)
+	}
+	s.loaded = append(s.loaded, loadedReplay{key, rp})
+	if len(s.loaded) > replayCacheSize {
+		s.loaded = s.loaded[1:]
+	}
+	return rp, nil
+}
+
+func (s *ReplayServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "replay" {
+		http.NotFound(w, r)
+		return
+	}
+	if p := RequestPrincipal(r); p != nil && !p.AllowsCamera(parts[1]) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	rp, err := s.replay(parts[1], parts[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 4 && parts[3] == "stream.mjpeg":
+		s.stream(w, r, rp)
+	case len(parts) == 4 && parts[3] == "clips.json":
+		type clip struct {
+			Name string    `json:"name"`
+			Time time.Time `json:"time"`
+			Size int       `json:"size"`
+		}
+		clips := make([]clip, len(rp.Clips))
+		for i, c := range rp.Clips {
+			clips[i] = clip{c.Name, c.Time, len(c.Data)}
+		}
+		writeJSON(w, clips)
+	case len(parts) >= 5 && parts[3] == "clips":
+		name := strings.Join(parts[4:], "/")
+		for _, c := range rp.Clips {
+			if c.Name == name {
+				w.Header().Set("Content-Type", "video/mp4")
+				http.ServeContent(w, r, path.Base(name), c.Time, bytes.NewReader(c.Data))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *ReplayServer) stream(w http.ResponseWriter, r *http.Request, rp *Replay) {
+
+	speed := s.Speed
+	if v, err := strconv.ParseFloat(r.FormValue("speed"), 64); err == nil && v > 0 {
+		speed = v
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	maxGap := s.MaxGap
+	if maxGap <= 0 {
+		maxGap = DefaultReplayMaxGap
+	}
+
+	frames := rp.Frames
+	if start := r.FormValue("start"); start != "" && len(frames) > 0 {
+		t, err := time.Parse("15:04", start)
+		if err != nil {
+			http.Error(w, "start takes hh:mm", http.StatusBadRequest)
+			return
+		}
+		day := frames[0].Time
+		from := time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location())
+		i := sort.Search(len(frames), func(i int) bool { return !frames[i].Time.Before(from) })
+		frames = frames[i:]
+	}
+	if len(frames) == 0 {
+		http.Error(w, "no snapshots to replay", http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	mw := newMJPEGWriter(w)
+	for i, f := range frames {
+		if err := mw.frame(f.Data); err != nil {
+			return
+		}
+		if i+1 == len(frames) {
+			return
+		}
+		wait := time.Duration(float64(frames[i+1].Time.Sub(f.Time)) / speed)
+		if wait > maxGap {
+			wait = maxGap
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// errNoReplay is returned by FrameAt when there is nothing to replay
+var errNoReplay = errors.New("Replay has no snapshots")
+
+// FrameAt returns the last snapshot captured at or before t, or the first
+// one if t is before them all
+func (rp *Replay) FrameAt(t time.Time) (*ReplayFile, error) {
+	if len(rp.Frames) == 0 {
+		return nil, errNoReplay
+	}
+	i := sort.Search(len(rp.Frames), func(i int) bool { return rp.Frames[i].Time.After(t) })
+	if i > 0 {
+		i--
+	}
+	return &rp.Frames[i], nil
+}
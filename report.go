@@ -0,0 +1,379 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report periods
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// The CameraReport type summarizes one camera over a report period. Uptime is
+// the fraction of samples in which the camera was online, or -1 when none were
+// taken. Stored figures are only filled in when the Reporter has a Catalog.
+type CameraReport struct {
+	Camera         string
+	Title          string
+	Uptime         float64
+	Events         int
+	EventTypes     map[string]int
+	PreviousEvents int
+	StoredItems    int
+	StoredBytes    int64
+	AddedBytes     int64
+	Anomalies      []string
+}
+
+// The Report type is a summary of every camera between From and To
+type Report struct {
+	Period  string
+	From    time.Time
+	To      time.Time
+	Cameras []CameraReport
+}
+
+// The Reporter type sends a daily or weekly Report through Notifier at Hour
+// local time, Monday for weekly reports. While running it samples whether each
+// camera is online every Sample to measure uptime, so uptime only covers the
+// time the Reporter has been running.
+type Reporter struct {
+	Cameras  *Cameras
+	Catalog  *Catalog
+	Notifier Notifier
+	Period   string
+	Hour     int
+	Sample   time.Duration
+
+	mu      sync.Mutex
+	samples map[string][]uptimeSample
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+type uptimeSample struct {
+	at     time.Time
+	online bool
+}
+
+// Start begins sampling and sending reports in the background
+func (rp *Reporter) Start() error {
+
+	if rp.Cameras == nil || rp.Notifier == nil {
+		return errors.New("Reporter needs Cameras and a Notifier")
+	}
+	if rp.Period == "" {
+		rp.Period = ReportDaily
+	}
+	if rp.Period != ReportDaily && rp.Period != ReportWeekly {
+		return fmt.Errorf("Unknown report period %q", rp.Period)
+	}
+	if rp.Sample <= 0 {
+		rp.Sample = 5 * time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rp.cancel = cancel
+	rp.done = make(chan struct{})
+	go rp.run(ctx)
+	return nil
+}
+
+// Stop ends the background loop
+func (rp *Reporter) Stop() {
+	rp.cancel()
+	<-rp.done
+}
+
+func (rp *Reporter) run(ctx context.Context) {
+	defer close(rp.done)
+
+	tick := time.NewTicker(rp.Sample)
+	defer tick.Stop()
+
+	due := rp.next(time.Now())
+	for {
+		rp.sample(ctx, time.Now())
+
+		if now := time.Now(); !now.Before(due) {
+			if err := rp.Send(ctx, due); err != nil {
+				warnf("report: %s\n", err)
+			}
+			due = rp.next(now)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// next returns the first report time after now
+func (rp *Reporter) next(now time.Time) time.Time {
+
+	t := time.Date(now.Year(), now.Month(), now.Day(), rp.Hour, 0, 0, 0, now.Location())
+	for !t.After(now) || (rp.Period == ReportWeekly && t.Weekday() != time.Monday) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// start returns the start of the period ending at to
+func (rp *Reporter) start(to time.Time) time.Time {
+	if rp.Period == ReportWeekly {
+		return to.AddDate(0, 0, -7)
+	}
+	return to.AddDate(0, 0, -1)
+}
+
+// sample records whether each camera is online
+func (rp *Reporter) sample(ctx context.Context, now time.Time) {
+
+	c, err := rp.Cameras.Dropcam.Cameras(ctx)
+	if err != nil {
+		warnf("report: failed to refresh cameras: %s\n", err)
+		return
+	}
+
+	// keep two periods so the previous one can still be reported late
+	keep := rp.start(rp.start(now))
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.samples == nil {
+		rp.samples = make(map[string][]uptimeSample)
+	}
+	record := func(o Owned) {
+		s := rp.samples[o.Uuid]
+		for len(s) > 0 && s[0].at.Before(keep) {
+			s = s[1:]
+		}
+		rp.samples[o.Uuid] = append(s, uptimeSample{at: now, online: o.IsOnline})
+	}
+	for _, o := range c.Cam {
+		record(o)
+	}
+	for _, s := range c.Shared {
+		record(s.Owned)
+	}
+}
+
+// uptime returns the online fraction and the time offline for camera uuid
+// between from and to
+func (rp *Reporter) uptime(uuid string, from, to time.Time) (float64, time.Duration) {
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	var online, total int
+	for _, s := range rp.samples[uuid] {
+		if s.at.Before(from) || !s.at.Before(to) {
+			continue
+		}
+		total++
+		if s.online {
+			online++
+		}
+	}
+	if total == 0 {
+		return -1, 0
+	}
+	return float64(online) / float64(total), time.Duration(total-online) * rp.Sample
+}
+
+// Build summarizes every camera over the period ending at to
+func (rp *Reporter) Build(ctx context.Context, to time.Time) (*Report, error) {
+
+	from := rp.start(to)
+	report := &Report{Period: rp.Period, From: from, To: to}
+
+	cams := append([]Owned(nil), rp.Cameras.Cam...)
+	for _, s := range rp.Cameras.Shared {
+		cams = append(cams, s.Owned)
+	}
+	for i := range cams {
+		o := &cams[i]
+		cr := CameraReport{Camera: o.Uuid, Title: o.Title, EventTypes: make(map[string]int)}
+
+		var offline time.Duration
+		cr.Uptime, offline = rp.uptime(o.Uuid, from, to)
+		if cr.Uptime >= 0 && cr.Uptime < 0.99 {
+			cr.Anomalies = append(cr.Anomalies, fmt.Sprintf("offline for about %s", offline.Round(time.Minute)))
+		}
+
+		events, err := rp.Cameras.GetEvents(ctx, o, from, to)
+		eventsErr := err
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			cr.Anomalies = append(cr.Anomalies, "events unavailable: "+err.Error())
+		}
+		cr.Events = len(events)
+		for _, e := range events {
+			cr.EventTypes[e.Type]++
+		}
+		if prev, err := rp.Cameras.GetEvents(ctx, o, rp.start(from), from); err == nil && eventsErr == nil {
+			cr.PreviousEvents = len(prev)
+			switch {
+			case cr.Events >= 2*cr.PreviousEvents && cr.Events-cr.PreviousEvents >= 10:
+				cr.Anomalies = append(cr.Anomalies, fmt.Sprintf("%d events, up from %d", cr.Events, cr.PreviousEvents))
+			case cr.Events == 0 && cr.PreviousEvents >= 10:
+				cr.Anomalies = append(cr.Anomalies, fmt.Sprintf("no events, down from %d", cr.PreviousEvents))
+			}
+		}
+
+		if rp.Catalog != nil {
+			cr.StoredItems, cr.StoredBytes, err = rp.Catalog.Usage(ctx, CatalogQuery{Camera: o.Uuid})
+			if err != nil {
+				return nil, err
+			}
+			var added int
+			added, cr.AddedBytes, err = rp.Catalog.Usage(ctx, CatalogQuery{Camera: o.Uuid, From: from, To: to})
+			if err != nil {
+				return nil, err
+			}
+			if added == 0 {
+				cr.Anomalies = append(cr.Anomalies, "nothing stored")
+			}
+		}
+
+		report.Cameras = append(report.Cameras, cr)
+	}
+	return report, nil
+}
+
+// Send builds the report for the period ending at to and delivers it
+func (rp *Reporter) Send(ctx context.Context, to time.Time) error {
+
+	report, err := rp.Build(ctx, to)
+	if err != nil {
+		return err
+	}
+	html, err := report.HTML()
+	if err != nil {
+		return err
+	}
+	return rp.Notifier.Notify(&Notification{
+		Kind:    NotifyReport,
+		Title:   report.Title(),
+		Message: report.Text(),
+		HTML:    html,
+		Time:    time.Now(),
+	})
+}
+
+// Title names the report, e.g. "Daily report for Mon Jan 2"
+func (r *Report) Title() string {
+	if r.Period == ReportWeekly {
+		return "Weekly report for " + r.From.Format("Mon Jan 2") + " to " + r.To.AddDate(0, 0, -1).Format("Mon Jan 2")
+	}
+	return "Daily report for " + r.From.Format("Mon Jan 2")
+}
+
+// Text renders the report as plain text
+func (r *Report) Text() string {
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", r.Title())
+	for _, c := range r.Cameras {
+		fmt.Fprintf(&b, "\n%s\n", c.Title)
+		fmt.Fprintf(&b, "  uptime:  %s\n", c.uptime())
+		fmt.Fprintf(&b, "  events:  %d%s (previous period %d)\n", c.Events, c.eventTypes(), c.PreviousEvents)
+		if c.StoredItems > 0 || c.AddedBytes > 0 {
+			fmt.Fprintf(&b, "  storage: %s in %d items, %s new\n", byteSize(c.StoredBytes), c.StoredItems, byteSize(c.AddedBytes))
+		}
+		for _, a := range c.Anomalies {
+			fmt.Fprintf(&b, "  ! %s\n", a)
+		}
+	}
+	return b.String()
+}
+
+// HTML renders the report as an HTML document
+func (r *Report) HTML() (string, error) {
+	var b bytes.Buffer
+	err := reportTemplate.Execute(&b, r)
+	return b.String(), err
+}
+
+func (c CameraReport) uptime() string {
+	if c.Uptime < 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%.1f%%", c.Uptime*100)
+}
+
+func (c CameraReport) eventTypes() string {
+
+	if len(c.EventTypes) == 0 {
+		return ""
+	}
+	types := make([]string, 0, len(c.EventTypes))
+	for t := range c.EventTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		types[i] = fmt.Sprintf("%s %d", t, c.EventTypes[t])
+	}
+	return " (" + strings.Join(types, ", ") + ")"
+}
+
+// byteSize formats n bytes for people
+func byteSize(n int64) string {
+
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":      byteSize,
+	"uptime":     CameraReport.uptime,
+	"eventTypes": CameraReport.eventTypes,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body{font-family:sans-serif}
+table{border-collapse:collapse}td,th{padding:.2em .8em;text-align:left;border-bottom:1px solid #ddd}
+.warn{color:#b00}
+</style>
+</head><body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Camera</th><th>Uptime</th><th>Events</th><th>Previous</th><th>Stored</th><th>New</th><th>Anomalies</th></tr>
+{{range .Cameras}}<tr>
+<td>{{.Title}}</td>
+<td>{{uptime .}}</td>
+<td>{{.Events}}{{eventTypes .}}</td>
+<td>{{.PreviousEvents}}</td>
+<td>{{bytes .StoredBytes}} ({{.StoredItems}})</td>
+<td>{{bytes .AddedBytes}}</td>
+<td class="warn">{{range .Anomalies}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+</body></html>
+`))
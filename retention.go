@@ -0,0 +1,202 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetentionInterval is how often a RetentionManager prunes when
+// RetentionManager.Interval is zero
+const DefaultRetentionInterval = time.Hour
+
+// The RetentionResult type is the outcome of one pruning pass. Kept and Size
+// are the objects left and their total size.
+type RetentionResult struct {
+	Removed int   `json:"removed"`
+	Freed   int64 `json:"freed"`
+	Kept    int   `json:"kept"`
+	Size    int64 `json:"size"`
+}
+
+// The RetentionManager type keeps saved snapshots and clips from filling the
+// disk. It deletes what is older than MaxAge, then the oldest of the rest
+// until they take no more than MaxSize bytes; a zero limit is not enforced.
+//
+// It prunes the files below Dir when set, oldest by modification time.
+// Otherwise it prunes the objects of Storage recorded in Catalog under
+// Prefix, such as a camera's "<uuid>/", oldest by capture time, removing
+// their records too; Storage must support deleting, as DirStorage, Router
+// and EncryptedStorage do.
+type RetentionManager struct {
+	Dir string
+
+	Storage Storage
+	Catalog *Catalog
+	Prefix  string
+
+	MaxAge   time.Duration
+	MaxSize  int64
+	Interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	last RetentionResult
+}
+
+// retained is one file or object subject to retention
+type retained struct {
+	key  string
+	size int64
+	time time.Time
+}
+
+// Prune deletes what is past the limits at now and reports what it did
+func (rm *RetentionManager) Prune(ctx context.Context, now time.Time) (RetentionResult, error) {
+
+	var res RetentionResult
+	items, err := rm.list(ctx)
+	if err != nil {
+		return res, err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].time.Before(items[j].time) })
+
+	for _, it := range items {
+		res.Size += it.size
+	}
+	res.Kept = len(items)
+
+	for _, it := range items {
+		old := rm.MaxAge > 0 && now.Sub(it.time) > rm.MaxAge
+		big := rm.MaxSize > 0 && res.Size > rm.MaxSize
+		if !old && !big {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if err := rm.remove(ctx, it.key); err != nil {
+			return res, fmt.Errorf("Failed to delete %s: %w", it.key, err)
+		}
+		res.Removed++
+		res.Freed += it.size
+		res.Kept--
+		res.Size -= it.size
+	}
+
+	rm.mu.Lock()
+	rm.last = res
+	rm.mu.Unlock()
+	if res.Removed > 0 {
+		Dbg("retention: removed %d objects, %d bytes\n", res.Removed, res.Freed)
+	}
+	return res, nil
+}
+
+// Last returns the result of the last Prune
+func (rm *RetentionManager) Last() RetentionResult {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.last
+}
+
+func (rm *RetentionManager) list(ctx context.Context) ([]retained, error) {
+
+	switch {
+	case rm.Dir != "":
+		var items []retained
+		err := filepath.Walk(rm.Dir, func(fn string, fi os.FileInfo, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			// skip the temporary files of writes in progress
+			if fi.Mode().IsRegular() && !strings.HasPrefix(fi.Name(), ".") {
+				items = append(items, retained{key: fn, size: fi.Size(), time: fi.ModTime()})
+			}
+			return nil
+		})
+		return items, err
+
+	case rm.Catalog != nil && rm.Storage != nil:
+		recs, err := rm.Catalog.Prefix(ctx, rm.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]retained, len(recs))
+		for i, r := range recs {
+			items[i] = retained{key: r.Key, size: r.Size, time: r.Time}
+		}
+		return items, nil
+	}
+	return nil, errors.New("RetentionManager needs a Dir, or a Storage and Catalog")
+}
+
+func (rm *RetentionManager) remove(ctx context.Context, key string) error {
+
+	if rm.Dir != "" {
+		err := os.Remove(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := deleteKey(rm.Storage, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return rm.Catalog.Remove(ctx, key)
+}
+
+// Start prunes now and then every Interval in the background
+func (rm *RetentionManager) Start() error {
+
+	if rm.MaxAge <= 0 && rm.MaxSize <= 0 {
+		return errors.New("RetentionManager needs a MaxAge or MaxSize")
+	}
+	if _, err := rm.list(context.Background()); err != nil {
+		return err
+	}
+	if rm.Interval <= 0 {
+		rm.Interval = DefaultRetentionInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rm.cancel = cancel
+	rm.wg.Add(1)
+	go func() {
+		defer rm.wg.Done()
+		tick := time.NewTicker(rm.Interval)
+		defer tick.Stop()
+		for {
+			if _, err := rm.Prune(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				warnf("retention: %s\n", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends pruning
+func (rm *RetentionManager) Stop() {
+	rm.cancel()
+	rm.wg.Wait()
+}
@@ -0,0 +1,257 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The RetryPolicy type controls how a Dropcam retries requests that fail.
+// Every request is passed to RetryOn, DefaultRetryOn when nil, to decide
+// whether its failure is worth retrying. Lookups are retried Retries times, 3
+// when zero; posts that change camera state are never retried. The first
+// retry waits Initial, 100ms when zero, and each further one Multiplier times
+// as long, 2 when zero, up to Max, 5s when zero. Jitter, between 0 and 1,
+// randomizes each wait by up to that fraction so clients failing together do
+// not retry together. A retried reply's Retry-After header lengthens its wait
+// when it asks for more.
+//
+// A 429 reply is returned as an APIError wrapping ErrRateLimited, carrying the
+// wait its Retry-After asked for. With WaitRateLimited set the request is
+// instead repeated after that wait, the backoff when absent, as long as it is
+// at most MaxRetryAfter, a minute when zero. This applies to posts too, as
+// the servers did not act on them.
+type RetryPolicy struct {
+	Disabled   bool
+	Retries    int
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+
+	WaitRateLimited bool
+	MaxRetryAfter   time.Duration
+
+	RetryOn func(resp *http.Response, err error) bool `json:"-"`
+}
+
+// DefaultRetryOn retries transport errors and 5xx replies
+func DefaultRetryOn(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// RetryOnRateLimit retries what DefaultRetryOn does and 429 replies
+func RetryOnRateLimit(resp *http.Response, err error) bool {
+	return DefaultRetryOn(resp, err) || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryable reports whether the outcome of a request should be retried
+func (p RetryPolicy) retryable(resp *http.Response, err error) bool {
+	if p.RetryOn != nil {
+		return p.RetryOn(resp, err)
+	}
+	return DefaultRetryOn(resp, err)
+}
+
+// rateLimitWait returns how long to wait before repeating a request the
+// servers rate limited, after n such waits, and false when it should not be
+// repeated
+func (p RetryPolicy) rateLimitWait(resp *http.Response, n int) (time.Duration, bool) {
+
+	if p.Disabled || !p.WaitRateLimited || n >= p.retries(3) {
+		return 0, false
+	}
+	max := p.MaxRetryAfter
+	if max <= 0 {
+		max = time.Minute
+	}
+	wait := retryAfter(resp)
+	if wait <= 0 {
+		wait = p.backoff(n)
+	}
+	return wait, wait <= max
+}
+
+// retryAfter returns the wait the Retry-After header of resp asks for, given
+// either in seconds or as a date, or 0 without one
+func retryAfter(resp *http.Response) time.Duration {
+
+	if resp == nil {
+		return 0
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retries returns the number of retries for a request that by default gets def
+func (p RetryPolicy) retries(def int) int {
+	if p.Disabled || def == 0 {
+		return 0
+	}
+	if p.Retries > 0 {
+		return p.Retries
+	}
+	return def
+}
+
+// backoff returns the wait before retrying after attempt, counted from 0
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+
+	wait, max := p.Initial, p.Max
+	if wait <= 0 {
+		wait = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+	mult := p.Multiplier
+	if mult <= 1 {
+		mult = 2
+	}
+	for i := 0; i < attempt && wait < max; i++ {
+		wait = time.Duration(float64(wait) * mult)
+	}
+	if wait > max {
+		wait = max
+	}
+	if j := p.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		wait -= time.Duration(rand.Float64() * j * float64(wait))
+	}
+	return wait
+}
+
+const retryBudgetBuckets = 10
+
+type budgetBucket struct {
+	slot     int64
+	requests int64
+	retries  int64
+	denied   int64
+}
+
+// The RetryBudget type caps retries across all requests of a Dropcam at Ratio
+// of the requests made over the sliding Window, with at least MinRetries
+// allowed per window. During an upstream outage this makes the client fail
+// fast instead of multiplying load on the API.
+type RetryBudget struct {
+	Ratio      float64
+	MinRetries int64
+	Window     time.Duration
+
+	mu      sync.Mutex
+	buckets [retryBudgetBuckets]budgetBucket
+}
+
+// The RetryBudgetStats type is the state of a RetryBudget over its current
+// window. Ratio is the retries spent per request, which the budget keeps
+// under its own Ratio once MinRetries are spent.
+type RetryBudgetStats struct {
+	Requests int64
+	Retries  int64
+	Denied   int64
+	Ratio    float64
+}
+
+// NewRetryBudget returns a budget allowing retries for 10% of requests over a
+// one minute window, and at least 10 retries per window
+func NewRetryBudget() *RetryBudget {
+	return &RetryBudget{Ratio: 0.1, MinRetries: 10, Window: time.Minute}
+}
+
+// bucket returns the bucket for now, clearing it if it holds a stale slot
+func (b *RetryBudget) bucket(now time.Time) *budgetBucket {
+	width := b.Window / retryBudgetBuckets
+	if width <= 0 {
+		width = time.Minute / retryBudgetBuckets
+	}
+	slot := now.UnixNano() / int64(width)
+	bk := &b.buckets[slot%retryBudgetBuckets]
+	if bk.slot != slot {
+		*bk = budgetBucket{slot: slot}
+	}
+	return bk
+}
+
+// totals sums the buckets that fall inside the window ending now
+func (b *RetryBudget) totals(now time.Time) RetryBudgetStats {
+	cur := b.bucket(now).slot
+	var st RetryBudgetStats
+	for _, bk := range b.buckets {
+		if cur-bk.slot < retryBudgetBuckets {
+			st.Requests += bk.requests
+			st.Retries += bk.retries
+			st.Denied += bk.denied
+		}
+	}
+	return st
+}
+
+func (b *RetryBudget) recordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket(time.Now()).requests++
+}
+
+// allowRetry reports whether a retry fits in the budget, and if so spends it
+func (b *RetryBudget) allowRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	st := b.totals(now)
+	if st.Retries >= b.MinRetries && float64(st.Retries+1) > b.Ratio*float64(st.Requests) {
+		b.bucket(now).denied++
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
+
+// Stats returns the requests, retries and denied retries in the current
+// window, and the ratio of retries to requests. A Monitor serves them, and
+// PushMetrics pushes them, as the dropcam_retry_budget_* metrics.
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.totals(time.Now())
+	if st.Requests > 0 {
+		st.Ratio = float64(st.Retries) / float64(st.Requests)
+	}
+	return st
+}
+
+// sleepCtx waits for d, returning early with the error of ctx once it is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
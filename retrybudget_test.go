@@ -0,0 +1,82 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spentBudget returns a budget that saw 20 requests and allowed 2 of 3
+// retries
+func spentBudget() *RetryBudget {
+	b := &RetryBudget{Ratio: 0.1, MinRetries: 2, Window: time.Minute}
+	for i := 0; i < 20; i++ {
+		b.recordRequest()
+	}
+	for i := 0; i < 3; i++ {
+		b.allowRetry()
+	}
+	return b
+}
+
+var retryBudgetSeries = []string{
+	"dropcam_retry_budget_requests 20\n",
+	"dropcam_retry_budget_retries 2\n",
+	"dropcam_retry_budget_denied 1\n",
+	"dropcam_retry_budget_ratio 0.1\n",
+	"dropcam_retry_budget_max_ratio 0.1\n",
+}
+
+func TestRetryBudgetStats(t *testing.T) {
+	st := spentBudget().Stats()
+	if st != (RetryBudgetStats{Requests: 20, Retries: 2, Denied: 1, Ratio: 0.1}) {
+		t.Errorf("Stats = %+v", st)
+	}
+}
+
+func TestRetryBudgetMetrics(t *testing.T) {
+
+	m := &Monitor{Dropcam: &Dropcam{RetryBudget: spentBudget()}}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range retryBudgetSeries {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("monitor metrics lack %q:\n%s", want, w.Body)
+		}
+	}
+
+	m = &Monitor{Dropcam: new(Dropcam)}
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(w.Body.String(), "dropcam_retry_budget") {
+		t.Errorf("monitor without a retry budget serves its metrics:\n%s", w.Body)
+	}
+}
+
+func TestPushRetryBudget(t *testing.T) {
+
+	var body string
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer gw.Close()
+
+	d := &Dropcam{RetryBudget: spentBudget()}
+	if err := d.PushMetrics(context.Background(), gw.URL, StartJob("dropcam_snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range retryBudgetSeries {
+		if !strings.Contains(body, want) {
+			t.Errorf("pushed metrics lack %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The Route type is where one camera's media goes. Layout is a text/template
+// that turns a key into the key used in Storage, executed with a RouteKey;
+// when empty keys are stored unchanged. Media older than Retention is deleted
+// by Router.Prune; zero keeps it forever.
+type Route struct {
+	Storage   Storage
+	Layout    string
+	Retention time.Duration
+
+	once   sync.Once
+	layout *template.Template
+	err    error
+}
+
+// template parses Layout the first time it is needed
+func (r *Route) template() (*template.Template, error) {
+	r.once.Do(func() {
+		r.layout, r.err = template.New("layout").Option("missingkey=error").Parse(r.Layout)
+	})
+	return r.layout, r.err
+}
+
+// The RouteKey type is the data a Route's Layout is executed with, for a key
+// such as "<uuid>/2014/10/26/clip-1.mp4"
+type RouteKey struct {
+	Key    string // the whole key
+	Camera string // the first segment, "<uuid>"
+	Title  string // the camera's title, from Router.Titles
+	Rest   string // the key after the first segment, "2014/10/26/clip-1.mp4"
+	Dir    string // the directory of Rest, "2014/10/26"
+	Name   string // the last segment, "clip-1.mp4"
+}
+
+// The Router type is a Storage sending each camera's media to its own Route,
+// e.g. the front door to S3 for 90 days and the workshop to a local NAS for 7.
+// Keys are routed on their first segment, the camera uuid, as the rest of the
+// package produces them. Cameras without a route use Default.
+//
+// Deleting needs storages with a Delete(key string) error method, as
+// DirStorage has.
+type Router struct {
+	Routes  map[string]*Route
+	Default *Route
+	Titles  map[string]string
+}
+
+// route returns the route for key and the key to use with its storage
+func (rt *Router) route(key string) (*Route, string, error) {
+
+	k, err := cleanKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	camera, rest := k, ""
+	if i := strings.IndexByte(k, '/'); i >= 0 {
+		camera, rest = k[:i], k[i+1:]
+	}
+
+	r := rt.Routes[camera]
+	if r == nil {
+		r = rt.Default
+	}
+	if r == nil || r.Storage == nil {
+		return nil, "", fmt.Errorf("No storage route for camera %s", camera)
+	}
+	if r.Layout == "" {
+		return r, k, nil
+	}
+
+	layout, err := r.template()
+	if err != nil {
+		return nil, "", fmt.Errorf("Bad storage layout for camera %s: %s", camera, err)
+	}
+	dir := path.Dir(rest)
+	if dir == "." {
+		dir = ""
+	}
+	var buf bytes.Buffer
+	err = layout.Execute(&buf, RouteKey{
+		Key:    k,
+		Camera: camera,
+		Title:  rt.Titles[camera],
+		Rest:   rest,
+		Dir:    dir,
+		Name:   path.Base(k),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	routed, err := cleanKey(buf.String())
+	return r, routed, err
+}
+
+// Check validates every route, so bad layouts are reported at startup rather
+// than on the first Put
+func (rt *Router) Check() error {
+
+	for camera, r := range rt.Routes {
+		if r == nil || r.Storage == nil {
+			return fmt.Errorf("Storage route for camera %s has no storage", camera)
+		}
+		if _, _, err := rt.route(camera + "/check"); err != nil {
+			return err
+		}
+	}
+	if rt.Default != nil {
+		if _, _, err := rt.route("default/check"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put implements Storage
+func (rt *Router) Put(key string, r io.Reader) error {
+
+	route, k, err := rt.route(key)
+	if err != nil {
+		return err
+	}
+	return route.Storage.Put(k, r)
+}
+
+// Get implements Storage
+func (rt *Router) Get(key string) (io.ReadCloser, error) {
+
+	route, k, err := rt.route(key)
+	if err != nil {
+		return nil, err
+	}
+	return route.Storage.Get(k)
+}
+
+// Delete removes the object stored under key
+func (rt *Router) Delete(key string) error {
+
+	route, k, err := rt.route(key)
+	if err != nil {
+		return err
+	}
+	return deleteKey(route.Storage, k)
+}
+
+// Prune deletes the media catalogued in c that is older than its camera's
+// retention, from storage and catalog, and returns how many objects it removed
+func (rt *Router) Prune(ctx context.Context, c *Catalog, now time.Time) (int, error) {
+
+	cameras, err := c.Cameras(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, camera := range cameras {
+		r := rt.Routes[camera]
+		if r == nil {
+			r = rt.Default
+		}
+		if r == nil || r.Retention <= 0 {
+			continue
+		}
+		recs, err := c.Query(ctx, CatalogQuery{Camera: camera, To: now.Add(-r.Retention)})
+		if err != nil {
+			return removed, err
+		}
+		for _, rec := range recs {
+			if err := rt.Delete(rec.Key); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("Failed to delete %s: %w", rec.Key, err)
+			}
+			if err := c.Remove(ctx, rec.Key); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// deleter is implemented by storages that can delete objects
+type deleter interface {
+	Delete(key string) error
+}
+
+// deleteKey deletes key from s if s supports it
+func deleteKey(s Storage, key string) error {
+	if d, ok := s.(deleter); ok {
+		return d.Delete(key)
+	}
+	return errors.New("Storage does not support deleting")
+}
+
+// Delete removes the file for key
+func (s DirStorage) Delete(key string) error {
+	fn, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fn)
+}
+
+// Delete removes the object stored under key
+func (s *EncryptedStorage) Delete(key string) error {
+	return deleteKey(s.Storage, key)
+}
@@ -0,0 +1,305 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultS3PartSize is the S3Uploader.PartSize used when it is zero. S3
+// needs parts of at least 5 MiB, but for the last.
+const DefaultS3PartSize = 16 << 20
+
+// s3PartAttempts is how many times a part is sent before the upload is left
+// to be resumed, waiting s3PartRetryWait more after each failure
+const s3PartAttempts = 3
+
+var s3PartRetryWait = time.Second
+
+// s3Part is a part of a multipart upload, as listed and completed
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+// multipartUpload uploads r as key in parts of partSize, first resuming an
+// upload of key left unfinished by an earlier failure. Parts already there
+// whose ETag is the MD5 of the data are not sent again. Each part is sent
+// with its Content-MD5, for S3 to check, and its ETag, and that of the
+// completed object, are checked against the MD5s of the data sent.
+func (s *S3Uploader) multipartUpload(ctx context.Context, key string, r io.Reader, contentType string, partSize int64) error {
+
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// it fits in one part after all
+		return s.putObject(ctx, key, buf[:n], contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	id, uploaded, err := s.pendingUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		if id, err = s.initiateUpload(ctx, key, contentType); err != nil {
+			return err
+		}
+	} else {
+		Dbg("s3: resuming the upload of %s, %d parts there\n", key, len(uploaded))
+	}
+
+	var parts []s3Part
+	var sums []byte
+	for number := 1; n > 0; number++ {
+		sum := md5.Sum(buf[:n])
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		if uploaded[number] != etag {
+			if err := s.putPart(ctx, key, id, number, buf[:n], sum[:]); err != nil {
+				return fmt.Errorf("S3 part %d failed, upload %s left to resume: %w", number, id, err)
+			}
+		}
+		parts = append(parts, s3Part{PartNumber: number, ETag: etag})
+		sums = append(sums, sum[:]...)
+
+		if n < len(buf) {
+			break
+		}
+		if n, err = io.ReadFull(r, buf); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	total := md5.Sum(sums)
+	want := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(total[:]), len(parts))
+	etag, err := s.completeUpload(ctx, key, id, parts)
+	if err != nil {
+		return err
+	}
+	if etag != want {
+		return fmt.Errorf("S3 object %s has ETag %s, not %s of the parts sent", key, etag, want)
+	}
+	return nil
+}
+
+// putObject uploads data as key in a single request
+func (s *S3Uploader) putObject(ctx context.Context, key string, data []byte, contentType string) error {
+
+	sum := md5.Sum(data)
+	resp, err := s.do(ctx, "PUT", key, nil, bytes.NewReader(data), int64(len(data)), func(h http.Header) {
+		h.Set("Content-Type", contentType)
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// pendingUpload returns the id of the latest unfinished upload of key, if
+// any, and the ETags of the parts it has by part number
+func (s *S3Uploader) pendingUpload(ctx context.Context, key string) (string, map[int]string, error) {
+
+	resp, err := s.do(ctx, "GET", "", url.Values{"uploads": {""}, "prefix": {key}}, nil, 0, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("S3 list uploads failed: %w", err)
+	}
+	var list struct {
+		Uploads []struct {
+			Key       string
+			UploadId  string
+			Initiated time.Time
+		} `xml:"Upload"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return "", nil, fmt.Errorf("S3 list uploads failed: %w", err)
+	}
+	var id string
+	var latest time.Time
+	for _, u := range list.Uploads {
+		if u.Key == key && (id == "" || u.Initiated.After(latest)) {
+			id, latest = u.UploadId, u.Initiated
+		}
+	}
+	if id == "" {
+		return "", nil, nil
+	}
+
+	uploaded := make(map[int]string)
+	marker := 0
+	for {
+		q := url.Values{"uploadId": {id}}
+		if marker > 0 {
+			q.Set("part-number-marker", strconv.Itoa(marker))
+		}
+		resp, err := s.do(ctx, "GET", key, q, nil, 0, nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("S3 list parts failed: %w", err)
+		}
+		var page struct {
+			IsTruncated          bool
+			NextPartNumberMarker int
+			Parts                []s3Part `xml:"Part"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("S3 list parts failed: %w", err)
+		}
+		for _, p := range page.Parts {
+			uploaded[p.PartNumber] = p.ETag
+		}
+		if !page.IsTruncated || page.NextPartNumberMarker <= marker {
+			return id, uploaded, nil
+		}
+		marker = page.NextPartNumberMarker
+	}
+}
+
+// initiateUpload starts a multipart upload of key and returns its id
+func (s *S3Uploader) initiateUpload(ctx context.Context, key, contentType string) (string, error) {
+
+	resp, err := s.do(ctx, "POST", key, url.Values{"uploads": {""}}, nil, 0, func(h http.Header) {
+		h.Set("Content-Type", contentType)
+	})
+	if err != nil {
+		return "", fmt.Errorf("S3 initiate upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var res struct{ UploadId string }
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil || res.UploadId == "" {
+		return "", fmt.Errorf("S3 initiate upload failed: no upload id: %v", err)
+	}
+	return res.UploadId, nil
+}
+
+// putPart sends part number of upload id, whose MD5 is sum, trying again
+// after failures
+func (s *S3Uploader) putPart(ctx context.Context, key, id string, number int, data, sum []byte) error {
+
+	q := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {id}}
+	want := `"` + hex.EncodeToString(sum) + `"`
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		resp, err = s.do(ctx, "PUT", key, q, bytes.NewReader(data), int64(len(data)), func(h http.Header) {
+			h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+		})
+		if err == nil {
+			resp.Body.Close()
+			etag := resp.Header.Get("ETag")
+			if etag == want {
+				return nil
+			}
+			err = fmt.Errorf("ETag %s, not the MD5 %s of the part", etag, want)
+		}
+		if attempt == s3PartAttempts || ctx.Err() != nil {
+			return err
+		}
+		Dbg("s3: part %d of %s: %s\n", number, key, err)
+		select {
+		case <-time.After(time.Duration(attempt) * s3PartRetryWait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// completeUpload joins the parts of upload id into key and returns the ETag
+// of the object
+func (s *S3Uploader) completeUpload(ctx context.Context, key, id string, parts []s3Part) (string, error) {
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(ctx, "POST", key, url.Values{"uploadId": {id}}, bytes.NewReader(body), int64(len(body)), func(h http.Header) {
+		h.Set("Content-Type", "application/xml")
+	})
+	if err != nil {
+		return "", fmt.Errorf("S3 complete upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	// failures can come after a 200 status, as an Error document
+	var res struct {
+		XMLName xml.Name
+		ETag    string
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("S3 complete upload failed: %w", err)
+	}
+	if res.XMLName.Local == "Error" {
+		return "", fmt.Errorf("S3 complete upload failed: %s: %s", res.Code, res.Message)
+	}
+	return res.ETag, nil
+}
+
+// do sends a signed request for key, or for the bucket when key is empty,
+// with the query q and the body r of size bytes, and returns the response
+// when it succeeded
+func (s *S3Uploader) do(ctx context.Context, method, key string, q url.Values, r io.Reader, size int64, header func(http.Header)) (*http.Response, error) {
+
+	u, region, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		u.Path, u.RawPath = strings.TrimSuffix(u.Path, "/")+"/", ""
+	}
+	// Signature Version 4 escapes spaces as %20
+	u.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
+	var body io.Reader
+	if r != nil {
+		body = ioutil.NopCloser(r)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if header != nil {
+		header(req.Header)
+	}
+	s.sign(req, region, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		return nil, errors.New(resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSaveWorkers is how many snapshots SaveAllImages fetches at once when
+// SaveAllOptions.Workers is zero
+const DefaultSaveWorkers = 4
+
+// The SaveAllOptions type configures SaveAllImages. Filter selects the
+// cameras, every owned camera when nil; Shared adds the cameras shared with
+// the account.
+type SaveAllOptions struct {
+	Workers int
+	Filter  CameraFilter
+	Shared  bool
+	Path    PathTemplate
+}
+
+// The SaveResult type reports the snapshot saved for one camera. Path is
+// empty when Err is set; CollisionSuffix may have changed it.
+type SaveResult struct {
+	Camera Owned
+	Path   string
+	Err    error
+}
+
+// The SaveAllImages method saves a snapshot of every selected camera into dir,
+// fetching them concurrently with at most opts.Workers requests in flight. Files
+// are named "<uuid>-<yyyymmdd-hhmmss>.jpg" after the time the fetch began, or
+// by opts.Path when set. One result is returned per camera, in the order of
+// c.Cam followed by c.Shared.
+func (c *Cameras) SaveAllImages(ctx context.Context, dir string, width int, opts SaveAllOptions) []SaveResult {
+
+	var selected []*Owned
+	for i := range c.Cam {
+		if opts.Filter == nil || opts.Filter(&c.Cam[i]) {
+			selected = append(selected, &c.Cam[i])
+		}
+	}
+	if opts.Shared {
+		for i := range c.Shared {
+			if opts.Filter == nil || opts.Filter(&c.Shared[i].Owned) {
+				selected = append(selected, &c.Shared[i].Owned)
+			}
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultSaveWorkers
+	}
+	now := time.Now()
+	stamp := now.Format("20060102-150405")
+
+	results := make([]SaveResult, len(selected))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(selected); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				o := selected[i]
+				fn := filepath.Join(dir, o.Uuid+"-"+stamp+".jpg")
+				if opts.Path != "" {
+					fn = opts.Path.File(dir, o, now, PathFields{})
+				}
+				res := SaveResult{Camera: *o, Path: fn}
+				written, err := c.saveNewImage(ctx, o, fn, width, now, UploadSnapshot)
+				if res.Err = err; err != nil {
+					res.Path = ""
+				} else if written != "" {
+					res.Path = written
+				}
+				results[i] = res
+			}
+		}()
+	}
+	for i := range selected {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,133 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Collision policies for snapshots saved over an existing file
+const (
+	CollisionOverwrite = "overwrite"
+	CollisionSkip      = "skip"
+	CollisionSuffix    = "suffix"
+)
+
+// The FileOptions type controls how SaveImage, SaveImageAs and the jobs built
+// on them write snapshots. Each is written to a temporary file next to its
+// destination and renamed into place, so a crash never leaves a truncated
+// image behind.
+//
+// Collision is what is done when the file exists: CollisionOverwrite, the
+// default, replaces it; CollisionSkip keeps it and writes nothing;
+// CollisionSuffix writes <name>-1.jpg, <name>-2.jpg, ... instead. Skipped
+// and suffixed files are claimed atomically, so concurrent writers never
+// replace each other's. Fsync flushes each file and its folder to disk before
+// the save returns.
+type FileOptions struct {
+	Collision string
+	Fsync     bool
+}
+
+// writeFile writes data to path as opts says and returns the path written,
+// "" when CollisionSkip left an existing file
+func (opts FileOptions) writeFile(path string, data []byte) (string, error) {
+
+	switch opts.Collision {
+	case "", CollisionOverwrite, CollisionSkip, CollisionSuffix:
+	default:
+		return "", fmt.Errorf("Unknown collision policy %q", opts.Collision)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, ".image-")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil && opts.Fsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	written := path
+	if opts.Collision == "" || opts.Collision == CollisionOverwrite {
+		err = os.Rename(tmp, path)
+	} else {
+		written, err = claimFile(tmp, path, opts.Collision == CollisionSuffix)
+	}
+	if err != nil || written == "" {
+		os.Remove(tmp)
+		return "", err
+	}
+	if opts.Fsync {
+		syncDir(dir)
+	}
+	return written, nil
+}
+
+// claimFile moves tmp to path, or with suffix to the first of path-1,
+// path-2, ... that is free, without ever replacing a file. It returns ""
+// when path is taken and suffix is not set.
+func claimFile(tmp, path string, suffix bool) (string, error) {
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 0; ; n++ {
+		name := path
+		if n > 0 {
+			name = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+
+		// a hard link fails, rather than replaces, when name exists
+		err := os.Link(tmp, name)
+		if err == nil {
+			os.Remove(tmp)
+			return name, nil
+		}
+		if !os.IsExist(err) {
+			// no hard links on this file system: check, then rename
+			_, serr := os.Lstat(name)
+			if os.IsNotExist(serr) {
+				return name, os.Rename(tmp, name)
+			}
+			if serr != nil {
+				return "", serr
+			}
+		}
+		if !suffix {
+			Dbg("\"%s\" exists, not writing\n", path)
+			return "", nil
+		}
+	}
+}
+
+// syncDir flushes the entries of dir to disk. Not every system can sync a
+// folder, so failures are ignored.
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	f.Sync()
+	f.Close()
+}
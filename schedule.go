@@ -0,0 +1,139 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// DefaultPollBackoffMax is the longest a PollBackoff stretches a polling
+// interval to when Max is zero
+const DefaultPollBackoffMax = 30 * time.Minute
+
+// Phase returns a deterministic offset in [0, interval) for camera uuid, so that
+// cameras polled at the same interval are spread across it instead of firing together
+func Phase(uuid string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(uuid))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// The PollSchedule type computes the capture times of one camera polled every
+// Interval. Captures land on the camera's Phase within each interval, spread at
+// random over a window of Jitter*Interval centred on it.
+type PollSchedule struct {
+	UUID     string
+	Interval time.Duration
+	Jitter   float64
+}
+
+// Next returns the camera's first capture time after now
+func (s PollSchedule) Next(now time.Time) time.Time {
+
+	if s.Interval <= 0 {
+		return now
+	}
+	phase := Phase(s.UUID, s.Interval)
+
+	// Slots are anchored to the Unix epoch so every process agrees on them
+	since := now.UnixNano() - int64(phase)
+	slot := since/int64(s.Interval) + 1
+	next := time.Unix(0, slot*int64(s.Interval)+int64(phase))
+
+	if s.Jitter > 0 {
+		j := s.Jitter * float64(s.Interval)
+		next = next.Add(time.Duration((rand.Float64()*2 - 1) * j / 2))
+	}
+	if !next.After(now) {
+		next = next.Add(s.Interval)
+	}
+	return next
+}
+
+// Wait sleeps until the camera's next capture time. It returns false if stop is
+// closed first.
+func (s PollSchedule) Wait(stop <-chan struct{}) bool {
+	t := time.NewTimer(time.Until(s.Next(time.Now())))
+	defer t.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// The PollBackoff type lengthens the interval a camera is polled at while it
+// is offline or failing, to save the request budget for the cameras that
+// answer. A poll finding the camera offline, or the Errors-th failure in a
+// row, 3 when zero, multiplies the interval by Multiplier, 2 when zero, up to
+// Max, DefaultPollBackoffMax when zero; the first poll to succeed snaps back
+// to the configured cadence. Stretched intervals vary by up to Jitter, 0.1
+// when zero, either way, so cameras that went down together do not come back
+// in step.
+type PollBackoff struct {
+	Max        time.Duration
+	Multiplier float64
+	Errors     int
+	Jitter     float64
+}
+
+// pollState is how far a PollBackoff has stretched the interval of one camera
+type pollState struct {
+	failures int
+	interval time.Duration
+}
+
+// next returns how long to wait for the next poll of a camera polled every
+// base after a poll ending with err, nil when it succeeded, or zero to keep
+// to the regular schedule. b may be nil, for no backoff.
+func (b *PollBackoff) next(st *pollState, base time.Duration, err error) time.Duration {
+
+	if b == nil || err == nil {
+		if st.interval > 0 {
+			Dbg("poll: back to every %s after %d failures\n", base, st.failures)
+		}
+		st.failures, st.interval = 0, 0
+		return 0
+	}
+	st.failures++
+	errs := b.Errors
+	if errs <= 0 {
+		errs = 3
+	}
+	if st.failures < errs && !errors.Is(err, ErrCameraOffline) {
+		return 0
+	}
+
+	mult := b.Multiplier
+	if mult <= 1 {
+		mult = 2
+	}
+	max := b.Max
+	if max <= 0 {
+		max = DefaultPollBackoffMax
+	}
+	if max < base {
+		max = base
+	}
+	if st.interval == 0 {
+		st.interval = base
+	}
+	st.interval = time.Duration(float64(st.interval) * mult)
+	if st.interval > max {
+		st.interval = max
+	}
+	jitter := b.Jitter
+	if jitter <= 0 {
+		jitter = 0.1
+	}
+	return st.interval + time.Duration((rand.Float64()*2-1)*jitter*float64(st.interval))
+}
@@ -0,0 +1,317 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The Session type is the login state of a Dropcam worth keeping between
+// runs: the session cookie and trusted-device token of CookieAuth or the
+// tokens of NestOAuth and GoogleOAuth.
+type Session struct {
+	Cookie        string      `json:"cookie,omitempty"`
+	TrustedDevice string      `json:"trusted_device,omitempty"`
+	Token         *OAuthToken `json:"token,omitempty"`
+	RefreshToken  string      `json:"refresh_token,omitempty"`
+	Saved         time.Time   `json:"saved"`
+}
+
+// The SessionStore interface persists sessions by account so that a process
+// started often, e.g. from cron, can reuse the last login instead of logging
+// in every time, which the servers answer with rate limits and CAPTCHAs.
+// Load returns nil and no error when nothing is stored for key.
+type SessionStore interface {
+	Load(key string) (*Session, error)
+	Save(key string, s *Session) error
+}
+
+// The SessionLocker interface is implemented by SessionStores shared between
+// processes. Lock holds off every other holder of the store until unlock is
+// called, so only one process logs in at a time and the others pick up the
+// session it saved instead of replacing it with one of their own, which would
+// invalidate the first.
+type SessionLocker interface {
+	Lock(ctx context.Context) (unlock func(), err error)
+}
+
+// DefaultSessionLockStale is how old a FileSessionStore lock file has to be
+// before it is taken to be left over from a process that died
+const DefaultSessionLockStale = time.Minute
+
+// The MemorySessionStore type keeps sessions for the life of the process,
+// which is enough to share a login between Dropcam values
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// Load implements SessionStore
+func (m *MemorySessionStore) Load(key string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[key]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Save implements SessionStore
+func (m *MemorySessionStore) Save(key string, s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = make(map[string]Session)
+	}
+	m.sessions[key] = *s
+	return nil
+}
+
+// The FileSessionStore type keeps sessions in a JSON file at Path, readable
+// only by its owner, replaced atomically on every save. It is a SessionLocker
+// using the lock file Path+".lock", so a CLI and a daemon on the same host can
+// share it; a lock file older than LockStale, DefaultSessionLockStale when
+// zero, is broken.
+type FileSessionStore struct {
+	Path      string
+	LockStale time.Duration
+
+	mu sync.Mutex
+}
+
+// Lock implements SessionLocker
+func (f *FileSessionStore) Lock(ctx context.Context) (func(), error) {
+
+	stale := f.LockStale
+	if stale <= 0 {
+		stale = DefaultSessionLockStale
+	}
+	name := f.Path + ".lock"
+	for {
+		fh, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(fh, "%d\n", os.Getpid())
+			fh.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) > stale {
+			Dbg("breaking stale session lock %s\n", name)
+			os.Remove(name)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (f *FileSessionStore) read() (map[string]Session, error) {
+	sessions := make(map[string]Session)
+	data, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, errors.New("Failed to read sessions: " + err.Error())
+	}
+	return sessions, nil
+}
+
+// Load implements SessionStore
+func (f *FileSessionStore) Load(key string) (*Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sessions, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := sessions[key]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// Save implements SessionStore
+func (f *FileSessionStore) Save(key string, s *Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sessions, err := f.read()
+	if err != nil {
+		return err
+	}
+	sessions[key] = *s
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), ".sessions-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// TempFile already creates the file owner-only
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// The sessionAuth interface is implemented by Authenticators whose state can
+// be stored in a Session. resume reports whether s was usable.
+type sessionAuth interface {
+	session(d *Dropcam) *Session
+	resume(d *Dropcam, s *Session) bool
+}
+
+func (a *CookieAuth) session(d *Dropcam) *Session {
+	return &Session{Cookie: d.SessionCookie(), TrustedDevice: a.TrustedDevice}
+}
+
+func (a *CookieAuth) resume(d *Dropcam, s *Session) bool {
+	// the device stays trusted after the session expires
+	if s.TrustedDevice != "" && a.TrustedDevice == "" {
+		a.TrustedDevice = s.TrustedDevice
+	}
+	if s.Cookie == "" {
+		return false
+	}
+	d.setCookie(s.Cookie)
+	return true
+}
+
+func (a *NestOAuth) session(d *Dropcam) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &Session{Token: a.Token}
+}
+
+func (a *NestOAuth) resume(d *Dropcam, s *Session) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !s.Token.Valid() {
+		return false
+	}
+	a.Token = s.Token
+	return true
+}
+
+func (a *GoogleOAuth) session(d *Dropcam) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &Session{Token: a.Token, RefreshToken: a.RefreshToken}
+}
+
+func (a *GoogleOAuth) resume(d *Dropcam, s *Session) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.RefreshToken != "" {
+		a.RefreshToken = s.RefreshToken
+	}
+	if !s.Token.Valid() {
+		return false
+	}
+	a.Token = s.Token
+	return true
+}
+
+// sessionKey is the key the session of d is stored under
+func (d *Dropcam) sessionKey() string {
+	if d.SessionKey != "" {
+		return d.SessionKey
+	}
+	return d.Creds.Username
+}
+
+// lockSession takes the session store's lock, if it has one
+func (d *Dropcam) lockSession(ctx context.Context) (func(), error) {
+	if l, ok := d.Sessions.(SessionLocker); ok {
+		return l.Lock(ctx)
+	}
+	return func() {}, nil
+}
+
+// resumeNewerSession restores the stored session if another process saved
+// one since d's was, reporting whether it did
+func (d *Dropcam) resumeNewerSession() bool {
+
+	sa, ok := d.Auth.(sessionAuth)
+	if d.Sessions == nil || !ok {
+		return false
+	}
+	s, err := d.Sessions.Load(d.sessionKey())
+	if err != nil || s == nil || sameSession(s, sa.session(d)) {
+		return false
+	}
+	return sa.resume(d, s)
+}
+
+// sameSession reports whether a and b hold the same credentials
+func sameSession(a, b *Session) bool {
+	token := func(s *Session) string {
+		if s.Token == nil {
+			return ""
+		}
+		return s.Token.AccessToken
+	}
+	return a.Cookie == b.Cookie && token(a) == token(b)
+}
+
+// resumeSession restores the stored session, reporting whether there was a
+// usable one
+func (d *Dropcam) resumeSession() bool {
+
+	sa, ok := d.Auth.(sessionAuth)
+	if d.Sessions == nil || !ok {
+		return false
+	}
+	s, err := d.Sessions.Load(d.sessionKey())
+	if err != nil {
+		warnf("session store: %s\n", err)
+		return false
+	}
+	if s == nil || !sa.resume(d, s) {
+		return false
+	}
+	Dbg("resumed session saved %s\n", s.Saved.Format(time.RFC3339))
+	return true
+}
+
+// saveSession stores the current session, if there is a store
+func (d *Dropcam) saveSession() {
+
+	sa, ok := d.Auth.(sessionAuth)
+	if d.Sessions == nil || !ok {
+		return
+	}
+	s := sa.session(d)
+	s.Saved = time.Now()
+	if err := d.Sessions.Save(d.sessionKey(), s); err != nil {
+		warnf("session store: %s\n", err)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The CameraProperty type is one property as reported by the camera info endpoint.
+// Values are booleans, numbers or strings depending on the property.
+type CameraProperty struct {
+	UUID  string      `json:"camera_uuid"`
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// The Schedule type is a streaming or notification schedule attached to a camera
+type Schedule struct {
+	Id                int64             `json:"id"`
+	CameraId          int64             `json:"camera_id"`
+	Key               string            `json:"key"`
+	Enabled           bool              `json:"enabled"`
+	GeofencingEnabled bool              `json:"geofencing_enabled"`
+	OverrideReason    string            `json:"override_reason"`
+	OverrideState     bool              `json:"override_state"`
+	OverriddenAt      int64             `json:"overridden_at"`
+	Periods           []json.RawMessage `json:"periods"`
+}
+
+// The NotificationTarget type links a camera to a device or address it notifies
+type NotificationTarget struct {
+	Id         int64  `json:"id"`
+	CameraId   int64  `json:"camera_id"`
+	TargetId   int64  `json:"target_id"`
+	LastSentAt string `json:"last_sent_at"`
+}
+
+// The CameraInfo type is the detailed camera record served by app/cameras/<uuid>
+type CameraInfo struct {
+	Owned
+	Properties              []CameraProperty     `json:"properties"`
+	Schedules               []Schedule           `json:"schedules"`
+	Notifications           []NotificationTarget `json:"notifications"`
+	AreNotificationsEnabled bool                 `json:"are_notifications_enabled"`
+	IsHDVideoEnabled        bool                 `json:"is_hd_video_enabled"`
+	IrledState              string               `json:"irled_state"`
+	SerialNumber            string               `json:"serial_number"`
+}
+
+// The CameraInfo method fetches the detailed record for camera o, including its
+// current properties and schedules
+func (c *Cameras) CameraInfo(ctx context.Context, o *Owned) (*CameraInfo, error) {
+
+	response, err := c.Dropcam.getRequest(ctx, c.Dropcam.CameraInfoPath+"/"+o.Uuid, url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("Camera Info Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Camera Info", response, body)
+	}
+
+	info := new(CameraInfo)
+	if err := json.Unmarshal(body, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// The SchedulePeriod type is a span of a schedule, as decoded by
+// Schedule.DecodePeriods. Days are the weekdays it applies to, Sunday being
+// 0, and Start and End are "15:04" times of day. Raw keeps the period as the
+// servers sent it, for fields not decoded here.
+type SchedulePeriod struct {
+	Days  []int           `json:"days"`
+	Start string          `json:"start_time"`
+	End   string          `json:"end_time"`
+	Raw   json.RawMessage `json:"-"`
+}
+
+// DecodePeriods decodes the periods of the schedule
+func (s *Schedule) DecodePeriods() ([]SchedulePeriod, error) {
+	periods := make([]SchedulePeriod, len(s.Periods))
+	for i, raw := range s.Periods {
+		if err := json.Unmarshal(raw, &periods[i]); err != nil {
+			return nil, fmt.Errorf("Can't unmarshal schedule period: %s", err)
+		}
+		periods[i].Raw = raw
+	}
+	return periods, nil
+}
+
+// The GetSchedule method returns the schedule of camera o with key, e.g. the
+// streaming schedule turning the camera on and off
+func (c *Cameras) GetSchedule(ctx context.Context, o *Owned, key string) (*Schedule, error) {
+
+	info, err := c.CameraInfo(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	for i := range info.Schedules {
+		if info.Schedules[i].Key == key {
+			return &info.Schedules[i], nil
+		}
+	}
+	return nil, fmt.Errorf("No schedule %q on %s: %w", key, o.Title, ErrNotFound)
+}
+
+// The NotificationSettings type is how a camera alerts its owner. Enabled is
+// the camera's master switch and Kinds whether each kind of event, such as
+// "motion" or "sound", notifies, from the camera's notify.<kind>.enabled
+// properties. Properties holds every notify.* property as reported and
+// Targets the devices and addresses notified.
+type NotificationSettings struct {
+	Enabled    bool                   `json:"enabled"`
+	Kinds      map[string]bool        `json:"kinds"`
+	Properties map[string]interface{} `json:"properties"`
+	Targets    []NotificationTarget   `json:"targets"`
+}
+
+// The GetNotificationSettings method fetches the notification settings of camera o
+func (c *Cameras) GetNotificationSettings(ctx context.Context, o *Owned) (*NotificationSettings, error) {
+
+	info, err := c.CameraInfo(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &NotificationSettings{
+		Enabled:    info.AreNotificationsEnabled,
+		Kinds:      make(map[string]bool),
+		Properties: make(map[string]interface{}),
+		Targets:    info.Notifications,
+	}
+	for _, p := range info.Properties {
+		if !strings.HasPrefix(p.Name, "notify.") {
+			continue
+		}
+		ns.Properties[p.Name] = p.Value
+		if kind := strings.TrimSuffix(strings.TrimPrefix(p.Name, "notify."), ".enabled"); kind != p.Name[len("notify."):] {
+			ns.Kinds[kind] = propertyBool(p.Value)
+		}
+	}
+	return ns, nil
+}
+
+// readOnlyProperties are reported by the camera but cannot be set
+var readOnlyProperties = map[string]bool{
+	"last_clustering_update": true,
+	"last_firmware_version":  true,
+}
+
+// The CameraSettings type is the portable form of a camera's configuration
+// written by ExportSettings. Schedules and notification targets are included
+// for reference; only Properties are re-applied by ImportSettings.
+type CameraSettings struct {
+	UUID          string                 `json:"camera_uuid"`
+	Title         string                 `json:"title"`
+	Exported      time.Time              `json:"exported"`
+	Properties    map[string]interface{} `json:"properties"`
+	Schedules     []Schedule             `json:"schedules"`
+	Notifications []NotificationTarget   `json:"notifications"`
+}
+
+// The ExportSettings method writes the configuration of camera o to w as JSON
+func (c *Cameras) ExportSettings(ctx context.Context, o *Owned, w io.Writer) error {
+
+	info, err := c.CameraInfo(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	settings := &CameraSettings{
+		UUID:          o.Uuid,
+		Title:         info.Title,
+		Exported:      time.Now(),
+		Properties:    make(map[string]interface{}),
+		Schedules:     info.Schedules,
+		Notifications: info.Notifications,
+	}
+	for _, p := range info.Properties {
+		settings.Properties[p.Name] = p.Value
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(settings)
+}
+
+// The ImportSettings method reads settings written by ExportSettings, possibly
+// from another camera, and applies every writable property to camera o
+func (c *Cameras) ImportSettings(ctx context.Context, o *Owned, r io.Reader) error {
+
+	settings := new(CameraSettings)
+	if err := json.NewDecoder(r).Decode(settings); err != nil {
+		return errors.New("Failed to read camera settings: " + err.Error())
+	}
+
+	names := make([]string, 0, len(settings.Properties))
+	for name := range settings.Properties {
+		if !readOnlyProperties[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := propertyString(settings.Properties[name])
+		if _, err := c.SetProperties(ctx, o, name, value); err != nil {
+			return fmt.Errorf("Failed to set %s on %s: %s", name, o.Title, err)
+		}
+	}
+	return nil
+}
+
+// propertyString formats a decoded property value the way SetProperties expects it
+func propertyString(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
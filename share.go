@@ -0,0 +1,97 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultShareTTL is how long a link GetClipShareURL makes lasts when no ttl
+// is given
+const DefaultShareTTL = 24 * time.Hour
+
+// The Presigner interface is implemented by Uploaders that can make a URL
+// reading an object without credentials, valid for ttl, as S3Uploader does
+type Presigner interface {
+	Presign(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+// clipShare is a share link in a share_clip reply
+type clipShare struct {
+	URL string `json:"share_url"`
+}
+
+// The GetClipShareURL method returns a link to the clip of event on camera o
+// that anyone can open for ttl, DefaultShareTTL when zero, without logging
+// in, so a notification can link the clip without carrying credentials.
+// With Dropcam.Uploads set and its Uploader a Presigner the clip is uploaded,
+// as SaveClip would upload it, and the link is a presigned URL of the
+// object; otherwise the servers are asked for a link to the clip they host.
+func (c *Cameras) GetClipShareURL(ctx context.Context, o *Owned, event Event, ttl time.Duration) (string, error) {
+
+	if ttl <= 0 {
+		ttl = DefaultShareTTL
+	}
+	if u := c.Dropcam.Uploads; u != nil {
+		if p, ok := u.Uploader.(Presigner); ok {
+			return c.presignClip(ctx, o, event, ttl, u, p)
+		}
+	}
+
+	if err := c.Dropcam.requireCVR("Share Clip", o); err != nil {
+		return "", err
+	}
+	data := map[string]interface{}{
+		"uuid":        o.Uuid,
+		"cuepoint_id": event.Id,
+		"expires_in":  int64(ttl / time.Second),
+	}
+	path := c.Dropcam.nexusPath(o, c.Dropcam.ClipSharePath)
+	if err := c.Dropcam.refuseWrite("Share Clip", "POST", path, o.Uuid, data); err != nil {
+		return "", err
+	}
+	items, err := c.Dropcam.postRequest(ctx, "Share Clip", path, o.Uuid, data)
+	if err != nil {
+		return "", err
+	}
+
+	// the link comes alone or as the only item of a list
+	var shares []clipShare
+	if err := json.Unmarshal(items, &shares); err != nil {
+		shares = make([]clipShare, 1)
+		if err := json.Unmarshal(items, &shares[0]); err != nil {
+			return "", errors.New("Can't unmarshal Clip Share: " + err.Error())
+		}
+	}
+	if len(shares) == 0 || shares[0].URL == "" {
+		return "", fmt.Errorf("Share Clip: no link for event %d: %w", event.Id, ErrNotFound)
+	}
+	return shares[0].URL, nil
+}
+
+// presignClip uploads the clip of event through u and returns a URL p signs
+// for it
+func (c *Cameras) presignClip(ctx context.Context, o *Owned, event Event, ttl time.Duration, u *Uploads, p Presigner) (string, error) {
+
+	clip, err := c.OpenClip(ctx, o, event)
+	if err != nil {
+		return "", err
+	}
+	defer clip.Close()
+
+	key, err := u.put(ctx, UploadClip, o, fmt.Sprintf("%d.mp4", event.Id), event.Start(), clip, clip.Length)
+	if err != nil {
+		return "", err
+	}
+	link, err := p.Presign(ctx, key, ttl)
+	if err != nil {
+		return "", fmt.Errorf("Presign of %s failed: %w", key, err)
+	}
+	return link, nil
+}
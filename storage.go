@@ -0,0 +1,83 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The Storage interface is a destination for captured media. Objects are
+// addressed by slash-separated keys such as "<uuid>/2014-10-26.tar.gz".
+type Storage interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+}
+
+// DirStorage stores objects as files below a local directory
+type DirStorage struct {
+	Root string
+}
+
+// Put writes r to the file for key, replacing it atomically
+func (s DirStorage) Put(key string, r io.Reader) error {
+
+	fn, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fn), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
+
+// Get opens the file for key
+func (s DirStorage) Get(key string) (io.ReadCloser, error) {
+	fn, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fn)
+}
+
+func (s DirStorage) path(key string) (string, error) {
+	k, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(k)), nil
+}
+
+// cleanKey normalizes a storage key and rejects keys that escape the store
+func cleanKey(key string) (string, error) {
+	k := path.Clean("/" + key)[1:]
+	if k == "" || strings.HasPrefix(k, "../") {
+		return "", errors.New("Invalid storage key: " + key)
+	}
+	return k, nil
+}
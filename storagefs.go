@@ -0,0 +1,256 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The StorageFS type exposes the objects in a Storage as a read-only fs.FS, so
+// they can be served with http.FileServer(http.FS(...)), walked with
+// fs.WalkDir or parsed as templates. Keys are used as paths.
+//
+// Directory listings, sizes and modification times come from Catalog when it
+// is set, otherwise from the Storage if it has a ReadDir method, as
+// DirStorage does. Objects whose reader cannot seek are read into memory when
+// opened.
+type StorageFS struct {
+	Storage Storage
+	Catalog *Catalog
+}
+
+// Open implements fs.FS
+func (s StorageFS) Open(name string) (fs.File, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return s.openDir(name)
+	}
+
+	rc, err := s.Storage.Get(name)
+	if err != nil {
+		if f, derr := s.openDir(name); derr == nil {
+			return f, nil
+		}
+		if os.IsNotExist(err) {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	info := fileInfo{name: path.Base(name), size: -1, mode: 0444}
+	if st, ok := rc.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if fi, err := st.Stat(); err == nil {
+			if fi.IsDir() {
+				rc.Close()
+				return s.openDir(name)
+			}
+			info.size, info.modTime = fi.Size(), fi.ModTime()
+		}
+	}
+	if s.Catalog != nil {
+		if rec, err := s.Catalog.Lookup(context.Background(), name); err == nil && rec != nil {
+			info.size, info.modTime = rec.Size, rec.Time
+		}
+	}
+
+	f := &storageFile{rc: rc, info: info}
+	if rs, ok := rc.(io.ReadSeeker); ok && info.size >= 0 {
+		f.r = rs
+		return f, nil
+	}
+
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.info.size = int64(len(data))
+	f.r = bytes.NewReader(data)
+	f.rc = ioutil.NopCloser(nil)
+	return f, nil
+}
+
+// ReadDir implements fs.ReadDirFS
+func (s StorageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+
+	f, err := s.openDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.ReadDir(-1)
+}
+
+func (s StorageFS) openDir(name string) (*dirFile, error) {
+
+	var entries []fs.DirEntry
+	var err error
+	switch rd, ok := s.Storage.(dirLister); {
+	case s.Catalog != nil:
+		entries, err = s.catalogDir(name)
+	case ok:
+		entries, err = listDir(rd, name)
+	case name != ".":
+		err = fs.ErrNotExist
+	}
+	if err == nil && len(entries) == 0 && name != "." {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &dirFile{info: fileInfo{name: path.Base(name), mode: fs.ModeDir | 0555}, entries: entries}, nil
+}
+
+// dirLister is implemented by storages that can list their directories
+type dirLister interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// listDir lists directory name from rd, reporting entries the way Open does
+func listDir(rd dirLister, name string) ([]fs.DirEntry, error) {
+
+	list, err := rd.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]fs.DirEntry, 0, len(list))
+	for _, e := range list {
+		if e.IsDir() {
+			entries = append(entries, fileInfo{name: e.Name(), mode: fs.ModeDir | 0555})
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fileInfo{name: e.Name(), size: fi.Size(), mode: 0444, modTime: fi.ModTime()})
+	}
+	return entries, nil
+}
+
+// catalogDir lists the immediate children of directory name from the catalog
+func (s StorageFS) catalogDir(name string) ([]fs.DirEntry, error) {
+
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	recs, err := s.Catalog.Prefix(context.Background(), prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for _, rec := range recs {
+		rest := strings.TrimPrefix(rec.Key, prefix)
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			dir := rest[:i]
+			if !seen[dir] {
+				seen[dir] = true
+				entries = append(entries, fileInfo{name: dir, mode: fs.ModeDir | 0555})
+			}
+			continue
+		}
+		entries = append(entries, fileInfo{name: rest, size: rec.Size, mode: 0444, modTime: rec.Time})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// ReadDir lists the directory name below Root for StorageFS. Temporary
+// files left by interrupted writes are skipped.
+func (s DirStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+
+	fn := s.Root
+	if name != "." {
+		var err error
+		if fn, err = s.path(name); err != nil {
+			return nil, err
+		}
+	}
+	all, err := os.ReadDir(fn)
+	if err != nil {
+		return nil, err
+	}
+	entries := all[:0]
+	for _, e := range all {
+		if !strings.HasPrefix(e.Name(), ".") {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry for StorageFS
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi fileInfo) Name() string               { return fi.name }
+func (fi fileInfo) Size() int64                { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}           { return nil }
+func (fi fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+type storageFile struct {
+	rc   io.ReadCloser
+	r    io.ReadSeeker
+	info fileInfo
+}
+
+func (f *storageFile) Read(p []byte) (int, error)                   { return f.r.Read(p) }
+func (f *storageFile) Seek(offset int64, whence int) (int64, error) { return f.r.Seek(offset, whence) }
+func (f *storageFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *storageFile) Close() error                                 { return f.rc.Close() }
+
+type dirFile struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
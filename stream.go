@@ -0,0 +1,122 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// The Stream type describes a negotiated live stream. URL is the RTMP address
+// the Dropcam web player connects to, including the session token, and can be
+// handed to ffmpeg, VLC or any other RTMP client.
+type Stream struct {
+	Camera string
+	Host   string
+	Token  string
+	URL    string
+
+	// FFmpeg is the ffmpeg binary Open runs; "ffmpeg" on the PATH when empty
+	FFmpeg string
+
+	bandwidth *Bandwidth
+}
+
+// The SessionToken method requests a token that authorizes live streams for
+// the current session
+func (d *Dropcam) SessionToken(ctx context.Context) (string, error) {
+
+	response, err := d.getRequest(ctx, d.SessionTokenPath, url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("Get Session Token Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]string]("Get Session Token", response)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 || items[0] == "" {
+		return "", errors.New("Get Session Token returned no token")
+	}
+	return items[0], nil
+}
+
+// The Stream method negotiates a live stream from camera o's stream host, or
+// the one CameraHosts gives it
+func (c *Cameras) Stream(ctx context.Context, o *Owned) (*Stream, error) {
+
+	host := c.Dropcam.liveStreamHost(o)
+	if host == "" {
+		return nil, fmt.Errorf("Camera %s has no live stream host", o.Title)
+	}
+	token, err := c.Dropcam.SessionToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u := url.URL{
+		Scheme:   "rtmp",
+		Host:     host,
+		Path:     "/nexus/" + o.Uuid,
+		RawQuery: url.Values{"sessionToken": {token}}.Encode(),
+	}
+	return &Stream{Camera: o.Uuid, Host: host, Token: token, URL: u.String(), bandwidth: c.Dropcam.Bandwidth}, nil
+}
+
+// The Open method starts receiving the stream and returns it remuxed, without
+// re-encoding, as an MPEG transport stream suitable for piping to a recorder.
+// RTMP is spoken by ffmpeg, which must be installed. Closing the reader, or
+// cancelling ctx, stops the stream.
+func (s *Stream) Open(ctx context.Context) (io.ReadCloser, error) {
+
+	bin := s.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, bin, "-loglevel", "error", "-i", s.URL, "-c", "copy", "-f", "mpegts", "pipe:1")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start %s: %s", bin, err)
+	}
+	return countedStream(s.bandwidth, s.Camera, &streamReader{ReadCloser: out, cmd: cmd, stderr: &stderr}), nil
+}
+
+type streamReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *strings.Builder
+	closed bool
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF && !r.closed {
+		r.closed = true
+		if werr := r.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("Stream ended: %s %s", werr, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (r *streamReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.cmd.Process.Kill()
+	r.cmd.Wait()
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ArmProperties and DisarmProperties are the properties Structure.Arm and
+// Structure.Disarm set: arming turns the cameras and their motion alerts on,
+// disarming turns the cameras off. They can be changed to suit.
+var (
+	ArmProperties    = map[string]string{PropStreamingEnabled: "true", "notify.motion.enabled": "true"}
+	DisarmProperties = map[string]string{PropStreamingEnabled: "false"}
+)
+
+// The Structure type is the cameras of one Nest structure, i.e. one home or
+// location, so they can be operated on together. Cameras not linked to a
+// structure form the structure with an empty ID. Name is the location its
+// cameras report, such as "Beach House", or the ID when they report none.
+type Structure struct {
+	ID      string
+	Name    string
+	Cameras *Cameras
+	Cams    []*Owned
+}
+
+// The StructureID method returns the Nest structure the camera belongs to,
+// or "" if it is not linked to one
+func (o *Owned) StructureID() string {
+	return propertyString(o.NestStructureId)
+}
+
+// The LocationName method returns the camera's location when it is a name,
+// or "" when it is missing or coordinates
+func (o *Owned) LocationName() string {
+	if s, ok := o.Location.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	return ""
+}
+
+// The StructureStatus type summarises the state of a structure's cameras.
+// Offline lists the titles of the cameras that are not online.
+type StructureStatus struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Total     int      `json:"total"`
+	Online    int      `json:"online"`
+	Streaming int      `json:"streaming"`
+	Offline   []string `json:"offline,omitempty"`
+}
+
+// The Structures method groups the owned and shared cameras by structure,
+// ordered by ID
+func (c *Cameras) Structures() []*Structure {
+
+	byID := c.ByStructure()
+	ids := make([]string, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	structures := make([]*Structure, len(ids))
+	for i, id := range ids {
+		structures[i] = byID[id]
+	}
+	return structures
+}
+
+// The ByStructure method groups the owned and shared cameras by structure ID
+func (c *Cameras) ByStructure() map[string]*Structure {
+
+	byID := make(map[string]*Structure)
+	for _, o := range c.all() {
+		id := o.StructureID()
+		s := byID[id]
+		if s == nil {
+			s = &Structure{ID: id, Cameras: c}
+			byID[id] = s
+		}
+		s.Cams = append(s.Cams, o)
+		if s.Name == "" {
+			s.Name = o.LocationName()
+		}
+	}
+	for _, s := range byID {
+		if s.Name == "" {
+			s.Name = s.ID
+		}
+	}
+	return byID
+}
+
+// The Structure method returns the structure with id, or else the one named
+// name, ignoring case
+func (c *Cameras) Structure(id string) (*Structure, error) {
+	structures := c.Structures()
+	for _, s := range structures {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	for _, s := range structures {
+		if id != "" && strings.EqualFold(s.Name, id) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("No cameras in structure %q: %w", id, ErrNotFound)
+}
+
+// The ByTitleAt method returns the camera titled title in the structure with
+// ID or name structure, so "Front Door" can be told apart in several homes.
+// Titles are matched as by ByTitle, and then by the camera's Where.
+func (c *Cameras) ByTitleAt(title, structure string) (*Owned, error) {
+	s, err := c.Structure(structure)
+	if err != nil {
+		return nil, err
+	}
+	return s.ByTitle(title)
+}
+
+// ByTitle returns the structure's camera titled title, as Cameras.ByTitle
+// does, or else its only camera whose Where is title
+func (s *Structure) ByTitle(title string) (*Owned, error) {
+	o, err := byTitle(s.Cams, title)
+	if err == nil {
+		return o, nil
+	}
+	if cams := s.Where(title); len(cams) == 1 {
+		return cams[0], nil
+	}
+	return nil, err
+}
+
+// Where returns the structure's cameras whose Where is where, ignoring case
+func (s *Structure) Where(where string) []*Owned {
+	var cams []*Owned
+	for _, o := range s.Cams {
+		if strings.EqualFold(o.Where, where) {
+			cams = append(cams, o)
+		}
+	}
+	return cams
+}
+
+// Filter returns a CameraFilter selecting the structure's cameras
+func (s *Structure) Filter() CameraFilter {
+	uuids := make([]string, len(s.Cams))
+	for i, o := range s.Cams {
+		uuids[i] = o.Uuid
+	}
+	return CameraUUIDs(uuids...)
+}
+
+// Arm sets ArmProperties on the structure's owned cameras
+func (s *Structure) Arm(ctx context.Context) []BulkResult {
+	return s.Cameras.ApplyToAll(ctx, s.Filter(), ArmProperties)
+}
+
+// Disarm sets DisarmProperties on the structure's owned cameras
+func (s *Structure) Disarm(ctx context.Context) []BulkResult {
+	return s.Cameras.ApplyToAll(ctx, s.Filter(), DisarmProperties)
+}
+
+// ApplyProfile applies the named profile of p to the structure's owned cameras
+func (s *Structure) ApplyProfile(ctx context.Context, p *Profiles, name string) ([]BulkResult, error) {
+	return p.Apply(ctx, s.Cameras, s.Filter(), name)
+}
+
+// SnapshotAll saves a snapshot of each of the structure's cameras into dir,
+// as Cameras.SaveAllImages does
+func (s *Structure) SnapshotAll(ctx context.Context, dir string, width int) []SaveResult {
+	return s.Cameras.SaveAllImages(ctx, dir, width, SaveAllOptions{Filter: s.Filter(), Shared: true})
+}
+
+// Status summarises the structure's cameras as of the camera list they came from
+func (s *Structure) Status() StructureStatus {
+	st := StructureStatus{ID: s.ID, Name: s.Name, Total: len(s.Cams)}
+	for _, o := range s.Cams {
+		if o.IsOnline {
+			st.Online++
+		} else {
+			st.Offline = append(st.Offline, o.Title)
+		}
+		if o.IsStreaming {
+			st.Streaming++
+		}
+	}
+	return st
+}
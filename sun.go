@@ -0,0 +1,62 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"math"
+	"time"
+)
+
+// julian2000 is the Julian date of 2000-01-01 12:00 UTC
+const julian2000 = 2451545.0
+
+// julianUnix is the Julian date of the Unix epoch
+const julianUnix = 2440587.5
+
+// SunTimes returns when the sun rises and sets at p on day, taken in its
+// location, with the sunrise equation. Near the poles the sun may not rise
+// or set that day; ok is then false and up reports whether it stays up.
+func SunTimes(p Position, day time.Time) (rise, set time.Time, up, ok bool) {
+
+	rad := math.Pi / 180
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + julianUnix - julian2000 + 0.0008)
+
+	// mean solar time, solar mean anomaly and equation of the center
+	j := n - p.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*j, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	ecliptic := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := julian2000 + j + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*ecliptic*rad)
+
+	declination := math.Asin(math.Sin(ecliptic*rad) * math.Sin(23.4397*rad))
+	lat := p.Latitude * rad
+	cosHour := (math.Sin(-0.833*rad) - math.Sin(lat)*math.Sin(declination)) / (math.Cos(lat) * math.Cos(declination))
+	if cosHour > 1 {
+		return time.Time{}, time.Time{}, false, false
+	}
+	if cosHour < -1 {
+		return time.Time{}, time.Time{}, true, false
+	}
+	hour := math.Acos(cosHour) / rad
+
+	julianTime := func(jd float64) time.Time {
+		return time.Unix(0, int64((jd-julianUnix)*86400*1e9)).In(day.Location())
+	}
+	return julianTime(transit - hour/360), julianTime(transit + hour/360), false, true
+}
+
+// Daylight reports whether the sun is up at p at t, whatever t's location
+func Daylight(p Position, t time.Time) bool {
+	// the day is that of p's solar time, so evenings are not taken for the
+	// next day in a zone ahead of p
+	solar := t.UTC().Add(time.Duration(p.Longitude / 15 * float64(time.Hour)))
+	rise, set, up, ok := SunTimes(p, solar)
+	if !ok {
+		return up
+	}
+	return !t.Before(rise) && t.Before(set)
+}
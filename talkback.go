@@ -0,0 +1,140 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Audio formats Talkback.Send reads
+const (
+	AudioAuto = ""     // any file ffmpeg recognizes, such as WAV, MP3 or Ogg
+	AudioPCM  = "pcm"  // raw signed 16-bit little-endian mono at SampleRate
+	AudioOpus = "opus" // Opus in an Ogg container
+)
+
+// DefaultTalkbackRate is the sample rate of talk-back audio when the servers
+// do not give one
+const DefaultTalkbackRate = 16000
+
+// The Talkback type is a negotiated channel to a camera's speaker, for
+// cameras with two-way audio such as the Dropcam Pro. URL is the RTMP address
+// audio is published to.
+type Talkback struct {
+	Camera     string
+	Host       string
+	Token      string
+	URL        string
+	SampleRate int
+
+	// FFmpeg is the ffmpeg binary Send runs; "ffmpeg" on the PATH when empty
+	FFmpeg string
+	// Codec is the ffmpeg encoder the camera is sent; "libspeex", as the
+	// Dropcam web player used, when empty
+	Codec string
+}
+
+// talkbackReply is the channel start_talkback opens
+type talkbackReply struct {
+	Host       string `json:"talkback_host"`
+	Token      string `json:"talkback_token"`
+	SampleRate int    `json:"sample_rate"`
+}
+
+// The Talkback method opens the talk-back channel of camera o through its
+// nexus host. Cameras reporting no speaker fail with ErrUnsupportedCapability
+// before any request is made.
+func (c *Cameras) Talkback(ctx context.Context, o *Owned) (*Talkback, error) {
+
+	if err := requireCapability("Talkback", o, "talk-back audio", Capabilities.HasSpeaker); err != nil {
+		return nil, err
+	}
+	// the session token is left out of what is logged
+	path := c.Dropcam.nexusPath(o, c.Dropcam.TalkbackPath)
+	if err := c.Dropcam.refuseWrite("Talkback", "POST", path, o.Uuid, map[string]interface{}{"uuid": o.Uuid}); err != nil {
+		return nil, err
+	}
+	token, err := c.Dropcam.SessionToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{"uuid": o.Uuid, "session_token": token}
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", path, o.Uuid, data)
+	if err != nil {
+		return nil, fmt.Errorf("Talkback Request Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]talkbackReply]("Talkback", response)
+	if err != nil {
+		var e *APIError
+		if errors.As(err, &e) && e.Err == nil && !c.Dropcam.isOnline(o) {
+			e.Err = ErrCameraOffline
+		}
+		return nil, err
+	}
+	if len(items) == 0 || items[0].Host == "" {
+		return nil, errors.New("Talkback returned no channel")
+	}
+
+	reply := items[0]
+	if reply.SampleRate <= 0 {
+		reply.SampleRate = DefaultTalkbackRate
+	}
+	u := url.URL{
+		Scheme:   "rtmp",
+		Host:     reply.Host,
+		Path:     "/talkback/" + o.Uuid,
+		RawQuery: url.Values{"token": {reply.Token}}.Encode(),
+	}
+	return &Talkback{Camera: o.Uuid, Host: reply.Host, Token: reply.Token, URL: u.String(), SampleRate: reply.SampleRate}, nil
+}
+
+// The Send method plays the audio read from r, in format, on the camera's
+// speaker, and returns once r is exhausted and the audio sent. The audio is
+// converted to mono at SampleRate and published by ffmpeg, which must be
+// installed. Cancelling ctx stops it.
+func (t *Talkback) Send(ctx context.Context, r io.Reader, format string) error {
+
+	bin := t.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	codec := t.Codec
+	if codec == "" {
+		codec = "libspeex"
+	}
+	rate := strconv.Itoa(t.SampleRate)
+
+	args := []string{"-loglevel", "error", "-re"}
+	switch format {
+	case AudioAuto:
+	case AudioPCM:
+		args = append(args, "-f", "s16le", "-ar", rate, "-ac", "1")
+	case AudioOpus:
+		args = append(args, "-f", "ogg", "-c:a", "libopus")
+	default:
+		return fmt.Errorf("Talkback: unknown audio format %q", format)
+	}
+	args = append(args, "-i", "pipe:0", "-vn", "-ac", "1", "-ar", rate, "-c:a", codec, "-f", "flv", t.URL)
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdin = r
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("Talkback to %s failed: %s %s", t.Camera, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
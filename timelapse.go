@@ -0,0 +1,217 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultTimelapseTemplate names timelapse frames when Timelapse.Template is empty
+const DefaultTimelapseTemplate = `{{.Camera}}-{{printf "%06d" .Seq}}.jpg`
+
+// The TimelapseFrame type is the data a Timelapse filename template is
+// executed with
+type TimelapseFrame struct {
+	Camera string
+	Title  string
+	Seq    int
+	Time   time.Time
+}
+
+// The Timelapse type captures a frame from Camera every Interval into Dir
+// until stopped. Frames are named by Template, a text/template executed with
+// a TimelapseFrame, e.g. `{{.Title}}-{{.Time.Format "20060102-150405"}}.jpg`,
+// or when Path is set by that PathTemplate, e.g. "{camera}/{yyyymmdd}/{seq}.jpg".
+//
+// When the Dropcam has Dedup set, frames identical to the previous one are
+// skipped. A failed capture is retried after a delay that doubles with each
+// further failure, up to MaxBackoff, and returns to Interval after the next
+// success.
+//
+// When Video is set, Stop stitches the captured frames into that file at FPS
+// frames per second, encoded as VideoOptions describes, which can stamp each
+// frame with its capture time. ffmpeg does the stitching and must be
+// installed.
+//
+// With Dropcam.Uploads set, frames are uploaded as UploadTimelapse captures.
+type Timelapse struct {
+	Cameras    *Cameras
+	Camera     *Owned
+	Interval   time.Duration
+	Width      int
+	Dir        string
+	Template   string
+	Path       PathTemplate
+	MaxBackoff time.Duration
+
+	Video        string
+	FPS          int
+	FFmpeg       string
+	VideoOptions RenderOptions
+
+	tmpl   *template.Template
+	mu     sync.Mutex
+	frames []VideoFrame
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start begins capturing in the background
+func (t *Timelapse) Start() error {
+
+	if t.Cameras == nil || t.Camera == nil {
+		return errors.New("Timelapse needs Cameras and a Camera")
+	}
+	if t.Interval <= 0 {
+		t.Interval = 5 * time.Second
+	}
+	if t.MaxBackoff < t.Interval {
+		t.MaxBackoff = 10 * t.Interval
+	}
+	if t.Dir == "" {
+		t.Dir = "."
+	}
+	text := t.Template
+	if text == "" {
+		text = DefaultTimelapseTemplate
+	}
+	tmpl, err := template.New("timelapse").Parse(text)
+	if err != nil {
+		return fmt.Errorf("Bad Timelapse Template: %s", err)
+	}
+	t.tmpl = tmpl
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go t.run(ctx)
+	return nil
+}
+
+// Stop ends capturing and, when Video is set, stitches the frames into it
+func (t *Timelapse) Stop() error {
+
+	t.cancel()
+	<-t.done
+	if t.Video == "" {
+		return nil
+	}
+	return t.Stitch(context.Background(), t.Video)
+}
+
+// Frames returns the paths of the frames captured so far, in order
+func (t *Timelapse) Frames() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	paths := make([]string, len(t.frames))
+	for i, f := range t.frames {
+		paths[i] = f.Path
+	}
+	return paths
+}
+
+func (t *Timelapse) run(ctx context.Context) {
+	defer close(t.done)
+
+	wait := t.Interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for seq := 0; ; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if saved, err := t.capture(ctx, seq); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if wait *= 2; wait > t.MaxBackoff {
+				wait = t.MaxBackoff
+			}
+			warnf("timelapse: %s: %s, retrying in %s\n", t.Camera.Title, err, wait)
+		} else {
+			if saved {
+				seq++
+			}
+			wait = t.Interval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// capture saves frame seq and reports whether it was new
+func (t *Timelapse) capture(ctx context.Context, seq int) (bool, error) {
+
+	now := time.Now()
+	var fn string
+	if t.Path != "" {
+		fn = t.Path.File(t.Dir, t.Camera, now, PathFields{Seq: seq})
+	} else {
+		var name bytes.Buffer
+		err := t.tmpl.Execute(&name, TimelapseFrame{
+			Camera: t.Camera.Uuid,
+			Title:  t.Camera.Title,
+			Seq:    seq,
+			Time:   now,
+		})
+		if err != nil {
+			return false, err
+		}
+		fn = filepath.Join(t.Dir, filepath.Clean("/"+name.String()))
+	}
+
+	fn, err := t.Cameras.saveNewImage(ctx, t.Camera, fn, t.Width, now, UploadTimelapse)
+	if err != nil || fn == "" {
+		return false, err
+	}
+	t.mu.Lock()
+	t.frames = append(t.frames, VideoFrame{Path: fn, Time: now})
+	t.mu.Unlock()
+	return true, nil
+}
+
+// Stitch encodes the frames captured so far into the video out, as
+// VideoOptions describes with FPS and FFmpeg as defaults
+func (t *Timelapse) Stitch(ctx context.Context, out string) error {
+	opts := t.VideoOptions
+	if opts.FPS <= 0 {
+		opts.FPS = t.FPS
+	}
+	if opts.FFmpeg == "" {
+		opts.FFmpeg = t.FFmpeg
+	}
+	return t.Render(ctx, out, opts)
+}
+
+// Render encodes the frames captured so far into the video out as opts
+// describes, e.g. as WebM with each frame stamped with its capture time. The
+// stamp is labelled with the camera title unless opts.Label is set.
+func (t *Timelapse) Render(ctx context.Context, out string, opts RenderOptions) error {
+
+	t.mu.Lock()
+	frames := append([]VideoFrame(nil), t.frames...)
+	t.mu.Unlock()
+	if len(frames) == 0 {
+		return errors.New("Timelapse has no frames to stitch")
+	}
+	if opts.Label == "" && opts.Timestamps {
+		opts.Label = t.Camera.Title
+	}
+	return RenderVideo(ctx, frames, out, opts)
+}
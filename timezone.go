@@ -0,0 +1,68 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"sync"
+	"time"
+)
+
+// locations caches the zones loaded by name, as LoadLocation reads the zone
+// database every time
+var locations struct {
+	sync.Mutex
+	byName map[string]*time.Location
+}
+
+// loadLocation returns the zone called name, or nil if it is not known
+func loadLocation(name string) *time.Location {
+
+	locations.Lock()
+	defer locations.Unlock()
+	if loc, ok := locations.byName[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		Dbg("unknown timezone %q: %s\n", name, err)
+		loc = nil
+	}
+	if locations.byName == nil {
+		locations.byName = make(map[string]*time.Location)
+	}
+	locations.byName[name] = loc
+	return loc
+}
+
+// The Zone method returns the zone camera o is in: its Timezone when that
+// names a known zone, otherwise a fixed zone at its TimezoneUtcOffset, which
+// does not follow daylight saving, or UTC without either. (Location is the
+// camera's place, as set in the app.)
+func (o *Owned) Zone() *time.Location {
+
+	if o.Timezone != "" {
+		if loc := loadLocation(o.Timezone); loc != nil {
+			return loc
+		}
+	}
+	if o.TimezoneUtcOffset != 0 {
+		return time.FixedZone("", int(o.TimezoneUtcOffset))
+	}
+	return time.UTC
+}
+
+// The In method returns t in camera o's zone
+func (o *Owned) In(t time.Time) time.Time {
+	return t.In(o.Zone())
+}
+
+// The Day method returns the start and end of the day containing t in camera
+// o's zone, so a query for "today's events" covers the camera's day rather
+// than the caller's. Days with a daylight saving change are 23 or 25 hours.
+func (o *Owned) Day(t time.Time) (start, end time.Time) {
+	t = o.In(t)
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
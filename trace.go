@@ -0,0 +1,93 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+)
+
+// The RequestTrace type describes one API call to the hooks of a Tracer.
+// Endpoint is the last element of its path, such as "cameras.get_image", and
+// Camera the uuid it was made for, empty for account calls such as login.
+// Attempts, the requests sent, Status, Err and Latency are set once the call
+// has ended; Latency covers retries, re-logins and rate limit waits.
+type RequestTrace struct {
+	Method   string
+	URL      string
+	Endpoint string
+	Camera   string
+	Start    time.Time
+
+	Attempts int
+	Status   int
+	Err      error
+	Latency  time.Duration
+}
+
+// The Tracer type hooks every API call of a Dropcam, so operators can trace
+// slow snapshot fetches to the cameras or endpoints responsible, or export
+// them as OpenTelemetry spans without this package depending on it:
+//
+//	d.Tracer = &dropcam.Tracer{
+//		OnRequest: func(ctx context.Context, t *dropcam.RequestTrace) context.Context {
+//			ctx, _ = tracer.Start(ctx, t.Endpoint, trace.WithAttributes(
+//				attribute.String("camera.uuid", t.Camera)))
+//			return ctx
+//		},
+//		OnResponse: func(ctx context.Context, t *dropcam.RequestTrace) {
+//			span := trace.SpanFromContext(ctx)
+//			span.SetAttributes(attribute.Int("http.status_code", t.Status))
+//			if t.Err != nil {
+//				span.RecordError(t.Err)
+//			}
+//			span.End()
+//		},
+//	}
+//
+// OnRequest is called before the call is sent. The context it returns, if
+// not nil, is passed to OnResponse and carries the requests of the call, so
+// an instrumented HTTPClient transport sees the span as their parent.
+// OnResponse is called once the call has ended, whether it succeeded or not.
+// Either may be nil. Both are called from the goroutine making the call and
+// must not block for long.
+type Tracer struct {
+	OnRequest  func(ctx context.Context, t *RequestTrace) context.Context
+	OnResponse func(ctx context.Context, t *RequestTrace)
+}
+
+// begin starts the trace of a call on behalf of camera whose first request
+// is req, returning the context its requests are sent with
+func (tr *Tracer) begin(ctx context.Context, req *http.Request, camera string) (context.Context, *RequestTrace) {
+
+	t := &RequestTrace{
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+		Endpoint: path.Base(req.URL.Path),
+		Camera:   camera,
+		Start:    time.Now(),
+	}
+	if tr.OnRequest != nil {
+		if c := tr.OnRequest(ctx, t); c != nil {
+			ctx = c
+		}
+	}
+	return ctx, t
+}
+
+// end completes t with the outcome of the call
+func (tr *Tracer) end(ctx context.Context, t *RequestTrace, resp *http.Response, err error) {
+
+	t.Latency = time.Since(t.Start)
+	t.Err = err
+	if err == nil && resp != nil {
+		t.Status = resp.StatusCode
+	}
+	if tr.OnResponse != nil {
+		tr.OnResponse(ctx, t)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the built-in transcode presets
+const (
+	PresetWeb     = "web"
+	PresetMobile  = "mobile"
+	PresetArchive = "archive"
+)
+
+// The TranscodePreset type fixes how a video is re-encoded for a kind of
+// device. Width scales the video down to at most that width, keeping the
+// aspect ratio; zero keeps the size. A VideoBitrate such as "800k" sets a
+// target bitrate, otherwise CRF sets constant quality. Speed is the encoder
+// preset, e.g. "veryfast". FrameRate, when set, caps the frame rate.
+type TranscodePreset struct {
+	Name         string
+	VideoCodec   string
+	Width        int
+	VideoBitrate string
+	CRF          int
+	Speed        string
+	FrameRate    int
+	AudioCodec   string
+	AudioBitrate string
+}
+
+// TranscodePresets are the presets known by name: web for browsers, mobile
+// for small screens and slow links, archive for compact long-term storage.
+// Entries can be replaced or added.
+var TranscodePresets = map[string]TranscodePreset{
+	PresetWeb: {
+		Name: PresetWeb, VideoCodec: "libx264", Width: 1280, CRF: 23, Speed: "veryfast",
+		AudioCodec: "aac", AudioBitrate: "128k",
+	},
+	PresetMobile: {
+		Name: PresetMobile, VideoCodec: "libx264", Width: 640, VideoBitrate: "800k", Speed: "veryfast",
+		FrameRate: 15, AudioCodec: "aac", AudioBitrate: "64k",
+	},
+	PresetArchive: {
+		Name: PresetArchive, VideoCodec: "libx265", CRF: 28, Speed: "slow",
+		AudioCodec: "aac", AudioBitrate: "96k",
+	},
+}
+
+// LookupPreset returns the preset called name
+func LookupPreset(name string) (TranscodePreset, error) {
+	if p, ok := TranscodePresets[name]; ok {
+		return p, nil
+	}
+	names := make([]string, 0, len(TranscodePresets))
+	for n := range TranscodePresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return TranscodePreset{}, fmt.Errorf("Unknown transcode preset %q, have %s", name, strings.Join(names, ", "))
+}
+
+// FFmpegArgs returns the ffmpeg output options implementing the preset
+func (p TranscodePreset) FFmpegArgs() []string {
+
+	var args []string
+	if p.Width > 0 {
+		// never scale up; -2 keeps the height even as most codecs require
+		args = append(args, "-vf", "scale='min("+strconv.Itoa(p.Width)+",iw)':-2")
+	}
+	codec := p.VideoCodec
+	if codec == "" {
+		codec = "libx264"
+	}
+	args = append(args, "-c:v", codec)
+	if p.Speed != "" {
+		args = append(args, "-preset", p.Speed)
+	}
+	if p.VideoBitrate != "" {
+		args = append(args, "-b:v", p.VideoBitrate)
+	} else if p.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(p.CRF))
+	}
+	if p.FrameRate > 0 {
+		args = append(args, "-r", strconv.Itoa(p.FrameRate))
+	}
+	args = append(args, "-pix_fmt", "yuv420p")
+
+	if p.AudioCodec == "" {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", p.AudioCodec)
+		if p.AudioBitrate != "" {
+			args = append(args, "-b:a", p.AudioBitrate)
+		}
+	}
+	return append(args, "-movflags", "+faststart")
+}
+
+// The Transcoder interface re-encodes the video file in into out following a preset
+type Transcoder interface {
+	Transcode(ctx context.Context, in, out string, p TranscodePreset) error
+}
+
+// The FFmpegTranscoder type is a Transcoder running ffmpeg, the binary
+// FFmpeg or "ffmpeg" from the PATH
+type FFmpegTranscoder struct {
+	FFmpeg string
+}
+
+// Transcode implements Transcoder
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, in, out string, p TranscodePreset) error {
+	bin := t.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+	args := append([]string{"-i", in}, p.FFmpegArgs()...)
+	return runFFmpeg(ctx, bin, append(args, "-y", out)...)
+}
+
+// The SaveClipPreset method downloads the clip for event from camera o and
+// writes it to path re-encoded with preset p by t, an FFmpegTranscoder when nil
+func (c *Cameras) SaveClipPreset(ctx context.Context, o *Owned, event Event, path string, p TranscodePreset, t Transcoder) error {
+
+	if t == nil {
+		t = &FFmpegTranscoder{}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".clip-raw-*.mp4")
+	if err != nil {
+		return err
+	}
+	raw := f.Name()
+	f.Close()
+	defer os.Remove(raw)
+
+	if err := c.SaveClip(ctx, o, event, raw); err != nil {
+		return err
+	}
+	return transcodeAtomic(ctx, t, raw, path, p)
+}
+
+// transcodeAtomic transcodes in to out through a temporary file so out only
+// appears once complete
+func transcodeAtomic(ctx context.Context, t Transcoder, in, out string, p TranscodePreset) error {
+
+	tmp := filepath.Join(filepath.Dir(out), ".transcode-"+filepath.Base(out))
+	if err := t.Transcode(ctx, in, tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
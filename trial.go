@@ -0,0 +1,87 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoCVR is returned for recorded frames and clips of a camera without
+// cloud recording, e.g. once its trial has lapsed
+var ErrNoCVR = errors.New("Camera has no cloud recording")
+
+// DefaultTrialWarnDays is how many days before a trial ends a Monitor warns
+const DefaultTrialWarnDays = 3
+
+// HasCVR reports whether the camera records to the cloud, so its past frames,
+// events and clips can be fetched. A camera in trial mode loses it when the
+// trial runs out. Cameras that do not report their recording window are
+// assumed to have it and left to the servers to refuse.
+func (o *Owned) HasCVR() bool {
+	return !(o.IsTrialMode && o.TrialDaysLeft <= 0)
+}
+
+// cvrState remembers which cameras had cloud recording in the latest camera
+// list, so jobs holding an older copy of a camera notice a lapsed trial
+type cvrState struct {
+	mu  sync.Mutex
+	has map[string]bool
+}
+
+func (s *cvrState) note(c *Cameras) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.has == nil {
+		s.has = make(map[string]bool)
+	}
+	for _, o := range c.all() {
+		s.has[o.Uuid] = o.HasCVR()
+	}
+}
+
+// hasCVR reports whether camera o has cloud recording, as of the latest
+// camera list when o is in it
+func (d *Dropcam) hasCVR(o *Owned) bool {
+	d.cvr.mu.Lock()
+	has, ok := d.cvr.has[o.Uuid]
+	d.cvr.mu.Unlock()
+	if ok {
+		return has
+	}
+	return o.HasCVR()
+}
+
+// requireCVR returns ErrNoCVR, naming op, unless camera o has cloud recording
+func (d *Dropcam) requireCVR(op string, o *Owned) error {
+	if d.hasCVR(o) {
+		return nil
+	}
+	return fmt.Errorf("%s Failed: %s: %w", op, o.Title, ErrNoCVR)
+}
+
+// trialNotice returns the trial notification due for sc, if any: a warning
+// each day of the last warnDays of a trial, and a notice when cloud
+// recording stops
+func trialNotice(sc StateChange, warnDays int) *Notification {
+
+	old, st := sc.Old, sc.New
+	known := old.Camera != ""
+	n := &Notification{Kind: NotifyTrial, Camera: st.Camera, Title: st.Title, Time: st.Updated}
+	switch {
+	case known && old.CVR && !st.CVR:
+		n.Message = "Cloud recording has stopped; recorded frames and clips are no longer available and digests fall back to live snapshots"
+	case st.IsTrialMode && st.CVR && st.TrialDaysLeft <= int64(warnDays) && (!known || st.TrialDaysLeft < old.TrialDaysLeft):
+		n.Message = fmt.Sprintf("Trial ends in %d days; recorded frames and clips will stop working", st.TrialDaysLeft)
+		if st.TrialDaysLeft == 1 {
+			n.Message = "Trial ends tomorrow; recorded frames and clips will stop working"
+		}
+	default:
+		return nil
+	}
+	return n
+}
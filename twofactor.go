@@ -0,0 +1,79 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Login form fields of two-factor authentication
+const (
+	otpField           = "otp"
+	trustDeviceField   = "trust_device"
+	trustedDeviceField = "trusted_device_token"
+)
+
+// The OTPFunc type supplies the one-time code of an account with two-factor
+// authentication, from an authenticator app or the text message the servers
+// just sent, when a login asks for it. c is the challenge, whose Message
+// usually says where the code was sent.
+type OTPFunc func(ctx context.Context, c *Challenge) (code string, err error)
+
+// twoFactor has a.OTP supply the code two-factor challenge c, the round'th
+// of this login, asks for and returns the values to log in again with
+func (a *CookieAuth) twoFactor(ctx context.Context, c *Challenge, round int) (url.Values, error) {
+
+	if round >= maxChallenges {
+		return nil, &ChallengeError{Challenge: c, Err: fmt.Errorf("still challenged after %d answers", round)}
+	}
+	Dbg("login needs a two-factor code\n")
+	code, err := a.OTP(ctx, c)
+	if err != nil {
+		return nil, &ChallengeError{Challenge: c, Err: err}
+	}
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if code == "" {
+		return nil, &ChallengeError{Challenge: c, Err: errors.New("no code given")}
+	}
+
+	v := url.Values{}
+	for k, vs := range c.Fields {
+		v[k] = vs
+	}
+	v.Set(otpField, code)
+	if a.TrustDevice {
+		v.Set(trustDeviceField, "true")
+	}
+	return v, nil
+}
+
+// trustedDevice returns the trusted-device token a login reply carries, in a
+// cookie or the reply's items, or ""
+func trustedDevice(resp *http.Response) string {
+
+	for _, c := range resp.Cookies() {
+		if c.Name == trustedDeviceField {
+			return c.Value
+		}
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	var reply struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if json.Unmarshal(body, &reply) != nil || len(reply.Items) == 0 {
+		return ""
+	}
+	token, _ := reply.Items[0][trustedDeviceField].(string)
+	return token
+}
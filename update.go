@@ -0,0 +1,142 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// The CameraUpdate type is a change to a camera's settings. Only the fields
+// that are set are changed; the others keep their current value.
+type CameraUpdate struct {
+	Title       *string
+	Description *string
+	Where       *string
+	Location    *string
+	Timezone    *string
+	IsPublic    *bool
+}
+
+// String returns a pointer to s, for the fields of a CameraUpdate
+func String(s string) *string {
+	return &s
+}
+
+// Bool returns a pointer to b, for the fields of a CameraUpdate
+func Bool(b bool) *bool {
+	return &b
+}
+
+// fields returns the update as the cameras.update parameters
+func (u CameraUpdate) fields() map[string]interface{} {
+	f := make(map[string]interface{})
+	if u.Title != nil {
+		f["title"] = *u.Title
+	}
+	if u.Description != nil {
+		f["description"] = *u.Description
+	}
+	if u.Where != nil {
+		f["where"] = *u.Where
+	}
+	if u.Location != nil {
+		f["location"] = *u.Location
+	}
+	if u.Timezone != nil {
+		f["timezone"] = *u.Timezone
+	}
+	if u.IsPublic != nil {
+		f["is_public"] = *u.IsPublic
+	}
+	return f
+}
+
+// mismatch names the first field of u that o does not show, or returns ""
+func (u CameraUpdate) mismatch(o *Owned) string {
+	switch {
+	case u.Title != nil && o.Title != *u.Title:
+		return "title"
+	case u.Description != nil && o.Description != *u.Description:
+		return "description"
+	case u.Where != nil && o.Where != *u.Where:
+		return "where"
+	case u.Location != nil && propertyString(o.Location) != *u.Location:
+		return "location"
+	case u.Timezone != nil && o.Timezone != *u.Timezone:
+		return "timezone"
+	case u.IsPublic != nil && o.IsPublic != *u.IsPublic:
+		return "is_public"
+	}
+	return ""
+}
+
+// The GetCamera method fetches the current state of camera uuid from
+// cameras.get, without the rest of the camera list
+func (c *Cameras) GetCamera(ctx context.Context, uuid string) (*Owned, error) {
+
+	v := url.Values{}
+	v.Set("uuid", uuid)
+	response, err := c.Dropcam.getRequest(ctx, c.Dropcam.CamerasGet, v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Camera Request Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]Owned]("Get Camera", response)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, &CameraNotFoundError{By: "uuid", Value: uuid}
+	}
+	return &items[0], nil
+}
+
+// The UpdateCamera method changes the settings of camera o set in u and
+// returns the camera as the server now has it. o is updated to match. It
+// fails if the reply does not show every change, so a change the server
+// ignored is not taken for done. A Timezone must be an IANA name such as
+// "America/New_York".
+func (c *Cameras) UpdateCamera(ctx context.Context, o *Owned, u CameraUpdate) (*Owned, error) {
+
+	if u.Title != nil && *u.Title == "" {
+		return nil, errors.New("Update Camera: title can't be empty")
+	}
+	if u.Timezone != nil {
+		if _, err := time.LoadLocation(*u.Timezone); err != nil || *u.Timezone == "" {
+			return nil, fmt.Errorf("Update Camera: unknown timezone %q", *u.Timezone)
+		}
+	}
+	fields := u.fields()
+	if len(fields) == 0 {
+		return o, nil
+	}
+	fields["uuid"] = o.Uuid
+	if err := c.Dropcam.refuseWrite("Update Camera", "POST", c.Dropcam.CamerasUpdate, o.Uuid, fields); err != nil {
+		return nil, err
+	}
+
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", c.Dropcam.CamerasUpdate, o.Uuid, fields)
+	if err != nil {
+		return nil, fmt.Errorf("Update Camera Request Failed: %w", err)
+	}
+	items, err := decodeEnvelope[[]Owned]("Update Camera", response)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New("Update Camera: reply has no camera")
+	}
+
+	c.Dropcam.cameras.invalidate()
+	updated := items[0]
+	if f := u.mismatch(&updated); f != "" {
+		return nil, fmt.Errorf("Update Camera: %s was not changed", f)
+	}
+	*o = updated
+	return &updated, nil
+}
@@ -0,0 +1,472 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Kinds of captures uploaded
+const (
+	UploadSnapshot  = "snapshot"
+	UploadClip      = "clip"
+	UploadTimelapse = "timelapse"
+)
+
+// DefaultUploadTemplate names uploaded objects when Uploads.Template is empty
+const DefaultUploadTemplate = `{{.Prefix}}{{.Camera}}/{{.Time.Format "2006/01/02"}}/{{.Name}}`
+
+// The Uploader interface stores an object in a bucket. Size is the length
+// of r, or -1 when unknown.
+type Uploader interface {
+	Upload(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+}
+
+// The UploadObject type is the data an Uploads key template is executed with.
+// Name is the base name the capture would have on disk and Time is in UTC.
+type UploadObject struct {
+	Prefix string
+	Kind   string
+	Camera string
+	Title  string
+	Name   string
+	Time   time.Time
+}
+
+// The Uploads type sends captures to object storage. Set as Dropcam.Uploads,
+// SaveImage, SaveClip and Timelapse frames are uploaded by Uploader under a
+// key made by Template, a text/template executed with an UploadObject.
+//
+// Prefixes gives the prefix of each kind of capture, UploadSnapshot,
+// UploadClip or UploadTimelapse, so bucket lifecycle rules can expire them
+// differently, e.g. "30d/" for snapshots and "1y/" for clips. ContentTypes
+// maps file extensions to the content type objects are stored with, which
+// otherwise follows the extension.
+//
+// Unless KeepLocal is set nothing is written to the local path, which only
+// names the object; a Timelapse with Video then has no frames to stitch.
+type Uploads struct {
+	Uploader     Uploader
+	Template     string
+	Prefixes     map[string]string
+	ContentTypes map[string]string
+	KeepLocal    bool
+
+	once sync.Once
+	tmpl *template.Template
+	err  error
+}
+
+// Key returns the key a capture of kind named name, taken by camera o at t,
+// is uploaded under
+func (u *Uploads) Key(kind string, o *Owned, name string, t time.Time) (string, error) {
+
+	u.once.Do(func() {
+		text := u.Template
+		if text == "" {
+			text = DefaultUploadTemplate
+		}
+		u.tmpl, u.err = template.New("upload").Parse(text)
+		if u.err != nil {
+			u.err = fmt.Errorf("Bad Uploads Template: %s", u.err)
+		}
+	})
+	if u.err != nil {
+		return "", u.err
+	}
+
+	var key bytes.Buffer
+	err := u.tmpl.Execute(&key, UploadObject{
+		Prefix: u.Prefixes[kind],
+		Kind:   kind,
+		Camera: o.Uuid,
+		Title:  o.Title,
+		Name:   name,
+		Time:   t.UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return cleanKey(key.String())
+}
+
+// ContentType returns the content type objects named name are stored with
+func (u *Uploads) ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ct, ok := u.ContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	switch ext {
+	case ".mp4":
+		return "video/mp4"
+	case ".ts":
+		return "video/mp2t"
+	}
+	return "application/octet-stream"
+}
+
+// put uploads r as the capture of kind named name and returns its key
+func (u *Uploads) put(ctx context.Context, kind string, o *Owned, name string, t time.Time, r io.Reader, size int64) (string, error) {
+
+	key, err := u.Key(kind, o, name, t)
+	if err != nil {
+		return "", err
+	}
+	if err := u.Uploader.Upload(ctx, key, r, size, u.ContentType(name)); err != nil {
+		return "", fmt.Errorf("Upload of %s failed: %w", key, err)
+	}
+	Dbg("uploaded %s\n", key)
+	return key, nil
+}
+
+// putFile uploads the file fn as the capture of kind named name
+func (u *Uploads) putFile(ctx context.Context, kind string, o *Owned, name string, t time.Time, fn string) (string, error) {
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return u.put(ctx, kind, o, name, t, f, fi.Size())
+}
+
+// The S3Uploader type uploads to Amazon S3 or a compatible store such as
+// MinIO, signing requests with AWS Signature Version 4. Endpoint is the
+// store's base URL, by default that of S3 in Region, itself "us-east-1" by
+// default. PathStyle addresses the bucket in the path rather than the host
+// name, as MinIO usually needs.
+//
+// Objects larger than PartSize, DefaultS3PartSize when zero, and those of
+// unknown size, are uploaded in parts of PartSize, each checked against its
+// MD5 and sent again after a failure. When a part keeps failing the upload
+// is left unfinished, and the next Upload of the same key resumes it,
+// sending only the parts missing. A bucket lifecycle rule aborting
+// incomplete multipart uploads cleans up those never resumed.
+type S3Uploader struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	PartSize     int64
+	Client       *http.Client
+}
+
+// Upload implements Uploader
+func (s *S3Uploader) Upload(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = DefaultS3PartSize
+	}
+	if size < 0 || size > partSize {
+		return s.multipartUpload(ctx, key, r, contentType, partSize)
+	}
+
+	u, region, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, region, time.Now().UTC())
+
+	return doUpload(s.Client, req)
+}
+
+// MaxPresignTTL is the longest S3 allows a presigned URL to last
+const MaxPresignTTL = 7 * 24 * time.Hour
+
+// Presign implements Presigner with a Signature Version 4 query string. The
+// URL lasts for ttl, at most MaxPresignTTL, unless the credentials it is
+// signed with expire first, as session tokens do.
+func (s *S3Uploader) Presign(ctx context.Context, key string, ttl time.Duration) (string, error) {
+
+	if ttl <= 0 || ttl > MaxPresignTTL {
+		return "", fmt.Errorf("S3 presigned URLs last from 1s to %s, not %s", MaxPresignTTL, ttl)
+	}
+	return s.presign(key, ttl, time.Now().UTC())
+}
+
+// presign returns the URL of the object key signed at now to last for ttl
+func (s *S3Uploader) presign(key string, ttl time.Duration, now time.Time) (string, error) {
+
+	u, region, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := date + "/" + region + "/s3/aws4_request"
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(ttl/time.Second), 10))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	// Signature Version 4 escapes spaces as %20
+	query := strings.ReplaceAll(q.Encode(), "+", "%20")
+
+	canonical := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	u.RawQuery = query + "&X-Amz-Signature=" + s.signature(date, region, toSign)
+	return u.String(), nil
+}
+
+// objectURL returns the URL of the object key and the region it is in
+func (s *S3Uploader) objectURL(key string) (*url.URL, string, error) {
+
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = s3Escape(u.Path)
+	return u, region, nil
+}
+
+// sign adds an AWS Signature Version 4 to req. The payload is left unsigned
+// so it can be streamed, which HTTPS endpoints accept.
+func (s *S3Uploader) sign(req *http.Request, region string, now time.Time) {
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			names = append(names, lk)
+			values[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, n := range names {
+		headers.WriteString(n + ":" + values[n] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		headers.String(),
+		signed,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	sig := s.signature(date, region, toSign)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// signature signs toSign with the key derived for date and region
+func (s *S3Uploader) signature(date, region, toSign string) string {
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape escapes a path as Signature Version 4 expects: every byte but
+// the unreserved characters and slashes
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// The GCSUploader type uploads to Google Cloud Storage through its JSON API.
+// Token returns an OAuth 2.0 access token with write access to Bucket; when
+// nil, tokens of the default service account are fetched from the metadata
+// server of the Compute Engine instance or GKE pod the client runs on.
+type GCSUploader struct {
+	Bucket   string
+	Token    func(ctx context.Context) (string, error)
+	Endpoint string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Upload implements Uploader
+func (g *GCSUploader) Upload(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	u := strings.TrimSuffix(endpoint, "/") + "/upload/storage/v1/b/" + url.PathEscape(g.Bucket) +
+		"/o?" + url.Values{"uploadType": {"media"}, "name": {key}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doUpload(g.Client, req)
+}
+
+// metadataTokenURL is where the metadata server hands out access tokens
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func (g *GCSUploader) accessToken(ctx context.Context) (string, error) {
+
+	if g.Token != nil {
+		return g.Token(ctx)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := g.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("No GCS token from the metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("No GCS token from the metadata server: " + resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	g.token = tok.AccessToken
+	// renew a minute early so a token does not expire mid-upload
+	g.expires = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
+
+func (g *GCSUploader) client() *http.Client {
+	if g.Client != nil {
+		return g.Client
+	}
+	return http.DefaultClient
+}
+
+func doUpload(client *http.Client, req *http.Request) error {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
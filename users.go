@@ -0,0 +1,199 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Roles of local users. Viewers may watch, operators may also change camera
+// settings, and admins may also manage users and keys.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleScopes = map[string][]string{
+	RoleViewer:   {ScopeRead},
+	RoleOperator: {ScopeControl},
+	RoleAdmin:    {ScopeAdmin},
+}
+
+const pbkdf2Iterations = 100000
+
+// The User type is a local user of the server endpoints
+type User struct {
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Salt    string   `json:"salt"`
+	Hash    string   `json:"hash"`
+	Cameras []string `json:"cameras,omitempty"`
+}
+
+// The Users type manages local users who share the single upstream Dropcam
+// account. Every access decision is written to Audit, naming the local user
+// and the upstream Account acted on. When Path is set, changes are saved there.
+type Users struct {
+	Path    string
+	Account string
+	Audit   io.Writer
+
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+// LoadUsers reads users saved at path; a missing file yields an empty set
+func LoadUsers(path string) (*Users, error) {
+	u := &Users{Path: path, users: make(map[string]*User)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errors.New("Failed to read users: " + err.Error())
+	}
+	for _, user := range list {
+		u.users[user.Name] = user
+	}
+	return u, nil
+}
+
+func (u *Users) save() error {
+	if u.Path == "" {
+		return nil
+	}
+	list := make([]*User, 0, len(u.users))
+	for _, user := range u.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(u.Path, data, 0600)
+}
+
+// passwordHash is PBKDF2-HMAC-SHA256 producing a single 32 byte block
+func passwordHash(password, salt string) (string, error) {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(salt))
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < pbkdf2Iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// Set adds a user or replaces an existing user's password and role.
+// cameras optionally restricts the user to those camera uuids.
+func (u *Users) Set(name, password, role string, cameras []string) error {
+
+	if _, ok := roleScopes[role]; !ok {
+		return errors.New("Unknown role: " + role)
+	}
+	if name == "" || password == "" {
+		return errors.New("User name and password are required")
+	}
+	salt, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	hash, err := passwordHash(password, salt)
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.users == nil {
+		u.users = make(map[string]*User)
+	}
+	u.users[name] = &User{Name: name, Role: role, Salt: salt, Hash: hash, Cameras: cameras}
+	return u.save()
+}
+
+// Remove deletes a user
+func (u *Users) Remove(name string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.users[name]; !ok {
+		return errors.New("Unknown user: " + name)
+	}
+	delete(u.users, name)
+	return u.save()
+}
+
+// Authenticate returns the user if name and password match
+func (u *Users) Authenticate(name, password string) *User {
+	u.mu.Lock()
+	user, ok := u.users[name]
+	u.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	hash, err := passwordHash(password, user.Salt)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hash), []byte(user.Hash)) != 1 {
+		return nil
+	}
+	return user
+}
+
+// Authorize implements Guard using HTTP basic authentication
+func (u *Users) Authorize(r *http.Request) (*Principal, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	user := u.Authenticate(name, password)
+	if user == nil {
+		return nil, false
+	}
+	return &Principal{Name: user.Name, Scopes: roleScopes[user.Role], Cameras: user.Cameras}, true
+}
+
+func (u *Users) audit(r *http.Request, p *Principal, allowed bool) {
+	if u.Audit == nil {
+		return
+	}
+	decision := "deny"
+	if allowed {
+		decision = "allow"
+	}
+	fmt.Fprintf(u.Audit, "%s %s user=%s account=%s %s %s\n",
+		time.Now().Format(time.RFC3339), decision, p.Name, u.Account, r.Method, r.URL.RequestURI())
+}
+
+// Middleware wraps h so that requests need a user whose role grants scope
+func (u *Users) Middleware(scope string, h http.Handler) http.Handler {
+	return Protect(u, scope, h)
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultVideoTimeFormat is how RenderVideo stamps frame times when
+// RenderOptions.TimeFormat is empty
+const DefaultVideoTimeFormat = "2006-01-02 15:04:05"
+
+// The VideoFrame type is one image of a sequence to render and when it was
+// captured
+type VideoFrame struct {
+	Path string
+	Time time.Time
+}
+
+// The RenderOptions type controls how RenderVideo assembles frames. The
+// video plays at FPS frames per second, 25 when zero. Its container and codec
+// follow the extension of the output: H.264 in MP4 for ".mp4" and anything
+// unknown, VP9 in WebM for ".webm". Quality is the encoder's constant rate
+// factor, lower being better; zero leaves the encoder's default of 23 for
+// H.264 and 32 for VP9.
+//
+// With Timestamps set each frame is stamped with its time, in Location (the
+// local zone when nil) and TimeFormat, and below it Label when set, as an
+// Overlay in the bottom-left corner. The stamping is done before encoding, so
+// ffmpeg needs no fonts. FFmpeg is the binary to run, "ffmpeg" from the PATH
+// when empty.
+type RenderOptions struct {
+	FPS        int
+	Quality    int
+	Timestamps bool
+	TimeFormat string
+	Location   *time.Location
+	Label      string
+	FFmpeg     string
+}
+
+// FramesFromFiles returns the images at paths as frames captured at their
+// modification times, oldest first
+func FramesFromFiles(paths []string) ([]VideoFrame, error) {
+
+	frames := make([]VideoFrame, 0, len(paths))
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, VideoFrame{Path: p, Time: fi.ModTime()})
+	}
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+	return frames, nil
+}
+
+// RenderVideo assembles the JPEG frames, in the order given, into the video
+// out, which only appears once complete. ffmpeg does the encoding and must be
+// installed.
+func RenderVideo(ctx context.Context, frames []VideoFrame, out string, opts RenderOptions) error {
+
+	if len(frames) == 0 {
+		return errors.New("No frames to render")
+	}
+	fps := opts.FPS
+	if fps <= 0 {
+		fps = 25
+	}
+	bin := opts.FFmpeg
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+
+	work, err := ioutil.TempDir("", "dropcam-render-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	paths := make([]string, len(frames))
+	for i, f := range frames {
+		if paths[i], err = filepath.Abs(f.Path); err != nil {
+			return err
+		}
+		if opts.Timestamps {
+			if paths[i], err = stampFrame(f, filepath.Join(work, fmt.Sprintf("%06d.jpg", i)), opts); err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+		}
+	}
+
+	// the concat demuxer ignores the duration of the last entry, so it is listed twice
+	var buf bytes.Buffer
+	for _, p := range append(paths, paths[len(paths)-1]) {
+		fmt.Fprintf(&buf, "file '%s'\nduration %f\n", strings.Replace(p, "'", `'\''`, -1), 1/float64(fps))
+	}
+	list := filepath.Join(work, "frames.txt")
+	if err := ioutil.WriteFile(list, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	args := []string{"-f", "concat", "-safe", "0", "-i", list,
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-pix_fmt", "yuv420p", "-r", fmt.Sprint(fps)}
+	format := "mp4"
+	switch strings.ToLower(filepath.Ext(out)) {
+	case ".webm":
+		format = "webm"
+		crf := opts.Quality
+		if crf <= 0 {
+			crf = 32
+		}
+		args = append(args, "-c:v", "libvpx-vp9", "-b:v", "0", "-crf", fmt.Sprint(crf))
+	default:
+		args = append(args, "-c:v", "libx264", "-movflags", "+faststart")
+		if opts.Quality > 0 {
+			args = append(args, "-crf", fmt.Sprint(opts.Quality))
+		}
+	}
+
+	tmp := filepath.Join(filepath.Dir(out), ".render-"+filepath.Base(out))
+	if err := runFFmpeg(ctx, bin, append(args, "-f", format, "-y", tmp)...); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// stampFrame writes frame f stamped with its time to path
+func stampFrame(f VideoFrame, path string, opts RenderOptions) (string, error) {
+
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	layout := opts.TimeFormat
+	if layout == "" {
+		layout = DefaultVideoTimeFormat
+	}
+	ov := &Overlay{Time: true, TimeFormat: layout, Location: opts.Location, Text: opts.Label}
+	out, err := encodeJPEG(ov.Draw(img, nil, f.Time), DefaultJPEGQuality)
+	if err != nil {
+		return "", err
+	}
+	return path, ioutil.WriteFile(path, out, 0600)
+}
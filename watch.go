@@ -0,0 +1,193 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often a Watcher polls when WatchOptions.Interval is zero
+const DefaultWatchInterval = 10 * time.Second
+
+// The WatchOptions type configures Cameras.Watch. Events that started before
+// Since, or before the call when Since is zero, are not delivered. Each poll
+// looks back Overlap past the previous one, twice the interval when zero, so
+// events the servers publish late are still caught; the overlap is what makes
+// deduplication necessary. With Backoff set, a camera that is offline or
+// keeps failing to list its events is polled less often until it answers
+// again; no events are missed, as the next poll reaches back to the last one
+// that succeeded.
+//
+// With Push set the Watcher subscribes to the event stream of the nexus
+// servers instead, as the official apps do, so events arrive as soon as they
+// are published; see Dropcam.EventStreamPath. OnStatus, if set, is called
+// with the camera's state whenever the stream reports it going online or
+// offline. Dropped streams are reconnected, and the events published while
+// disconnected are fetched before the stream resumes. Servers without the
+// stream are polled as usual.
+type WatchOptions struct {
+	Interval time.Duration
+	Since    time.Time
+	Overlap  time.Duration
+	Buffer   int
+	Backoff  *PollBackoff
+	Push     bool
+	OnStatus func(online bool)
+}
+
+// The Watcher type delivers new events of one camera on C as they appear. C is
+// closed once the Watcher stops, either through Stop or because the context
+// passed to Watch was cancelled.
+type Watcher struct {
+	C <-chan Event
+
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// The Watch method polls camera o for new events, or subscribes to them with
+// opts.Push, and delivers each one once, oldest first, on the returned
+// Watcher's channel
+func (c *Cameras) Watch(ctx context.Context, o *Owned, opts WatchOptions) *Watcher {
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultWatchInterval
+	}
+	if opts.Overlap <= 0 {
+		opts.Overlap = 2 * opts.Interval
+	}
+	if opts.Since.IsZero() {
+		opts.Since = time.Now()
+	}
+
+	ch := make(chan Event, opts.Buffer)
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{C: ch, cancel: cancel, done: make(chan struct{})}
+	go w.run(ctx, c, o, opts, ch)
+	return w
+}
+
+// Stop ends polling and waits for C to be closed. Events not yet received are dropped.
+func (w *Watcher) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+// Err returns the error of the most recent poll, or nil if it succeeded
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Watcher) run(ctx context.Context, c *Cameras, o *Owned, opts WatchOptions, ch chan<- Event) {
+	defer close(w.done)
+	defer close(ch)
+
+	ws := &watchState{opts: opts, ch: ch, from: opts.Since, seen: make(map[int64]time.Time)}
+	if opts.Push && w.push(ctx, c, o, ws) {
+		return
+	}
+
+	tick := time.NewTicker(opts.Interval)
+	defer tick.Stop()
+
+	var ps pollState
+	for {
+		err := w.poll(ctx, c, o, ws)
+		if ctx.Err() != nil {
+			return
+		}
+
+		perr := err
+		if perr == nil && !c.Dropcam.isOnline(o) {
+			perr = c.Dropcam.requireOnline("Watch", o)
+		}
+		next := tick.C
+		if wait := opts.Backoff.next(&ps, opts.Interval, perr); wait > 0 {
+			next = time.After(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+		}
+	}
+}
+
+// watchState is what a Watcher remembers between polls: where the next one
+// starts and the events already delivered
+type watchState struct {
+	opts WatchOptions
+	ch   chan<- Event
+	from time.Time
+	seen map[int64]time.Time
+}
+
+// poll delivers the events of camera o since the previous poll
+func (w *Watcher) poll(ctx context.Context, c *Cameras, o *Owned, ws *watchState) error {
+
+	polled := time.Now()
+	events, err := c.GetEvents(ctx, o, ws.from, polled)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w.setErr(err)
+	if err != nil {
+		warnf("watch: %s: %s\n", o.Title, err)
+		return err
+	}
+	if !ws.deliver(ctx, events) {
+		return ctx.Err()
+	}
+	ws.advance(polled)
+	return nil
+}
+
+// advance moves the start of the next poll to Overlap before t, when every
+// event published until t has been delivered
+func (ws *watchState) advance(t time.Time) {
+
+	ws.from = t.Add(-ws.opts.Overlap)
+	if ws.from.Before(ws.opts.Since) {
+		ws.from = ws.opts.Since
+	}
+	// events that started before the window can no longer be returned
+	for id, t := range ws.seen {
+		if t.Before(ws.from.Add(-ws.opts.Overlap)) {
+			delete(ws.seen, id)
+		}
+	}
+}
+
+// deliver sends the events not delivered before, oldest first. It returns
+// false when ctx is done first.
+func (ws *watchState) deliver(ctx context.Context, events Events) bool {
+
+	sort.Slice(events, func(i, j int) bool { return events[i].StartTime < events[j].StartTime })
+	for _, e := range events {
+		if _, ok := ws.seen[e.Id]; ok || e.Start().Before(ws.opts.Since) {
+			continue
+		}
+		ws.seen[e.Id] = e.Start()
+		select {
+		case ws.ch <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) setErr(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The WebhookPayload type is the JSON body a Webhook posts
+type WebhookPayload struct {
+	Kind        string    `json:"kind"`
+	Camera      string    `json:"camera_uuid"`
+	Title       string    `json:"title"`
+	Message     string    `json:"message"`
+	EventType   string    `json:"event_type,omitempty"`
+	Time        time.Time `json:"time"`
+	Labels      []string  `json:"labels,omitempty"`
+	Media       string    `json:"media,omitempty"`
+	SnapshotURL string    `json:"snapshot_url,omitempty"`
+	ClipURL     string    `json:"clip_url,omitempty"`
+}
+
+// The Webhook type is a Notifier posting each notification as a
+// WebhookPayload to every one of URLs. SnapshotURL, when set, supplies the
+// payload's snapshot_url, e.g. the address of an ImageCache.
+//
+// When Secret is set every request carries the headers
+//
+//	X-Dropcam-Timestamp: <unix seconds>
+//	X-Dropcam-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with Secret>
+//
+// so receivers can verify the sender and reject replays.
+//
+// Failed deliveries, network errors, 429 and 5xx replies, are retried up to
+// Retries times, 3 when zero, waiting Backoff, one second when zero, and
+// doubling the wait each time.
+type Webhook struct {
+	URLs        []string
+	Secret      string
+	SnapshotURL func(n *Notification) string
+	Retries     int
+	Backoff     time.Duration
+	Client      *http.Client
+}
+
+// Notify implements Notifier. Every URL is tried; the first error is returned.
+func (wh *Webhook) Notify(n *Notification) error {
+
+	p := WebhookPayload{
+		Kind:      n.Kind,
+		Camera:    n.Camera,
+		Title:     n.Title,
+		Message:   n.Message,
+		EventType: n.EventType,
+		Time:      n.Time,
+		Labels:    n.Labels,
+		Media:     n.Media,
+		ClipURL:   n.ClipURL,
+	}
+	if wh.SnapshotURL != nil {
+		p.SnapshotURL = wh.SnapshotURL(n)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, u := range wh.URLs {
+		if err := wh.deliver(u, body); err != nil {
+			warnf("webhook: %s: %s\n", u, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// deliver posts body to u, retrying as configured
+func (wh *Webhook) deliver(u string, body []byte) error {
+
+	retries := wh.Retries
+	if retries <= 0 {
+		retries = 3
+	}
+	wait := wh.Backoff
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = wh.post(u, body); err == nil || !retry || attempt >= retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (wh *Webhook) post(u string, body []byte) (bool, error) {
+
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dropcam-webhook")
+	if wh.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Dropcam-Timestamp", ts)
+		req.Header.Set("X-Dropcam-Signature", "sha256="+SignWebhook(wh.Secret, ts, body))
+	}
+
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("Webhook returned %s", resp.Status)
+}
+
+// SignWebhook returns the hex signature a Webhook sends for body at timestamp
+// ts, for receivers to compare with hmac.Equal
+func SignWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,198 @@
+// Copyright 2014 Robert Baruch (robertbaruch@mac.com). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dropcam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+)
+
+// The ZonePoint type is a corner of an activity zone. X and Y are fractions
+// of the frame's width and height from its top left corner, so zones do not
+// depend on the snapshot size. It is sent as an [x, y] pair.
+type ZonePoint struct {
+	X, Y float64
+}
+
+// MarshalJSON implements json.Marshaler
+func (p ZonePoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]float64{p.X, p.Y})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *ZonePoint) UnmarshalJSON(data []byte) error {
+	var xy [2]float64
+	if err := json.Unmarshal(data, &xy); err != nil {
+		return err
+	}
+	p.X, p.Y = xy[0], xy[1]
+	return nil
+}
+
+// The ActivityZone type is a region of a camera's view that events are
+// reported for. Events list the zones they happened in by Id in ZoneIds.
+type ActivityZone struct {
+	Id      int64       `json:"id,omitempty"`
+	Name    string      `json:"label"`
+	Polygon []ZonePoint `json:"coordinates"`
+}
+
+// The ActivityZones type is the zones of a camera
+type ActivityZones []ActivityZone
+
+// ByID returns the zone with id, or nil
+func (zs ActivityZones) ByID(id int64) *ActivityZone {
+	for i := range zs {
+		if zs[i].Id == id {
+			return &zs[i]
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the zones an event with ZoneIds ids happened
+// in; zones no longer defined are named by their id
+func (zs ActivityZones) Names(ids []int64) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		if z := zs.ByID(id); z != nil && z.Name != "" {
+			names[i] = z.Name
+		} else {
+			names[i] = fmt.Sprintf("zone %d", id)
+		}
+	}
+	return names
+}
+
+// zonesPath is where the activity zones of camera o are read and written
+func (c *Cameras) zonesPath(o *Owned) string {
+	return c.Dropcam.CameraInfoPath + "/" + o.Uuid + "/activity_zones"
+}
+
+// The GetActivityZones method fetches the activity zones of camera o
+func (c *Cameras) GetActivityZones(ctx context.Context, o *Owned) (ActivityZones, error) {
+
+	v := url.Values{}
+	v.Set("uuid", o.Uuid)
+	response, err := c.Dropcam.getRequest(ctx, c.zonesPath(o), v)
+	if err != nil {
+		return nil, fmt.Errorf("Get Activity Zones Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, newAPIError("Get Activity Zones", response, body)
+	}
+
+	// the zones come bare or wrapped in items
+	var zones ActivityZones
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var reply struct {
+			Items ActivityZones `json:"items"`
+		}
+		err = json.Unmarshal(body, &reply)
+		zones = reply.Items
+	} else {
+		err = json.Unmarshal(body, &zones)
+	}
+	if err != nil {
+		return nil, errors.New("Can't unmarshal Activity Zones: " + err.Error())
+	}
+	return zones, nil
+}
+
+// The SetActivityZone method creates zone z on camera o when its Id is zero,
+// or replaces the zone with its Id, and returns the zone as stored. Points
+// must lie within the frame and a polygon needs at least three. Cameras
+// without activity zones fail with ErrUnsupportedCapability.
+func (c *Cameras) SetActivityZone(ctx context.Context, o *Owned, z ActivityZone) (*ActivityZone, error) {
+
+	if err := requireCapability("Set Activity Zone", o, "activity zones", Capabilities.SupportsZones); err != nil {
+		return nil, err
+	}
+
+	if len(z.Polygon) < 3 {
+		return nil, fmt.Errorf("Activity Zone %q needs at least 3 points, has %d", z.Name, len(z.Polygon))
+	}
+	for _, p := range z.Polygon {
+		if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+			return nil, fmt.Errorf("Activity Zone %q point [%g, %g] is outside the frame", z.Name, p.X, p.Y)
+		}
+	}
+
+	path := c.zonesPath(o)
+	if z.Id != 0 {
+		path += "/" + strconv.FormatInt(z.Id, 10)
+	}
+	if err := c.Dropcam.refuseWrite("Set Activity Zone", "POST", path, o.Uuid, z); err != nil {
+		return nil, err
+	}
+	response, err := c.Dropcam.jsonRequest(ctx, "POST", path, o.Uuid, z)
+	if err != nil {
+		return nil, fmt.Errorf("Set Activity Zone Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		return nil, newAPIError("Set Activity Zone", response, body)
+	}
+
+	// the stored zone comes bare or wrapped in items; keep z when the
+	// reply does not echo it
+	stored := z
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		var reply struct {
+			ActivityZone
+			Items []ActivityZone `json:"items"`
+		}
+		if err := json.Unmarshal(trimmed, &reply); err == nil {
+			switch {
+			case len(reply.Items) > 0:
+				stored = reply.Items[0]
+			case reply.Id != 0:
+				stored = reply.ActivityZone
+			}
+		}
+	}
+	return &stored, nil
+}
+
+// The DeleteActivityZone method removes the zone with id from camera o.
+// Removing a zone that does not exist returns an error wrapping ErrNotFound.
+func (c *Cameras) DeleteActivityZone(ctx context.Context, o *Owned, id int64) error {
+
+	path := c.zonesPath(o) + "/" + strconv.FormatInt(id, 10)
+	if err := c.Dropcam.refuseWrite("Delete Activity Zone", "DELETE", path, o.Uuid, nil); err != nil {
+		return err
+	}
+	response, err := c.Dropcam.jsonRequest(ctx, "DELETE", path, o.Uuid, nil)
+	if err != nil {
+		return fmt.Errorf("Delete Activity Zone Request Failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != 200 && response.StatusCode != 204 {
+		return newAPIError("Delete Activity Zone", response, body)
+	}
+	return nil
+}